@@ -8,3 +8,31 @@ var ErrorInvalidCAS = errors.New("invalidCAS")
 
 // ErrorRollback for transactions.
 var ErrorRollback = errors.New("rollback")
+
+// ErrorStall write refused because memory footprint of the index
+// exceeded its hard limit.
+var ErrorStall = errors.New("stall")
+
+// ErrorLocktimeout transaction could not acquire lock on a key within
+// the configured lock-wait timeout.
+var ErrorLocktimeout = errors.New("locktimeout")
+
+// ErrorDeadlock transaction waiting for a lock on a key would form a
+// cycle with other transactions waiting for locks held by it.
+var ErrorDeadlock = errors.New("deadlock")
+
+// ErrorInvalidSavepoint savepoint was not taken by the transaction, or
+// it was discarded by rolling back to an older savepoint.
+var ErrorInvalidSavepoint = errors.New("invalidsavepoint")
+
+// ErrorExpired transaction or view was active for longer than the
+// configured max age, and was aborted.
+var ErrorExpired = errors.New("expired")
+
+// ErrorReadonly write operation refused because index is opened for
+// read-only access.
+var ErrorReadonly = errors.New("readonly")
+
+// ErrorMissingdeletes subscription cannot be served because non-lsm
+// deletes, since the subscribed seqno, are no longer available.
+var ErrorMissingdeletes = errors.New("missingdeletes")
@@ -6,6 +6,22 @@ type Getter func(key, value []byte) (val []byte, cas uint64, del, ok bool)
 // Iterator function to iterate on each indexed entry in sort order.
 type Iterator func(fin bool) (key, val []byte, seqno uint64, del bool, e error)
 
+// MergeOperator function to fold operand over value, for the same key,
+// and return the result. Value is nil if key is missing or deleted.
+// Operands can be folded together before folding them over the value,
+// hence the operator must be associative.
+type MergeOperator func(key, value, operand []byte) []byte
+
+// MergeGetter is same as Getter, additionally return whether val is a
+// merge operand, yet to be folded over older versions of the key.
+type MergeGetter func(
+	key, value []byte) (val []byte, cas uint64, del, operand, ok bool)
+
+// MergeIterator is same as Iterator, additionally return whether val is
+// a merge operand, yet to be folded over older versions of the key.
+type MergeIterator func(
+	fin bool) (key, val []byte, seqno uint64, del, operand bool, e error)
+
 // EntryIterator function to iterate on each indexed entry in sort order.
 // Returned IndexEntry is valid only till next call to the EntryIterator.
 type EntryIterator func(fin bool) IndexEntry
@@ -43,6 +59,12 @@ type Index interface {
 	// ScanEntries return a full table iterator.
 	ScanEntries() EntryIterator
 
+	// ScanRange return an iterator from low key, inclusive, upto high
+	// key. If inclusive is true, entry with high key is also included.
+	// A nil low starts from the first entry and a nil high iterates till
+	// the last entry.
+	ScanRange(low, high []byte, inclusive bool) Iterator
+
 	// BeginTxn starts a read-write transaction. Transactions must
 	// satisfy ACID properties. Finally all transactor objects must
 	// be Aborted or Committed.
@@ -128,6 +150,15 @@ type Cursor interface {
 	// YNext implements Iterator api, to iterate over the index. Typically
 	// used for lsm-sort.
 	YNext(fin bool) (key, val []byte, seqno uint64, deleted bool, err error)
+
+	// GetPrev move cursor to previous entry in snapshot and return its key
+	// and value. Returned byte slices will be a reference to index entry,
+	// hence must not be used after transaction is committed or aborted.
+	GetPrev() (key, value []byte, deleted bool, err error)
+
+	// YPrev implements Iterator api, to iterate over the index in
+	// descending order. Typically used for descending lsm-sort.
+	YPrev(fin bool) (key, val []byte, seqno uint64, deleted bool, err error)
 }
 
 // IndexEntry interface can be used to access individual fields in an entry.
@@ -145,6 +176,14 @@ type IndexEntry interface {
 	// Valueref returns reference to value, if value is stored in separate
 	// file, else vpos will be -1.
 	Valueref() (valuelen uint64, vpos int64)
+
+	// Expiry return entry's expiry as unix time in seconds, ZERO if entry
+	// never expires. Entry is returned as is, even if it has expired.
+	Expiry() int64
+
+	// IsOperand return true if entry's value is a merge operand, yet to
+	// be folded over older versions of the key.
+	IsOperand() bool
 }
 
 // Disksnapshot provides read-only API to fetch snapshot information.
@@ -152,3 +191,44 @@ type Disksnapshot interface {
 	// Appdata return application data associated with this snapshot.
 	Appdata() []byte
 }
+
+// Subscriber is implemented by indexes that can stream committed
+// mutations in seqno order, for change-data-capture.
+type Subscriber interface {
+	// Subscribe return an iterator over mutations with seqno greater
+	// than or equal to `seqno`, in seqno order, uptil the latest
+	// mutation at the time of subscription. Iterator returns io.EOF
+	// once it has caught up, subscribers can follow the index by
+	// subscribing again from the seqno following the last mutation
+	// they have acknowledged. If mutations since `seqno` cannot be
+	// delivered without missing a delete, iterator returns
+	// ErrorMissingdeletes.
+	Subscribe(seqno uint64) Iterator
+}
+
+// Expirer is implemented by indexes that can expire entries.
+type Expirer interface {
+	// SetExpiry is same as Index.Set, additionally the entry shall
+	// expire at `expiry`, unix time in seconds. Expired entries are
+	// reported as deleted, and purged while compacting the index. If
+	// expiry is ZERO, entry never expires.
+	SetExpiry(key, value, oldvalue []byte, expiry int64) ([]byte, uint64)
+}
+
+// Merger is implemented by indexes that can remember merge operands, so
+// that values can be updated without reading them first.
+type Merger interface {
+	// Merge operand into key's value using merge operator. If key is
+	// missing and lsm is true, operand is remembered as merge operand,
+	// to be folded over older versions of the key, else operand is
+	// folded over nil value.
+	Merge(key, operand []byte, merge MergeOperator, lsm bool) uint64
+
+	// Getoperand is same as Index.Get, additionally return whether v
+	// is a merge operand.
+	Getoperand(key, value []byte) (v []byte, cas uint64, del, op, ok bool)
+
+	// ScanOperands is same as Index.ScanRange, additionally return
+	// whether each value is a merge operand.
+	ScanOperands(low, high []byte, inclusive bool) MergeIterator
+}
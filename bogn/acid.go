@@ -3,10 +3,20 @@ package bogn
 import "time"
 import "runtime"
 
+import "github.com/bnclabs/gostore/lib"
+
 type txnmeta struct {
 	cursors   chan *Cursor
 	txncache  chan *Txn
 	viewcache chan *View
+	// key locks held by transactions, released on commit or abort.
+	keylocks    *lib.Keylocks
+	locktimeout time.Duration
+	// active transactions and views, expired ones are reported and
+	// aborted if txnabort is true.
+	tracker   *lib.Txntracker
+	txnmaxage time.Duration
+	txnabort  bool
 }
 
 func (meta *txnmeta) inittxns() {
@@ -14,6 +24,8 @@ func (meta *txnmeta) inittxns() {
 	meta.txncache = make(chan *Txn, numcpu)
 	meta.viewcache = make(chan *View, numcpu)
 	meta.cursors = make(chan *Cursor, numcpu*4)
+	meta.keylocks = lib.NewKeylocks()
+	meta.tracker = lib.NewTxntracker(meta.txnmaxage)
 }
 
 func (meta *txnmeta) gettxn(id uint64, bogn *Bogn, snap *snapshot) (txn *Txn) {
@@ -31,12 +43,19 @@ func (meta *txnmeta) gettxn(id uint64, bogn *Bogn, snap *snapshot) (txn *Txn) {
 }
 
 func (meta *txnmeta) puttxn(txn *Txn) {
+	meta.tracker.End(txn)
+	meta.keylocks.Unlockall(txn)
 	for _, cur := range txn.cursors {
 		txn.putcursor(cur)
 	}
 	txn.mwtxn, txn.mrview, txn.mcview = nil, nil, nil
 	txn.dviews = txn.dviews[:0]
 	txn.cursors, txn.gets = txn.cursors[:0], txn.gets[:0]
+	txn.writes, txn.mwlocked = txn.writes[:0], false
+	txn.saves, txn.stallerr = txn.saves[:0], nil
+	if txn.expired { // application might still hold on to txn.
+		return
+	}
 	select {
 	case meta.txncache <- txn:
 	default: // Left for GC
@@ -60,12 +79,16 @@ func (meta *txnmeta) getview(
 }
 
 func (meta *txnmeta) putview(view *View) {
+	meta.tracker.End(view)
 	for _, cur := range view.cursors {
 		view.putcursor(cur)
 	}
 	view.mwview, view.mrview, view.mcview = nil, nil, nil
 	view.dviews = view.dviews[:0]
 	view.cursors, view.gets = view.cursors[:0], view.gets[:0]
+	if view.expired { // application might still hold on to view.
+		return
+	}
 	select {
 	case meta.viewcache <- view:
 	default: // Left for GC
@@ -25,17 +25,31 @@ import "github.com/bnclabs/gostore/bubt"
 import s "github.com/bnclabs/gosettings"
 import humanize "github.com/dustin/go-humanize"
 
-// TODO: enable count aggregation across snapshots, with data-structures
-// that support LSM it is difficult to maintain accurate count.
-
 // Bogn instance to index key,value pairs.
 type Bogn struct {
 	// atomic access, 8-byte aligned
 	nroutines int64
 	dgmstate  int64
 	snapspin  int64
+	following int64
+	failed    int64 // log failed, refer to waitsync().
 	// statistics
 	wramplification int64
+	nfiltdropped    int64
+	nfiltchanged    int64
+	npersists       int64
+	persisttime     int64
+	nflushes        int64
+	flushtime       int64
+	ncompacts       int64
+	compacttime     int64
+	// write stalls
+	stallheap    int64
+	nstallchecks int64
+	nstallsoft   int64
+	nstallhard   int64
+	nstallerrors int64
+	stalltime    int64
 
 	name         string
 	epoch        time.Time
@@ -45,10 +59,15 @@ type Bogn struct {
 	finch        chan struct{}
 	snaprw       sync.RWMutex
 	compactorch  chan []interface{}
+	wal          *wal
+	mwmu         sync.Mutex // refer to walock().
 	txnmeta
 
 	// bogn settings
 	logpath       string
+	logsync       string
+	logsynctick   time.Duration
+	logsegsize    int64
 	memstore      string
 	diskstore     string
 	durable       bool
@@ -58,9 +77,35 @@ type Bogn struct {
 	compactratio  float64
 	autocommit    time.Duration
 	compactperiod time.Duration
+	compaction    string
 	memcapacity   int64
+	stallsoft     int64
+	stallhard     int64
+	stallblock    bool
 	setts         s.Settings
 	logprefix     string
+
+	// compaction filter and merge operator, not persisted with disk
+	// settings.
+	compactionfilter CompactionFilter
+	mergeoperator    api.MergeOperator
+	policy           CompactionPolicy
+	observer         Observer
+	// background compaction in progress, for observer.
+	compactwhat  string
+	compactstart time.Time
+
+	// shared by all disk builders flushing and compacting in background.
+	ratelimiter *bubt.Ratelimiter
+
+	// opened via OpenReadOnly(), refreshmu serializes Refresh() calls.
+	readonly  bool
+	refreshmu sync.Mutex
+
+	// replication, to followers and from leader.
+	replmu      sync.Mutex
+	replicators []*replicator
+	follower    *follower
 }
 
 // PurgeIndex will purge all the disk level snapshots for index `name`
@@ -81,7 +126,22 @@ func CompactIndex(name, diskstore string, diskpaths []string, merge bool) {
 	return
 }
 
-// New create a new bogn instance.
+// New create a new bogn instance. If durable, mutations that are
+// logged but not yet flushed to disk are replayed from the log into
+// the write store. Recovery guarantee depends on `logsync` setting:
+//
+// "write", every acknowledged mutation survives a process crash and
+// a system crash.
+//
+// "group", same as "write", but acknowledgements are delayed by upto
+// `logsynctick`, so that several mutations can share a single fsync.
+//
+// "none", every acknowledged mutation survives a process crash, but
+// mutations acknowledged after the last fsync, at segment rotation,
+// flush or close, can be lost on a system crash.
+//
+// Torn or corrupted record, at the tail of the log, is truncated
+// during recovery.
 func New(name string, setts s.Settings) (*Bogn, error) {
 	bogn := (&Bogn{
 		name:      name,
@@ -106,7 +166,7 @@ func New(name string, setts s.Settings) (*Bogn, error) {
 		return nil, err
 	}
 	// NOTE: If settings have changed in between a re-boot from disk,
-	// user should use a migration tool to move disk snapshots
+	// user should use MigrateIndex() to move disk snapshots
 	// from older settings to new settings.
 	lastseqno := bogn.loaddisksettings(disks[:])
 
@@ -117,6 +177,21 @@ func New(name string, setts s.Settings) (*Bogn, error) {
 		bogn.Close()
 		return nil, err
 	}
+	if bogn.durable {
+		if err = bogn.replaywal(head.mw, lastseqno); err != nil {
+			bogn.Close()
+			return nil, err
+		}
+		logdir, seqno := bogn.logdir(""), head.mwseqno()+1
+		bogn.wal, err = openwal(
+			bogn.logprefix, logdir, seqno,
+			bogn.logsync, bogn.logsynctick, bogn.logsegsize,
+		)
+		if err != nil {
+			bogn.Close()
+			return nil, err
+		}
+	}
 	head.refer()
 	bogn.setheadsnapshot(head)
 
@@ -128,6 +203,10 @@ func New(name string, setts s.Settings) (*Bogn, error) {
 // validatesettings().
 func (bogn *Bogn) readsettings(setts s.Settings) *Bogn {
 	bogn.logpath = setts.String("logpath")
+	bogn.logsync = setts.String("logsync")
+	bogn.logsynctick = time.Duration(setts.Int64("logsynctick"))
+	bogn.logsynctick *= time.Millisecond
+	bogn.logsegsize = setts.Int64("logsegsize")
 	bogn.memstore = setts.String("memstore")
 	bogn.diskstore = setts.String("diskstore")
 	bogn.durable = setts.Bool("durable")
@@ -139,6 +218,17 @@ func (bogn *Bogn) readsettings(setts s.Settings) *Bogn {
 	bogn.autocommit *= time.Second
 	bogn.compactperiod = time.Duration(setts.Int64("compactperiod"))
 	bogn.compactperiod *= time.Second
+	bogn.compactionfilter = bogn.readfilter(setts)
+	bogn.mergeoperator = bogn.readmerge(setts)
+	bogn.compaction = setts.String("compaction")
+	bogn.policy = bogn.readpolicy(setts)
+	bogn.observer = bogn.readobserver(setts)
+	bogn.ratelimiter = bubt.NewRatelimiter(setts.Int64("ratelimit"))
+	bogn.locktimeout = time.Duration(setts.Int64("locktimeout"))
+	bogn.locktimeout *= time.Millisecond
+	bogn.txnmaxage = time.Duration(setts.Int64("txnmaxage"))
+	bogn.txnmaxage *= time.Second
+	bogn.txnabort = setts.Bool("txnabort")
 	bogn.setts = setts
 
 	atomic.StoreInt64(&bogn.dgmstate, 0)
@@ -157,6 +247,11 @@ func (bogn *Bogn) readsettings(setts s.Settings) *Bogn {
 	default:
 		panic(fmt.Errorf("invalid diskstore %q", bogn.diskstore))
 	}
+	switch bogn.logsync {
+	case "write", "group", "none":
+	default:
+		panic(fmt.Errorf("invalid logsync %q", bogn.logsync))
+	}
 
 	// pick a logpath, if not supplied, from bubt-diskpaths.
 	if bogn.durable {
@@ -167,7 +262,19 @@ func (bogn *Bogn) readsettings(setts s.Settings) *Bogn {
 				if len(diskpaths) == 0 {
 					panic(fmt.Errorf("missing bubt `diskpaths` settings"))
 				}
-				bogn.logpath = diskpaths[rand.Intn(10000)%len(diskpaths)]
+				// on reboot, locate the logpath used by previous run.
+				dirname := fmt.Sprintf("bogn-%v-logs", bogn.name)
+				for _, diskpath := range diskpaths {
+					logdir := filepath.Join(diskpath, dirname)
+					if _, err := os.Stat(logdir); err == nil {
+						bogn.logpath = diskpath
+						break
+					}
+				}
+				if len(bogn.logpath) == 0 {
+					n := rand.Intn(10000) % len(diskpaths)
+					bogn.logpath = diskpaths[n]
+				}
 
 			default:
 				panic(fmt.Errorf("invalid diskstore %q", bogn.diskstore))
@@ -188,7 +295,7 @@ func (bogn *Bogn) readmemsettings(setts s.Settings) *Bogn {
 		llrbsetts := bogn.setts.Section("llrb.").Trim("llrb.")
 		bogn.memcapacity = llrbsetts.Int64("memcapacity")
 	}
-	return bogn
+	return bogn.readstallsettings(setts)
 }
 
 func (bogn *Bogn) settingstodisk() s.Settings {
@@ -196,6 +303,9 @@ func (bogn *Bogn) settingstodisk() s.Settings {
 	diskversions := bogn.diskversions
 	setts := s.Settings{
 		"logpath":       bogn.logpath,
+		"logsync":       bogn.logsync,
+		"logsynctick":   bogn.logsynctick,
+		"logsegsize":    bogn.logsegsize,
 		"memstore":      bogn.memstore,
 		"diskstore":     bogn.diskstore,
 		"workingset":    bogn.workingset,
@@ -203,6 +313,12 @@ func (bogn *Bogn) settingstodisk() s.Settings {
 		"compactratio":  bogn.compactratio,
 		"autocommit":    bogn.autocommit,
 		"compactperiod": bogn.compactperiod,
+		"compaction":    bogn.compaction,
+		"compactlevels": bogn.setts.Int64("compactlevels"),
+		"garbageratio":  bogn.setts.Float64("garbageratio"),
+		"tierwidth":     bogn.setts.Int64("tierwidth"),
+		"tierratio":     bogn.setts.Float64("tierratio"),
+		"timewindow":    bogn.setts.Int64("timewindow"),
 		"memversions":   memversions,
 		"diskversions":  diskversions,
 	}
@@ -232,14 +348,18 @@ func (bogn *Bogn) loaddisksettings(disks []api.Index) (seqno uint64) {
 		disksetts := bogn.settingsfromdisk(alldisks[0])
 		bogn.memversions = disksetts["memversions"].([3]int)
 		bogn.diskversions = disksetts["diskversions"].([16]int)
-		bogn.logpath = disksetts.String("logpath")
-		bogn.validatesettings(disksetts)
+		// disk levels restored from a checkpoint are relocated.
+		relocated := bogn.ischeckpointed(alldisks[0])
+		if relocated == false {
+			bogn.logpath = disksetts.String("logpath")
+		}
+		bogn.validatesettings(disksetts, relocated)
 		return bogn.getdiskseqno(alldisks[0])
 	}
 	return 0
 }
 
-func (bogn *Bogn) validatesettings(disksetts s.Settings) {
+func (bogn *Bogn) validatesettings(disksetts s.Settings, relocated bool) {
 	setts := bogn.setts
 	if memstore := disksetts.String("memstore"); memstore != bogn.memstore {
 		fmsg := "found memstore:%q on disk, expected %q"
@@ -250,7 +370,7 @@ func (bogn *Bogn) validatesettings(disksetts s.Settings) {
 		fmsg := "found diskstore:%q on disk, expected %q"
 		panic(fmt.Errorf(fmsg, diskstore, bogn.diskstore))
 	}
-	if bogn.durable {
+	if bogn.durable && relocated == false {
 		if logpath := disksetts.String("logpath"); logpath != bogn.logpath {
 			fmsg := "found logpath:%q on disk, expected %q"
 			panic(fmt.Errorf(fmsg, logpath, bogn.logpath))
@@ -262,7 +382,9 @@ func (bogn *Bogn) validatesettings(disksetts s.Settings) {
 	sort.Strings(diskpaths1)
 	diskpaths2 := setts.Strings("bubt.diskpaths")
 	sort.Strings(diskpaths2)
-	if reflect.DeepEqual(diskpaths1, diskpaths2) == false {
+	if relocated {
+		// disk levels are checkpointed from a different set of paths.
+	} else if reflect.DeepEqual(diskpaths1, diskpaths2) == false {
 		fmsg := "found diskpaths:%v on disk, expected %v"
 		panic(fmt.Errorf(fmsg, diskpaths1, diskpaths2))
 	}
@@ -392,6 +514,92 @@ func (bogn *Bogn) mvccfromdisk(
 	return mw
 }
 
+// replay all mutations logged after seqno into write store.
+func (bogn *Bogn) replaywal(mw api.Index, seqno uint64) error {
+	dgm := atomic.LoadInt64(&bogn.dgmstate) == 1
+
+	logdir := bogn.logdir("")
+	segments, err := walsegments(logdir)
+	if err != nil {
+		errorf("%v replay: %v", bogn.logprefix, err)
+		return err
+	}
+
+	now, nrecords, nentries := time.Now(), 0, 0
+	for i, segment := range segments {
+		fpos, torn, err := readsegment(segment, func(rec *walrecord) bool {
+			if rec.endseqno <= seqno { // already flushed to disk.
+				return true
+			}
+			bogn.applyrecord(mw, rec, dgm)
+			nrecords, nentries = nrecords+1, nentries+len(rec.entries)
+			return true
+		})
+		if err != nil {
+			errorf("%v replay: %v", bogn.logprefix, err)
+			return err
+
+		} else if torn && i < len(segments)-1 {
+			err := fmt.Errorf("corrupted log segment %q at %v", segment, fpos)
+			errorf("%v replay: %v", bogn.logprefix, err)
+			return err
+
+		} else if torn {
+			fmsg := "%v replay: truncating torn log %q at %v"
+			warnf(fmsg, bogn.logprefix, segment, fpos)
+			if err := os.Truncate(segment, fpos); err != nil {
+				errorf("%v replay: %v", bogn.logprefix, err)
+				return err
+			}
+		}
+	}
+
+	fmsg := "%v replay: %v records, %v entries from seqno %v to %v in %v"
+	took := time.Since(now).Round(time.Millisecond)
+	endseqno := bogn.indexseqno(mw)
+	infof(fmsg, bogn.logprefix, nrecords, nentries, seqno, endseqno, took)
+	return nil
+}
+
+// applyrecord apply mutations from log record on mw, each mutation
+// is applied with the same seqno as it was logged.
+func (bogn *Bogn) applyrecord(mw api.Index, rec *walrecord, dgm bool) {
+	for _, entry := range rec.entries {
+		if entry.seqno > 0 {
+			setmwseqno(mw, entry.seqno-1)
+		}
+		switch entry.cmd {
+		case walSet:
+			mw.Set(entry.key, entry.value, nil)
+		case walSetexpiry:
+			key, value, expiry := entry.key, entry.value, entry.expiry
+			mw.(api.Expirer).SetExpiry(key, value, nil, expiry)
+		case walDelete:
+			mw.Delete(entry.key, nil, dgm /*lsm*/)
+		case walDeletelsm:
+			mw.Delete(entry.key, nil, true /*lsm*/)
+		case walMerge:
+			if bogn.mergeoperator == nil {
+				panic("merge operator not configured")
+			}
+			merge := bogn.mergeoperator
+			mw.(api.Merger).Merge(entry.key, entry.value, merge, dgm)
+		}
+	}
+	setmwseqno(mw, rec.endseqno)
+}
+
+func setmwseqno(mw api.Index, seqno uint64) {
+	switch index := mw.(type) {
+	case *llrb.LLRB:
+		index.Setseqno(seqno)
+	case *llrb.MVCC:
+		index.Setseqno(seqno)
+	default:
+		panic(fmt.Errorf("unsupported memstore %T", mw))
+	}
+}
+
 // Start bogn service. Typically bogn instances are created and
 // started as:
 //   inst := NewBogn("storage", setts).Start()
@@ -487,6 +695,38 @@ func (bogn *Bogn) snaprunlock() {
 	}
 }
 
+// waitsync for ticket to be synced to log, unless err, from logging the
+// mutation, is not nil. Once log has failed, index is turned read-only
+// since mutations applied on the write store can no more be persisted.
+func (bogn *Bogn) waitsync(ticket int64, err error) error {
+	if err == nil {
+		err = bogn.wal.waitsync(ticket)
+	}
+	if err != nil && atomic.CompareAndSwapInt64(&bogn.failed, 0, 1) {
+		fmsg := "%v log failed, index is turned read-only: %v"
+		errorf(fmsg, bogn.logprefix, err)
+	}
+	return err
+}
+
+// walock lock the log for a write on the write store. Transactions on
+// llrb write store lock it from BeginTxn till Commit or Abort, and lock
+// the log only while committing, hence writes on llrb write store are
+// serialized with transactions by mwmu, ahead of locking the log.
+func (bogn *Bogn) walock() {
+	if bogn.memstore == "llrb" {
+		bogn.mwmu.Lock()
+	}
+	bogn.wal.lock()
+}
+
+func (bogn *Bogn) walunlock() {
+	bogn.wal.unlock()
+	if bogn.memstore == "llrb" {
+		bogn.mwmu.Unlock()
+	}
+}
+
 func (bogn *Bogn) snaplock() {
 	addlatch := func() {
 		for {
@@ -527,7 +767,7 @@ func (bogn *Bogn) snapunlock() {
 }
 
 func (bogn *Bogn) mwmetadata(
-	seqno uint64, flushunix string, appdata []byte,
+	seqno, delseqno uint64, flushunix string, appdata []byte,
 	settstodisk s.Settings) []byte {
 
 	if len(flushunix) == 0 {
@@ -536,6 +776,7 @@ func (bogn *Bogn) mwmetadata(
 	appdatastr := base64.StdEncoding.EncodeToString(appdata)
 	metadata := map[string]interface{}{
 		"seqno":     fmt.Sprintf(`"%v"`, seqno),
+		"delseqno":  fmt.Sprintf(`"%v"`, delseqno),
 		"flushunix": flushunix,
 		"appdata":   appdatastr,
 	}
@@ -562,100 +803,55 @@ func (bogn *Bogn) flushelapsed() bool {
 	return time.Now().Sub(time.Unix(int64(x), 0)) > bogn.autocommit
 }
 
-// cdisks is a list of disk snapshots being compacted.
-func (bogn *Bogn) pickflushdisk(
-	cdisks []api.Index) (fdisks []api.Index, nlevel int, what string) {
-
-	var ok bool
-
-	if fdisks, nlevel, ok = bogn.pickflushdisk1(cdisks); ok {
-		return fdisks, nlevel, "flush.fresh"
-	} else if fdisks, nlevel, ok = bogn.pickflushdisk2(cdisks); ok {
-		return fdisks, nlevel, "flush.aggressive"
-	} else if fdisks, nlevel, ok = bogn.pickflushdisk3(cdisks); ok {
-		return fdisks, nlevel, "flush.fallback"
-	} else if fdisks, nlevel, ok = bogn.pickflushdisk4(cdisks); ok {
-		return fdisks, nlevel, "flush.merge"
+// compactionlevels describe disk levels in snap for CompactionPolicy.
+func (bogn *Bogn) compactionlevels(snap *snapshot) *Levels {
+	levels := &Levels{Nlevels: len(snap.disks), Memheap: snap.memheap()}
+	for _, disk := range snap.disklevels([]api.Index{}) {
+		level, _, _ := bogn.path2level(disk.ID())
+		mdata := bogn.diskmetadata(disk)
+		x, _ := strconv.Atoi(strings.Trim(mdata["flushunix"].(string), `"`))
+		levels.Disks = append(levels.Disks, Level{
+			Level:     level,
+			Count:     bogn.indexcount(disk),
+			Payload:   bogn.indexpayload(disk),
+			Footprint: bogn.indexfootprint(disk),
+			Flushunix: int64(x),
+		})
 	}
-	panic("unreachable code")
+	return levels
 }
 
-// first time flush
-func (bogn *Bogn) pickflushdisk1(
-	cdisks []api.Index) (fdisks []api.Index, nlevel int, ok bool) {
-
-	snap := bogn.currsnapshot()
-	latestlevel, _ := snap.latestlevel()
-	if latestlevel < 0 && len(cdisks) > 0 {
-		panic("impossible situation")
-
-	} else if latestlevel < 0 { // first time flush.
-		return nil, len(snap.disks) - 1, true
+// leveldisks return disk snapshots, from snap, for levels.
+func (bogn *Bogn) leveldisks(snap *snapshot, levels []int) []api.Index {
+	if len(levels) == 0 {
+		return nil
 	}
-	return nil, -1, false
-}
-
-// if all of the allowed-snapshot levels are exhausted then flush by
-// merging all snapshot levels.
-func (bogn *Bogn) pickflushdisk2(
-	cdisks []api.Index) (fdisks []api.Index, nlevel int, ok bool) {
-
-	snap := bogn.currsnapshot()
-	disks := snap.disklevels([]api.Index{})
-	if len(disks) == len(snap.disks) {
-		till := 16
-		if len(cdisks) > 0 {
-			till, _, _ = bogn.path2level(cdisks[0].ID())
-		}
-		fdisks := []api.Index{}
-		for _, disk := range disks {
-			if level, _, _ := bogn.path2level(disk.ID()); level >= till {
-				break
-			}
-			fdisks = append(fdisks, disk)
+	disks := make([]api.Index, 0, len(levels))
+	for _, level := range levels {
+		if snap.disks[level] == nil {
+			panic(fmt.Errorf("impossible situation, empty level %v", level))
 		}
-		if len(fdisks) == 0 { // all of them are being compacted
-			return nil, -1, true
-		}
-		level, _, _ := bogn.path2level(fdisks[len(fdisks)-1].ID())
-		return fdisks, snap.nextbutlevel(level), true
+		disks = append(disks, snap.disks[level])
 	}
-	return nil, -1, false
+	return disks
 }
 
-// fallback by one level and flush without merge
-func (bogn *Bogn) pickflushdisk3(
-	cdisks []api.Index) (fdisks []api.Index, nlevel int, ok bool) {
+// cdisks is a list of disk snapshots being compacted.
+func (bogn *Bogn) pickflushdisk(
+	cdisks []api.Index) (fdisks []api.Index, nlevel int, what string) {
 
 	snap := bogn.currsnapshot()
-	latestlevel, latestdisk := snap.latestlevel()
-	if latestlevel <= 0 { // handled by 1 & 2.
-		panic("impossible situation")
-	}
-	if len(cdisks) > 0 {
-		level0, _, _ := bogn.path2level(cdisks[0].ID())
-		if latestlevel > level0 {
-			panic("impossible situation")
-		} else if latestlevel == level0 {
-			return nil, latestlevel - 1, true // fallback by one level.
-		}
+	compacting := []int{}
+	for _, disk := range cdisks {
+		level, _, _ := bogn.path2level(disk.ID())
+		compacting = append(compacting, level)
 	}
-
-	payload := float64(bogn.indexpayload(latestdisk))
-	if (float64(snap.memheap()) / payload) < bogn.flushratio {
-		return nil, latestlevel - 1, true // fallback by one level
+	levels := bogn.compactionlevels(snap)
+	merge, nlevel, what := bogn.policy.Flush(levels, compacting)
+	if nlevel < 0 {
+		return nil, -1, what
 	}
-
-	return nil, -1, false
-}
-
-// pick the latest disk snapshot and flush with merge.
-func (bogn *Bogn) pickflushdisk4(
-	cdisks []api.Index) (fdisks []api.Index, nlevel int, ok bool) {
-
-	snap := bogn.currsnapshot()
-	latestlevel, latestdisk := snap.latestlevel()
-	return []api.Index{latestdisk}, snap.nextbutlevel(latestlevel), true
+	return bogn.leveldisks(snap, merge), nlevel, what
 }
 
 func (bogn *Bogn) pickcompactdisks(tombstonepurge bool) (
@@ -664,24 +860,19 @@ func (bogn *Bogn) pickcompactdisks(tombstonepurge bool) (
 	var ok bool
 
 	// "offlinemerge", "persist"
-	if disks, nextlevel, ok = bogn.pickcompactdisks1(tombstonepurge); ok {
+	if disks, nextlevel, ok = bogn.picktombstonepurge(tombstonepurge); ok {
 		return disks, nextlevel, "compact.tombstonepurge"
-	} else if disks, nextlevel, ok = bogn.pickcompactdisks2(); ok {
-		return disks, nextlevel, "compact.none"
-	} else if disks, nextlevel, ok = bogn.pickcompactdisks3(); ok {
-		return disks, nextlevel, "compact.aggressive"
-	} else if disks, nextlevel, ok = bogn.pickcompactdisks4(); ok {
-		return disks, nextlevel, "compact.ratio"
-	} else if disks, nextlevel, ok = bogn.pickcompactdisks5(); ok {
-		return disks, nextlevel, "compact.period"
-	} else if disks, nextlevel, ok = bogn.pickcompactdisks6(); ok {
-		return disks, nextlevel, "compact.self"
 	}
-	return nil, -1, "none"
+	snap := bogn.currsnapshot()
+	merge, nextlevel, what := bogn.policy.Compact(bogn.compactionlevels(snap))
+	if nextlevel < 0 {
+		return nil, -1, what
+	}
+	return bogn.leveldisks(snap, merge), nextlevel, what
 }
 
 // tombstone purge for the last level
-func (bogn *Bogn) pickcompactdisks1(tombstonepurge bool) (
+func (bogn *Bogn) picktombstonepurge(tombstonepurge bool) (
 	cdisks []api.Index, nextlevel int, ok bool) {
 
 	snap := bogn.currsnapshot()
@@ -699,105 +890,13 @@ func (bogn *Bogn) pickcompactdisks1(tombstonepurge bool) (
 	return []api.Index{disk}, snap.nextbutlevel(level), true
 }
 
-// no compaction: there is only zero or one disk level
-func (bogn *Bogn) pickcompactdisks2() (
-	cdisks []api.Index, nextlevel int, ok bool) {
-
-	snap := bogn.currsnapshot()
-	disks := snap.disklevels([]api.Index{})
-	if len(disks) <= 1 {
-		return nil, -1, true
-	}
-	return nil, -1, false
-}
-
-// aggressive compaction, if number of levels is more than 3 then
-// compact without checking for compactratio or compactperiod.
-func (bogn *Bogn) pickcompactdisks3() (
-	cdisks []api.Index, nextlevel int, ok bool) {
-
-	snap := bogn.currsnapshot()
-	disks := snap.disklevels([]api.Index{})
-	if len(disks) > 3 {
-		// leave the first level for flusher logic, and leave the
-		// last level since it might be too big !!
-		cdisks = disks[1 : len(disks)-1]
-		level, _, _ := bogn.path2level(cdisks[len(cdisks)-1].ID())
-		return cdisks, snap.nextbutlevel(level), true
-	}
-	return nil, -1, false
-}
-
-// check whether ratio between two snapshot's payload exceeds compactratio.
-func (bogn *Bogn) pickcompactdisks4() (
-	cdisks []api.Index, nextlevel int, ok bool) {
-
-	snap := bogn.currsnapshot()
-	disks := snap.disklevels([]api.Index{})
-	for i := 0; i < len(disks)-1; i++ {
-		disk0, disk1 := disks[i], disks[i+1]
-		payload0 := float64(bogn.indexpayload(disk0))
-		payload1 := float64(bogn.indexpayload(disk1))
-		if (payload0 / payload1) > bogn.compactratio {
-			level1, _, _ := bogn.path2level(disk1.ID())
-			cdisks := []api.Index{disk0, disk1}
-			return cdisks, snap.nextbutlevel(level1), true
-		}
-	}
-	return nil, -1, false
-}
-
-// check whether disk's lifetime exceeds compact period.
-func (bogn *Bogn) pickcompactdisks5() (
-	cdisks []api.Index, nextlevel int, ok bool) {
-
-	snap := bogn.currsnapshot()
-	disks := snap.disklevels([]api.Index{})
-	for i := 0; i < len(disks)-1; i++ {
-		mdata := bogn.diskmetadata(disks[i])
-		x, _ := strconv.Atoi(strings.Trim(mdata["flushunix"].(string), `"`))
-		if time.Now().Sub(time.Unix(int64(x), 0)) > bogn.compactperiod {
-			level, _, _ := bogn.path2level(disks[i].ID())
-			if cdisks := disks[i:]; len(cdisks) > 1 {
-				return cdisks, snap.nextbutlevel(level), true
-			}
-		}
-	}
-	return nil, -1, false
-}
-
-func (bogn *Bogn) pickcompactdisks6() (
-	cdisks []api.Index, nextlevel int, ok bool) {
-
-	snap := bogn.currsnapshot()
-	disks := snap.disklevels([]api.Index{})
-	disk := disks[len(disks)-1]
-	level, _, _ := bogn.path2level(disk.ID())
-	if level != len(snap.disks)-1 {
-		panic("impossible situation")
-	}
-	payload := float64(bogn.indexpayload(disk))
-	footprint := float64(bogn.indexfootprint(disk))
-	if (payload / footprint) < 0.25 { // TODO: no magic number
-		return []api.Index{disk}, level, true
-	}
-	return nil, -1, false
-}
-
 func (bogn *Bogn) pickwindupdisk() (disk api.Index, nlevel int) {
 	snap := bogn.currsnapshot()
-
-	latestlevel, latestdisk := snap.latestlevel()
-	if latestlevel < 0 { // first time flush.
-		return nil, len(snap.disks) - 1
-
-	} else if latestlevel > 0 {
-		payload := float64(bogn.indexpayload(latestdisk))
-		if (float64(snap.memheap()) / payload) < bogn.flushratio {
-			return nil, latestlevel - 1
-		}
+	level, nlevel := bogn.policy.Windup(bogn.compactionlevels(snap))
+	if level < 0 {
+		return nil, nlevel
 	}
-	return latestdisk, snap.nextbutlevel(latestlevel)
+	return bogn.leveldisks(snap, []int{level})[0], nlevel
 }
 
 func (bogn *Bogn) levelname(level, version int, sha string) string {
@@ -810,9 +909,10 @@ func (bogn *Bogn) memlevelname(level string, version int) string {
 }
 
 func (bogn *Bogn) logdir(logpath string) string {
-	if len(logpath) == 0 && len(bogn.logpath) > 0 {
+	if len(logpath) == 0 {
 		logpath = bogn.logpath
-	} else if len(bogn.logpath) == 0 {
+	}
+	if len(logpath) == 0 {
 		return ""
 	}
 	dirname := fmt.Sprintf("bogn-%v-logs", bogn.name)
@@ -856,6 +956,80 @@ func (bogn *Bogn) Getseqno() uint64 {
 	return bogn.currsnapshot().mwseqno()
 }
 
+// ApproximateCount return an estimate of number of live keys in the
+// index, without reading entries from memory or disk levels, count of
+// tombstones is read from statistics of each level. Every tombstone is
+// assumed to shadow an entry in an older level, and every entry is
+// assumed to be unique across levels.
+func (bogn *Bogn) ApproximateCount() int64 {
+	bogn.snaprlock()
+	defer bogn.snaprunlock()
+
+	snap := bogn.latestsnapshot()
+	if snap == nil {
+		return 0
+	}
+	defer snap.release()
+
+	// mc caches entries from disk levels, skip them.
+	indexes := []api.Index{snap.mw}
+	if snap.mr != nil {
+		indexes = append(indexes, snap.mr)
+	}
+	indexes = snap.disklevels(indexes)
+
+	count := int64(0)
+	for i, index := range indexes {
+		deleted := bogn.indexdeleted(index)
+		count += bogn.indexcount(index) - deleted
+		if i < len(indexes)-1 {
+			count -= deleted
+		}
+	}
+	if count < 0 {
+		return 0
+	}
+	return count
+}
+
+// ApproximateSize return an estimate of bytes, in keys and values,
+// held by entries between low and high, both inclusive. If low is nil
+// range starts from the first key, if high is nil range extends till
+// the last key. Disk levels are estimated from their index blocks,
+// without reading the entries. Memory levels are assumed to have the
+// same key distribution as disk levels, if there are no disk levels,
+// memory levels are estimated from the shape of their tree.
+func (bogn *Bogn) ApproximateSize(low, high []byte) int64 {
+	bogn.snaprlock()
+	defer bogn.snaprunlock()
+
+	snap := bogn.latestsnapshot()
+	if snap == nil {
+		return 0
+	}
+	defer snap.release()
+
+	rangesize, disksize := int64(0), int64(0)
+	for _, disk := range snap.disklevels([]api.Index{}) {
+		rangesize += bogn.indexrangesize(disk, low, high)
+		disksize += bogn.indexrangesize(disk, nil, nil)
+	}
+
+	// mc caches entries from disk levels, skip them.
+	size := rangesize
+	for _, index := range []api.Index{snap.mw, snap.mr} {
+		if index == nil {
+			continue
+		} else if disksize > 0 {
+			ratio := float64(rangesize) / float64(disksize)
+			size += int64(float64(bogn.indexpayload(index)) * ratio)
+			continue
+		}
+		size += bogn.indexrangesize(index, low, high)
+	}
+	return size
+}
+
 // Disksnapshots return a iterator to iterate on disk snapshots. Until
 // the iterator is closed, by calling diskiterator(true /*fin*/), all
 // write operations will be blocked. Caller can iterate until a nil is
@@ -886,9 +1060,14 @@ func (bogn *Bogn) Disksnapshots() func(fin bool) api.Disksnapshot {
 // it might increase the memory pressure on the system. Concurrent
 // transactions are allowed, and serialized internally.
 func (bogn *Bogn) BeginTxn(id uint64) api.Transactor {
+	bogn.refusewrite()
+	// if stalls are not blocking, writes are refused during commit.
+	stallerr := bogn.stallwrite(bogn.stallblock)
 	bogn.snaprlock()
 	if snap := bogn.latestsnapshot(); snap != nil {
 		txn := bogn.gettxn(id, bogn, snap)
+		txn.stallerr = stallerr
+		bogn.tracker.Begin(txn, txn.id, "txn", 1)
 		return txn
 	}
 	return nil
@@ -916,11 +1095,20 @@ func (bogn *Bogn) View(id uint64) api.Transactor {
 	bogn.snaprlock()
 	if snap := bogn.latestsnapshot(); snap != nil {
 		view := bogn.getview(id, bogn, snap)
+		bogn.tracker.Begin(view, view.id, "view", 1)
 		return view
 	}
 	return nil
 }
 
+// sweeptxns log transactions and views that are active beyond
+// txnmaxage, pinning older snapshots.
+func (bogn *Bogn) sweeptxns() {
+	for _, msg := range bogn.tracker.Sweep() {
+		warnf("%v %v", bogn.logprefix, msg)
+	}
+}
+
 func (bogn *Bogn) abortview(view *View) error {
 	view.snap.release()
 	bogn.putview(view)
@@ -933,6 +1121,7 @@ func (bogn *Bogn) abortview(view *View) error {
 // the oldest and top-most disk level, provided the highest seqno stored
 // in that level is less that `seqno`.
 func (bogn *Bogn) TombstonePurge() {
+	bogn.refusewrite()
 	tombstonepurge(bogn)
 }
 
@@ -940,9 +1129,142 @@ func (bogn *Bogn) TombstonePurge() {
 // Applications can supply appdata that will be stored as part of the
 // lastest snapshot.
 func (bogn *Bogn) Commit(appdata []byte) {
+	bogn.refusewrite()
 	postcommit(bogn, appdata)
 }
 
+// Checkpoint will flush latest mutations to disk and hard-link, or
+// copy if dir is on a different file-system, disk levels from latest
+// snapshot into dir, along with a manifest of checkpointed levels.
+// Checkpoint can be opened using New(), with the same name, by
+// pointing `bubt.diskpaths` to dir. Only durable instances can be
+// checkpointed.
+func (bogn *Bogn) Checkpoint(dir string) error {
+	if bogn.readonly {
+		return api.ErrorReadonly
+	} else if bogn.durable == false {
+		return fmt.Errorf("cannot checkpoint non-durable index %q", bogn.name)
+	}
+	return postcheckpoint(bogn, dir)
+}
+
+// SetRatelimit to change the rate, in bytes per second, at which
+// background flush and compaction can write to disk. ZERO disables
+// throttling.
+func (bogn *Bogn) SetRatelimit(rate int64) {
+	bogn.ratelimiter.SetRate(rate)
+	infof("%v ratelimit set to %v bytes/sec", bogn.logprefix, rate)
+}
+
+// Ratelimitstats return statistics on throttled background writes,
+// refer to bubt.Ratelimiter.Stats for details.
+func (bogn *Bogn) Ratelimitstats() s.Settings {
+	return bogn.ratelimiter.Stats()
+}
+
+// Stats return statistics on bogn index, durations are in nanoseconds
+// and sizes are in bytes. Statistics on disk levels are listed under
+// "levels", latest level first, with "level", "version", "id",
+// "count", "footprint", "payload", "minseqno", "maxseqno" and "age",
+// in seconds since level's latest flush. Since disk levels don't
+// remember their minimum seqno, minseqno is computed from next older
+// level. Other statistics:
+//
+// "dgmstate", 1 if index is in disk-greater-than-memory mode.
+// "seqno", latest sequence number.
+// "mw.heap", "mr.heap", "mc.heap", footprint of memory levels.
+// "mw.count", number of entries in write level.
+// "memheap", total footprint of all memory levels.
+// "n_persists", "persisttime", levels built with full set in memory.
+// "n_flushes", "flushtime", levels built by flushing memory levels.
+// "n_compacts", "compacttime", levels built by compacting disk levels.
+// "wramplification", total bytes written to disk.
+// "wramplification.ratio", bytes written per byte of disk payload.
+// "rdamplification", number of levels a lookup might have to read.
+// "n_filtdropped", "n_filtchanged", entries filtered by compaction.
+// "ratelimit", refer to Ratelimitstats().
+// "stall", refer to Stallstats().
+// "txns", active transactions and views, refer to "txnmaxage" settings.
+// "failed", 1 if log has failed and index is turned read-only.
+func (bogn *Bogn) Stats() map[string]interface{} {
+	stats := map[string]interface{}{
+		"dgmstate":        atomic.LoadInt64(&bogn.dgmstate),
+		"failed":          atomic.LoadInt64(&bogn.failed),
+		"n_persists":      atomic.LoadInt64(&bogn.npersists),
+		"persisttime":     atomic.LoadInt64(&bogn.persisttime),
+		"n_flushes":       atomic.LoadInt64(&bogn.nflushes),
+		"flushtime":       atomic.LoadInt64(&bogn.flushtime),
+		"n_compacts":      atomic.LoadInt64(&bogn.ncompacts),
+		"compacttime":     atomic.LoadInt64(&bogn.compacttime),
+		"wramplification": atomic.LoadInt64(&bogn.wramplification),
+		"n_filtdropped":   atomic.LoadInt64(&bogn.nfiltdropped),
+		"n_filtchanged":   atomic.LoadInt64(&bogn.nfiltchanged),
+		"ratelimit":       map[string]interface{}(bogn.Ratelimitstats()),
+		"stall":           map[string]interface{}(bogn.Stallstats()),
+		"txns":            bogn.tracker.Stats(),
+	}
+
+	bogn.snaprlock()
+	defer bogn.snaprunlock()
+
+	snap := bogn.latestsnapshot()
+	if snap == nil {
+		return stats
+	}
+	defer snap.release()
+
+	stats["seqno"] = snap.mwseqno()
+	if replication := bogn.replstats(snap.mwseqno()); replication != nil {
+		stats["replication"] = replication
+	}
+	stats["mw.heap"] = bogn.indexfootprint(snap.mw)
+	stats["mr.heap"] = bogn.indexfootprint(snap.mr)
+	stats["mc.heap"] = bogn.indexfootprint(snap.mc)
+	stats["mw.count"] = bogn.indexcount(snap.mw)
+	stats["memheap"] = snap.memheap()
+
+	rdamplification := 0
+	for _, index := range []api.Index{snap.mw, snap.mr, snap.mc} {
+		if index != nil {
+			rdamplification++
+		}
+	}
+
+	disks := snap.disklevels([]api.Index{})
+	levels, payload := make([]map[string]interface{}, 0, len(disks)), int64(0)
+	now := time.Now()
+	for i, disk := range disks {
+		level, version, _ := bogn.path2level(disk.ID())
+		minseqno := uint64(0)
+		if i < len(disks)-1 {
+			minseqno = bogn.getdiskseqno(disks[i+1]) + 1
+		}
+		flushunix := strings.Trim(bogn.getflushunix(disk), `"`)
+		x, _ := strconv.Atoi(flushunix)
+		levels = append(levels, map[string]interface{}{
+			"level":     level,
+			"version":   version,
+			"id":        disk.ID(),
+			"count":     bogn.indexcount(disk),
+			"footprint": bogn.indexfootprint(disk),
+			"payload":   bogn.indexpayload(disk),
+			"minseqno":  minseqno,
+			"maxseqno":  bogn.getdiskseqno(disk),
+			"age":       int64(now.Sub(time.Unix(int64(x), 0)).Seconds()),
+		})
+		payload += bogn.indexpayload(disk)
+		rdamplification++
+	}
+	stats["levels"] = levels
+	stats["rdamplification"] = rdamplification
+	stats["wramplification.ratio"] = float64(0)
+	if payload > 0 {
+		ratio := float64(stats["wramplification"].(int64)) / float64(payload)
+		stats["wramplification.ratio"] = ratio
+	}
+	return stats
+}
+
 // Log vital statistics for all active bogn levels.
 func (bogn *Bogn) Log() {
 	bogn.snaprlock()
@@ -962,6 +1284,11 @@ func (bogn *Bogn) Log() {
 		bogn.logstore(disk)
 	}
 	snap.release()
+
+	stats := bogn.ratelimiter.Stats()
+	fmsg := "%v ratelimit %v bytes/sec, throttled %v times for %v"
+	throttled := time.Duration(stats.Int64("throttled"))
+	infof(fmsg, bogn.logprefix, stats["rate"], stats["n_throttles"], throttled)
 }
 
 // Validate active bogn levels.
@@ -1010,7 +1337,12 @@ func (bogn *Bogn) validatedisklevel(
 
 // Close this instance, no calls allowed after Close.
 func (bogn *Bogn) Close() {
-	if bogn.autocommit == 0 {
+	if bogn.readonly {
+		bogn.closereadonly()
+		return
+	}
+	following := bogn.stopreplication()
+	if bogn.autocommit == 0 && following == false {
 		if snap := bogn.currsnapshot(); snap.isdirty() {
 			panic("commit before close")
 		}
@@ -1025,6 +1357,9 @@ func (bogn *Bogn) Close() {
 
 	bogn.logstatistics("close")
 
+	// all mutations are flushed to disk, by dowindup, close the log.
+	bogn.wal.close()
+
 	// check whether all mutations are flushed to disk.
 	snap := bogn.currsnapshot()
 	mwseqno, disks := bogn.indexseqno(snap.mw), snap.disklevels([]api.Index{})
@@ -1057,6 +1392,10 @@ func (bogn *Bogn) Close() {
 
 // Destroy the disk snapshots of this instance, no calls allowed after Destroy.
 func (bogn *Bogn) Destroy() {
+	// index turned read-only on log failure can still be destroyed.
+	if bogn.readonly || atomic.LoadInt64(&bogn.following) == 1 {
+		panic(api.ErrorReadonly)
+	}
 	diskpaths := bogn.getdiskpaths()
 	bogn.destroydisksnaps("destory", bogn.logpath, bogn.diskstore, diskpaths)
 	infof("%v destroyed ...", bogn.logprefix)
@@ -1081,13 +1420,24 @@ func (bogn *Bogn) Get(key, value []byte) (v []byte, cas uint64, del, ok bool) {
 // reaching end of table (io.EOF), application should call iterator
 // with fin as true. EG: iter(true)
 func (bogn *Bogn) Scan() api.Iterator {
+	return bogn.ScanRange(nil, nil, false)
+}
+
+// ScanRange return an iterator from low key, inclusive, upto high
+// key. If inclusive is true, entry with high key is also included.
+// If low is nil, iteration starts from first entry, and if high is
+// nil, iteration continues till last entry. Range is applied on
+// every level, hence disk levels stop reading beyond the high key.
+// If iteration is stopped before reaching the end of range (io.EOF),
+// application should call iterator with fin as true. EG: iter(true)
+func (bogn *Bogn) ScanRange(low, high []byte, inclusive bool) api.Iterator {
 	var key, value []byte
 	var seqno uint64
 	var del bool
 	var err error
 
 	snap := bogn.latestsnapshot()
-	iter := snap.iterator()
+	iter := snap.iterator(low, high, inclusive)
 	return func(fin bool) ([]byte, []byte, uint64, bool, error) {
 		if err == io.EOF {
 			return nil, nil, 0, false, err
@@ -1111,30 +1461,112 @@ func (bogn *Bogn) Scan() api.Iterator {
 	}
 }
 
-// ScanEntries is not supported by Bogn.
+// ScanEntries return a full table iterator on index entries, merged
+// across all the levels. Entries from disk levels return Valueref()
+// for values held in value logs. Note that returned IndexEntry is
+// valid only till the next call to the iterator. If iteration is
+// stopped before reaching io.EOF, application should call iterator
+// with fin as true. EG: itere(true)
 func (bogn *Bogn) ScanEntries() api.EntryIterator {
-	panic("unsupported API")
+	var entry api.IndexEntry
+	var err error
+
+	eof := neweofentry()
+	snap := bogn.latestsnapshot()
+	itere := snap.entryiterator()
+	return func(fin bool) api.IndexEntry {
+		if err == io.EOF {
+			return eof
+
+		} else if itere == nil {
+			err = io.EOF
+			snap.release()
+			return eof
+
+		} else if fin {
+			itere(fin) // close all underlying iterations.
+			err = io.EOF
+			snap.release()
+			return eof
+		}
+		entry = itere(fin)
+		if _, _, _, err = entry.Key(); err == io.EOF {
+			itere(true /*fin*/)
+			snap.release()
+		}
+		return entry
+	}
 }
 
 //---- Exported write methods
 
 // Set a key, value pair in the index, if key is already present, its value
 // will be over-written. Make sure key is not nil. Return old value if
-// oldvalue points to valid buffer.
+// oldvalue points to valid buffer. If mutation could not be logged, index
+// is turned read-only, refer to Stats()["failed"].
 func (bogn *Bogn) Set(key, value, oldvalue []byte) (ov []byte, cas uint64) {
+	var ticket int64
+	var err error
+
+	bogn.refusewrite()
+	bogn.stallwrite(true /*block*/)
+
 	bogn.snaprlock()
-	ov, cas = bogn.currsnapshot().set(key, value, oldvalue)
+	if bogn.wal == nil {
+		ov, cas = bogn.currsnapshot().set(key, value, oldvalue)
+	} else {
+		bogn.walock()
+		ov, cas = bogn.currsnapshot().set(key, value, oldvalue)
+		ticket, err = bogn.wal.logset(key, value, cas)
+		bogn.walunlock()
+	}
 	bogn.snaprunlock()
+	bogn.waitsync(ticket, err)
+	return ov, cas
+}
+
+// SetExpiry is same as Set, additionally the entry shall expire at
+// `expiry`, unix time in seconds. Expired entries are reported as
+// deleted, without value, and purged while compacting the disk levels.
+// If expiry is ZERO, entry never expires.
+func (bogn *Bogn) SetExpiry(
+	key, value, oldvalue []byte, expiry int64) (ov []byte, cas uint64) {
+
+	var ticket int64
+	var err error
+
+	bogn.refusewrite()
+	bogn.stallwrite(true /*block*/)
+
+	bogn.snaprlock()
+	if bogn.wal == nil {
+		ov, cas = bogn.currsnapshot().setexpiry(key, value, oldvalue, expiry)
+	} else {
+		bogn.walock()
+		ov, cas = bogn.currsnapshot().setexpiry(key, value, oldvalue, expiry)
+		ticket, err = bogn.wal.logsetexpiry(key, value, expiry, cas)
+		bogn.walunlock()
+	}
+	bogn.snaprunlock()
+	bogn.waitsync(ticket, err)
 	return ov, cas
 }
 
 // SetCAS a key, value pair in the index, if CAS is ZERO then key should
 // not be present in the index, otherwise existing CAS should match the
 // supplied CAS. Value will be over-written. Make sure key is not nil.
-// Return old value if oldvalue points to valid buffer.
+// Return old value if oldvalue points to valid buffer. Return error if
+// mutation could not be logged, and turn the index read-only.
 func (bogn *Bogn) SetCAS(
 	key, value, oldvalue []byte, cas uint64) ([]byte, uint64, error) {
 
+	if bogn.isreadonly() {
+		return oldvalue, 0, api.ErrorReadonly
+	}
+	if err := bogn.stallwrite(bogn.stallblock); err != nil {
+		atomic.AddInt64(&bogn.nstallerrors, 1)
+		return oldvalue, 0, err
+	}
 	ov, rccas, err, ok := bogn.setcasMem(key, value, oldvalue, cas)
 	if ok {
 		return ov, rccas, err
@@ -1160,16 +1592,31 @@ func (bogn *Bogn) setcasMem(
 
 	var ov []byte
 	var rccas uint64
-	var err error
+	var ticket int64
+	var err, lerr error
 
 	ok := false
 
 	bogn.snaprlock()
 	if atomic.LoadInt64(&bogn.dgmstate) == 0 {
-		ov, rccas, err = bogn.currsnapshot().setCAS(key, value, oldvalue, cas)
+		if bogn.wal == nil {
+			snap := bogn.currsnapshot()
+			ov, rccas, err = snap.setCAS(key, value, oldvalue, cas)
+		} else {
+			bogn.walock()
+			snap := bogn.currsnapshot()
+			ov, rccas, err = snap.setCAS(key, value, oldvalue, cas)
+			if err == nil {
+				ticket, lerr = bogn.wal.logset(key, value, rccas)
+			}
+			bogn.walunlock()
+		}
 		ok = true
 	}
 	bogn.snaprunlock()
+	if lerr = bogn.waitsync(ticket, lerr); lerr != nil {
+		err = lerr
+	}
 	return ov, rccas, err, ok
 }
 
@@ -1178,15 +1625,62 @@ func (bogn *Bogn) setcasMem(
 // as deleted. Again, if lsm is true but key is not found in index, a new
 // entry will inserted.
 func (bogn *Bogn) Delete(key, oldvalue []byte, lsm bool) ([]byte, uint64) {
+	var ov []byte
+	var cas uint64
+	var ticket int64
+	var err error
+
+	bogn.refusewrite()
+	bogn.stallwrite(true /*block*/)
 	bogn.snaprlock()
 	if atomic.LoadInt64(&bogn.dgmstate) == 1 { // auto-enable lsm in dgm
 		lsm = true
 	}
-	ov, cas := bogn.currsnapshot().delete(key, oldvalue, lsm)
+	if bogn.wal == nil {
+		ov, cas = bogn.currsnapshot().delete(key, oldvalue, lsm)
+	} else {
+		bogn.walock()
+		ov, cas = bogn.currsnapshot().delete(key, oldvalue, lsm)
+		ticket, err = bogn.wal.logdelete(key, cas, lsm)
+		bogn.walunlock()
+	}
 	bogn.snaprunlock()
+	bogn.waitsync(ticket, err)
 	return ov, cas
 }
 
+// Merge operand into key's value using the merge operator supplied
+// via "mergeoperator" setting, without reading the key's value. In
+// dgm mode, operand is stored as is and folded over older versions of
+// the key on Get, ScanRange, ScanEntries, and while flushing memory
+// to disk. Cursors on Txn and View don't fold operands. Subscribers
+// receive the operand as the value.
+func (bogn *Bogn) Merge(key, operand []byte) uint64 {
+	var cas uint64
+	var ticket int64
+	var err error
+
+	bogn.refusewrite()
+	if bogn.mergeoperator == nil {
+		panic("merge operator not configured")
+	}
+
+	bogn.stallwrite(true /*block*/)
+	bogn.snaprlock()
+	lsm := atomic.LoadInt64(&bogn.dgmstate) == 1
+	if bogn.wal == nil {
+		cas = bogn.currsnapshot().merge(key, operand, lsm)
+	} else {
+		bogn.walock()
+		cas = bogn.currsnapshot().merge(key, operand, lsm)
+		ticket, err = bogn.wal.logmerge(key, operand, cas)
+		bogn.walunlock()
+	}
+	bogn.snaprunlock()
+	bogn.waitsync(ticket, err)
+	return cas
+}
+
 //---- local methods
 
 func (bogn *Bogn) newmemstore(
@@ -1225,14 +1719,18 @@ func (bogn *Bogn) builddiskstore(
 	logprefix string,
 	level, version int, sha, flushunix string, settstodisk s.Settings,
 	itere api.EntryIterator, appendid string, valuelogs []string,
-	what string, appdata []byte) (index api.Index, err error) {
+	what string, throttle bool, appdata []byte) (index api.Index, err error) {
 
 	switch bogn.diskstore {
 	case "bubt":
+		start := time.Now()
 		index, err = bogn.builddiskbubt(
 			logprefix, level, version, sha, flushunix, settstodisk, itere,
-			appendid, valuelogs, what, appdata,
+			appendid, valuelogs, what, throttle, appdata,
 		)
+		if err == nil {
+			bogn.addbuildtime(logprefix, time.Since(start))
+		}
 		fmsg := "%v %v: new bubt snapshot %q"
 		infof(fmsg, bogn.logprefix, logprefix, index.ID())
 		return
@@ -1244,26 +1742,31 @@ func (bogn *Bogn) builddiskbubt(
 	logprefix string,
 	level, version int, sha, flushunix string, settstodisk s.Settings,
 	itere api.EntryIterator, appendid string, valuelogs []string,
-	what string, appdata []byte) (index api.Index, err error) {
+	what string, throttle bool, appdata []byte) (index api.Index, err error) {
 
-	// book-keep largest seqno for this snapshot.
+	// book-keep largest seqno for this snapshot, including entries
+	// dropped by compaction filter.
 	var diskseqno, count uint64
 	eof := &eofentry{}
 
-	wrap := func(fin bool) (entry api.IndexEntry) {
+	source := func(fin bool) (entry api.IndexEntry) {
 		if itere != nil {
 			entry = itere(fin)
-			_, seqno, _, e := entry.Key()
-			if seqno > diskseqno {
+			if _, seqno, _, _ := entry.Key(); seqno > diskseqno {
 				diskseqno = seqno
 			}
-			if e == nil {
-				count++
-			}
 			return
 		}
 		return eof
 	}
+	filtered := bogn.filteriterator(source, level)
+	wrap := func(fin bool) api.IndexEntry {
+		entry := filtered(fin)
+		if _, _, _, err := entry.Key(); err == nil {
+			count++
+		}
+		return entry
+	}
 
 	now := time.Now()
 	dirname := bogn.levelname(level, version, sha)
@@ -1280,6 +1783,16 @@ func (bogn *Bogn) builddiskbubt(
 	}
 
 	// futher configure bubt builder.
+	if _, ok := bubtsetts["bloombits"]; ok { // older settings don't have it
+		bt.BloomFilter(bubtsetts.Int64("bloombits"))
+	}
+	// expired entries can be purged only when there are no older disk
+	// levels holding previous versions of the same key.
+	bt.ExpiryPurge(bogn.islastlevel(level))
+	// only background flush and compaction are throttled.
+	if throttle {
+		bt.Ratelimit(bogn.ratelimiter)
+	}
 	if what == "compact.tombstonepurge" {
 		bt.TombstonePurge(true)
 
@@ -1292,7 +1805,12 @@ func (bogn *Bogn) builddiskbubt(
 		errorf("%v Build(): %v", bogn.logprefix, err)
 		return nil, err
 	}
-	mwmetadata := bogn.mwmetadata(diskseqno, flushunix, appdata, settstodisk)
+	// non-lsm deletes are not carried by the level, remember the latest
+	// one so that subscribers can't miss them, refer to Subscribe().
+	delseqno := bogn.snapdelseqno(bogn.currsnapshot())
+	mwmetadata := bogn.mwmetadata(
+		diskseqno, delseqno, flushunix, appdata, settstodisk,
+	)
 	if _, err = bt.Writemetadata(mwmetadata); err != nil {
 		errorf("%v Writemetadata(): %v", bogn.logprefix, err)
 		return nil, err
@@ -1324,6 +1842,18 @@ func (bogn *Bogn) builddiskbubt(
 	return ndisk, nil
 }
 
+// islastlevel return true if there are no disk levels older than level.
+func (bogn *Bogn) islastlevel(level int) bool {
+	if snap := bogn.currsnapshot(); snap != nil {
+		for _, disk := range snap.disks[level+1:] {
+			if disk != nil {
+				return false
+			}
+		}
+	}
+	return true
+}
+
 // open latest versions for each disk level
 func (bogn *Bogn) opendisksnaps(
 	setts s.Settings) (disks [16]api.Index, err error) {
@@ -1502,7 +2032,8 @@ func (bogn *Bogn) mergedisksnapshots(
 	version := diskversions[level] + 1
 	ndisk, err := bogn.builddiskstore(
 		logprefix, level, version, uuid, flushunix, disksetts, itere,
-		"" /*appendid*/, nil /*valuelogs*/, "offlinemerge", appdata,
+		"" /*appendid*/, nil /*valuelogs*/, "offlinemerge", false /*throttle*/,
+		appdata,
 	)
 	if err != nil {
 		return err
@@ -1588,6 +2119,127 @@ func (bogn *Bogn) destroybubtsnaps(logprefix string, diskpaths []string) error {
 	return nil
 }
 
+// checkpoint disk levels into dir and write the manifest, manifest
+// is written last so that its presence marks a complete checkpoint.
+func (bogn *Bogn) checkpointdisks(dir string, disks []api.Index) error {
+	if err := os.MkdirAll(dir, 0775); err != nil {
+		errorf("%v checkpoint.MkdirAll(): %v", bogn.logprefix, err)
+		return err
+	}
+
+	var seqno uint64
+	names := []string{}
+	for _, disk := range disks {
+		switch bogn.diskstore {
+		case "bubt":
+			if err := bogn.checkpointbubt(dir, disk); err != nil {
+				return err
+			}
+		default:
+			panic("impossible situation")
+		}
+		if dseqno := bogn.getdiskseqno(disk); dseqno > seqno {
+			seqno = dseqno
+		}
+		names = append(names, disk.ID())
+	}
+	return bogn.writemanifest(dir, names, seqno)
+}
+
+// writemanifest of disk levels, identified by names, relocated into
+// dir, either by checkpoint or by bootstrapping a follower.
+func (bogn *Bogn) writemanifest(
+	dir string, names []string, seqno uint64) error {
+
+	levels := []interface{}{}
+	for _, name := range names {
+		level, version, _ := bogn.path2level(name)
+		levels = append(levels, map[string]interface{}{
+			"name": name, "level": level, "version": version,
+		})
+	}
+	manifest := map[string]interface{}{
+		"name":   bogn.name,
+		"seqno":  strconv.FormatUint(seqno, 10),
+		"levels": levels,
+	}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		panic(err)
+	}
+	filename := filepath.Join(dir, bogn.checkpointfile())
+	tmpfile := filename + ".tmp"
+	if err := ioutil.WriteFile(tmpfile, data, 0664); err != nil {
+		errorf("%v checkpoint.WriteFile(): %v", bogn.logprefix, err)
+		return err
+	} else if err := os.Rename(tmpfile, filename); err != nil {
+		errorf("%v checkpoint.Rename(): %v", bogn.logprefix, err)
+		return err
+	}
+	return nil
+}
+
+// link or copy all files of bubt snapshot, from all diskpaths, into
+// a single directory under dir.
+func (bogn *Bogn) checkpointbubt(dir string, disk api.Index) error {
+	ndir := filepath.Join(dir, disk.ID())
+	if err := os.MkdirAll(ndir, 0775); err != nil {
+		errorf("%v checkpoint.MkdirAll(): %v", bogn.logprefix, err)
+		return err
+	}
+	for _, path := range bogn.getdiskpaths() {
+		odir := filepath.Join(path, disk.ID())
+		fis, err := ioutil.ReadDir(odir)
+		if err != nil && os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			errorf("%v checkpoint.ReadDir(): %v", bogn.logprefix, err)
+			return err
+		}
+		for _, fi := range fis {
+			if fi.IsDir() || filepath.Ext(fi.Name()) != ".data" {
+				continue // skip lock files.
+			}
+			oldfile := filepath.Join(odir, fi.Name())
+			newfile := filepath.Join(ndir, fi.Name())
+			if err := linkorcopy(oldfile, newfile); err != nil {
+				errorf("%v checkpoint %q: %v", bogn.logprefix, oldfile, err)
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (bogn *Bogn) checkpointfile() string {
+	return fmt.Sprintf("bogn-%v-checkpoint.json", bogn.name)
+}
+
+// ischeckpointed return true if disk is one of the levels listed in
+// a checkpoint manifest under diskpaths.
+func (bogn *Bogn) ischeckpointed(disk api.Index) bool {
+	for _, path := range bogn.getdiskpaths() {
+		filename := filepath.Join(path, bogn.checkpointfile())
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			continue
+		}
+		manifest := map[string]interface{}{}
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			errorf("%v checkpoint %q: %v", bogn.logprefix, filename, err)
+			continue
+		}
+		levels, _ := manifest["levels"].([]interface{})
+		for _, level := range levels {
+			level, _ := level.(map[string]interface{})
+			if name, _ := level["name"].(string); name == disk.ID() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // release resources held in disk levels.
 func (bogn *Bogn) closelevels(indexes ...api.Index) {
 	for _, index := range indexes {
@@ -1650,6 +2302,16 @@ func (bogn *Bogn) getdiskseqno(disk api.Index) uint64 {
 	return metadata["seqno"].(uint64)
 }
 
+// getdiskdelseqno return seqno of the latest non-lsm delete applied on
+// the index before disk was built.
+func (bogn *Bogn) getdiskdelseqno(disk api.Index) uint64 {
+	metadata := bogn.diskmetadata(disk)
+	if delseqno, ok := metadata["delseqno"].(uint64); ok {
+		return delseqno
+	}
+	return 0
+}
+
 func (bogn *Bogn) getflushunix(disk api.Index) string {
 	metadata := bogn.diskmetadata(disk)
 	return metadata["flushunix"].(string)
@@ -1750,6 +2412,20 @@ func (bogn *Bogn) indexpayload(index api.Index) int64 {
 		return 0
 	}
 	switch idx := index.(type) {
+	case *llrb.LLRB:
+		if idx == nil {
+			return 0
+		}
+		stats := idx.Stats()
+		return stats["keymemory"].(int64) + stats["valmemory"].(int64)
+
+	case *llrb.MVCC:
+		if idx == nil {
+			return 0
+		}
+		stats := idx.Stats()
+		return stats["keymemory"].(int64) + stats["valmemory"].(int64)
+
 	case *bubt.Snapshot:
 		if idx == nil {
 			return 0
@@ -1762,6 +2438,99 @@ func (bogn *Bogn) indexpayload(index api.Index) int64 {
 	panic("unreachable code")
 }
 
+// return number of entries marked as deleted in index.
+// return seqno of the latest non-lsm delete applied on index.
+func (bogn *Bogn) indexdelseqno(index api.Index) uint64 {
+	switch idx := index.(type) {
+	case *llrb.LLRB:
+		if idx == nil {
+			return 0
+		}
+		return idx.Stats()["delseqno"].(uint64)
+
+	case *llrb.MVCC:
+		if idx == nil {
+			return 0
+		}
+		return idx.Stats()["delseqno"].(uint64)
+
+	case *bubt.Snapshot:
+		if idx == nil {
+			return 0
+		}
+		return bogn.getdiskdelseqno(idx)
+	}
+	panic("unreachable code")
+}
+
+// return seqno of the latest non-lsm delete applied on snapshot's
+// write store, flushed store and disk levels.
+func (bogn *Bogn) snapdelseqno(snap *snapshot) uint64 {
+	if snap == nil {
+		return 0
+	}
+	indexes := []api.Index{}
+	for _, index := range []api.Index{snap.mw, snap.mr} {
+		if index != nil {
+			indexes = append(indexes, index)
+		}
+	}
+	delseqno := uint64(0)
+	for _, index := range snap.disklevels(indexes) {
+		if seqno := bogn.indexdelseqno(index); seqno > delseqno {
+			delseqno = seqno
+		}
+	}
+	return delseqno
+}
+
+func (bogn *Bogn) indexdeleted(index api.Index) int64 {
+	switch idx := index.(type) {
+	case *llrb.LLRB:
+		if idx == nil {
+			return 0
+		}
+		return idx.Stats()["n_deleted"].(int64)
+
+	case *llrb.MVCC:
+		if idx == nil {
+			return 0
+		}
+		return idx.Stats()["n_deleted"].(int64)
+
+	case *bubt.Snapshot:
+		if idx == nil {
+			return 0
+		}
+		return idx.Info().Int64("n_deleted")
+	}
+	panic("unreachable code")
+}
+
+// return approximate size of keys and values between low and high.
+func (bogn *Bogn) indexrangesize(index api.Index, low, high []byte) int64 {
+	switch idx := index.(type) {
+	case *bubt.Snapshot:
+		if idx == nil {
+			return 0
+		}
+		return idx.ApproximateSize(low, high)
+
+	case *llrb.LLRB:
+		if idx == nil {
+			return 0
+		}
+		return idx.ApproximateSize(low, high)
+
+	case *llrb.MVCC:
+		if idx == nil {
+			return 0
+		}
+		return idx.ApproximateSize(low, high)
+	}
+	panic("unreachable code")
+}
+
 // return the oldest snapshots value-logs.
 func (bogn *Bogn) indexvaluelogs(disks []api.Index) (string, []string) {
 	if len(disks) == 0 {
@@ -1829,6 +2598,15 @@ func (bogn *Bogn) diskmetadata(disk api.Index) map[string]interface{} {
 		}
 		metadata["seqno"] = seqno
 
+		// cure `delseqno`, older levels don't have it.
+		if val, ok := metadata["delseqno"].(string); ok {
+			delseqno, err := strconv.ParseUint(strings.Trim(val, `"`), 10, 64)
+			if err != nil {
+				panic(err)
+			}
+			metadata["delseqno"] = delseqno
+		}
+
 		// cure memversions
 		mvers := metadata["memversions"].([]interface{})
 		metadata["memversions"] = [3]int{
@@ -1855,6 +2633,22 @@ func (bogn *Bogn) addamplification(ndisk api.Index) {
 	}
 }
 
+// addbuildtime account time taken to build a disk level, by persist,
+// flush or compaction.
+func (bogn *Bogn) addbuildtime(logprefix string, took time.Duration) {
+	switch logprefix {
+	case "dopersist":
+		atomic.AddInt64(&bogn.npersists, 1)
+		atomic.AddInt64(&bogn.persisttime, int64(took))
+	case "doflush":
+		atomic.AddInt64(&bogn.nflushes, 1)
+		atomic.AddInt64(&bogn.flushtime, int64(took))
+	case "startdisk":
+		atomic.AddInt64(&bogn.ncompacts, 1)
+		atomic.AddInt64(&bogn.compacttime, int64(took))
+	}
+}
+
 func (bogn *Bogn) newuuid() string {
 	uuid, err := lib.Newuuid(make([]byte, 8))
 	if err != nil {
@@ -1867,6 +2661,12 @@ func (bogn *Bogn) newuuid() string {
 func (bogn *Bogn) logstatistics(logprefix string) {
 	n := humanize.Bytes(uint64(atomic.LoadInt64(&bogn.wramplification)))
 	infof("%v %v: write amplifications %v", bogn.logprefix, logprefix, n)
+	if bogn.compactionfilter != nil {
+		x := atomic.LoadInt64(&bogn.nfiltdropped)
+		y := atomic.LoadInt64(&bogn.nfiltchanged)
+		fmsg := "%v %v: compaction filter dropped %v changed %v entries"
+		infof(fmsg, bogn.logprefix, logprefix, x, y)
+	}
 }
 
 func (bogn *Bogn) isappendvlogs(
@@ -1885,3 +2685,28 @@ func (bogn *Bogn) isappendvlogs(
 	ok = ok || what == "compact.period"
 	return ok
 }
+
+// linkorcopy hard-link oldfile as newfile, if they are on different
+// file-systems fallback to copy.
+func linkorcopy(oldfile, newfile string) error {
+	err := os.Link(oldfile, newfile)
+	if err == nil || os.IsExist(err) {
+		return err
+	}
+
+	rfd, err := os.Open(oldfile)
+	if err != nil {
+		return err
+	}
+	defer rfd.Close()
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	wfd, err := os.OpenFile(newfile, flags, 0664)
+	if err != nil {
+		return err
+	}
+	defer wfd.Close()
+	if _, err = io.Copy(wfd, rfd); err != nil {
+		return err
+	}
+	return wfd.Sync()
+}
@@ -1,14 +1,22 @@
 package bogn
 
 import "io"
+import "os"
 import "fmt"
+import "bytes"
+import "strconv"
 import "testing"
 import "time"
 import "sync"
 import "sync/atomic"
+import "net"
 import "math/rand"
+import "path/filepath"
+import "encoding/binary"
 
+import "github.com/bnclabs/gostore/api"
 import "github.com/bnclabs/gostore/llrb"
+import "github.com/bnclabs/gostore/bubt"
 
 func TestReload(t *testing.T) {
 	destoryindex("index", makepaths())
@@ -236,6 +244,450 @@ func TestReloadDurable(t *testing.T) {
 	t.Logf("re-reload and iteration successful")
 }
 
+func TestScanRange(t *testing.T) {
+	destoryindex("index", makepaths())
+
+	mindex := llrb.NewLLRB("mindex", llrb.Defaultsettings())
+	defer mindex.Destroy()
+	setts, paths := makesettings(), makepaths()
+	setts["bubt.diskpaths"] = paths
+	setts["autocommit"] = 1
+	index, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+
+	// first half shall be flushed to disk, and second half in memory.
+	n := 10000
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key%08d", rand.Intn(n)*2))
+		val := []byte(fmt.Sprintf("val%08d", i))
+		mindex.Set(key, val, nil)
+		index.Set(key, val, nil)
+		if i%10 == 0 {
+			mindex.Delete(key, nil, true /*lsm*/)
+			index.Delete(key, nil, true /*lsm*/)
+		}
+		if i == n/2 {
+			time.Sleep(2 * time.Second)
+		}
+	}
+
+	// wait for mvcc snapshot to catch up with the tip.
+	w := time.Duration(setts.Int64("llrb.snapshottick")) * time.Millisecond
+	time.Sleep(w * 100)
+
+	mkkey := func(i int) []byte {
+		return []byte(fmt.Sprintf("key%08d", i))
+	}
+	for i := 0; i < 100; i++ {
+		x, y := rand.Intn(2*n), rand.Intn(2*n)
+		low, high, incl := mkkey(x), mkkey(y), x%2 == 0
+		if i%10 == 0 {
+			low = nil
+		} else if i%10 == 1 {
+			high = nil
+		}
+
+		miter := mindex.ScanRange(low, high, incl)
+		iter := index.ScanRange(low, high, incl)
+		key1, val1, seqno1, del1, err1 := miter(false /*fin*/)
+		key2, val2, seqno2, del2, err2 := iter(false /*fin*/)
+		for err1 == nil && err2 == nil {
+			if string(key1) != string(key2) {
+				t.Fatalf("expected %q, got %q", key1, key2)
+			} else if seqno1 != seqno2 {
+				t.Errorf("%q expected %v, got %v", key1, seqno1, seqno2)
+			} else if del1 != del2 {
+				t.Errorf("%q expected %v, got %v", key1, del1, del2)
+			} else if del1 == false && string(val1) != string(val2) {
+				t.Errorf("%q expected %q, got %q", key1, val1, val2)
+			}
+			key1, val1, seqno1, del1, err1 = miter(false /*fin*/)
+			key2, val2, seqno2, del2, err2 = iter(false /*fin*/)
+		}
+		if err1 != io.EOF || err2 != io.EOF {
+			t.Errorf("%q-%q unexpected %v %v", low, high, err1, err2)
+		}
+		miter(true /*fin*/)
+		iter(true /*fin*/)
+	}
+
+	index.Close()
+	index.Destroy()
+}
+
+func TestScanEntries(t *testing.T) {
+	destoryindex("index", makepaths())
+
+	mindex := llrb.NewLLRB("mindex", llrb.Defaultsettings())
+	defer mindex.Destroy()
+	setts, paths := makesettings(), makepaths()
+	setts["bubt.diskpaths"] = paths
+	setts["dgm"] = true
+	setts["bubt.vblocksize"] = 4096
+	setts["autocommit"] = 1
+	index, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+
+	// first half shall be flushed to disk, and second half in memory.
+	n := 10000
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key%08d", rand.Intn(n)*2))
+		val := []byte(fmt.Sprintf("val%08d", i))
+		mindex.Set(key, val, nil)
+		index.Set(key, val, nil)
+		if i%10 == 0 {
+			mindex.Delete(key, nil, true /*lsm*/)
+			index.Delete(key, nil, true /*lsm*/)
+		}
+		if i == n/2 {
+			time.Sleep(2 * time.Second)
+		}
+	}
+
+	// wait for mvcc snapshot to catch up with the tip.
+	w := time.Duration(setts.Int64("llrb.snapshottick")) * time.Millisecond
+	time.Sleep(w * 100)
+
+	compare := func(mitere, itere api.EntryIterator) {
+		count, nvlogs := 0, 0
+		entry1, entry2 := mitere(false /*fin*/), itere(false /*fin*/)
+		key1, seqno1, del1, err1 := entry1.Key()
+		key2, seqno2, del2, err2 := entry2.Key()
+		for err1 == nil && err2 == nil {
+			if string(key1) != string(key2) {
+				t.Fatalf("expected %q, got %q", key1, key2)
+			} else if seqno1 != seqno2 {
+				t.Errorf("%q expected %v, got %v", key1, seqno1, seqno2)
+			} else if del1 != del2 {
+				t.Errorf("%q expected %v, got %v", key1, del1, del2)
+			} else if v1, v2 := entry1.Value(), entry2.Value(); !del1 {
+				if string(v1) != string(v2) {
+					t.Errorf("%q expected %q, got %q", key1, v1, v2)
+				}
+			}
+			if _, vlogpos := entry2.Valueref(); vlogpos >= 0 {
+				nvlogs++
+			}
+			count++
+			entry1, entry2 = mitere(false /*fin*/), itere(false /*fin*/)
+			key1, seqno1, del1, err1 = entry1.Key()
+			key2, seqno2, del2, err2 = entry2.Key()
+		}
+		if err1 != io.EOF || err2 != io.EOF {
+			t.Errorf("unexpected %v %v", err1, err2)
+		} else if x := mindex.Count(); int64(count) != x {
+			t.Errorf("expected %v, got %v", x, count)
+		}
+		t.Logf("compared %v entries, %v in value log", count, nvlogs)
+	}
+
+	compare(mindex.ScanEntries(), index.ScanEntries())
+
+	// export bogn to bubt and verify.
+	name, bpaths := "scanentries", setts.Strings("bubt.diskpaths")
+	bt, err := bubt.NewBubt(name, bpaths, 4096, 4096, 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	itere := index.ScanEntries()
+	if err := bt.Build(itere, []byte("export")); err != nil {
+		t.Fatal(err)
+	}
+	itere(true /*fin*/)
+	bt.Close()
+	snap, err := bubt.OpenSnapshot(name, bpaths, false /*mmap*/)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compare(mindex.ScanEntries(), snap.ScanEntries())
+	snap.Close()
+	snap.Destroy()
+
+	// iteration stopped half way shall release the snapshot.
+	itere = index.ScanEntries()
+	itere(false /*fin*/)
+	itere(true /*fin*/)
+
+	index.Close()
+	index.Destroy()
+}
+
+func TestBloomGet(t *testing.T) {
+	destoryindex("index", makepaths())
+
+	mindex := llrb.NewLLRB("mindex", llrb.Defaultsettings())
+	defer mindex.Destroy()
+	setts, paths := makesettings(), makepaths()
+	setts["bubt.diskpaths"] = paths
+	setts["bubt.bloombits"] = 10
+	setts["dgm"] = true
+	setts["autocommit"] = 1
+	index, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+
+	n := 10000
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key%08d", i))
+		val := []byte(fmt.Sprintf("val%08d", i))
+		mindex.Set(key, val, nil)
+		index.Set(key, val, nil)
+		if i == n/2 {
+			time.Sleep(2 * time.Second)
+		}
+	}
+
+	nblooms := 0
+	snap := index.latestsnapshot()
+	for _, disk := range snap.disklevels([]api.Index{}) {
+		if disk.(*bubt.Snapshot).Info().Int64("bloomsize") > 0 {
+			nblooms++
+		}
+	}
+	snap.release()
+	if nblooms == 0 {
+		t.Errorf("expected bloom filter on disk levels")
+	}
+
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key%08d", i))
+		refval, _, _, _ := mindex.Get(key, []byte{})
+		val, _, _, ok := index.Get(key, []byte{})
+		if ok == false {
+			t.Errorf("missing key %q", key)
+		} else if string(val) != string(refval) {
+			t.Errorf("%q expected %q, got %q", key, refval, val)
+		}
+		key = []byte(fmt.Sprintf("miss%08d", i))
+		if _, _, _, ok := index.Get(key, []byte{}); ok {
+			t.Errorf("unexpected key %q", key)
+		}
+	}
+
+	index.Close()
+	index.Destroy()
+}
+
+func TestCursorGetPrev(t *testing.T) {
+	destoryindex("index", makepaths())
+
+	mindex := llrb.NewLLRB("mindex", llrb.Defaultsettings())
+	defer mindex.Destroy()
+	setts, paths := makesettings(), makepaths()
+	setts["bubt.diskpaths"] = paths
+	setts["autocommit"] = 1
+	index, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+
+	// first half shall be flushed to disk, and second half in memory.
+	n := 10000
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key%08d", rand.Intn(n)*2))
+		val := []byte(fmt.Sprintf("val%08d", i))
+		mindex.Set(key, val, nil)
+		index.Set(key, val, nil)
+		if i%10 == 0 {
+			mindex.Delete(key, nil, true /*lsm*/)
+			index.Delete(key, nil, true /*lsm*/)
+		}
+		if i == n/2 {
+			time.Sleep(2 * time.Second)
+		}
+	}
+
+	// wait for mvcc snapshot to catch up with the tip.
+	w := time.Duration(setts.Int64("llrb.snapshottick")) * time.Millisecond
+	time.Sleep(w * 100)
+
+	walk := func(key []byte, moves []int) {
+		mview, view := mindex.View(0), index.View(0)
+		defer mview.Abort()
+		defer view.Abort()
+
+		mcur, _ := mview.OpenCursor(key)
+		mcur.YNext(false /*fin*/) // bogn cursor is positioned on open.
+		cur, err := view.OpenCursor(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var key1, key2 []byte
+		var seqno1, seqno2 uint64
+		var del1, del2 bool
+		var err1, err2 error
+		for i, move := range moves {
+			switch move {
+			case 0:
+				key1, _, seqno1, del1, err1 = mcur.YNext(false /*fin*/)
+				key2, _, seqno2, del2, err2 = cur.YNext(false /*fin*/)
+			case 1:
+				key1, _, seqno1, del1, err1 = mcur.YPrev(false /*fin*/)
+				key2, _, seqno2, del2, err2 = cur.YPrev(false /*fin*/)
+			case 2:
+				key1, _, del1, err1 = mcur.GetPrev()
+				key2, _, del2, err2 = cur.GetPrev()
+				seqno1, seqno2 = 0, 0
+			}
+			if err1 != err2 {
+				t.Fatalf("%q:%v expected %v, got %v", key, i, err1, err2)
+			} else if err1 != nil {
+				continue
+			} else if string(key1) != string(key2) {
+				t.Fatalf("%q:%v expected %q, got %q", key, i, key1, key2)
+			} else if seqno1 != seqno2 {
+				t.Errorf("%q expected %v, got %v", key1, seqno1, seqno2)
+			} else if del1 != del2 {
+				t.Errorf("%q expected %v, got %v", key1, del1, del2)
+			}
+		}
+	}
+
+	// full table scan, forward and then backward.
+	moves := []int{}
+	for i := 0; i < 2*n; i++ {
+		moves = append(moves, 0)
+	}
+	for i := 0; i < 2*n; i++ {
+		moves = append(moves, 1)
+	}
+	walk(nil, append(moves, 0, 0, 2, 0, 1))
+
+	for i := 0; i < 100; i++ {
+		moves = moves[:0]
+		for j := 0; j < 1000; j++ {
+			moves = append(moves, rand.Intn(5)%3)
+		}
+		walk([]byte(fmt.Sprintf("key%08d", rand.Intn(2*n))), moves)
+	}
+
+	index.Close()
+	index.Destroy()
+}
+
+func TestWriteAheadLog(t *testing.T) {
+	destoryindex("index", makepaths())
+
+	setts, paths := makesettings(), makepaths()
+	setts["bubt.diskpaths"] = paths
+	setts["durable"] = true
+	setts["logsync"] = "group"
+	index, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+
+	type mutation struct {
+		cmd        byte
+		key, value string
+		seqno      uint64
+	}
+	mutations := []mutation{}
+	addmutation := func(cmd byte, key, value []byte, seqno uint64) {
+		m := mutation{cmd, string(key), string(value), seqno}
+		mutations = append(mutations, m)
+	}
+
+	n := 1000
+	k, v := []byte("key000000000000"), []byte("val00000000000000")
+	for i := 0; i < n; i++ {
+		x := fmt.Sprintf("%d", i)
+		key, val := append(k[:3], x...), append(v[:3], x...)
+		_, cas := index.Set(key, val, nil)
+		addmutation(walSet, key, val, cas)
+		if i%10 == 0 {
+			_, cas = index.Delete(key, nil, true /*lsm*/)
+			addmutation(walDeletelsm, key, nil, cas)
+		}
+	}
+	key, val := []byte("key1"), []byte("valcas")
+	_, oldcas, _, _ := index.Get(key, nil)
+	_, cas, err := index.SetCAS(key, val, nil, oldcas)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addmutation(walSet, key, val, cas)
+	// transaction shall be logged as a single batch.
+	txn := index.BeginTxn(0x1234)
+	txn.Set([]byte("txnkey1"), []byte("txnval1"), nil)
+	txn.Set([]byte("txnkey2"), []byte("txnval2"), nil)
+	if err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	records := []walrecord{}
+	segments, err := walsegments(index.logdir(""))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(segments) != 1 {
+		t.Fatalf("unexpected %v", segments)
+	}
+	_, torn, err := readsegment(segments[0], func(rec *walrecord) bool {
+		entries := make([]walentry, 0, len(rec.entries))
+		for _, entry := range rec.entries {
+			entry.key = append([]byte(nil), entry.key...)
+			entry.value = append([]byte(nil), entry.value...)
+			entries = append(entries, entry)
+		}
+		records = append(records, walrecord{rec.endseqno, entries})
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if torn {
+		t.Errorf("unexpected torn segment")
+	} else if x, y := len(mutations)+1, len(records); x != y {
+		t.Fatalf("expected %v, got %v", x, y)
+	}
+	for i, m := range mutations {
+		rec := records[i]
+		if len(rec.entries) != 1 {
+			t.Fatalf("unexpected %v", len(rec.entries))
+		} else if rec.endseqno != m.seqno {
+			t.Errorf("expected %v, got %v", m.seqno, rec.endseqno)
+		}
+		entry := rec.entries[0]
+		if entry.cmd != m.cmd {
+			t.Errorf("expected %v, got %v", m.cmd, entry.cmd)
+		} else if string(entry.key) != m.key {
+			t.Errorf("expected %q, got %q", m.key, entry.key)
+		} else if string(entry.value) != m.value {
+			t.Errorf("expected %q, got %q", m.value, entry.value)
+		} else if entry.seqno != m.seqno {
+			t.Errorf("expected %v, got %v", m.seqno, entry.seqno)
+		}
+	}
+	rec := records[len(records)-1]
+	if len(rec.entries) != 2 {
+		t.Fatalf("unexpected %v", len(rec.entries))
+	} else if seqno := index.Getseqno(); rec.endseqno != seqno {
+		t.Errorf("expected %v, got %v", seqno, rec.endseqno)
+	}
+	for _, entry := range rec.entries {
+		value, seqno, _, ok := index.Get(entry.key, []byte{})
+		if ok == false {
+			t.Errorf("missing key %q", entry.key)
+		} else if string(value) != string(entry.value) {
+			t.Errorf("expected %q, got %q", value, entry.value)
+		} else if seqno != entry.seqno {
+			t.Errorf("expected %v, got %v", seqno, entry.seqno)
+		}
+	}
+
+	index.Close()
+	index.Destroy()
+}
+
 func TestSnaplock(t *testing.T) {
 	bogn := &Bogn{}
 	buffer := make([]byte, 1000)
@@ -302,3 +754,1940 @@ func TestSnaplock(t *testing.T) {
 
 // TODO: unit test case
 // Open a bogn instance with one level of disk snapshots,
+
+func TestWriteBatch(t *testing.T) {
+	destoryindex("index", makepaths())
+
+	setts, paths := makesettings(), makepaths()
+	setts["bubt.diskpaths"] = paths
+	index, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+
+	index.Set([]byte("key0"), []byte("val0"), nil)
+	_, cas0, _, _ := index.Get([]byte("key0"), nil)
+	seqno := index.Getseqno()
+
+	// cas mismatch shall not apply any write.
+	batch := index.NewWriteBatch()
+	batch.Set([]byte("key1"), []byte("val1")).Delete([]byte("key0"), false)
+	batch.SetCAS([]byte("key2"), []byte("val2"), cas0+100)
+	if err := batch.Apply(); err != api.ErrorInvalidCAS {
+		t.Errorf("expected %v, got %v", api.ErrorInvalidCAS, err)
+	} else if x := index.Getseqno(); x != seqno {
+		t.Errorf("expected %v, got %v", seqno, x)
+	} else if _, _, _, ok := index.Get([]byte("key1"), nil); ok {
+		t.Errorf("unexpected key1")
+	} else if batch.Count() != 3 {
+		t.Errorf("expected %v, got %v", 3, batch.Count())
+	}
+
+	batch.Reset()
+	batch.Set([]byte("key1"), []byte("val1"))
+	batch.SetCAS([]byte("key2"), []byte("val2"), 0)
+	batch.SetCAS([]byte("key0"), []byte("val00"), cas0)
+	batch.Set([]byte("key3"), []byte("val3")).Delete([]byte("key3"), false)
+	if err := batch.Apply(); err != nil {
+		t.Fatal(err)
+	} else if batch.Count() != 0 {
+		t.Errorf("expected %v, got %v", 0, batch.Count())
+	}
+	// writes on key3 are coalesced.
+	if x := index.Getseqno(); x != seqno+4 {
+		t.Errorf("expected %v, got %v", seqno+4, x)
+	}
+	refs := map[string]string{"key0": "val00", "key1": "val1", "key2": "val2"}
+	for key, ref := range refs {
+		val, cas, _, ok := index.Get([]byte(key), []byte{})
+		if ok == false {
+			t.Errorf("missing key %q", key)
+		} else if string(val) != ref {
+			t.Errorf("%q expected %q, got %q", key, ref, val)
+		} else if cas <= seqno || cas > seqno+4 {
+			t.Errorf("%q unexpected seqno %v", key, cas)
+		}
+	}
+	if _, _, _, ok := index.Get([]byte("key3"), nil); ok {
+		t.Errorf("unexpected key3")
+	}
+
+	index.Close()
+	index.Destroy()
+}
+
+func TestWriteBatchDurable(t *testing.T) {
+	destoryindex("index", makepaths())
+
+	mindex := llrb.NewLLRB("mindex", llrb.Defaultsettings())
+	defer mindex.Destroy()
+	setts, paths := makesettings(), makepaths()
+	setts["bubt.diskpaths"] = paths
+	setts["durable"] = true
+	index, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+
+	n, batch := 1000, index.NewWriteBatch()
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key%08d", i))
+		val := []byte(fmt.Sprintf("val%08d", i))
+		batch.Set(key, val)
+		mindex.Set(key, val, nil)
+		if i%3 == 0 {
+			batch.Delete(key, false)
+			mindex.Delete(key, nil, false)
+		}
+		if batch.Count() >= 10 {
+			if err := batch.Apply(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := batch.Apply(); err != nil {
+		t.Fatal(err)
+	}
+	seqno := index.Getseqno()
+	index.Close()
+
+	index, err = New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+	if x := index.Getseqno(); x != seqno {
+		t.Errorf("expected %v, got %v", seqno, x)
+	}
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key%08d", i))
+		refval, _, _, refok := mindex.Get(key, []byte{})
+		val, _, deleted, ok := index.Get(key, []byte{})
+		if refok == false {
+			if ok && !deleted {
+				t.Errorf("unexpected key %q", key)
+			}
+		} else if ok == false || deleted {
+			t.Errorf("missing key %q", key)
+		} else if string(val) != string(refval) {
+			t.Errorf("%q expected %q, got %q", key, refval, val)
+		}
+	}
+
+	index.Close()
+	index.Destroy()
+}
+
+func TestCheckpoint(t *testing.T) {
+	for _, dgm := range []bool{false, true} {
+		testcheckpoint(t, dgm)
+	}
+}
+
+func testcheckpoint(t *testing.T, dgm bool) {
+	destoryindex("index", makepaths())
+	cpdir := filepath.Join(os.TempDir(), "checkpoint")
+	os.RemoveAll(cpdir)
+	defer os.RemoveAll(cpdir)
+
+	mindex := llrb.NewLLRB("mindex", llrb.Defaultsettings())
+	defer mindex.Destroy()
+	setts, paths := makesettings(), makepaths()
+	setts["bubt.diskpaths"] = paths
+	setts["dgm"] = dgm
+	setts["autocommit"] = 1
+	index, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+
+	n := 10000
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key%08d", i))
+		val := []byte(fmt.Sprintf("val%08d", i))
+		mindex.Set(key, val, nil)
+		index.Set(key, val, nil)
+		if i%10 == 0 {
+			mindex.Delete(key, nil, false)
+			index.Delete(key, nil, false)
+		}
+		if i == n/2 {
+			time.Sleep(2 * time.Second)
+		}
+	}
+	if err := index.Checkpoint(cpdir); err != nil {
+		t.Fatal(err)
+	}
+	seqno := index.Getseqno()
+	// mutations after checkpoint.
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("key%08d", i))
+		index.Set(key, []byte("after"), nil)
+	}
+	index.Close()
+	index.Destroy()
+
+	manifest := filepath.Join(cpdir, "bogn-index-checkpoint.json")
+	if _, err := os.Stat(manifest); err != nil {
+		t.Fatal(err)
+	}
+
+	setts = makesettings()
+	setts["bubt.diskpaths"] = cpdir
+	setts["dgm"] = dgm
+	index, err = New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+
+	if x := index.Getseqno(); x != seqno {
+		t.Errorf("dgm:%v expected %v, got %v", dgm, seqno, x)
+	}
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key%08d", i))
+		refval, _, _, refok := mindex.Get(key, []byte{})
+		val, _, deleted, ok := index.Get(key, []byte{})
+		if refok == false {
+			if ok && !deleted {
+				t.Errorf("dgm:%v unexpected key %q", dgm, key)
+			}
+		} else if ok == false || deleted {
+			t.Errorf("dgm:%v missing key %q", dgm, key)
+		} else if string(val) != string(refval) {
+			t.Errorf("dgm:%v %q expected %q, got %q", dgm, key, refval, val)
+		}
+	}
+
+	// restored index shall flush new mutations into checkpoint dir.
+	index.Set([]byte("newkey"), []byte("newval"), nil)
+	index.Close()
+	index, err = New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+	if val, _, _, ok := index.Get([]byte("newkey"), []byte{}); !ok {
+		t.Errorf("dgm:%v missing newkey", dgm)
+	} else if string(val) != "newval" {
+		t.Errorf("dgm:%v expected %q, got %q", dgm, "newval", val)
+	}
+	index.Close()
+	index.Destroy()
+}
+
+func TestSubscribe(t *testing.T) {
+	destoryindex("index", makepaths())
+
+	setts, paths := makesettings(), makepaths()
+	setts["bubt.diskpaths"] = paths
+	setts["durable"] = true
+	setts["autocommit"] = 1
+	index, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+
+	type mutation struct {
+		key     string
+		seqno   uint64
+		deleted bool
+	}
+	mutations := []mutation{}
+	load := func(index *Bogn, n int) {
+		for i := 0; i < n; i++ {
+			key := fmt.Sprintf("key%08d", rand.Intn(n))
+			if i%5 == 0 {
+				_, cas := index.Delete([]byte(key), nil, true /*lsm*/)
+				mutations = append(mutations, mutation{key, cas, true})
+				continue
+			}
+			_, cas := index.Set([]byte(key), []byte(key), nil)
+			mutations = append(mutations, mutation{key, cas, false})
+		}
+	}
+	// every logged mutation from seqno.
+	verifylog := func(index *Bogn, from uint64) {
+		iter := index.Subscribe(from)
+		defer iter(true /*fin*/)
+
+		refs := mutations[from-1:]
+		key, val, seqno, del, err := iter(false /*fin*/)
+		for ; err == nil; key, val, seqno, del, err = iter(false) {
+			if len(refs) == 0 {
+				t.Errorf("unexpected mutation %q %v", key, seqno)
+				return
+			}
+			ref := refs[0]
+			refs = refs[1:]
+			if string(key) != ref.key || seqno != ref.seqno {
+				fmsg := "expected %q %v, got %q %v"
+				t.Errorf(fmsg, ref.key, ref.seqno, key, seqno)
+			} else if del != ref.deleted {
+				t.Errorf("%q expected %v, got %v", key, ref.deleted, del)
+			} else if del == false && string(val) != ref.key {
+				t.Errorf("%q expected %q, got %q", key, ref.key, val)
+			}
+		}
+		if err != io.EOF {
+			t.Errorf("unexpected %v", err)
+		} else if len(refs) > 0 {
+			t.Errorf("missing %v mutations from seqno %v", len(refs), from)
+		}
+	}
+
+	load(index, 1000)
+	verifylog(index, 1)
+	verifylog(index, 500)
+
+	// resume after restart, mutations before restart are flushed to
+	// disk and their log is purged.
+	index.Close()
+	index, err = New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+	load(index, 1000)
+	verifylog(index, 1001)
+	verifylog(index, 1900)
+
+	// after flush, log is purged and mutations are served by seqno scan.
+	time.Sleep(3 * time.Second)
+	latest := map[string]mutation{}
+	for _, m := range mutations {
+		latest[m.key] = m
+	}
+	from, count, lastseqno := uint64(1500), 0, uint64(0)
+	iter := index.Subscribe(from)
+	key, _, seqno, del, err := iter(false /*fin*/)
+	for ; err == nil; key, _, seqno, del, err = iter(false /*fin*/) {
+		ref := latest[string(key)]
+		if seqno <= lastseqno {
+			t.Errorf("unexpected seqno %v after %v", seqno, lastseqno)
+		} else if seqno != ref.seqno || del != ref.deleted {
+			fmsg := "%q expected %v %v, got %v %v"
+			t.Errorf(fmsg, key, ref.seqno, ref.deleted, seqno, del)
+		}
+		lastseqno, count = seqno, count+1
+	}
+	iter(true /*fin*/)
+	refcount := 0
+	for _, m := range latest {
+		if m.seqno >= from {
+			refcount++
+		}
+	}
+	if count != refcount {
+		t.Errorf("expected %v, got %v", refcount, count)
+	}
+
+	// non-lsm deletes cannot be served by seqno scan, even after restart,
+	// while they are still delivered from the log.
+	subscribe := func(index *Bogn, from uint64, referr error) {
+		iter := index.Subscribe(from)
+		defer iter(true /*fin*/)
+		_, _, _, del, err := iter(false /*fin*/)
+		if err != referr {
+			t.Errorf("from:%v expected %v, got %v", from, referr, err)
+		} else if err == nil && del == false {
+			t.Errorf("from:%v expected delete", from)
+		}
+	}
+	_, delseqno := index.Delete([]byte(mutations[0].key), nil, false)
+	time.Sleep(3 * time.Second)
+	subscribe(index, from, api.ErrorMissingdeletes)
+	subscribe(index, delseqno, nil)
+	index.Close()
+	index, err = New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+	subscribe(index, from, api.ErrorMissingdeletes)
+	subscribe(index, delseqno+1, io.EOF)
+
+	index.Close()
+	index.Destroy()
+}
+
+func TestExpiry(t *testing.T) {
+	destoryindex("index", makepaths())
+
+	setts, paths := makesettings(), makepaths()
+	setts["bubt.diskpaths"] = paths
+	setts["durable"] = true
+	setts["autocommit"] = 1
+	index, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+
+	n := 1000
+	now := time.Now().Unix()
+	past, future := now-10, now+3600
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key%08d", i))
+		switch i % 4 {
+		case 0:
+			index.SetExpiry(key, key, nil, past)
+		case 1:
+			index.SetExpiry(key, key, nil, future)
+		case 2:
+			index.Set(key, key, nil)
+		case 3: // expired entry over-written by set.
+			index.SetExpiry(key, key, nil, past)
+			index.Set(key, key, nil)
+		}
+	}
+
+	verify := func(index *Bogn) {
+		for i := 0; i < n; i++ {
+			key := []byte(fmt.Sprintf("key%08d", i))
+			val, _, deleted, ok := index.Get(key, []byte{})
+			if i%4 == 0 && ok && deleted == false {
+				t.Errorf("%q expected expired, got %q", key, val)
+			} else if i%4 != 0 && (ok == false || deleted) {
+				t.Errorf("%q unexpected %v %v", key, ok, deleted)
+			} else if i%4 != 0 && bytes.Compare(key, val) != 0 {
+				t.Errorf("%q expected %q, got %q", key, key, val)
+			}
+		}
+	}
+	verify(index)
+
+	// wait for persistence, expired entries should be purged on disk.
+	time.Sleep(3 * time.Second)
+	snap := index.latestsnapshot()
+	disks := snap.disklevels([]api.Index{})
+	if len(disks) != 1 {
+		t.Errorf("unexpected disks %v", len(disks))
+	} else if x, y := disks[0].(*bubt.Snapshot).Count(), n-(n/4); x != int64(y) {
+		t.Errorf("expected %v, got %v", y, x)
+	}
+	snap.release()
+	verify(index)
+	index.Close()
+
+	// reload from disk.
+	index, err = New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+	verify(index)
+	index.Close()
+	index.Destroy()
+}
+
+func TestMerge(t *testing.T) {
+	for _, dgm := range []bool{false, true} {
+		t.Logf("dgm: %v", dgm)
+		testmerge(t, dgm)
+	}
+}
+
+func testmerge(t *testing.T, dgm bool) {
+	destoryindex("index", makepaths())
+
+	setts, paths := makesettings(), makepaths()
+	setts["bubt.diskpaths"] = paths
+	setts["durable"] = true
+	setts["dgm"] = dgm
+	setts["autocommit"] = 1
+	setts["mergeoperator"] = addmerge
+	index, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+
+	n, m := 1000, 1100
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key%08d", i))
+		index.Set(key, []byte(strconv.Itoa(i)), nil)
+	}
+	// wait for persistence, operands should fold over disk values.
+	time.Sleep(3 * time.Second)
+	for i := 0; i < m; i++ {
+		key := []byte(fmt.Sprintf("key%08d", i))
+		index.Merge(key, []byte("1"))
+		if i%2 == 0 {
+			index.Merge(key, []byte("2"))
+		}
+	}
+
+	refvalue := func(i int) string {
+		x := 1
+		if i%2 == 0 {
+			x += 2
+		}
+		if i < n {
+			x += i
+		}
+		return strconv.Itoa(x)
+	}
+	verify := func(index *Bogn) {
+		// wait for read snapshot to catchup with merges.
+		snap := index.latestsnapshot()
+		snap.catchupindex(snap.mw, snap.mwseqno())
+		snap.release()
+
+		for i := 0; i < m; i++ {
+			key := []byte(fmt.Sprintf("key%08d", i))
+			val, _, deleted, ok := index.Get(key, []byte{})
+			if ok == false || deleted {
+				t.Errorf("%q unexpected %v %v", key, ok, deleted)
+			} else if ref := refvalue(i); string(val) != ref {
+				t.Errorf("%q expected %s, got %s", key, ref, val)
+			}
+		}
+
+		iter, i := index.Scan(), 0
+		for key, val, _, _, err := iter(false); err == nil; i++ {
+			if ref := refvalue(i); string(val) != ref {
+				t.Errorf("%q expected %s, got %s", key, ref, val)
+			}
+			key, val, _, _, err = iter(false)
+		}
+		iter(true /*fin*/)
+		if i != m {
+			t.Errorf("expected %v, got %v", m, i)
+		}
+
+		itere, i := index.ScanEntries(), 0
+		for entry := itere(false); ; entry = itere(false) {
+			key, _, _, err := entry.Key()
+			if err != nil {
+				break
+			} else if entry.IsOperand() {
+				t.Errorf("%q unexpected operand", key)
+			} else if ref := refvalue(i); string(entry.Value()) != ref {
+				t.Errorf("%q expected %s, got %s", key, ref, entry.Value())
+			}
+			i++
+		}
+		itere(true /*fin*/)
+		if i != m {
+			t.Errorf("expected %v, got %v", m, i)
+		}
+	}
+	verify(index)
+
+	// wait for persistence, operands should be folded on disk.
+	time.Sleep(3 * time.Second)
+	verify(index)
+	index.Close()
+
+	// reload from disk.
+	index, err = New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+	verify(index)
+	index.Close()
+	index.Destroy()
+}
+
+// addmerge treat values as decimal integers and add them.
+func addmerge(key, value, operand []byte) []byte {
+	x, _ := strconv.Atoi(string(value))
+	y, _ := strconv.Atoi(string(operand))
+	return []byte(strconv.Itoa(x + y))
+}
+
+func TestCompactionFilter(t *testing.T) {
+	destoryindex("index", makepaths())
+
+	filter := func(
+		key, value []byte, seqno uint64, deleted bool) (int, []byte) {
+
+		var i int
+		fmt.Sscanf(string(key), "key%08d", &i)
+		switch i % 3 {
+		case 0:
+			return FilterDrop, nil
+		case 1:
+			return FilterChange, bytes.ToUpper(value)
+		}
+		return FilterKeep, nil
+	}
+
+	setts, paths := makesettings(), makepaths()
+	setts["bubt.diskpaths"] = paths
+	setts["durable"] = true
+	setts["dgm"] = true
+	setts["autocommit"] = 1
+	setts["compactionfilter"] = filter
+	index, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+
+	n := 1000
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key%08d", i))
+		index.Set(key, key, nil)
+	}
+
+	verify := func(index *Bogn) {
+		for i := 0; i < n; i++ {
+			key := []byte(fmt.Sprintf("key%08d", i))
+			val, _, deleted, ok := index.Get(key, []byte{})
+			if i%3 == 0 && ok && deleted == false {
+				t.Errorf("%q expected dropped, got %q", key, val)
+				continue
+			} else if i%3 == 0 {
+				continue
+			} else if ok == false || deleted {
+				t.Errorf("%q unexpected %v %v", key, ok, deleted)
+			}
+			ref := key
+			if i%3 == 1 {
+				ref = bytes.ToUpper(key)
+			}
+			if bytes.Compare(ref, val) != 0 {
+				t.Errorf("%q expected %q, got %q", key, ref, val)
+			}
+		}
+	}
+
+	// wait for flush, entries should be filtered on disk.
+	time.Sleep(3 * time.Second)
+	verify(index)
+	dropped := atomic.LoadInt64(&index.nfiltdropped)
+	changed := atomic.LoadInt64(&index.nfiltchanged)
+	if x := int64((n + 2) / 3); dropped != x {
+		t.Errorf("expected %v, got %v", x, dropped)
+	} else if x := int64((n + 1) / 3); changed != x {
+		t.Errorf("expected %v, got %v", x, changed)
+	}
+	index.Close()
+
+	// reload from disk.
+	index, err = New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+	verify(index)
+	index.Close()
+	index.Destroy()
+}
+
+func TestCompactionFilterPersist(t *testing.T) {
+	destoryindex("index", makepaths())
+
+	filter := func(
+		key, value []byte, seqno uint64, deleted bool) (int, []byte) {
+
+		var i int
+		fmt.Sscanf(string(key), "key%08d", &i)
+		if i%3 == 0 {
+			return FilterDrop, nil
+		}
+		return FilterKeep, nil
+	}
+
+	setts, paths := makesettings(), makepaths()
+	setts["bubt.diskpaths"] = paths
+	setts["durable"] = true
+	setts["autocommit"] = 1
+	setts["compactionfilter"] = filter
+	index, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+
+	n := 1000
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key%08d", i))
+		index.Set(key, key, nil)
+	}
+
+	// wait for persist, entries should be filtered on disk.
+	time.Sleep(3 * time.Second)
+	snap := index.latestsnapshot()
+	_, disk := snap.latestlevel()
+	if disk == nil {
+		t.Errorf("expected persisted disk level")
+	} else if x, y := index.indexcount(disk), int64(n-(n+2)/3); x != y {
+		t.Errorf("expected %v, got %v", y, x)
+	}
+	snap.release()
+	if x := atomic.LoadInt64(&index.nfiltdropped); x < int64((n+2)/3) {
+		t.Errorf("expected atleast %v, got %v", (n+2)/3, x)
+	}
+	index.Close()
+	index.Destroy()
+}
+
+func TestCompactionFilterAppend(t *testing.T) {
+	destoryindex("index", makepaths())
+
+	filter := func(
+		key, value []byte, seqno uint64, deleted bool) (int, []byte) {
+
+		var i int
+		fmt.Sscanf(string(key), "key%08d", &i)
+		if i%2 == 1 {
+			return FilterChange, bytes.ToUpper(value)
+		}
+		return FilterKeep, nil
+	}
+
+	setts, paths := makesettings(), makepaths()
+	setts["bubt.diskpaths"] = paths
+	setts["bubt.vblocksize"] = 4096
+	setts["durable"] = true
+	setts["dgm"] = true
+	setts["autocommit"] = 1
+	setts["flushratio"] = 0.0 // always merge with latest level.
+	setts["compactionfilter"] = filter
+	index, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+
+	// first batch is flushed fresh, second batch is merged with the
+	// first, appending to its value log.
+	n := 1000
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key%08d", i))
+		index.Set(key, key, nil)
+		if i == n/2 {
+			time.Sleep(3 * time.Second)
+		}
+	}
+	time.Sleep(3 * time.Second)
+
+	verify := func(index *Bogn) {
+		for i := 0; i < n; i++ {
+			key := []byte(fmt.Sprintf("key%08d", i))
+			val, _, deleted, ok := index.Get(key, []byte{})
+			if ok == false || deleted {
+				t.Errorf("%q unexpected %v %v", key, ok, deleted)
+				continue
+			}
+			ref := key
+			if i%2 == 1 {
+				ref = bytes.ToUpper(key)
+			}
+			if bytes.Compare(ref, val) != 0 {
+				t.Errorf("%q expected %q, got %q", key, ref, val)
+			}
+		}
+	}
+
+	verify(index)
+	index.Close()
+
+	// reload from disk.
+	index, err = New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+	verify(index)
+	index.Close()
+	index.Destroy()
+}
+
+func TestRatelimit(t *testing.T) {
+	destoryindex("index", makepaths())
+
+	setts, paths := makesettings(), makepaths()
+	setts["bubt.diskpaths"] = paths
+	setts["durable"] = true
+	setts["dgm"] = true
+	setts["autocommit"] = 1
+	setts["ratelimit"] = 1024 * 1024
+	index, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+
+	n := 10000
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key%08d", i))
+		index.Set(key, key, nil)
+	}
+
+	// wait for background flush, which shall be throttled.
+	time.Sleep(3 * time.Second)
+	stats := index.Ratelimitstats()
+	if x := stats.Int64("rate"); x != 1024*1024 {
+		t.Errorf("expected %v, got %v", 1024*1024, x)
+	} else if x := stats.Int64("n_bytes"); x == 0 {
+		t.Errorf("unexpected %v", x)
+	}
+
+	// change rate at runtime.
+	index.SetRatelimit(0)
+	if x := index.Ratelimitstats().Int64("rate"); x != 0 {
+		t.Errorf("unexpected %v", x)
+	}
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key%08d", i))
+		val, _, deleted, ok := index.Get(key, []byte{})
+		if ok == false || deleted {
+			t.Errorf("%q unexpected %v %v", key, ok, deleted)
+		} else if bytes.Compare(key, val) != 0 {
+			t.Errorf("%q expected %q, got %q", key, key, val)
+		}
+	}
+	index.Close()
+	index.Destroy()
+}
+
+func TestStall(t *testing.T) {
+	destoryindex("index", makepaths())
+
+	setts, paths := makesettings(), makepaths()
+	setts["bubt.diskpaths"] = paths
+	setts["durable"] = true
+	setts["llrb.memcapacity"] = 100 * 1024 * 1024
+	setts["stallsoftlimit"] = 0.01
+	setts["stallhardlimit"] = 0.02
+	setts["stallblock"] = false
+	index, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+
+	// index is not flushed until autocommit period, fill memory till
+	// writes are refused.
+	value := make([]byte, 1000)
+	for i := 0; ; i++ {
+		key := []byte(fmt.Sprintf("key%08d", i))
+		_, _, err := index.SetCAS(key, value, nil, 0)
+		if err == api.ErrorStall {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats := index.Stallstats()
+	if x := stats.Int64("n_softstalls"); x == 0 {
+		t.Errorf("expected soft stalls")
+	} else if x := stats.Int64("stalltime"); x == 0 {
+		t.Errorf("expected stall time")
+	} else if x := stats.Int64("memheap"); x <= stats.Int64("hardlimit") {
+		t.Errorf("unexpected %v", x)
+	}
+
+	// transactions shall be refused.
+	key := []byte("key")
+	txn := index.BeginTxn(0xC0FFEE)
+	txn.Set(key, value, nil)
+	if err := txn.Commit(); err != api.ErrorStall {
+		t.Errorf("expected %v, got %v", api.ErrorStall, err)
+	}
+	if _, _, _, ok := index.Get(key, nil); ok {
+		t.Errorf("unexpected %q", key)
+	}
+	// so are batches.
+	batch := index.NewWriteBatch().Set(key, value)
+	if err := batch.Apply(); err != api.ErrorStall {
+		t.Errorf("expected %v, got %v", api.ErrorStall, err)
+	}
+	if _, _, _, ok := index.Get(key, nil); ok {
+		t.Errorf("unexpected %q", key)
+	}
+	if x := index.Stallstats().Int64("n_stallerrors"); x != 3 {
+		t.Errorf("expected %v, got %v", 3, x)
+	}
+	index.Close()
+	index.Destroy()
+
+	// non-durable index is never flushed, stalls are disabled.
+	setts["durable"] = false
+	index, err = New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+	stats = index.Stallstats()
+	if x := stats.Int64("softlimit"); x != 0 {
+		t.Errorf("unexpected softlimit %v", x)
+	} else if x := stats.Int64("hardlimit"); x != 0 {
+		t.Errorf("unexpected hardlimit %v", x)
+	}
+	index.Close()
+	index.Destroy()
+}
+
+func TestStallBlock(t *testing.T) {
+	destoryindex("index", makepaths())
+
+	setts, paths := makesettings(), makepaths()
+	setts["bubt.diskpaths"] = paths
+	setts["durable"] = true
+	setts["dgm"] = true
+	setts["autocommit"] = 1
+	setts["llrb.memcapacity"] = 100 * 1024 * 1024
+	setts["stallsoftlimit"] = 0.0
+	setts["stallhardlimit"] = 0.02
+	index, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+
+	// writers shall block till memory is flushed to disk.
+	n, value := 10000, make([]byte, 100)
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key%08d", i))
+		index.Set(key, value, nil)
+	}
+	stats := index.Stallstats()
+	if x := stats.Int64("n_hardstalls"); x == 0 {
+		t.Errorf("expected hard stalls")
+	} else if x := stats.Int64("n_stallerrors"); x != 0 {
+		t.Errorf("unexpected %v", x)
+	}
+	t.Logf("stallstats %v", stats)
+
+	for i := 0; i < n; i += 100 {
+		key := []byte(fmt.Sprintf("key%08d", i))
+		if _, _, deleted, ok := index.Get(key, nil); !ok || deleted {
+			t.Errorf("%q unexpected %v %v", key, ok, deleted)
+		}
+	}
+	index.Close()
+	index.Destroy()
+}
+
+func TestStats(t *testing.T) {
+	destoryindex("index", makepaths())
+
+	setts, paths := makesettings(), makepaths()
+	setts["bubt.diskpaths"] = paths
+	setts["durable"] = true
+	setts["dgm"] = true
+	setts["autocommit"] = 1
+	index, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+
+	n := 10000
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key%08d", i))
+		index.Set(key, key, nil)
+	}
+	// wait for flush.
+	time.Sleep(3 * time.Second)
+	for i := n; i < n+100; i++ {
+		key := []byte(fmt.Sprintf("key%08d", i))
+		index.Set(key, key, nil)
+	}
+
+	stats := index.Stats()
+	t.Logf("%v", stats)
+	if x := stats["dgmstate"].(int64); x != 1 {
+		t.Errorf("expected %v, got %v", 1, x)
+	} else if x := stats["seqno"].(uint64); x != uint64(n+100) {
+		t.Errorf("expected %v, got %v", n+100, x)
+	} else if x := stats["mw.count"].(int64); x != 100 {
+		t.Errorf("expected %v, got %v", 100, x)
+	} else if x := stats["mw.heap"].(int64); x <= 0 {
+		t.Errorf("unexpected %v", x)
+	} else if x := stats["n_flushes"].(int64); x < 1 {
+		t.Errorf("unexpected %v", x)
+	} else if x := stats["flushtime"].(int64); x <= 0 {
+		t.Errorf("unexpected %v", x)
+	} else if x := stats["wramplification"].(int64); x <= 0 {
+		t.Errorf("unexpected %v", x)
+	}
+
+	levels := stats["levels"].([]map[string]interface{})
+	if len(levels) == 0 {
+		t.Fatalf("expected disk levels")
+	} else if x := stats["rdamplification"].(int); x != len(levels)+1 {
+		t.Errorf("expected %v, got %v", len(levels)+1, x)
+	}
+	count := int64(0)
+	for _, level := range levels {
+		count += level["count"].(int64)
+		if x := level["footprint"].(int64); x <= 0 {
+			t.Errorf("unexpected %v", x)
+		} else if x := level["payload"].(int64); x <= 0 {
+			t.Errorf("unexpected %v", x)
+		}
+		minseqno, maxseqno := level["minseqno"], level["maxseqno"]
+		if minseqno.(uint64) > maxseqno.(uint64) {
+			t.Errorf("unexpected range %v-%v", minseqno, maxseqno)
+		}
+	}
+	if latest := levels[0]["maxseqno"].(uint64); latest != uint64(n) {
+		t.Errorf("expected %v, got %v", n, latest)
+	} else if count != int64(n) {
+		t.Errorf("expected %v, got %v", n, count)
+	}
+
+	index.Close()
+	index.Destroy()
+}
+
+func TestApproximate(t *testing.T) {
+	destoryindex("index", makepaths())
+
+	setts, paths := makesettings(), makepaths()
+	setts["bubt.diskpaths"] = paths
+	setts["durable"] = true
+	setts["dgm"] = true
+	setts["autocommit"] = 1
+	index, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+
+	if x := index.ApproximateCount(); x != 0 {
+		t.Errorf("unexpected %v", x)
+	} else if x := index.ApproximateSize(nil, nil); x != 0 {
+		t.Errorf("unexpected %v", x)
+	}
+
+	n := 10000
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key%08d", i))
+		index.Set(key, key, nil)
+	}
+	// from memory levels.
+	if x := index.ApproximateCount(); x != int64(n) {
+		t.Errorf("expected %v, got %v", n, x)
+	} else if x := index.ApproximateSize(nil, nil); x != int64(n*22) {
+		t.Errorf("expected %v, got %v", n*22, x)
+	}
+	low, high := []byte("key00002500"), []byte("key00007499")
+	if x := index.ApproximateSize(low, high); x < int64(n*22)/4 {
+		t.Errorf("expected %v, got %v", n*22/2, x)
+	} else if x > int64(n*22*3)/4 {
+		t.Errorf("expected %v, got %v", n*22/2, x)
+	}
+	// wait for flush.
+	time.Sleep(3 * time.Second)
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("key%08d", i))
+		index.Delete(key, nil, true /*lsm*/)
+	}
+
+	// disk level with 10000 entries and memory level with 100 tombstones.
+	if x := index.ApproximateCount(); x != int64(n-100) {
+		t.Errorf("expected %v, got %v", n-100, x)
+	}
+	total := index.ApproximateSize(nil, nil)
+	if total < int64(n*22) || total > int64(n*22*2) {
+		t.Errorf("unexpected %v", total)
+	}
+	size := index.ApproximateSize(low, high)
+	if size < total/4 || size > (total*3)/4 {
+		t.Errorf("expected %v, got %v", total/2, size)
+	}
+	if x := index.ApproximateSize(high, low); x != 0 {
+		t.Errorf("unexpected %v", x)
+	}
+
+	index.Close()
+	index.Destroy()
+}
+
+func TestTxnLogLock(t *testing.T) {
+	destoryindex("index", makepaths())
+
+	setts, paths := makesettings(), makepaths()
+	setts["bubt.diskpaths"] = paths
+	setts["memstore"] = "llrb"
+	setts["durable"] = true
+	setts["logsync"] = "group"
+	index, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+
+	key := []byte("key")
+	txn := index.BeginTxn(0xC0DE)
+	txn.Set(key, key, nil)
+
+	// log is locked only while committing, an open transaction shall
+	// not block the log.
+	donech := make(chan struct{})
+	go func() {
+		index.wal.purge(0)
+		close(donech)
+	}()
+	select {
+	case <-donech:
+	case <-time.After(time.Second):
+		t.Fatalf("log locked by open transaction")
+	}
+
+	if err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if val, _, _, ok := index.Get(key, []byte{}); !ok {
+		t.Errorf("missing %q", key)
+	} else if string(val) != string(key) {
+		t.Errorf("expected %q, got %q", key, val)
+	}
+	index.Close()
+	index.Destroy()
+}
+
+func TestLogFailure(t *testing.T) {
+	destoryindex("index", makepaths())
+
+	setts, paths := makesettings(), makepaths()
+	setts["bubt.diskpaths"] = paths
+	setts["durable"] = true
+	setts["logsync"] = "write"
+	index, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+
+	key := []byte("key1")
+	if _, _, err := index.SetCAS(key, key, nil, 0); err != nil {
+		t.Fatal(err)
+	}
+	// simulate a failing disk by closing the log segment.
+	index.wal.lock()
+	index.wal.fd.Close()
+	index.wal.unlock()
+
+	key = []byte("key2")
+	if _, _, err := index.SetCAS(key, key, nil, 0); err == nil {
+		t.Errorf("expected log error")
+	} else if err == api.ErrorReadonly {
+		t.Errorf("unexpected %v", err)
+	}
+	if failed := index.Stats()["failed"].(int64); failed != 1 {
+		t.Errorf("expected %v, got %v", 1, failed)
+	}
+	_, _, err = index.SetCAS([]byte("key3"), key, nil, 0)
+	if err != api.ErrorReadonly {
+		t.Errorf("expected %v, got %v", api.ErrorReadonly, err)
+	}
+	if _, _, _, ok := index.Get([]byte("key1"), []byte{}); !ok {
+		t.Errorf("missing %q", "key1")
+	}
+	index.Close()
+	index.Destroy()
+}
+
+func TestTxnConflict(t *testing.T) {
+	destoryindex("index", makepaths())
+
+	setts, paths := makesettings(), makepaths()
+	setts["bubt.diskpaths"] = paths
+	setts["durable"] = true
+	setts["dgm"] = true
+	setts["autocommit"] = 1
+	index, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+
+	n := 1000
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key%08d", i))
+		index.Set(key, key, nil)
+	}
+	// wait for flush.
+	time.Sleep(3 * time.Second)
+
+	key1, key2 := []byte("key00000001"), []byte("key00000002")
+
+	// key read from disk level is updated before commit.
+	txn := index.BeginTxn(0x1234)
+	if value, _, _, _ := txn.Get(key1, []byte{}); string(value) != string(key1) {
+		t.Errorf("expected %s, got %s", key1, value)
+	}
+	txn.Set(key2, []byte("txnvalue"), nil)
+	index.Set(key1, []byte("value1"), nil)
+	if err := txn.Commit(); err != api.ErrorRollback {
+		t.Errorf("expected %v, got %v", api.ErrorRollback, err)
+	}
+	if value, _, _, _ := index.Get(key2, []byte{}); string(value) != string(key2) {
+		t.Errorf("expected %s, got %s", key2, value)
+	}
+
+	// key read from write store is updated before commit.
+	w := time.Duration(setts.Int64("llrb.snapshottick")) * time.Millisecond
+	time.Sleep(2 * w)
+	txn = index.BeginTxn(0x12345)
+	if value, _, _, _ := txn.Get(key1, []byte{}); string(value) != "value1" {
+		t.Errorf("expected %s, got %s", "value1", value)
+	}
+	txn.Set(key2, []byte("txnvalue"), nil)
+	index.Set(key1, []byte("value11"), nil)
+	if err := txn.Commit(); err != api.ErrorRollback {
+		t.Errorf("expected %v, got %v", api.ErrorRollback, err)
+	}
+
+	// no conflict.
+	time.Sleep(2 * w)
+	txn = index.BeginTxn(0x123456)
+	txn.Get(key1, []byte{})
+	txn.Set(key2, []byte("txnvalue"), nil)
+	index.Set([]byte("key00000003"), []byte("value3"), nil)
+	if err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * w)
+	if value, _, _, _ := index.Get(key2, []byte{}); string(value) != "txnvalue" {
+		t.Errorf("expected %s, got %s", "txnvalue", value)
+	}
+
+	index.Close()
+	index.Destroy()
+}
+
+func TestTxnSavepoint(t *testing.T) {
+	destoryindex("index", makepaths())
+
+	setts, paths := makesettings(), makepaths()
+	setts["bubt.diskpaths"] = paths
+	setts["durable"] = true
+	index, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+
+	key1, key2, key3 := []byte("key1"), []byte("key2"), []byte("key3")
+	index.Set(key1, []byte("value1"), nil)
+
+	txn := index.BeginTxn(0x1234).(*Txn)
+	txn.Set(key2, []byte("value2"), nil)
+	sp := txn.Savepoint()
+	txn.Set(key1, []byte("value11"), nil)
+	txn.Delete(key2, nil, true)
+	txn.Set(key3, []byte("value3"), nil)
+	if len(txn.writes) != 4 {
+		t.Errorf("expected %v, got %v", 4, len(txn.writes))
+	}
+	if err := txn.RollbackTo(sp); err != nil {
+		t.Fatal(err)
+	} else if len(txn.writes) != 1 {
+		t.Errorf("expected %v, got %v", 1, len(txn.writes))
+	} else if err := txn.RollbackTo(sp + 1); err != api.ErrorInvalidSavepoint {
+		t.Errorf("expected %v, got %v", api.ErrorInvalidSavepoint, err)
+	}
+	if v, _, _, _ := txn.Get(key2, []byte{}); string(v) != "value2" {
+		t.Errorf("unexpected %s", v)
+	}
+	if err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	w := time.Duration(setts.Int64("llrb.snapshottick")) * time.Millisecond
+	time.Sleep(2 * w)
+	if v, _, _, _ := index.Get(key1, []byte{}); string(v) != "value1" {
+		t.Errorf("unexpected %s", v)
+	} else if v, _, _, _ := index.Get(key2, []byte{}); string(v) != "value2" {
+		t.Errorf("unexpected %s", v)
+	} else if _, _, _, ok := index.Get(key3, []byte{}); ok {
+		t.Errorf("unexpected %s", key3)
+	}
+
+	index.Close()
+	index.Destroy()
+}
+
+func TestTxnExpiry(t *testing.T) {
+	destoryindex("index", makepaths())
+
+	setts, paths := makesettings(), makepaths()
+	setts["bubt.diskpaths"] = paths
+	setts["durable"] = true
+	setts["txnmaxage"], setts["txnabort"] = 1, true
+	index, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+
+	key1 := []byte("key1")
+	index.Set(key1, []byte("value1"), nil)
+
+	txn := index.BeginTxn(0x1234).(*Txn)
+	view := index.View(0x12345)
+	txn.Set(key1, []byte("txnvalue"), nil)
+	stats := index.Stats()["txns"].(map[string]interface{})
+	if x := stats["n_active"].(int64); x != 2 {
+		t.Errorf("expected %v, got %v", 2, x)
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	if _, _, _, ok := view.Get(key1, []byte{}); ok {
+		t.Errorf("unexpected %s", key1)
+	}
+	view.Abort()
+	if _, _, _, _, err := txn.GetForUpdate(key1, nil); err != api.ErrorExpired {
+		t.Errorf("expected %v, got %v", api.ErrorExpired, err)
+	} else if err := txn.Commit(); err != api.ErrorExpired {
+		t.Errorf("expected %v, got %v", api.ErrorExpired, err)
+	}
+	txn.Abort()
+
+	stats = index.Stats()["txns"].(map[string]interface{})
+	if x := stats["n_active"].(int64); x != 0 {
+		t.Errorf("expected %v, got %v", 0, x)
+	} else if x := stats["n_aborted"].(int64); x != 2 {
+		t.Errorf("expected %v, got %v", 2, x)
+	}
+	w := time.Duration(setts.Int64("llrb.snapshottick")) * time.Millisecond
+	time.Sleep(2 * w)
+	if v, _, _, _ := index.Get(key1, []byte{}); string(v) != "value1" {
+		t.Errorf("unexpected %s", v)
+	}
+
+	index.Close()
+	index.Destroy()
+}
+
+func TestObserver(t *testing.T) {
+	destoryindex("index", makepaths())
+
+	var mu sync.Mutex
+	events := map[string][]Event{}
+	observer := func(event Event) {
+		mu.Lock()
+		events[event.Kind] = append(events[event.Kind], event)
+		mu.Unlock()
+	}
+
+	setts, paths := makesettings(), makepaths()
+	setts["bubt.diskpaths"] = paths
+	setts["durable"] = true
+	setts["dgm"] = true
+	setts["autocommit"] = 1
+	setts["observer"] = observer
+	index, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+
+	n := 1000
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key%08d", i))
+		index.Set(key, key, nil)
+	}
+	// wait for flush and purge.
+	time.Sleep(4 * time.Second)
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key%08d", i+n))
+		index.Set(key, key, nil)
+	}
+	index.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if x, y := len(events[EventFlushStart]), len(events[EventFlushEnd]); x == 0 {
+		t.Errorf("expected flush events")
+	} else if x != y {
+		t.Errorf("expected %v, got %v", x, y)
+	}
+	event := events[EventFlushEnd][0]
+	if event.Err != nil {
+		t.Errorf("unexpected %v", event.Err)
+	} else if event.Entries != int64(n) {
+		t.Errorf("expected %v, got %v", n, event.Entries)
+	} else if event.Bytes <= 0 || event.Took <= 0 {
+		t.Errorf("unexpected %v %v", event.Bytes, event.Took)
+	} else if event.Level == "" || len(event.Levels) == 0 {
+		t.Errorf("unexpected %v %v", event.Level, event.Levels)
+	}
+	if len(events[EventPurge]) == 0 {
+		t.Errorf("expected purge events")
+	} else if event := events[EventPurge][0]; len(event.Levels) == 0 {
+		t.Errorf("unexpected %v", event.Levels)
+	}
+	if x := len(events[EventWindupEnd]); x != 1 {
+		t.Errorf("expected %v, got %v", 1, x)
+	} else if event := events[EventWindupEnd][0]; event.Entries != int64(2*n) {
+		t.Errorf("expected %v, got %v", 2*n, event.Entries)
+	}
+
+	index.Destroy()
+}
+
+func TestGetForUpdate(t *testing.T) {
+	destoryindex("index", makepaths())
+
+	setts, paths := makesettings(), makepaths()
+	setts["bubt.diskpaths"] = paths
+	setts["durable"] = true
+	setts["dgm"] = true
+	setts["autocommit"] = 1
+	setts["locktimeout"] = 100
+	index, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+
+	key1, key2 := []byte("key1"), []byte("key2")
+	index.Set(key1, []byte("0"), nil)
+	index.Set(key2, []byte("0"), nil)
+	// wait for flush, keys shall be read from disk.
+	time.Sleep(3 * time.Second)
+
+	// concurrent transactions increment the same key.
+	n, donech := 10, make(chan error, 10)
+	for i := 0; i < n; i++ {
+		go func(id uint64) {
+			txn := index.BeginTxn(id).(*Txn)
+			value, _, _, _, err := txn.GetForUpdate(key1, []byte{})
+			if err != nil {
+				txn.Abort()
+				donech <- err
+				return
+			}
+			x, _ := strconv.Atoi(string(value))
+			txn.Set(key1, []byte(strconv.Itoa(x+1)), nil)
+			donech <- txn.Commit()
+		}(uint64(i + 1))
+	}
+	for i := 0; i < n; i++ {
+		if err := <-donech; err != nil {
+			t.Errorf("unexpected %v", err)
+		}
+	}
+	w := time.Duration(setts.Int64("llrb.snapshottick")) * time.Millisecond
+	time.Sleep(2 * w)
+	if value, _, _, _ := index.Get(key1, []byte{}); string(value) != "10" {
+		t.Errorf("expected %v, got %s", 10, value)
+	}
+
+	// lock timeout, lock is released on abort.
+	txn1 := index.BeginTxn(0x1).(*Txn)
+	txn2 := index.BeginTxn(0x2).(*Txn)
+	if _, _, _, _, err := txn1.GetForUpdate(key2, nil); err != nil {
+		t.Fatal(err)
+	}
+	_, _, _, _, err = txn2.GetForUpdate(key2, nil)
+	if err != api.ErrorLocktimeout {
+		t.Errorf("expected %v, got %v", api.ErrorLocktimeout, err)
+	}
+	txn1.Abort()
+	if _, _, _, _, err := txn2.GetForUpdate(key2, nil); err != nil {
+		t.Errorf("unexpected %v", err)
+	}
+	txn2.Abort()
+
+	index.Close()
+	index.Destroy()
+}
+
+func TestReadOnly(t *testing.T) {
+	destoryindex("index", makepaths())
+
+	setts, paths := makesettings(), makepaths()
+	setts["bubt.diskpaths"] = paths
+	setts["durable"] = true
+	setts["dgm"] = true
+	setts["autocommit"] = 1
+	index, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+
+	n := 1000
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key%08d", i))
+		index.Set(key, key, nil)
+	}
+	time.Sleep(2 * time.Second) // let autocommit elapse.
+	index.Commit(nil)
+
+	rsetts := makesettings()
+	rsetts["bubt.diskpaths"] = paths
+	reader, err := OpenReadOnly("index", rsetts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	countkeys := func() (count int) {
+		iter := reader.Scan()
+		for _, _, _, _, err := iter(false); err == nil; count++ {
+			_, _, _, _, err = iter(false)
+		}
+		iter(true)
+		return count
+	}
+	if count := countkeys(); count != n {
+		t.Errorf("expected %v, got %v", n, count)
+	}
+	key := []byte(fmt.Sprintf("key%08d", 10))
+	if value, _, _, ok := reader.Get(key, []byte{}); !ok {
+		t.Errorf("missing key %s", key)
+	} else if string(value) != string(key) {
+		t.Errorf("expected %s, got %s", key, value)
+	}
+	if seqno := reader.Getseqno(); seqno != uint64(n) {
+		t.Errorf("expected %v, got %v", n, seqno)
+	}
+
+	// writes are refused.
+	func() {
+		defer func() {
+			if r := recover(); r != api.ErrorReadonly {
+				t.Errorf("expected %v, got %v", api.ErrorReadonly, r)
+			}
+		}()
+		reader.Set(key, key, nil)
+	}()
+	if _, _, err := reader.SetCAS(key, key, nil, 0); err != api.ErrorReadonly {
+		t.Errorf("expected %v, got %v", api.ErrorReadonly, err)
+	}
+	if err := reader.NewWriteBatch().Set(key, key).Apply(); err != api.ErrorReadonly {
+		t.Errorf("expected %v, got %v", api.ErrorReadonly, err)
+	}
+
+	// view opened before refresh shall continue with older levels.
+	view := reader.View(0x1234)
+
+	for i := n; i < 2*n; i++ {
+		key := []byte(fmt.Sprintf("key%08d", i))
+		index.Set(key, key, nil)
+	}
+	time.Sleep(2 * time.Second) // let autocommit elapse.
+	index.Commit(nil)
+
+	key = []byte(fmt.Sprintf("key%08d", n+10))
+	if _, _, _, ok := reader.Get(key, nil); ok {
+		t.Errorf("unexpected key %s before refresh", key)
+	}
+	if err := reader.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, ok := reader.Get(key, nil); !ok {
+		t.Errorf("missing key %s after refresh", key)
+	}
+	if count := countkeys(); count != 2*n {
+		t.Errorf("expected %v, got %v", 2*n, count)
+	}
+	if _, _, _, ok := view.Get(key, nil); ok {
+		t.Errorf("unexpected key %s in older view", key)
+	}
+	view.Abort()
+
+	// refresh without new levels is a no-op.
+	if err := reader.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if err := index.Refresh(); err == nil {
+		t.Errorf("expected error refreshing writable index")
+	}
+
+	reader.Close()
+	index.Close()
+	index.Destroy()
+}
+
+func TestReadOnlyCompact(t *testing.T) {
+	destoryindex("index", makepaths())
+
+	policy := &mergepolicy{}
+	setts, paths := makesettings(), makepaths()
+	setts["bubt.diskpaths"] = paths
+	setts["durable"] = true
+	setts["dgm"] = true
+	setts["autocommit"] = 1
+	setts["compactionpolicy"] = policy
+	index, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+
+	n := 1000
+	flush := func(from int) {
+		for i := from; i < from+n; i++ {
+			key := []byte(fmt.Sprintf("key%08d", i))
+			index.Set(key, key, nil)
+		}
+		time.Sleep(2 * time.Second) // let autocommit elapse.
+		index.Commit(nil)
+	}
+	flush(0)
+	flush(n)
+
+	rsetts := makesettings()
+	rsetts["bubt.diskpaths"] = paths
+	reader, err := OpenReadOnly("index", rsetts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	disks := reader.currsnapshot().disklevels([]api.Index{})
+	if len(disks) != 2 {
+		t.Fatalf("expected %v, got %v", 2, len(disks))
+	}
+	stale := disks[0].ID()
+
+	// merge both levels while reader is holding them.
+	atomic.StoreInt64(&policy.compact, 1)
+	flush(2 * n)
+	for i := 0; len(index.currsnapshot().disklevels([]api.Index{})) != 2; i++ {
+		if i > 1000 {
+			t.Fatalf("compaction did not complete")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := reader.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	for _, disk := range reader.currsnapshot().disklevels([]api.Index{}) {
+		if disk.ID() == stale {
+			t.Errorf("unexpected stale level %v", stale)
+		}
+	}
+	count, iter := 0, reader.Scan()
+	for _, _, _, _, err := iter(false); err == nil; count++ {
+		_, _, _, _, err = iter(false)
+	}
+	iter(true)
+	if count != 3*n {
+		t.Errorf("expected %v, got %v", 3*n, count)
+	}
+
+	// writer shall be able to purge the stale level.
+	ispurged := func() bool {
+		for _, path := range index.getdiskpaths() {
+			if _, err := os.Stat(filepath.Join(path, stale)); err == nil {
+				return false
+			}
+		}
+		return true
+	}
+	for i := 0; ispurged() == false; i++ {
+		if i > 1000 {
+			t.Fatalf("stale level %v not purged", stale)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	reader.Close()
+	index.Close()
+	index.Destroy()
+}
+
+// mergepolicy flush to a newer level without merge, and merge all disk
+// levels into the oldest level when compact is set.
+type mergepolicy struct {
+	compact int64
+}
+
+func (policy *mergepolicy) Flush(
+	levels *Levels, compacting []int) ([]int, int, string) {
+
+	if latest, ok := levels.Latest(); ok {
+		return nil, latest.Level - 1, "flush.fallback"
+	}
+	return nil, levels.Nlevels - 1, "flush.fresh"
+}
+
+func (policy *mergepolicy) Compact(levels *Levels) ([]int, int, string) {
+	if len(levels.Disks) < 2 {
+		return nil, -1, "compact.none"
+	} else if atomic.CompareAndSwapInt64(&policy.compact, 1, 0) {
+		oldest, _ := levels.Oldest()
+		return levelsof(levels.Disks), oldest.Level, "compact.merge"
+	}
+	return nil, -1, "compact.none"
+}
+
+func (policy *mergepolicy) Windup(levels *Levels) (int, int) {
+	if latest, ok := levels.Latest(); ok {
+		return -1, latest.Level - 1
+	}
+	return -1, levels.Nlevels - 1
+}
+
+func TestReplication(t *testing.T) {
+	// in-process transport.
+	ltr, ftr := NewPipe()
+	testreplication(t, ltr, ftr)
+
+	// tcp loopback transport.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	connch := make(chan net.Conn, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			t.Error(err)
+		}
+		connch <- conn
+	}()
+	fconn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lconn := <-connch
+	ln.Close()
+	testreplication(t, NewConnTransport(lconn), NewConnTransport(fconn))
+}
+
+func TestFollowerClose(t *testing.T) {
+	lpaths := makepaths()
+	fpaths := filepath.Join(os.TempDir(), "f1")
+	fpaths += "," + filepath.Join(os.TempDir(), "f2")
+	destoryindex("index", lpaths)
+	destoryindex("index", fpaths)
+
+	setts := makesettings()
+	setts["bubt.diskpaths"] = lpaths
+	setts["durable"] = true
+	leader, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leader.Start()
+
+	n := 100
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key%08d", i))
+		leader.Set(key, key, nil)
+	}
+
+	ltr, ftr := NewPipe()
+	if err := leader.Replicate(ltr); err != nil {
+		t.Fatal(err)
+	}
+	fsetts := makesettings()
+	fsetts["bubt.diskpaths"] = fpaths
+	fsetts["durable"] = true
+	follower, err := Follow("index", fsetts, ftr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		if follower.Getseqno() == leader.Getseqno() {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if x, y := leader.Getseqno(), follower.Getseqno(); x != y {
+		t.Fatalf("expected %v, got %v", x, y)
+	}
+
+	// closed follower is no more following, and can be destroyed.
+	follower.Close()
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				t.Errorf("unexpected panic %v", r)
+			}
+		}()
+		follower.Destroy()
+	}()
+
+	// leader forgets the follower once replication to it has stopped.
+	for i := 0; i < 1000; i++ {
+		if leader.Stats()["replication"] == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if x := leader.Stats()["replication"]; x != nil {
+		t.Errorf("unexpected %v", x)
+	}
+	leader.Close()
+	leader.Destroy()
+}
+
+func TestTransportMaxmsg(t *testing.T) {
+	lconn, fconn := net.Pipe()
+	tr := NewConnTransport(fconn)
+	defer tr.Close()
+
+	go func() {
+		var hdr [4]byte
+		binary.BigEndian.PutUint32(hdr[:], replmaxmsg+1)
+		lconn.Write(hdr[:])
+		lconn.Close()
+	}()
+	if _, err := tr.Recv(); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestReplicationPurge(t *testing.T) {
+	lpaths := makepaths()
+	destoryindex("index", lpaths)
+
+	setts := makesettings()
+	setts["bubt.diskpaths"] = lpaths
+	setts["durable"] = true
+	leader, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leader.Start()
+
+	n := 100
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key%08d", i))
+		leader.Set(key, key, nil)
+	}
+	seqno := leader.Getseqno()
+
+	ltr, ftr := NewPipe()
+	if err := leader.Replicate(ltr); err != nil {
+		t.Fatal(err)
+	}
+	waitfor := func(ref uint64) {
+		for i := 0; i < 1000; i++ {
+			if leader.replpurgeseqno(seqno) == ref {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("expected %v, got %v", ref, leader.replpurgeseqno(seqno))
+	}
+
+	// log is retained upto the seqno acknowledged by follower.
+	ftr.Send(replseqnomsg(nil, replHello, 0))
+	waitfor(0)
+	ftr.Send(replseqnomsg(nil, replAck, seqno/2))
+	waitfor(seqno / 2)
+	// and not held back by followers that stopped replicating.
+	ftr.Close()
+	waitfor(seqno)
+
+	leader.Close()
+	leader.Destroy()
+}
+
+func testreplication(t *testing.T, ltr, ftr Transport) {
+	lpaths := makepaths()
+	fpaths := filepath.Join(os.TempDir(), "f1")
+	fpaths += "," + filepath.Join(os.TempDir(), "f2")
+	destoryindex("index", lpaths)
+	destoryindex("index", fpaths)
+
+	setts := makesettings()
+	setts["bubt.diskpaths"] = lpaths
+	setts["durable"] = true
+	setts["dgm"] = true
+	setts["autocommit"] = 1
+	leader, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leader.Start()
+
+	n := 1000
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key%08d", i))
+		leader.Set(key, key, nil)
+	}
+	time.Sleep(2 * time.Second) // let autocommit elapse.
+	leader.Commit(nil)          // flush to disk and purge log.
+	for i := n; i < n+(n/2); i++ {
+		key := []byte(fmt.Sprintf("key%08d", i))
+		leader.Set(key, key, nil)
+	}
+	leader.Delete([]byte(fmt.Sprintf("key%08d", 10)), nil, true)
+
+	if err := leader.Replicate(ltr); err != nil {
+		t.Fatal(err)
+	}
+	fsetts := makesettings()
+	fsetts["bubt.diskpaths"] = fpaths
+	fsetts["durable"] = true
+	fsetts["dgm"] = true
+	fsetts["autocommit"] = 1
+	follower, err := Follow("index", fsetts, ftr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	catchup := func() {
+		for i := 0; i < 1000; i++ {
+			stats := leader.Stats()["replication"].(map[string]interface{})
+			lagging := stats["followers"].([]map[string]interface{})[0]["lag"]
+			if follower.Getseqno() == leader.Getseqno() && lagging == int64(0) {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("follower at %v, leader at %v",
+			follower.Getseqno(), leader.Getseqno())
+	}
+	verify := func(count int) {
+		for i := 0; i < count; i++ {
+			key := []byte(fmt.Sprintf("key%08d", i))
+			lv, lcas, ldel, lok := leader.Get(key, []byte{})
+			fv, fcas, fdel, fok := follower.Get(key, []byte{})
+			if lok != fok || ldel != fdel {
+				t.Fatalf("%s: expected %v,%v, got %v,%v", key, lok, ldel, fok, fdel)
+			} else if lcas != fcas {
+				t.Fatalf("%s: expected cas %v, got %v", key, lcas, fcas)
+			} else if ldel == false && string(lv) != string(fv) {
+				t.Fatalf("%s: expected %s, got %s", key, lv, fv)
+			}
+		}
+	}
+
+	catchup()
+	verify(n + (n / 2))
+
+	// mutations after bootstrap are streamed.
+	for i := n + (n / 2); i < 2*n; i++ {
+		key := []byte(fmt.Sprintf("key%08d", i))
+		leader.Set(key, key, nil)
+	}
+	catchup()
+	verify(2 * n)
+	stats := follower.Stats()["replication"].(map[string]interface{})
+	if lag := stats["leader"].(map[string]interface{})["lag"]; lag != int64(0) {
+		t.Errorf("unexpected lag %v", lag)
+	}
+
+	// writes are refused, until follower is promoted.
+	key := []byte("newkey")
+	func() {
+		defer func() {
+			if r := recover(); r != api.ErrorReadonly {
+				t.Errorf("expected %v, got %v", api.ErrorReadonly, r)
+			}
+		}()
+		follower.Set(key, key, nil)
+	}()
+	if err := follower.Promote(); err != nil {
+		t.Fatal(err)
+	}
+	if _, cas := follower.Set(key, key, nil); cas != leader.Getseqno()+1 {
+		t.Errorf("expected %v, got %v", leader.Getseqno()+1, cas)
+	}
+
+	follower.Close()
+	follower.Destroy()
+	leader.Close()
+	leader.Destroy()
+}
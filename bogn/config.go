@@ -11,6 +11,22 @@ import "github.com/bnclabs/gostore/llrb"
 //		Directory path to store log files. If not supplied, and durable
 //		is true, then one of the diskpath from diskstore will be used.
 //
+// "logsync" (string, default: "none")
+//		This configuration is valid only when `durable` is set to true.
+//		Every mutation is appended to write-ahead-log before it is
+//		acknowledged. Can be "write", to sync log file on every write,
+//		"group", to sync log file every logsynctick and block writers
+//		until their mutation is synced, or "none", to leave it to the
+//		OS.
+//
+// "logsynctick" (int64, default: 10)
+//		Time in milliseconds to periodically sync log file, when logsync
+//		is "group".
+//
+// "logsegsize" (int64, default: 67108864)
+//		Size in bytes beyond which log file shall be rotated to a new
+//		segment.
+//
 // "memstore" (string, default: "llrb")
 //		Type of index for in memory storage, can be "llrb" or "mvcc".
 //
@@ -50,6 +66,98 @@ import "github.com/bnclabs/gostore/llrb"
 //      If the lifetime, measured in seconds, of a disk snapshot exceeds
 //		compactperiod, then it will be merged with next disk level snapshot.
 //
+// "compaction" (string, default: "default")
+//      This configuration is valid only when `dgm` is set to true.
+//		Compaction policy to pick disk levels for flush and compaction,
+//		can be one of the following:
+//		"default", levels are merged based on flushratio, compactratio,
+//		compactperiod, compactlevels and garbageratio.
+//		"sizetiered", levels of similar size are merged, refer to
+//		tierwidth and tierratio.
+//		"timewindow", levels flushed within the same time window are
+//		merged, refer to timewindow.
+//
+// "compactlevels" (int64, default: 3)
+//      Applicable for "default" compaction. When number of disk levels
+//		exceed compactlevels, intermediate levels are merged together.
+//
+// "garbageratio" (floating, default: .25)
+//      Applicable for "default" compaction. When ratio between payload
+//		and disk-footprint of the oldest level falls below garbageratio,
+//		oldest level is compacted on to itself.
+//
+// "tierwidth" (int64, default: 4)
+//      Applicable for "sizetiered" compaction. Minimum number of
+//		successive levels, of similar size, to be merged together.
+//
+// "tierratio" (floating, default: 2.0)
+//      Applicable for "sizetiered" compaction. Levels are of similar
+//		size if ratio between largest and smallest level is within
+//		tierratio.
+//
+// "timewindow" (int64, default: 3600)
+//      Applicable for "timewindow" compaction. Size of time window, in
+//		seconds, levels flushed within the same window are merged
+//		together once the window has elapsed.
+//
+// "compactionpolicy" (CompactionPolicy, default: nil)
+//		Optional application defined policy, overrides "compaction".
+//		This setting is not persisted.
+//
+// "ratelimit" (int64, default: 0)
+//      Limit, in bytes per second, on disk writes done by background
+//		flush and compaction, shared by all of them. ZERO means
+//		unlimited. Can be changed at runtime via SetRatelimit().
+//
+// "stallsoftlimit" (floating, default: .80)
+//      Ratio of llrb.memcapacity. When memory footprint of in-memory
+//		levels exceeds this limit, writes are slowed down in proportion,
+//		giving flushers a chance to catch up. ZERO disables soft stall.
+//
+// "stallhardlimit" (floating, default: .95)
+//      Ratio of llrb.memcapacity. When memory footprint of in-memory
+//		levels exceeds this limit, writes are stopped until flushers
+//		release memory. ZERO disables hard stall. Stalls are disabled
+//		for non-durable index, whose memory is never flushed to disk.
+//
+// "stallblock" (bool, default: true)
+//      If false, SetCAS() and Txn.Commit() shall return ErrorStall,
+//		instead of blocking, when memory footprint exceeds hard limit.
+//		Set(), SetExpiry(), Delete() and Merge() always block.
+//
+// "locktimeout" (int64, default: 1000)
+//      Time period in millisecond, a transaction shall wait to lock a
+//		key in Txn.GetForUpdate(). If ZERO, wait till the lock is
+//		released.
+//
+// "txnmaxage" (int64, default: 0)
+//      Time period in seconds. Transactions and views active beyond
+//		txnmaxage pin older snapshots, preventing memory levels from
+//		being freed and compacted disk levels from being purged. They
+//		are logged as warnings along with the stack that created them,
+//		refer to Stats()["txns"]. If ZERO, tracking is disabled.
+//
+// "txnabort" (bool, default: false)
+//      If true, transactions and views active beyond txnmaxage are
+//		aborted on their next call, Commit, OpenCursor, GetForUpdate
+//		and RollbackTo shall return ErrorExpired.
+//
+// "compactionfilter" (CompactionFilter, default: nil)
+//		Optional callback applied on every entry written to a new disk
+//		level while flushing, compacting and winding up. Refer to
+//		CompactionFilter for details. This setting is not persisted.
+//
+// "mergeoperator" (api.MergeOperator, default: nil)
+//		Optional callback to fold operands supplied via Merge, over
+//		the key's older value. Operator must be associative. This
+//		setting is not persisted, and must be supplied every time the
+//		index is opened.
+//
+// "observer" (Observer, default: nil)
+//		Optional observer notified when index changes its layout, on
+//		flush, compaction, windup, dgm switch and purge of levels.
+//		Refer to Observer for details. This setting is not persisted.
+//
 // "bubt.mblocksize" (int64, default: 4096)
 //		BottomsUpBTree, size of intermediate node, m-nodes, on disk.
 //
@@ -59,6 +167,11 @@ import "github.com/bnclabs/gostore/llrb"
 // "bubt.vblocksize" (int64, default: same as mblocksize)
 //		BottomsUpBTree, size of value log blocsk, on disk.
 //
+// "bubt.bloombits" (int64, default: 0)
+//		BottomsUpBTree, bits per key for bloom filter on each disk level,
+//		used to skip disk lookups for missing keys. ZERO disables bloom
+//		filter.
+//
 // "bubt.mmap" (bool, default: true)
 //		BottomsUpBTree, whether to memory-map leaf node, intermediate
 //		nodes are always memory-mapped.
@@ -69,16 +182,32 @@ import "github.com/bnclabs/gostore/llrb"
 //
 func Defaultsettings() s.Settings {
 	setts := s.Settings{
-		"logpath":       "",
-		"memstore":      "mvcc",
-		"diskstore":     "bubt",
-		"durable":       true,
-		"dgm":           false,
-		"workingset":    false,
-		"flushratio":    0.25,
-		"autocommit":    100,
-		"compactratio":  0.50,
-		"compactperiod": 300,
+		"logpath":        "",
+		"logsync":        "none",
+		"logsynctick":    10,
+		"logsegsize":     64 * 1024 * 1024,
+		"memstore":       "mvcc",
+		"diskstore":      "bubt",
+		"durable":        true,
+		"dgm":            false,
+		"workingset":     false,
+		"flushratio":     0.25,
+		"autocommit":     100,
+		"compactratio":   0.50,
+		"compactperiod":  300,
+		"compaction":     "default",
+		"compactlevels":  3,
+		"garbageratio":   0.25,
+		"tierwidth":      4,
+		"tierratio":      2.0,
+		"timewindow":     3600,
+		"ratelimit":      0,
+		"stallsoftlimit": 0.80,
+		"stallhardlimit": 0.95,
+		"stallblock":     true,
+		"locktimeout":    1000,
+		"txnmaxage":      0,
+		"txnabort":       false,
 	}
 	switch setts.String("memstore") {
 	case "mvcc", "llrb":
@@ -92,6 +221,7 @@ func Defaultsettings() s.Settings {
 			"bubt.mblocksize": 4096,
 			"bubt.zblocksize": 4096,
 			"bubt.vblocksize": 0,
+			"bubt.bloombits":  0,
 			"bubt.mmap":       true,
 		}
 		setts = (s.Settings{}).Mixin(setts, bubtsetts)
@@ -1,6 +1,8 @@
 package bogn
 
+import "io"
 import "fmt"
+import "bytes"
 
 import "github.com/bnclabs/gostore/api"
 import "github.com/bnclabs/gostore/lib"
@@ -17,32 +19,62 @@ type Cursor struct {
 	cas     uint64
 	deleted bool
 
+	// to switch direction, level cursors are re-opened at lastkey.
+	seekkey []byte // key with which the cursor was opened.
+	lastkey []byte // last entry returned by the cursor.
+	reverse bool   // cursor is moving in descending order.
+	eof     bool
+	skip    bool
+
 	iter  api.Iterator
 	iters []api.Iterator
 }
 
 func (cur *Cursor) opencursor(t *Txn, v *View, key []byte) (*Cursor, error) {
+	cur.txn, cur.view = t, v
+	cur.seekkey = lib.Fixbuffer(cur.seekkey, int64(len(key)))
+	copy(cur.seekkey, key)
+	cur.lastkey = lib.Fixbuffer(cur.lastkey, 0)
+	cur.reverse, cur.eof, cur.skip = false, false, false
+
+	if err := cur.openiters(key, false /*reverse*/); err != nil {
+		return cur, err
+	}
+	cur.YNext(false /*fin*/)
+	return cur, nil
+}
+
+// openiters open a cursor on every level positioned at key and
+// lsm-sort them, in ascending order or in descending order.
+func (cur *Cursor) openiters(key []byte, reverse bool) error {
 	var mrview, mcview api.Transactor
 	var dviews [32]api.Transactor
 	var dviews1 []api.Transactor
 
-	cur.txn, cur.view = t, v
+	cur.iter, cur.iters = nil, cur.iters[:0]
+	addcursor := func(c api.Cursor) {
+		if reverse {
+			cur.iters = append(cur.iters, c.YPrev)
+			return
+		}
+		cur.iters = append(cur.iters, c.YNext)
+	}
 
 	if cur.txn != nil {
 		mwcur, err := cur.txn.mwtxn.OpenCursor(key)
 		if err != nil {
-			return cur, err
+			return err
 		}
-		cur.iters = append(cur.iters, mwcur.YNext)
+		addcursor(mwcur)
 		mrview, mcview = cur.txn.mrview, cur.txn.mcview
 		dviews1 = dviews[:copy(dviews[:], cur.txn.dviews)]
 
 	} else if cur.view != nil {
 		mwcur, err := cur.view.mwview.OpenCursor(key)
 		if err != nil {
-			return cur, err
+			return err
 		}
-		cur.iters = append(cur.iters, mwcur.YNext)
+		addcursor(mwcur)
 		mrview, mcview = cur.view.mrview, cur.view.mcview
 		dviews1 = dviews[:copy(dviews[:], cur.view.dviews)]
 	}
@@ -50,33 +82,54 @@ func (cur *Cursor) opencursor(t *Txn, v *View, key []byte) (*Cursor, error) {
 	if mrview != nil {
 		mcur, err := mrview.OpenCursor(key)
 		if err != nil {
-			return cur, err
+			return err
 		}
-		cur.iters = append(cur.iters, mcur.YNext)
+		addcursor(mcur)
 	}
 	if mcview != nil {
 		mcur, err := mcview.OpenCursor(key)
 		if err != nil {
-			return cur, err
+			return err
 		}
-		cur.iters = append(cur.iters, mcur.YNext)
+		addcursor(mcur)
 	}
 	for _, dview := range dviews1 {
 		dcur, err := dview.OpenCursor(key)
 		if err != nil {
-			return cur, err
+			return err
 		}
-		cur.iters = append(cur.iters, dcur.YNext)
+		addcursor(dcur)
 	}
 	if len(cur.iters) > 0 {
 		cur.iter = cur.iters[len(cur.iters)-1]
 		for i := len(cur.iters) - 2; i >= 0; i-- {
-			cur.iter = lsm.YSort(cur.iters[i], cur.iter)
+			if reverse {
+				cur.iter = lsm.YSortDesc(cur.iters[i], cur.iter)
+			} else {
+				cur.iter = lsm.YSort(cur.iters[i], cur.iter)
+			}
 		}
 	}
+	return nil
+}
 
-	cur.YNext(false /*fin*/)
-	return cur, nil
+// turnaround re-open level cursors to iterate in the other direction,
+// starting from the entry next to lastkey. If cursor has moved past
+// the end, entry at lastkey shall be the next entry.
+func (cur *Cursor) turnaround(reverse bool) error {
+	key, include := cur.lastkey, cur.eof
+	if len(key) == 0 { // cursor never had a valid entry.
+		key, include = cur.seekkey, true
+	}
+	var seekkey []byte
+	if len(key) > 0 {
+		seekkey = append(make([]byte, 0, len(key)+1), key...)
+	}
+	if reverse && include {
+		seekkey = append(seekkey, 0)
+	}
+	cur.reverse, cur.eof, cur.skip = reverse, false, !reverse && !include
+	return cur.openiters(seekkey, reverse)
 }
 
 // Key return current key under the cursor. Returned byte slice will
@@ -101,6 +154,14 @@ func (cur *Cursor) GetNext() (key, value []byte, deleted bool, err error) {
 	return cur.key, cur.value, cur.deleted, err
 }
 
+// GetPrev move cursor to previous entry in snapshot and return its key
+// and value. Returned byte slices will be a reference to index entry,
+// hence must not be used after transaction is committed or aborted.
+func (cur *Cursor) GetPrev() (key, value []byte, deleted bool, err error) {
+	_, _, _, _, err = cur.YPrev(false /*fin*/)
+	return cur.key, cur.value, cur.deleted, err
+}
+
 // Set is an alias to txn.Set call. The current position of the cursor
 // does not affect the set operation.
 func (cur *Cursor) Set(key, value, oldvalue []byte) []byte {
@@ -132,7 +193,39 @@ func (cur *Cursor) Delcursor(lsm bool) {
 func (cur *Cursor) YNext(
 	fin bool) (key, value []byte, cas uint64, deleted bool, err error) {
 
-	key, value, cur.cas, cur.deleted, err = cur.iter(false /*fin*/)
+	if cur.reverse {
+		if err = cur.turnaround(false /*reverse*/); err != nil {
+			return nil, nil, 0, false, err
+		}
+	}
+	key, value, cas, deleted, err = cur.yiter()
+	if cur.skip && err == nil && bytes.Equal(key, cur.lastkey) {
+		key, value, cas, deleted, err = cur.yiter()
+	}
+	cur.skip = false
+	return
+}
+
+// YPrev can be used for descending lsm-sort.
+func (cur *Cursor) YPrev(
+	fin bool) (key, value []byte, cas uint64, deleted bool, err error) {
+
+	if cur.reverse == false {
+		if err = cur.turnaround(true /*reverse*/); err != nil {
+			return nil, nil, 0, false, err
+		}
+	}
+	return cur.yiter()
+}
+
+func (cur *Cursor) yiter() (
+	key, value []byte, cas uint64, deleted bool, err error) {
+
+	if cur.iter == nil {
+		key, value, cur.cas, cur.deleted, err = nil, nil, 0, false, io.EOF
+	} else {
+		key, value, cur.cas, cur.deleted, err = cur.iter(false /*fin*/)
+	}
 
 	cur.key = lib.Fixbuffer(cur.key, int64(len(key)))
 	copy(cur.key, key)
@@ -140,5 +233,10 @@ func (cur *Cursor) YNext(
 	cur.value = lib.Fixbuffer(cur.value, int64(len(value)))
 	copy(cur.value, value)
 
+	if cur.eof = err != nil; cur.eof == false {
+		cur.lastkey = lib.Fixbuffer(cur.lastkey, int64(len(key)))
+		copy(cur.lastkey, key)
+	}
+
 	return cur.key, cur.value, cur.cas, cur.deleted, err
 }
@@ -0,0 +1,124 @@
+package bogn
+
+import "sync/atomic"
+
+import "github.com/bnclabs/gostore/api"
+import s "github.com/bnclabs/gosettings"
+
+// Actions returned by CompactionFilter.
+const (
+	// FilterKeep shall retain the entry as is.
+	FilterKeep int = iota
+	// FilterDrop shall remove the entry from disk level.
+	FilterDrop
+	// FilterChange shall replace entry's value with returned value.
+	FilterChange
+)
+
+// CompactionFilter is called for every entry that is written to a new
+// disk level, while flushing memory to disk, compacting disk levels,
+// and winding up the index on Close. Return one of FilterKeep,
+// FilterDrop or FilterChange, along with the new value for
+// FilterChange. Filter is called from background routines and must not
+// call back into the index.
+type CompactionFilter func(
+	key, value []byte, seqno uint64, deleted bool) (action int, nv []byte)
+
+func (bogn *Bogn) readfilter(setts s.Settings) CompactionFilter {
+	switch fn := setts["compactionfilter"].(type) {
+	case CompactionFilter:
+		return fn
+	case func([]byte, []byte, uint64, bool) (int, []byte):
+		return CompactionFilter(fn)
+	}
+	return nil
+}
+
+// filteriterator apply compaction filter on entries from itere that are
+// destined to disk `level`, applied on every disk level built by flush,
+// persist, compaction and windup. Dropped entries are retained as
+// tombstones, unless level is the last level, so that older versions
+// of the same key in other levels stay shadowed.
+func (bogn *Bogn) filteriterator(
+	itere api.EntryIterator, level int) api.EntryIterator {
+
+	if itere == nil || bogn.compactionfilter == nil {
+		return itere
+	}
+
+	lastlevel := bogn.islastlevel(level)
+	fentry := &filterentry{}
+	return func(fin bool) api.IndexEntry {
+		for {
+			entry := itere(fin)
+			key, seqno, deleted, err := entry.Key()
+			if err != nil || fin {
+				return entry
+			}
+			value := entry.Value()
+			action, nv := bogn.compactionfilter(key, value, seqno, deleted)
+			switch action {
+			case FilterKeep:
+				return entry
+
+			case FilterDrop:
+				atomic.AddInt64(&bogn.nfiltdropped, 1)
+				if lastlevel {
+					continue
+				}
+				return fentry.set(entry, nil, true /*deleted*/)
+
+			case FilterChange:
+				atomic.AddInt64(&bogn.nfiltchanged, 1)
+				return fentry.set(entry, nv, false /*deleted*/)
+			}
+			panic("invalid compaction filter action")
+		}
+	}
+}
+
+// filterentry wraps an entry whose value is replaced or deleted by
+// compaction filter.
+type filterentry struct {
+	entry   api.IndexEntry
+	value   []byte
+	deleted bool
+}
+
+func (fe *filterentry) set(
+	entry api.IndexEntry, value []byte, deleted bool) *filterentry {
+
+	fe.entry, fe.value, fe.deleted = entry, value, deleted
+	return fe
+}
+
+// ID of a filtered entry is always empty, its value is either changed
+// or dropped, hence it can neither refer to the value-log of the level
+// it was read from, nor shall it be appended as such by bubt.
+func (fe *filterentry) ID() string {
+	return ""
+}
+
+func (fe *filterentry) Key() (key []byte, seqno uint64, del bool, err error) {
+	key, seqno, _, err = fe.entry.Key()
+	return key, seqno, fe.deleted, err
+}
+
+func (fe *filterentry) Value() []byte {
+	return fe.value
+}
+
+func (fe *filterentry) Valueref() (valuelen uint64, vlogpos int64) {
+	return uint64(len(fe.value)), -1
+}
+
+func (fe *filterentry) Expiry() int64 {
+	if fe.deleted {
+		return 0
+	}
+	return fe.entry.Expiry()
+}
+
+func (fe *filterentry) IsOperand() bool {
+	return false
+}
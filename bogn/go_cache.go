@@ -13,6 +13,7 @@ type setcache struct {
 	value   []byte
 	seqno   uint64
 	deleted bool
+	expiry  int64
 }
 
 func cacher(bogn *Bogn, mc api.Index, setch, cachech chan *setcache) {
@@ -46,6 +47,13 @@ func cacher(bogn *Bogn, mc api.Index, setch, cachech chan *setcache) {
 				panic("impossible situation")
 			}
 
+		} else if cmd.expiry > 0 {
+			key, value := cmd.key, cmd.value
+			_, cas := mc.(api.Expirer).SetExpiry(key, value, nil, cmd.expiry)
+			if cas != cmd.seqno {
+				panic("impossible situation")
+			}
+
 		} else if _, cas := mc.Set(cmd.key, cmd.value, nil); cas != cmd.seqno {
 			panic("impossible situation")
 		}
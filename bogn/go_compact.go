@@ -24,6 +24,7 @@ var Compacttick = time.Duration(1 * time.Second)
 //   startdisk( bogn *Bogn, disks []api.Index, nlevel int)
 //   findisk(bogn *Bogn, disks []api.Index, ndisk api.Index) error
 // dowindup(bogn *Bogn) error
+// docheckpoint(bogn *Bogn, disks []api.Index, dir string) error
 
 func posttick(bogn *Bogn) {
 	respch := make(chan []interface{}, 1)
@@ -42,6 +43,19 @@ func postcommit(bogn *Bogn, appdata []byte) {
 	lib.FailsafeRequest(bogn.compactorch, respch, cmd, nil)
 }
 
+func postcheckpoint(bogn *Bogn, dir string) error {
+	respch := make(chan []interface{}, 1)
+	cmd := []interface{}{"compact.checkpoint", dir, respch}
+	reqch, finch := bogn.compactorch, bogn.finch
+	resp, err := lib.FailsafeRequest(reqch, respch, cmd, finch)
+	if err != nil {
+		return err
+	} else if resp[0] != nil {
+		return resp[0].(error)
+	}
+	return nil
+}
+
 func compactorclose(bogn *Bogn) {
 	respch := make(chan []interface{}, 1)
 	cmd := []interface{}{"compact.close", respch}
@@ -152,6 +166,11 @@ func compactor(bogn *Bogn, compactorch chan []interface{}) {
 			}
 			respch <- []interface{}{nil}
 
+		case "compact.checkpoint":
+			dir, respch := cmd[1].(string), cmd[2].(chan []interface{})
+			err := docheckpoint(bogn, disks, dir)
+			respch <- []interface{}{err}
+
 		case "compact.findisk":
 			a, b, ndisk, err := cmd[1], cmd[2], api.Index(nil), error(nil)
 			if a != nil {
@@ -212,6 +231,7 @@ func makeflusher(bogn *Bogn) func([]api.Index, []byte) error {
 			if overflow { // fallback to dgm mode.
 				atomic.StoreInt64(&bogn.dgmstate, 1)
 				mwthreshold = int64(memcap * .5) // start with 50% of capacity
+				bogn.notify(Event{Kind: EventDgm, What: "overflow", Dgmstate: 1})
 
 			} else if bogn.flushelapsed() {
 				return dopersist(bogn, appdata)
@@ -250,13 +270,23 @@ func dopersist(bogn *Bogn, appdata []byte) (err error) {
 	nversion := bogn.nextdiskversion(level)
 	disksetts := bogn.settingstodisk()
 
+	start := time.Now()
+	levels := levelids(snap.mw)
+	levels = append(levels, levelids(snap.disklevels([]api.Index{})...)...)
+	bogn.notify(Event{Kind: EventPersistStart, What: "persist", Levels: levels})
+
 	// iterate on snap.mw
 	itere, uuid := snap.persistiterator(), bogn.newuuid()
 	ndisk, err := bogn.builddiskstore(
 		"dopersist", level, nversion, uuid, "" /*flushunix*/, disksetts, itere,
-		"" /*appendid*/, nil /*valuelogs*/, "persist", appdata,
+		"" /*appendid*/, nil /*valuelogs*/, "persist", true /*throttle*/,
+		appdata,
 	)
 	if err != nil {
+		took := time.Since(start)
+		event := bogn.levelevent(
+			EventPersistEnd, "persist", levels, nil, took, err)
+		bogn.notify(event)
 		return err
 	}
 	itere(true /*fin*/)
@@ -287,6 +317,11 @@ func dopersist(bogn *Bogn, appdata []byte) (err error) {
 		fmsg = "%v dopersist: new snapshot %v after persistance %v"
 		infof(fmsg, snap.bogn.logprefix, head.attributes(), ndisk.ID())
 	}()
+	bogn.wal.purge(bogn.replpurgeseqno(lastseqno))
+
+	took := time.Since(start)
+	event := bogn.levelevent(EventPersistEnd, "persist", levels, ndisk, took, nil)
+	bogn.notify(event)
 
 	return nil
 }
@@ -350,6 +385,10 @@ func doflush(
 		infof(fmsg, head.bogn.logprefix, head.attributes(), head.id)
 	}()
 
+	start := time.Now()
+	levels := append(levelids(snap.mr, snap.mc), levelids(fdisks...)...)
+	bogn.notify(Event{Kind: EventFlushStart, What: what, Levels: levels})
+
 	nversion := bogn.nextdiskversion(nlevel)
 	disksetts := bogn.settingstodisk()
 
@@ -360,13 +399,16 @@ func doflush(
 
 	// iterate on snap.mr [+ snap.mc] [+ fdisks]
 	uuid = bogn.newuuid()
-	itere := snap.flushiterator(fdisks)
+	itere := snap.flushiterator(fdisks, nlevel)
 	appendid, valuelogs := bogn.indexvaluelogs(fdisks)
 	ndisk, err := bogn.builddiskstore(
 		"doflush", nlevel, nversion, uuid, "" /*flushunix*/, disksetts, itere,
-		appendid, valuelogs, what, appdata,
+		appendid, valuelogs, what, true /*throttle*/, appdata,
 	)
 	if err != nil {
+		took := time.Since(start)
+		event := bogn.levelevent(EventFlushEnd, what, levels, nil, took, err)
+		bogn.notify(event)
 		return err
 	}
 	itere(true /*fin*/)
@@ -412,6 +454,11 @@ func doflush(
 		fmsg := "%v doflush: new snapshot %v after flush to %v"
 		infof(fmsg, snap.bogn.logprefix, head.attributes(), ndisk.ID())
 	}()
+	bogn.wal.purge(bogn.replpurgeseqno(mwseqno))
+
+	took := time.Since(start)
+	event := bogn.levelevent(EventFlushEnd, what, levels, ndisk, took, nil)
+	bogn.notify(event)
 
 	return nil
 }
@@ -432,16 +479,23 @@ func startdisk(bogn *Bogn, disks []api.Index, nlevel int, what string) {
 	}
 	appendid, valuelogs := bogn.indexvaluelogs(disks)
 
+	start := time.Now()
+	bogn.compactwhat, bogn.compactstart = what, start
+	bogn.notify(Event{Kind: EventCompactStart, What: what, Levels: ids})
+
 	go func() {
 		fmsg := "%v startdisk: compaction (%v) %v ..."
 		infof(fmsg, bogn.logprefix, what, strings.Join(ids, " + "))
 
 		ndisk, err := bogn.builddiskstore(
 			"startdisk", nlevel, nversion, uuid, flushunix, disksetts, itere,
-			appendid, valuelogs, what, appdata,
+			appendid, valuelogs, what, true /*throttle*/, appdata,
 		)
 		itere(true /*fin*/)
 		if err != nil {
+			took := time.Since(start)
+			event := bogn.levelevent(EventCompactEnd, what, ids, nil, took, err)
+			bogn.notify(event)
 			postfindisk(bogn, nil, err)
 
 		} else {
@@ -484,6 +538,11 @@ func findisk(bogn *Bogn, disks []api.Index, ndisk api.Index) error {
 		fmsg := "%v findisk: new snapshot %v after to compact disk %v"
 		infof(fmsg, snap.bogn.logprefix, head.attributes(), ndisk.ID())
 	}()
+
+	what, took := bogn.compactwhat, time.Since(bogn.compactstart)
+	levels := levelids(disks...)
+	event := bogn.levelevent(EventCompactEnd, what, levels, ndisk, took, nil)
+	bogn.notify(event)
 	return nil
 }
 
@@ -523,16 +582,23 @@ func dowindup(bogn *Bogn) error {
 	}
 	infof("%v dowindup: %v", bogn.logprefix, strings.Join(ids, " + "))
 
+	start := time.Now()
+	levels := levelids(snap.mw, snap.mr, snap.mc, purgedisk)
+	bogn.notify(Event{Kind: EventWindupStart, What: "windup", Levels: levels})
+
 	// Finalize mw level, to catch up with tip.
 	snap.finalizeindex(snap.mw)
 
-	itere, uuid := snap.windupiterator(purgedisk), bogn.newuuid()
+	itere, uuid := snap.windupiterator(purgedisk, nlevel), bogn.newuuid()
 	appendid, valuelogs := bogn.indexvaluelogs([]api.Index{purgedisk})
 	ndisk, err := bogn.builddiskstore(
 		"dowindup", nlevel, nversion, uuid, "" /*flushunix*/, disksetts, itere,
-		appendid, valuelogs, "windup", nil, /*appdata*/
+		appendid, valuelogs, "windup", false /*throttle*/, nil, /*appdata*/
 	)
 	if err != nil {
+		took := time.Since(start)
+		event := bogn.levelevent(EventWindupEnd, "windup", levels, nil, took, err)
+		bogn.notify(event)
 		return err
 	}
 	itere(true /*fin*/)
@@ -559,6 +625,52 @@ func dowindup(bogn *Bogn) error {
 		fmsg := "%v dowindup: new snapshot %s windup on disk %v"
 		infof(fmsg, snap.bogn.logprefix, head.attributes(), ndisk.ID())
 	}()
+	purgeseqno := bogn.replpurgeseqno(bogn.getdiskseqno(ndisk))
+	bogn.wal.purge(purgeseqno)
+
+	took := time.Since(start)
+	event := bogn.levelevent(EventWindupEnd, "windup", levels, ndisk, took, nil)
+	bogn.notify(event)
+	return nil
+}
+
+// flush latest mutations to disk and checkpoint disk levels, from
+// the latest snapshot, into dir. Disk levels are not purged until the
+// checkpoint is complete because compactor is blocked on this call.
+func docheckpoint(bogn *Bogn, disks []api.Index, dir string) error {
+	infof("%v docheckpoint %q ...", bogn.logprefix, dir)
+
+	snap := bogn.currsnapshot()
+	if seqno := snap.mwseqno(); snap.isdirty() {
+		var err error
+		if atomic.LoadInt64(&bogn.dgmstate) == 0 { // fullset in memory
+			// persist shall iterate on mw, wait for mw to catch up.
+			snap.catchupindex(snap.mw, seqno)
+			err = dopersist(bogn, nil /*appdata*/)
+		} else {
+			overflow, elapsed := false, true
+			err = doflush(bogn, disks, overflow, elapsed, nil /*appdata*/)
+		}
+		if err != nil {
+			return err
+		}
+		_, disk := bogn.currsnapshot().latestlevel()
+		if disk == nil || bogn.getdiskseqno(disk) < seqno {
+			fmsg := "unable to flush mutations upto %v for checkpoint"
+			return fmt.Errorf(fmsg, seqno)
+		}
+	}
+
+	snap = bogn.latestsnapshot()
+	defer snap.release()
+
+	levels := snap.disklevels([]api.Index{})
+	if err := bogn.checkpointdisks(dir, levels); err != nil {
+		return err
+	}
+
+	fmsg := "%v docheckpoint: %v disk levels checkpointed into %q"
+	infof(fmsg, bogn.logprefix, len(levels), dir)
 	return nil
 }
 
@@ -5,6 +5,7 @@ import "sync/atomic"
 import "runtime/debug"
 
 import "github.com/bnclabs/gostore/lib"
+import "github.com/bnclabs/gostore/bubt"
 
 func purger(bogn *Bogn) {
 	infof("%v rpurger: starting ...", bogn.logprefix)
@@ -28,6 +29,7 @@ loop:
 		if snap != nil && purgesnapshot(next) {
 			atomic.StorePointer(&snap.next, nil)
 		}
+		bogn.sweeptxns()
 		select {
 		case <-bogn.finch:
 			break loop
@@ -48,18 +50,34 @@ func purgesnapshot(snap *snapshot) bool {
 			// all older snapshots are purged,
 			// and this snapshot is not referred by anyone.
 
+			bogn, start := snap.bogn, time.Now()
+			event := Event{Kind: EventPurge, What: "purge"}
+			for _, index := range snap.purgeindexes {
+				event.Levels = append(event.Levels, index.ID())
+				event.Entries += bogn.indexcount(index)
+				event.Bytes += bogn.indexfootprint(index)
+			}
+
 			// first close the disk snapshot, this shall dereference the
 			// snapshot.
 			for _, index := range snap.purgeindexes {
 				index.Close()
 			}
-			// then destroy
+			// then destroy, disk levels of read-only index are owned by
+			// the writer process.
 			for _, index := range snap.purgeindexes {
+				if _, ok := index.(*bubt.Snapshot); ok && bogn.readonly {
+					continue
+				}
 				index.Destroy()
 				fmsg := "%v rpurger: purged %q in snapshot %v"
 				infof(fmsg, snap.bogn.logprefix, index.ID(), snap.id)
 			}
 			snap.close()
+			if len(event.Levels) > 0 {
+				event.Took = time.Since(start)
+				bogn.notify(event)
+			}
 			return true
 		}
 		snap.clearpurge()
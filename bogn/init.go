@@ -5,4 +5,8 @@ import "github.com/bnclabs/gostore/api"
 func init() {
 	// check whether bogn confirms to api.Index{} interface.
 	var _ api.Index = &Bogn{}
+	// check whether bogn confirms to api.Subscriber{} interface.
+	var _ api.Subscriber = &Bogn{}
+	// check whether bogn confirms to api.Expirer{} interface.
+	var _ api.Expirer = &Bogn{}
 }
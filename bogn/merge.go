@@ -0,0 +1,92 @@
+package bogn
+
+import "github.com/bnclabs/gostore/api"
+import "github.com/bnclabs/gostore/lib"
+import "github.com/bnclabs/gostore/lsm"
+import "github.com/bnclabs/gostore/llrb"
+import s "github.com/bnclabs/gosettings"
+
+func (bogn *Bogn) readmerge(setts s.Settings) api.MergeOperator {
+	switch fn := setts["mergeoperator"].(type) {
+	case api.MergeOperator:
+		return fn
+	case func([]byte, []byte, []byte) []byte:
+		return api.MergeOperator(fn)
+	}
+	return nil
+}
+
+// mergegetter return a getter on index, transaction or view, that
+// shall report entries holding merge operands.
+func mergegetter(index interface{}, get api.Getter) api.MergeGetter {
+	switch idx := index.(type) {
+	case api.Merger:
+		return idx.Getoperand
+	case *llrb.Txn:
+		return idx.Getoperand
+	case *llrb.View:
+		return idx.Getoperand
+	}
+	return lsm.Mergeable(get)
+}
+
+// foldget fold merge operands, left over after reading all the levels,
+// over nil value.
+func (bogn *Bogn) foldget(get api.MergeGetter) api.Getter {
+	return func(key, value []byte) ([]byte, uint64, bool, bool) {
+		val, cas, del, op, ok := get(key, value)
+		if op {
+			val = bogn.mergeoperator(key, nil, val)
+		}
+		if value != nil {
+			value = lib.Fixbuffer(value, int64(len(val)))
+			copy(value, val)
+			val = value
+		}
+		return val, cas, del, ok
+	}
+}
+
+// folditer fold merge operands, left over after iterating all the
+// levels, over nil value.
+func (bogn *Bogn) folditer(iter api.MergeIterator) api.Iterator {
+	if iter == nil {
+		return nil
+	}
+	return func(fin bool) ([]byte, []byte, uint64, bool, error) {
+		key, val, seqno, del, op, err := iter(fin)
+		if err == nil && op {
+			val = bogn.mergeoperator(key, nil, val)
+		}
+		return key, val, seqno, del, err
+	}
+}
+
+// foldentries fold merge operands, left over after iterating all the
+// levels, over the value returned by get. Get is nil when there are
+// no older levels to look into.
+func (bogn *Bogn) foldentries(
+	itere api.EntryIterator, get api.Getter) api.EntryIterator {
+
+	if itere == nil {
+		return nil
+	}
+
+	fentry, value := &filterentry{}, make([]byte, 0, 16)
+	return func(fin bool) api.IndexEntry {
+		entry := itere(fin)
+		if entry.IsOperand() == false {
+			return entry
+		}
+		key, _, _, _ := entry.Key()
+		var val []byte
+		if get != nil {
+			v, _, del, ok := get(key, value)
+			if ok && !del {
+				val, value = v, v
+			}
+		}
+		nv := bogn.mergeoperator(key, val, entry.Value())
+		return fentry.set(entry, nv, false /*deleted*/)
+	}
+}
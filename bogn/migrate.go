@@ -0,0 +1,229 @@
+package bogn
+
+import "fmt"
+import "time"
+
+import "github.com/bnclabs/gostore/api"
+import "github.com/bnclabs/gostore/bubt"
+import s "github.com/bnclabs/gosettings"
+import humanize "github.com/dustin/go-humanize"
+
+// MigrateIndex will rebuild all disk levels of index `name`, found
+// under `diskpaths`, with settings from the latest disk level
+// overridden by `setts`. Following settings can be migrated:
+//
+// "memstore", memory store to use on the next reboot.
+//
+// "bubt.mblocksize", "bubt.zblocksize", "bubt.vblocksize", block sizes
+// for disk levels, a vblocksize of ZERO moves values back into the
+// z-blocks, otherwise values are moved into value-logs.
+//
+// "bubt.bloombits", bloom filter to build with each disk level.
+//
+// Index shall not be in use while it is migrated. Each disk level is
+// rebuilt as the next version of the same level and its older version
+// is removed only after the new version is completely written. Levels
+// are migrated from oldest to latest, and settings are persisted with
+// the latest level, so if migration is interrupted, index shall reboot
+// with its older settings and MigrateIndex can be called again.
+func MigrateIndex(
+	name, diskstore string, diskpaths []string, setts s.Settings) error {
+
+	bogn := &Bogn{name: name, diskstore: diskstore, snapshot: nil}
+	bogn.logprefix = fmt.Sprintf("BOGN [%v]", name)
+	return bogn.migratedisksnaps("migrate", diskstore, diskpaths, setts)
+}
+
+func (bogn *Bogn) migratedisksnaps(
+	logprefix, diskstore string, diskpaths []string,
+	setts s.Settings) error {
+
+	switch diskstore {
+	case "bubt":
+		return bogn.migratebubtsnaps(logprefix, diskpaths, setts)
+	}
+	panic(fmt.Errorf("invalid diskstore %v", diskstore))
+}
+
+func (bogn *Bogn) migratebubtsnaps(
+	logprefix string, diskpaths []string, setts s.Settings) error {
+
+	// compact away older versions and bad snapshots.
+	merge := false
+	err := bogn.compactdisksnaps(logprefix, "bubt", diskpaths, merge)
+	if err != nil {
+		return err
+	}
+
+	mmap := false
+	disks, err := bogn.openbubtsnaps(diskpaths, mmap)
+	if err != nil {
+		return err
+	}
+	validdisks := []api.Index{}
+	for _, disk := range disks {
+		if disk != nil {
+			validdisks = append(validdisks, disk)
+		}
+	}
+	if len(validdisks) == 0 {
+		fmsg := "%v %v: no disk levels found for migration"
+		infof(fmsg, bogn.logprefix, logprefix)
+		return nil
+	}
+
+	err = bogn.migratesettings(validdisks[0], diskpaths, setts)
+	if err != nil {
+		bogn.closelevels(validdisks...)
+		return err
+	}
+	settstodisk := bogn.settingstodisk()
+
+	for i := len(validdisks) - 1; i >= 0; i-- {
+		disk := validdisks[i]
+		level, version, _ := bogn.path2level(disk.ID())
+		if version < bogn.currdiskversion(level) {
+			version = bogn.currdiskversion(level)
+		}
+		bogn.diskversions[level] = version + 1
+		// diskversions are persisted with each level, latest level
+		// shall carry the final set of versions.
+		settstodisk["diskversions"] = bogn.diskversions
+
+		err := bogn.migratebubt(logprefix, disk, version+1, settstodisk)
+		if err != nil {
+			bogn.closelevels(validdisks[:i+1]...)
+			return err
+		}
+		infof("%v %v: migrated out %q", bogn.logprefix, logprefix, disk.ID())
+		bogn.destroylevels(disk)
+	}
+	return nil
+}
+
+// migratesettings load settings from latest disk level, override them
+// with setts and prepare bogn instance for settingstodisk().
+func (bogn *Bogn) migratesettings(
+	disk api.Index, diskpaths []string, setts s.Settings) error {
+
+	for key := range setts {
+		switch key {
+		case "memstore", "bubt.mblocksize", "bubt.zblocksize":
+		case "bubt.vblocksize", "bubt.bloombits":
+		default:
+			return fmt.Errorf("setting %q cannot be migrated", key)
+		}
+	}
+	if _, ok := setts["memstore"]; ok {
+		switch memstore := setts.String("memstore"); memstore {
+		case "llrb", "mvcc":
+		default:
+			return fmt.Errorf("invalid memstore %q", memstore)
+		}
+	}
+	if _, ok := setts["bubt.mblocksize"]; ok {
+		if msize := setts.Int64("bubt.mblocksize"); msize <= 0 {
+			return fmt.Errorf("invalid mblocksize %v", msize)
+		}
+	}
+	// ZERO zblocksize defaults to mblocksize, ZERO vblocksize disables
+	// value log.
+	if _, ok := setts["bubt.zblocksize"]; ok {
+		if zsize := setts.Int64("bubt.zblocksize"); zsize < 0 {
+			return fmt.Errorf("invalid zblocksize %v", zsize)
+		}
+	}
+	if _, ok := setts["bubt.vblocksize"]; ok {
+		if vsize := setts.Int64("bubt.vblocksize"); vsize < 0 {
+			return fmt.Errorf("invalid vblocksize %v", vsize)
+		}
+	}
+
+	disksetts := bogn.settingsfromdisk(disk)
+	memversions := disksetts["memversions"].([3]int)
+	diskversions := disksetts["diskversions"].([16]int)
+	for _, key := range []string{"memversions", "diskversions"} {
+		delete(disksetts, key)
+	}
+	metakeys := []string{"seqno", "delseqno", "flushunix", "appdata"}
+	for _, key := range metakeys {
+		delete(disksetts, key)
+	}
+	// durations are persisted in nano-seconds.
+	if _, ok := disksetts["logsynctick"]; ok {
+		tick := time.Duration(disksetts.Int64("logsynctick"))
+		disksetts["logsynctick"] = int64(tick / time.Millisecond)
+	}
+	autocommit := time.Duration(disksetts.Int64("autocommit"))
+	disksetts["autocommit"] = int64(autocommit / time.Second)
+	compactperiod := time.Duration(disksetts.Int64("compactperiod"))
+	disksetts["compactperiod"] = int64(compactperiod / time.Second)
+	disksetts["bubt.diskpaths"] = diskpaths
+
+	// older disk levels might not have all the settings.
+	newsetts := (s.Settings{}).Mixin(Defaultsettings(), disksetts, setts)
+	newsetts["durable"] = false // logpath is carried over from disk.
+	bogn.readsettings(newsetts)
+	bogn.memversions, bogn.diskversions = memversions, diskversions
+	return nil
+}
+
+// migratebubt rebuild disk as next version of its level, seqno,
+// flushunix and appdata are carried over from disk.
+func (bogn *Bogn) migratebubt(
+	logprefix string, disk api.Index, version int,
+	settstodisk s.Settings) error {
+
+	now := time.Now()
+	level, _, _ := bogn.path2level(disk.ID())
+	dirname := bogn.levelname(level, version, bogn.newuuid())
+
+	bubtsetts := bogn.setts.Section("bubt.").Trim("bubt.")
+	paths := bubtsetts.Strings("diskpaths")
+	msize := bubtsetts.Int64("mblocksize")
+	zsize := bubtsetts.Int64("zblocksize")
+	vsize := bubtsetts.Int64("vblocksize")
+	bt, err := bubt.NewBubt(dirname, paths, msize, zsize, vsize)
+	if err != nil {
+		errorf("%v NewBubt(): %v", bogn.logprefix, err)
+		return err
+	}
+	if _, ok := bubtsetts["bloombits"]; ok { // older settings don't have it
+		bt.BloomFilter(bubtsetts.Int64("bloombits"))
+	}
+
+	itere := disk.ScanEntries()
+	err = bt.Build(itere, nil)
+	itere(true /*fin*/)
+	if err != nil {
+		errorf("%v Build(): %v", bogn.logprefix, err)
+		bt.Close()
+		bubt.PurgeSnapshot(dirname, paths)
+		return err
+	}
+
+	seqno, flushunix := bogn.getdiskseqno(disk), bogn.getflushunix(disk)
+	appdata, delseqno := bogn.getappdata(disk), bogn.getdiskdelseqno(disk)
+	metadata := bogn.mwmetadata(
+		seqno, delseqno, flushunix, appdata, settstodisk,
+	)
+	if _, err = bt.Writemetadata(metadata); err != nil {
+		errorf("%v Writemetadata(): %v", bogn.logprefix, err)
+		bt.Close()
+		bubt.PurgeSnapshot(dirname, paths)
+		return err
+	}
+	bt.Close()
+
+	ndisk, err := bubt.OpenSnapshot(dirname, paths, false /*mmap*/)
+	if err != nil {
+		errorf("%v OpenSnapshot(): %v", bogn.logprefix, err)
+		return err
+	}
+	fp := humanize.Bytes(uint64(ndisk.Footprint()))
+	id, count := ndisk.ID(), ndisk.Count()
+	fmsg := "%v %v: took %v for bubt %v with %v entries, %v\n"
+	infof(fmsg, bogn.logprefix, logprefix, time.Since(now), id, count, fp)
+	ndisk.Close()
+	return nil
+}
@@ -0,0 +1,100 @@
+package bogn
+
+import "io"
+import "fmt"
+import "strings"
+import "testing"
+import "time"
+
+func TestMigrateIndex(t *testing.T) {
+	destoryindex("index", makepaths())
+
+	setts, paths := makesettings(), makepaths()
+	setts["bubt.diskpaths"] = paths
+	setts["durable"] = true
+	setts["dgm"] = true
+	setts["autocommit"] = 1
+	index, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+
+	n := 10000
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key%08d", i))
+		index.Set(key, key, nil)
+	}
+	// wait for flush.
+	time.Sleep(3 * time.Second)
+	for i := n; i < n+100; i++ {
+		key := []byte(fmt.Sprintf("key%08d", i))
+		index.Set(key, key, nil)
+	}
+	index.Close()
+
+	diskpaths := strings.Split(paths, ",")
+	migsetts := map[string]interface{}{"bubt.diskpaths": paths}
+	if err := MigrateIndex("index", "bubt", diskpaths, migsetts); err == nil {
+		t.Errorf("expected error")
+	}
+	for _, key := range []string{"mblocksize", "zblocksize", "vblocksize"} {
+		migsetts = map[string]interface{}{"bubt." + key: -1}
+		err := MigrateIndex("index", "bubt", diskpaths, migsetts)
+		if err == nil {
+			t.Errorf("%v expected error", key)
+		}
+	}
+
+	migsetts = map[string]interface{}{
+		"memstore":        "llrb",
+		"bubt.zblocksize": 8192,
+		"bubt.vblocksize": 4096,
+	}
+	if err := MigrateIndex("index", "bubt", diskpaths, migsetts); err != nil {
+		t.Fatal(err)
+	}
+
+	setts = (makesettings()).Mixin(setts, migsetts)
+	index, err = New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+
+	disks := index.currsnapshot().disklevels(nil)
+	if len(disks) == 0 {
+		t.Fatalf("expected disk levels")
+	}
+	for _, disk := range disks {
+		info := index.diskmetadata(disk)
+		if level, version, _ := index.path2level(disk.ID()); version < 2 {
+			t.Errorf("level %v unexpected version %v", level, version)
+		} else if x := info["bubt.zblocksize"].(float64); x != 8192 {
+			t.Errorf("expected %v, got %v", 8192, x)
+		} else if x := info["memstore"].(string); x != "llrb" {
+			t.Errorf("expected %v, got %v", "llrb", x)
+		}
+	}
+
+	iter, i := index.Scan(), 0
+	key, value, _, _, err := iter(false /*fin*/)
+	for ; err == nil; i++ {
+		refkey := fmt.Sprintf("key%08d", i)
+		if string(key) != refkey {
+			t.Errorf("expected %q, got %q", refkey, key)
+		} else if string(value) != refkey {
+			t.Errorf("expected %q, got %q", refkey, value)
+		}
+		key, value, _, _, err = iter(false /*fin*/)
+	}
+	iter(true /*fin*/)
+	if err != io.EOF {
+		t.Errorf("unexpected %v", err)
+	} else if i != n+100 {
+		t.Errorf("expected %v, got %v", n+100, i)
+	}
+
+	index.Close()
+	index.Destroy()
+}
@@ -0,0 +1,120 @@
+package bogn
+
+import "time"
+import "runtime/debug"
+
+import "github.com/bnclabs/gostore/api"
+import "github.com/bnclabs/gostore/lib"
+import s "github.com/bnclabs/gosettings"
+
+// Kind of events delivered to Observer.
+const (
+	// EventPersistStart full set of entries in memory is being
+	// persisted to disk, dopersist.
+	EventPersistStart = "persist.start"
+	// EventPersistEnd persisted to disk.
+	EventPersistEnd = "persist.end"
+	// EventFlushStart memory levels, and optionally disk levels, are
+	// being flushed into a new disk level, doflush.
+	EventFlushStart = "flush.start"
+	// EventFlushEnd flushed to disk.
+	EventFlushEnd = "flush.end"
+	// EventCompactStart disk levels are being compacted in background.
+	EventCompactStart = "compact.start"
+	// EventCompactEnd compacted disk level replaced older levels.
+	EventCompactEnd = "compact.end"
+	// EventWindupStart memory levels are being flushed on Close().
+	EventWindupStart = "windup.start"
+	// EventWindupEnd flushed on Close().
+	EventWindupEnd = "windup.end"
+	// EventDgm index switched from full set in memory to dgm.
+	EventDgm = "dgm"
+	// EventPurge levels, no longer referred by any snapshot, are
+	// closed, memory levels are released and disk levels are removed.
+	EventPurge = "purge"
+)
+
+// Event describe a change in the layout of the index.
+type Event struct {
+	Kind   string   // one of the Event* constants.
+	What   string   // cause for flush or compaction.
+	Levels []string // ID of levels being flushed, compacted or purged.
+	// Level ID of the new disk level, for *.end events.
+	Level string
+	// Entries number of entries in the new disk level, for *.end
+	// events, and in purged levels for purge event.
+	Entries int64
+	// Bytes written to disk for the new disk level, for *.end events,
+	// and footprint of purged levels for purge event.
+	Bytes    int64
+	Took     time.Duration // time taken, for *.end and purge events.
+	Dgmstate int64         // for dgm event.
+	Err      error         // for *.end events, if they failed.
+}
+
+// Observer is notified of changes to the layout of the index. Events
+// are delivered synchronously from background routines, possibly
+// concurrently, hence Observer must return quickly and must not call
+// back into the index.
+type Observer interface {
+	Observe(event Event)
+}
+
+// ObserverFunc adapt a function to Observer interface.
+type ObserverFunc func(event Event)
+
+// Observe implement Observer interface.
+func (fn ObserverFunc) Observe(event Event) {
+	fn(event)
+}
+
+func (bogn *Bogn) readobserver(setts s.Settings) Observer {
+	switch obs := setts["observer"].(type) {
+	case Observer:
+		return obs
+	case func(Event):
+		return ObserverFunc(obs)
+	}
+	return nil
+}
+
+// notify observer with event, observer shall not crash background
+// routines.
+func (bogn *Bogn) notify(event Event) {
+	if bogn.observer == nil {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			errorf("%v observer crashed %v", bogn.logprefix, r)
+			errorf("\n%s", lib.GetStacktrace(2, debug.Stack()))
+		}
+	}()
+	bogn.observer.Observe(event)
+}
+
+// levelevent return an event for the new disk level built by kind.
+func (bogn *Bogn) levelevent(
+	kind, what string, levels []string, ndisk api.Index,
+	took time.Duration, err error) Event {
+
+	event := Event{
+		Kind: kind, What: what, Levels: levels, Took: took, Err: err,
+	}
+	if ndisk != nil {
+		event.Level, event.Entries = ndisk.ID(), bogn.indexcount(ndisk)
+		event.Bytes = bogn.diskwritebytes(ndisk)
+	}
+	return event
+}
+
+// levelids return ID of non-nil indexes.
+func levelids(indexes ...api.Index) []string {
+	ids := []string{}
+	for _, index := range indexes {
+		if index != nil {
+			ids = append(ids, index.ID())
+		}
+	}
+	return ids
+}
@@ -0,0 +1,430 @@
+package bogn
+
+import "fmt"
+import "time"
+
+import s "github.com/bnclabs/gosettings"
+
+// CompactionPolicy decides when and how the latest batch of mutations
+// in memory is flushed to disk, and when disk levels are compacted
+// together. Policy is consulted by the compactor routine, one call at
+// a time. Disk levels are numbered from 0 to Levels.Nlevels-1, lower
+// levels hold the latest mutations.
+type CompactionPolicy interface {
+	// Flush return disk levels to be merged with the latest batch of
+	// mutations, and the level to flush into. compacting, if not
+	// empty, is the list of levels that are being compacted in the
+	// background, they must not be picked for merge. Return nlevel
+	// as -1 to skip the flush.
+	Flush(
+		levels *Levels, compacting []int) (merge []int, nlevel int, what string)
+
+	// Compact return disk levels to be compacted together, and the
+	// level to compact into. Return nlevel as -1 to skip compaction.
+	Compact(levels *Levels) (merge []int, nlevel int, what string)
+
+	// Windup return the disk level to be merged with memory while
+	// closing the index, and the level to windup into. Return disk
+	// as -1 to windup without merge.
+	Windup(levels *Levels) (disk, nlevel int)
+}
+
+// Levels describe the latest snapshot, as seen by CompactionPolicy.
+type Levels struct {
+	Nlevels int     // total number of disk levels.
+	Memheap int64   // memory footprint of the latest batch of mutations.
+	Disks   []Level // disk levels, latest level first.
+}
+
+// Level describe a single disk level.
+type Level struct {
+	Level     int
+	Count     int64 // number of entries.
+	Payload   int64 // size of keys, values and their meta-data in bytes.
+	Footprint int64 // size on disk in bytes.
+	Flushunix int64 // time of the latest flush, unix time in seconds.
+}
+
+// Latest return the latest disk level, ok is false if there are no
+// disk levels.
+func (levels *Levels) Latest() (level Level, ok bool) {
+	if len(levels.Disks) == 0 {
+		return level, false
+	}
+	return levels.Disks[0], true
+}
+
+// Oldest return the oldest disk level, ok is false if there are no
+// disk levels.
+func (levels *Levels) Oldest() (level Level, ok bool) {
+	if len(levels.Disks) == 0 {
+		return level, false
+	}
+	return levels.Disks[len(levels.Disks)-1], true
+}
+
+// Nextbut return the oldest free level that is newer than the next
+// occupied level after `level`. If there is no free level, level is
+// returned as is.
+func (levels *Levels) Nextbut(level int) int {
+	if level >= levels.Nlevels {
+		panic("impossible situation")
+	}
+	next := levels.Nlevels
+	for _, disk := range levels.Disks {
+		if disk.Level > level && disk.Level < next {
+			next = disk.Level
+		}
+	}
+	return next - 1
+}
+
+// levelsof return the level numbers of disks.
+func levelsof(disks []Level) []int {
+	merge := make([]int, 0, len(disks))
+	for _, disk := range disks {
+		merge = append(merge, disk.Level)
+	}
+	return merge
+}
+
+func (bogn *Bogn) readpolicy(setts s.Settings) CompactionPolicy {
+	if policy, ok := setts["compactionpolicy"].(CompactionPolicy); ok {
+		return policy
+	}
+	switch name := setts.String("compaction"); name {
+	case "default":
+		return newdefaultpolicy(setts)
+	case "sizetiered":
+		return newsizetiered(setts)
+	case "timewindow":
+		return newtimewindow(setts)
+	default:
+		panic(fmt.Errorf("invalid compaction %q", name))
+	}
+}
+
+//---- default policy
+
+// defaultpolicy flush the latest batch of mutations to a newer level
+// as long as it is comparable in size with the latest level, else
+// merge them with the latest level. Disk levels are compacted when
+// there are too many of them, when two successive levels are
+// comparable in size, when they are older than compactperiod, and
+// when the oldest level is mostly garbage.
+type defaultpolicy struct {
+	flushratio    float64
+	compactratio  float64
+	compactperiod time.Duration
+	compactlevels int
+	garbageratio  float64
+}
+
+func newdefaultpolicy(setts s.Settings) *defaultpolicy {
+	policy := &defaultpolicy{
+		flushratio:    setts.Float64("flushratio"),
+		compactratio:  setts.Float64("compactratio"),
+		compactperiod: time.Duration(setts.Int64("compactperiod")),
+		compactlevels: int(setts.Int64("compactlevels")),
+		garbageratio:  setts.Float64("garbageratio"),
+	}
+	policy.compactperiod *= time.Second
+	return policy
+}
+
+func (policy *defaultpolicy) Flush(
+	levels *Levels, compacting []int) ([]int, int, string) {
+
+	if merge, nlevel, ok := flushfresh(levels, compacting); ok {
+		return merge, nlevel, "flush.fresh"
+	} else if merge, nlevel, ok := flushaggressive(levels, compacting); ok {
+		return merge, nlevel, "flush.aggressive"
+	} else if nlevel, ok := policy.flushfallback(levels, compacting); ok {
+		return nil, nlevel, "flush.fallback"
+	}
+	merge, nlevel := flushmerge(levels)
+	return merge, nlevel, "flush.merge"
+}
+
+// fallback by one level and flush without merge.
+func (policy *defaultpolicy) flushfallback(
+	levels *Levels, compacting []int) (nlevel int, ok bool) {
+
+	latest, _ := levels.Latest()
+	if nlevel, ok = flushcompacting(levels, compacting); ok {
+		return nlevel, ok
+	}
+	payload := float64(latest.Payload)
+	if (float64(levels.Memheap) / payload) < policy.flushratio {
+		return latest.Level - 1, true
+	}
+	return -1, false
+}
+
+func (policy *defaultpolicy) Compact(levels *Levels) ([]int, int, string) {
+	if len(levels.Disks) <= 1 {
+		return nil, -1, "compact.none"
+	} else if merge, nlevel, ok := policy.compactaggressive(levels); ok {
+		return merge, nlevel, "compact.aggressive"
+	} else if merge, nlevel, ok := policy.compactbyratio(levels); ok {
+		return merge, nlevel, "compact.ratio"
+	} else if merge, nlevel, ok := policy.compactperiodic(levels); ok {
+		return merge, nlevel, "compact.period"
+	} else if merge, nlevel, ok := policy.compactself(levels); ok {
+		return merge, nlevel, "compact.self"
+	}
+	return nil, -1, "none"
+}
+
+// aggressive compaction, if number of levels is more than
+// compactlevels then compact without checking for compactratio or
+// compactperiod.
+func (policy *defaultpolicy) compactaggressive(
+	levels *Levels) ([]int, int, bool) {
+
+	if disks := levels.Disks; len(disks) > policy.compactlevels {
+		// leave the first level for flusher logic, and leave the
+		// last level since it might be too big !!
+		merge := levelsof(disks[1 : len(disks)-1])
+		if len(merge) > 0 {
+			return merge, levels.Nextbut(merge[len(merge)-1]), true
+		}
+	}
+	return nil, -1, false
+}
+
+// check whether ratio between two snapshot's payload exceeds
+// compactratio.
+func (policy *defaultpolicy) compactbyratio(
+	levels *Levels) ([]int, int, bool) {
+
+	disks := levels.Disks
+	for i := 0; i < len(disks)-1; i++ {
+		disk0, disk1 := disks[i], disks[i+1]
+		payload0, payload1 := float64(disk0.Payload), float64(disk1.Payload)
+		if (payload0 / payload1) > policy.compactratio {
+			merge := []int{disk0.Level, disk1.Level}
+			return merge, levels.Nextbut(disk1.Level), true
+		}
+	}
+	return nil, -1, false
+}
+
+// check whether disk's lifetime exceeds compact period.
+func (policy *defaultpolicy) compactperiodic(
+	levels *Levels) ([]int, int, bool) {
+
+	disks := levels.Disks
+	for i := 0; i < len(disks)-1; i++ {
+		flushtime := time.Unix(disks[i].Flushunix, 0)
+		if time.Now().Sub(flushtime) > policy.compactperiod {
+			if merge := levelsof(disks[i:]); len(merge) > 1 {
+				return merge, levels.Nextbut(disks[i].Level), true
+			}
+		}
+	}
+	return nil, -1, false
+}
+
+// compact the oldest level on itself, if it is mostly garbage.
+func (policy *defaultpolicy) compactself(levels *Levels) ([]int, int, bool) {
+	oldest, _ := levels.Oldest()
+	if oldest.Level != levels.Nlevels-1 {
+		panic("impossible situation")
+	}
+	payload, footprint := float64(oldest.Payload), float64(oldest.Footprint)
+	if (payload / footprint) < policy.garbageratio {
+		return []int{oldest.Level}, oldest.Level, true
+	}
+	return nil, -1, false
+}
+
+func (policy *defaultpolicy) Windup(levels *Levels) (disk, nlevel int) {
+	latest, ok := levels.Latest()
+	if !ok { // first time flush.
+		return -1, levels.Nlevels - 1
+
+	} else if latest.Level > 0 {
+		payload := float64(latest.Payload)
+		if (float64(levels.Memheap) / payload) < policy.flushratio {
+			return -1, latest.Level - 1
+		}
+	}
+	return latest.Level, levels.Nextbut(latest.Level)
+}
+
+//---- size tiered policy
+
+// sizetiered flush every batch of mutations into a newer level, and
+// compact atleast tierwidth successive levels, when they are of
+// similar size. Suitable for write heavy workloads, at the cost of
+// more levels to read from.
+type sizetiered struct {
+	tierwidth int
+	tierratio float64
+}
+
+func newsizetiered(setts s.Settings) *sizetiered {
+	return &sizetiered{
+		tierwidth: int(setts.Int64("tierwidth")),
+		tierratio: setts.Float64("tierratio"),
+	}
+}
+
+func (policy *sizetiered) Flush(
+	levels *Levels, compacting []int) ([]int, int, string) {
+
+	return flushnewer(levels, compacting)
+}
+
+func (policy *sizetiered) Compact(levels *Levels) ([]int, int, string) {
+	disks := levels.Disks
+	for i := 0; i < len(disks); i++ {
+		min, max, j := disks[i].Payload, disks[i].Payload, i+1
+		for ; j < len(disks); j++ {
+			min1, max1 := min, max
+			if payload := disks[j].Payload; payload < min1 {
+				min1 = payload
+			} else if payload > max1 {
+				max1 = payload
+			}
+			if float64(max1) > (float64(min1) * policy.tierratio) {
+				break
+			}
+			min, max = min1, max1
+		}
+		if (j - i) >= policy.tierwidth {
+			merge := levelsof(disks[i:j])
+			return merge, levels.Nextbut(disks[j-1].Level), "compact.tier"
+		}
+	}
+	return nil, -1, "none"
+}
+
+func (policy *sizetiered) Windup(levels *Levels) (disk, nlevel int) {
+	return windupnewer(levels)
+}
+
+//---- time window policy
+
+// timewindow flush every batch of mutations into a newer level, and
+// compact levels flushed within the same time window, once the window
+// has elapsed. Levels from different windows are never compacted
+// together, suitable for append mostly time-series keys.
+type timewindow struct {
+	window int64
+}
+
+func newtimewindow(setts s.Settings) *timewindow {
+	return &timewindow{window: setts.Int64("timewindow")}
+}
+
+func (policy *timewindow) Flush(
+	levels *Levels, compacting []int) ([]int, int, string) {
+
+	return flushnewer(levels, compacting)
+}
+
+func (policy *timewindow) Compact(levels *Levels) ([]int, int, string) {
+	current := time.Now().Unix() / policy.window
+	disks := levels.Disks
+	for i := 0; i < len(disks); {
+		window, j := disks[i].Flushunix/policy.window, i+1
+		for ; j < len(disks); j++ {
+			if disks[j].Flushunix/policy.window != window {
+				break
+			}
+		}
+		if window < current && (j-i) > 1 {
+			merge := levelsof(disks[i:j])
+			return merge, levels.Nextbut(disks[j-1].Level), "compact.window"
+		}
+		i = j
+	}
+	return nil, -1, "none"
+}
+
+func (policy *timewindow) Windup(levels *Levels) (disk, nlevel int) {
+	return windupnewer(levels)
+}
+
+//---- flush helpers
+
+// first time flush.
+func flushfresh(levels *Levels, compacting []int) ([]int, int, bool) {
+	if _, ok := levels.Latest(); ok {
+		return nil, -1, false
+	} else if len(compacting) > 0 {
+		panic("impossible situation")
+	}
+	return nil, levels.Nlevels - 1, true
+}
+
+// if all of the allowed-snapshot levels are exhausted then flush by
+// merging all snapshot levels, that are not being compacted.
+func flushaggressive(levels *Levels, compacting []int) ([]int, int, bool) {
+	if len(levels.Disks) < levels.Nlevels {
+		return nil, -1, false
+	}
+	till := levels.Nlevels
+	if len(compacting) > 0 {
+		till = compacting[0]
+	}
+	merge := []int{}
+	for _, disk := range levels.Disks {
+		if disk.Level >= till {
+			break
+		}
+		merge = append(merge, disk.Level)
+	}
+	if len(merge) == 0 { // all of them are being compacted
+		return nil, -1, true
+	}
+	return merge, levels.Nextbut(merge[len(merge)-1]), true
+}
+
+// if latest level is being compacted, fallback by one level.
+func flushcompacting(levels *Levels, compacting []int) (int, bool) {
+	latest, _ := levels.Latest()
+	if latest.Level <= 0 { // handled by fresh & aggressive.
+		panic("impossible situation")
+	}
+	if len(compacting) > 0 {
+		if latest.Level > compacting[0] {
+			panic("impossible situation")
+		} else if latest.Level == compacting[0] {
+			return latest.Level - 1, true
+		}
+	}
+	return -1, false
+}
+
+// pick the latest disk snapshot and flush with merge.
+func flushmerge(levels *Levels) ([]int, int) {
+	latest, _ := levels.Latest()
+	return []int{latest.Level}, levels.Nextbut(latest.Level)
+}
+
+// flush into a newer level, unless there is none left.
+func flushnewer(levels *Levels, compacting []int) ([]int, int, string) {
+	if merge, nlevel, ok := flushfresh(levels, compacting); ok {
+		return merge, nlevel, "flush.fresh"
+	} else if merge, nlevel, ok := flushaggressive(levels, compacting); ok {
+		return merge, nlevel, "flush.aggressive"
+	} else if latest, _ := levels.Latest(); latest.Level > 0 {
+		return nil, latest.Level - 1, "flush.fallback"
+	}
+	merge, nlevel := flushmerge(levels)
+	return merge, nlevel, "flush.merge"
+}
+
+// windup into a newer level, unless there is none left.
+func windupnewer(levels *Levels) (disk, nlevel int) {
+	latest, ok := levels.Latest()
+	if !ok { // first time flush.
+		return -1, levels.Nlevels - 1
+	} else if latest.Level > 0 {
+		return -1, latest.Level - 1
+	}
+	return latest.Level, levels.Nextbut(latest.Level)
+}
@@ -0,0 +1,151 @@
+package bogn
+
+import "time"
+import "reflect"
+import "testing"
+
+func TestLevelsNextbut(t *testing.T) {
+	levels := &Levels{
+		Nlevels: 16,
+		Disks:   []Level{{Level: 3}, {Level: 7}, {Level: 15}},
+	}
+	testcases := [][2]int{{0, 2}, {3, 6}, {7, 14}, {15, 15}, {10, 14}}
+	for _, tcase := range testcases {
+		if level := levels.Nextbut(tcase[0]); level != tcase[1] {
+			t.Errorf("for %v expected %v, got %v", tcase[0], tcase[1], level)
+		}
+	}
+}
+
+func TestDefaultPolicy(t *testing.T) {
+	setts := makesettings()
+	policy := newdefaultpolicy(setts)
+	now := time.Now().Unix()
+
+	// flush
+	levels := &Levels{Nlevels: 16, Memheap: 1000}
+	testflush(t, policy, levels, nil, nil, 15, "flush.fresh")
+	levels.Disks = []Level{{Level: 15, Payload: 10000, Flushunix: now}}
+	testflush(t, policy, levels, nil, nil, 14, "flush.fallback")
+	testflush(t, policy, levels, []int{15}, nil, 14, "flush.fallback")
+	levels.Memheap = 5000
+	testflush(t, policy, levels, nil, []int{15}, 15, "flush.merge")
+
+	// compact
+	testcompact(t, policy, levels, nil, -1, "compact.none")
+	levels.Disks = []Level{
+		{Level: 13, Payload: 1000, Footprint: 1000, Flushunix: now},
+		{Level: 14, Payload: 10000, Footprint: 10000, Flushunix: now},
+		{Level: 15, Payload: 100000, Footprint: 100000, Flushunix: now},
+	}
+	testcompact(t, policy, levels, nil, -1, "none")
+	levels.Disks[2].Payload = 15000
+	testcompact(t, policy, levels, []int{14, 15}, 15, "compact.ratio")
+	levels.Disks[2].Payload, levels.Disks[2].Footprint = 100000, 1000000
+	testcompact(t, policy, levels, []int{15}, 15, "compact.self")
+	levels.Disks[2].Footprint = 100000
+	levels.Disks[0].Flushunix = now - 3600
+	testcompact(t, policy, levels, []int{13, 14, 15}, 13, "compact.period")
+	levels.Disks = append([]Level{{Level: 10, Flushunix: now}}, levels.Disks...)
+	testcompact(t, policy, levels, []int{13, 14}, 14, "compact.aggressive")
+
+	// windup
+	levels = &Levels{Nlevels: 16, Memheap: 1000}
+	testwindup(t, policy, levels, -1, 15)
+	levels.Disks = []Level{{Level: 15, Payload: 10000}}
+	testwindup(t, policy, levels, -1, 14)
+	levels.Memheap = 5000
+	testwindup(t, policy, levels, 15, 15)
+}
+
+func TestSizetieredPolicy(t *testing.T) {
+	setts := makesettings()
+	setts["tierwidth"], setts["tierratio"] = 3, 2.0
+	policy := newsizetiered(setts)
+
+	// flush
+	levels := &Levels{Nlevels: 4, Memheap: 100000}
+	testflush(t, policy, levels, nil, nil, 3, "flush.fresh")
+	levels.Disks = []Level{{Level: 3, Payload: 10}}
+	testflush(t, policy, levels, nil, nil, 2, "flush.fallback")
+	levels.Disks = []Level{{Level: 0}, {Level: 2}}
+	testflush(t, policy, levels, nil, []int{0}, 1, "flush.merge")
+	levels.Disks = []Level{{Level: 0}, {Level: 1}, {Level: 2}, {Level: 3}}
+	testflush(t, policy, levels, []int{2, 3}, []int{0, 1}, 1, "flush.aggressive")
+
+	// compact
+	levels.Disks = []Level{
+		{Level: 0, Payload: 100}, {Level: 1, Payload: 1000},
+		{Level: 2, Payload: 1500}, {Level: 3, Payload: 100000},
+	}
+	testcompact(t, policy, levels, nil, -1, "none")
+	levels.Disks[0].Payload = 800
+	testcompact(t, policy, levels, []int{0, 1, 2}, 2, "compact.tier")
+
+	// windup
+	levels.Disks = []Level{{Level: 2}, {Level: 3}}
+	testwindup(t, policy, levels, -1, 1)
+	levels.Disks = []Level{{Level: 0}, {Level: 3}}
+	testwindup(t, policy, levels, 0, 2)
+}
+
+func TestTimewindowPolicy(t *testing.T) {
+	setts := makesettings()
+	setts["timewindow"] = 3600
+	policy := newtimewindow(setts)
+
+	window := (time.Now().Unix() / 3600) * 3600
+	levels := &Levels{
+		Nlevels: 16,
+		Disks: []Level{
+			{Level: 11, Flushunix: window + 1},
+			{Level: 12, Flushunix: window},
+			{Level: 13, Flushunix: window - 10},
+			{Level: 14, Flushunix: window - 3600},
+			{Level: 15, Flushunix: window - 10800},
+		},
+	}
+	testcompact(t, policy, levels, []int{13, 14}, 14, "compact.window")
+	levels.Disks[3].Flushunix = window - 3601
+	testcompact(t, policy, levels, nil, -1, "none")
+}
+
+func testflush(
+	t *testing.T, policy CompactionPolicy, levels *Levels,
+	compacting, refmerge []int, refnlevel int, refwhat string) {
+
+	merge, nlevel, what := policy.Flush(levels, compacting)
+	if what != refwhat {
+		t.Errorf("expected %q, got %q", refwhat, what)
+	} else if nlevel != refnlevel {
+		t.Errorf("%v expected %v, got %v", what, refnlevel, nlevel)
+	} else if !reflect.DeepEqual(merge, refmerge) {
+		t.Errorf("%v expected %v, got %v", what, refmerge, merge)
+	}
+}
+
+func testcompact(
+	t *testing.T, policy CompactionPolicy, levels *Levels,
+	refmerge []int, refnlevel int, refwhat string) {
+
+	merge, nlevel, what := policy.Compact(levels)
+	if what != refwhat {
+		t.Errorf("expected %q, got %q", refwhat, what)
+	} else if nlevel != refnlevel {
+		t.Errorf("%v expected %v, got %v", what, refnlevel, nlevel)
+	} else if !reflect.DeepEqual(merge, refmerge) {
+		t.Errorf("%v expected %v, got %v", what, refmerge, merge)
+	}
+}
+
+func testwindup(
+	t *testing.T, policy CompactionPolicy, levels *Levels,
+	refdisk, refnlevel int) {
+
+	disk, nlevel := policy.Windup(levels)
+	if disk != refdisk {
+		t.Errorf("expected %v, got %v", refdisk, disk)
+	} else if nlevel != refnlevel {
+		t.Errorf("expected %v, got %v", refnlevel, nlevel)
+	}
+}
@@ -0,0 +1,285 @@
+package bogn
+
+import "fmt"
+import "sort"
+import "time"
+import "unsafe"
+import "io/ioutil"
+import "sync/atomic"
+
+import "github.com/bnclabs/gostore/api"
+import "github.com/bnclabs/gostore/bubt"
+import s "github.com/bnclabs/gosettings"
+
+// OpenReadOnly open disk levels of index `name`, under "bubt.diskpaths",
+// for read-only access. Typically used by processes other than the one
+// writing into the index, to scan the same data set without going
+// through the writer process. There is no memstore, write-ahead-log or
+// compactor for read-only index, all write operations are refused with
+// api.ErrorReadonly, and mutations in writer's memory are not visible
+// until they are flushed to disk.
+//
+// Disk levels opened by the reader are share-locked, and writer process
+// cannot purge them until reader moves to newer levels via Refresh(),
+// or Close() the index. Hence readers are expected to Refresh() as
+// often as writer publishes new disk levels.
+func OpenReadOnly(name string, setts s.Settings) (*Bogn, error) {
+	setts = (s.Settings{}).Mixin(Defaultsettings(), setts, s.Settings{
+		"durable":    false,
+		"dgm":        true,
+		"workingset": false,
+		"autocommit": int64(0),
+	})
+	bogn := (&Bogn{
+		name:      name,
+		logprefix: fmt.Sprintf("BOGN [%v]", name),
+		readonly:  true,
+	}).readsettings(setts)
+	bogn.inittxns()
+	bogn.epoch = time.Now()
+	bogn.finch = make(chan struct{})
+
+	startedat := bogn.epoch.Format(time.RFC3339Nano)
+	infof("%v readonly: starting epoch@%v ...", bogn.logprefix, startedat)
+
+	var nodisks [16]api.Index
+	disks, err := bogn.openreadonlydisks(nodisks)
+	if err != nil {
+		return nil, err
+	}
+	head, err := bogn.readonlysnapshot(disks)
+	if err != nil {
+		closedisks(disks, nodisks)
+		return nil, err
+	}
+	head.refer()
+	bogn.setheadsnapshot(head)
+
+	go purger(bogn)
+	for atomic.LoadInt64(&bogn.nroutines) < 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	infof("%v readonly: opened snapshot %v", bogn.logprefix, head.attributes())
+	return bogn, nil
+}
+
+// Refresh read-only index to the latest set of disk levels published
+// by the writer process. Views, transactions and iterators created
+// before Refresh continue to read from older levels, which are closed
+// after they are released.
+func (bogn *Bogn) Refresh() error {
+	if bogn.readonly == false {
+		return fmt.Errorf("cannot refresh writable index %q", bogn.name)
+	}
+
+	bogn.refreshmu.Lock()
+	defer bogn.refreshmu.Unlock()
+
+	curr := bogn.currsnapshot()
+	disks, err := bogn.openreadonlydisks(curr.disks)
+	if err != nil {
+		return err
+	} else if disks == curr.disks {
+		return nil // writer has not published new levels.
+	}
+	head, err := bogn.readonlysnapshot(disks)
+	if err != nil {
+		closedisks(disks, curr.disks)
+		return err
+	}
+
+	// there are no writes to serialize with, and views hold the
+	// read-latch for their entire life, hence the head is swapped
+	// without snaplock(), snapshots in use are kept alive by refcount.
+	atomic.StorePointer(&head.next, unsafe.Pointer(curr))
+	head.refer()
+	bogn.setheadsnapshot(head)
+
+	curr.addtopurge(curr.mw)
+	for level, disk := range curr.disks {
+		if disk != nil && disks[level] != disk {
+			curr.addtopurge(disk)
+		}
+	}
+	curr.release()
+
+	fmsg := "%v readonly: refreshed to snapshot %v"
+	infof(fmsg, bogn.logprefix, head.attributes())
+	return nil
+}
+
+// readonlysnapshot create a new snapshot over disks. An empty memstore,
+// that is never written to, is used as write store so that reads can
+// go through the same path as that of a writable index.
+func (bogn *Bogn) readonlysnapshot(disks [16]api.Index) (*snapshot, error) {
+	seqno := uint64(0)
+	for _, disk := range disks {
+		if disk != nil {
+			seqno = bogn.getdiskseqno(disk)
+			break
+		}
+	}
+	mw, err := bogn.newmemstore("readonly", "mw", seqno)
+	if err != nil {
+		return nil, err
+	}
+	return newsnapshot(bogn, mw, nil, nil, disks, "", seqno), nil
+}
+
+// openreadonlydisks open the latest version of each disk level, reusing
+// levels from `open` that are still the latest. Unlike opendisksnaps,
+// older versions are left as is and versions that fail to open, say
+// because writer is still building them or purging them, are skipped.
+// Levels that are already merged into older levels, but not yet purged
+// by the writer, are skipped as well.
+func (bogn *Bogn) openreadonlydisks(
+	open [16]api.Index) (disks [16]api.Index, err error) {
+
+	switch bogn.diskstore {
+	case "bubt":
+		bubtsetts := bogn.setts.Section("bubt.").Trim("bubt.")
+		diskpaths := bubtsetts.Strings("diskpaths")
+		mmap := bubtsetts.Bool("mmap")
+		disks, err = bogn.openbubtreadonly(open, diskpaths, mmap)
+		return
+	}
+	panic("impossible situation")
+}
+
+func (bogn *Bogn) openbubtreadonly(
+	open [16]api.Index,
+	paths []string, mmap bool) (disks [16]api.Index, err error) {
+
+	// gather versions of each level, latest version first.
+	var versions [16][]string
+	dircache := map[string]bool{}
+	for _, path := range paths {
+		fis, err := ioutil.ReadDir(path)
+		if err != nil {
+			errorf("%v openbubtreadonly.ReadDir(): %v", bogn.logprefix, err)
+			return disks, err
+		}
+		for _, fi := range fis {
+			dirname := fi.Name()
+			if _, ok := dircache[dirname]; ok || !fi.IsDir() {
+				continue
+			}
+			dircache[dirname] = true
+			if level, _, _ := bogn.path2level(dirname); level >= 0 {
+				versions[level] = append(versions[level], dirname)
+			}
+		}
+	}
+
+	for level, dirnames := range versions {
+		sort.Slice(dirnames, func(i, j int) bool {
+			_, x, _ := bogn.path2level(dirnames[i])
+			_, y, _ := bogn.path2level(dirnames[j])
+			return x > y
+		})
+		for _, dirname := range dirnames {
+			if disk := open[level]; disk != nil && disk.ID() == dirname {
+				disks[level] = disk
+				break
+			}
+			disk, err := bogn.tryopenbubt(dirname, paths, mmap)
+			if err != nil {
+				fmsg := "%v readonly: skip disk level %v: %v"
+				warnf(fmsg, bogn.logprefix, dirname, err)
+				continue
+			}
+			infof("%v readonly: open-disksnapshot %v", bogn.logprefix, dirname)
+			disks[level] = disk
+			break
+		}
+	}
+
+	// writer publishes disk levels with latest mutations in lower
+	// levels, seqno persisted in metadata shall strictly increase from
+	// the oldest level to the latest level. A level that is not newer
+	// than the levels below it is already merged into them, drop it so
+	// that writer can purge it.
+	var seqno uint64
+	for level := len(disks) - 1; level >= 0; level-- {
+		disk := disks[level]
+		if disk == nil {
+			continue
+		}
+		if dseqno := bogn.getdiskseqno(disk); dseqno > seqno {
+			seqno = dseqno
+			continue
+		}
+		fmsg := "%v readonly: skip stale disk level %v"
+		infof(fmsg, bogn.logprefix, disk.ID())
+		if disk != open[level] {
+			disk.Close()
+		}
+		disks[level] = nil
+	}
+	return disks, nil
+}
+
+// tryopenbubt open bubt snapshot, that might be partially built or
+// partially removed by the writer process.
+func (bogn *Bogn) tryopenbubt(
+	dirname string, paths []string, mmap bool) (disk api.Index, err error) {
+
+	defer func() {
+		if r := recover(); r != nil {
+			disk, err = nil, fmt.Errorf("%v", r)
+		}
+	}()
+	snap, err := bubt.OpenSnapshot(dirname, paths, mmap)
+	if err != nil {
+		return nil, err
+	}
+	return snap, nil
+}
+
+// closedisks close levels in disks that are not in open.
+func closedisks(disks, open [16]api.Index) {
+	for level, disk := range disks {
+		if disk != nil && disk != open[level] {
+			disk.Close()
+		}
+	}
+}
+
+// closereadonly is Close() for read-only index.
+func (bogn *Bogn) closereadonly() {
+	close(bogn.finch)
+	for atomic.LoadInt64(&bogn.nroutines) > 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// disk levels are only closed, they are purged by the writer.
+	snap := bogn.currsnapshot()
+	snap.addtopurge(snap.mw)
+	snap.addtopurge(snap.disklevels([]api.Index{})...)
+	snap.release()
+	for purgesnapshot(snap) == false {
+		time.Sleep(10 * time.Millisecond)
+		snap = bogn.currsnapshot()
+	}
+	bogn.setheadsnapshot(nil)
+
+	infof("%v closed ...", bogn.logprefix)
+}
+
+// isreadonly return true if index is opened for read-only access, if
+// index is following a leader, or if its log has failed.
+func (bogn *Bogn) isreadonly() bool {
+	if bogn.readonly || atomic.LoadInt64(&bogn.following) == 1 {
+		return true
+	}
+	return atomic.LoadInt64(&bogn.failed) == 1
+}
+
+// refusewrite panic if index is opened for read-only access, if index
+// is following a leader, or if its log has failed.
+func (bogn *Bogn) refusewrite() {
+	if bogn.isreadonly() {
+		panic(api.ErrorReadonly)
+	}
+}
@@ -0,0 +1,699 @@
+package bogn
+
+import "io"
+import "os"
+import "fmt"
+import "time"
+import "strings"
+import "io/ioutil"
+import "sync/atomic"
+import "path/filepath"
+import "runtime/debug"
+import "encoding/binary"
+
+import "github.com/bnclabs/gostore/api"
+import "github.com/bnclabs/gostore/lib"
+import s "github.com/bnclabs/gosettings"
+
+// Replicatick interval at which leader polls its log for new mutations,
+// once all logged mutations are streamed to follower.
+var Replicatick = time.Duration(10 * time.Millisecond)
+
+// size of data chunks while shipping disk levels to follower.
+const replchunk = 1024 * 1024
+
+// replmaxmsg is the largest replication message, a chunk of disk level
+// or a log record, along with its header.
+const replmaxmsg = replchunk + walmaxrecord
+
+// Replication messages, first byte of every message is one of the
+// following:
+//
+//   replHello     seqno uint64, from follower, mutations applied so far.
+//   replAck       seqno uint64, from follower, mutations applied so far.
+//   replBootstrap from leader, disk levels follow as replFile.
+//   replFile      namelen uint32, name, data, from leader, chunk of a
+//                 disk level file, name is relative to diskpath.
+//   replResume    seqno uint64, from leader, log records follow from
+//                 seqno+1.
+//   replRecord    log record's payload, from leader.
+//   replHeartbeat seqno uint64, from leader, latest seqno on leader.
+const (
+	replHello byte = iota + 1
+	replAck
+	replBootstrap
+	replFile
+	replResume
+	replRecord
+	replHeartbeat
+)
+
+func replseqnomsg(msg []byte, cmd byte, seqno uint64) []byte {
+	var scratch [8]byte
+	binary.BigEndian.PutUint64(scratch[:], seqno)
+	return append(append(msg[:0], cmd), scratch[:]...)
+}
+
+func replmsgseqno(msg []byte, cmd byte) (uint64, bool) {
+	if len(msg) != 9 || msg[0] != cmd {
+		return 0, false
+	}
+	return binary.BigEndian.Uint64(msg[1:]), true
+}
+
+//---- leader
+
+// replicator stream logged mutations, in seqno order, to a follower.
+type replicator struct {
+	// atomic access, 8-byte aligned
+	sentseqno   uint64
+	ackseqno    uint64
+	nrecords    int64
+	nbootstraps int64
+
+	bogn   *Bogn
+	tr     Transport
+	finch  chan struct{}
+	lostch chan struct{} // closed when transport fails to receive.
+	donech chan struct{}
+}
+
+// Replicate mutations committed on this index to a follower, that is
+// connected via tr, see Follow(). If follower has fallen behind the
+// mutations held in the log, disk levels are shipped to the follower
+// before streaming mutations from the log. Only durable index can be
+// replicated, and there can be any number of followers for an index.
+// Replication to a follower stops when transport fails, or when index
+// is closed.
+func (bogn *Bogn) Replicate(tr Transport) error {
+	if bogn.readonly {
+		return api.ErrorReadonly
+	} else if bogn.durable == false {
+		return fmt.Errorf("cannot replicate non-durable index %q", bogn.name)
+	}
+	r := &replicator{
+		bogn:   bogn,
+		tr:     tr,
+		finch:  make(chan struct{}),
+		lostch: make(chan struct{}),
+		donech: make(chan struct{}),
+	}
+	bogn.replmu.Lock()
+	bogn.replicators = append(bogn.replicators, r)
+	bogn.replmu.Unlock()
+
+	go r.run()
+	return nil
+}
+
+func (r *replicator) run() {
+	bogn := r.bogn
+	infof("%v replicator: starting ...", bogn.logprefix)
+
+	defer func() {
+		if x := recover(); x != nil {
+			errorf("%v replicator crashed %v", bogn.logprefix, x)
+			errorf("\n%s", lib.GetStacktrace(2, debug.Stack()))
+		} else {
+			infof("%v replicator: stopped", bogn.logprefix)
+		}
+		r.tr.Close()
+		bogn.removereplicator(r)
+		close(r.donech)
+	}()
+
+	msg, err := r.tr.Recv()
+	if err != nil {
+		errorf("%v replicator: %v", bogn.logprefix, err)
+		return
+	}
+	seqno, ok := replmsgseqno(msg, replHello)
+	if !ok {
+		errorf("%v replicator: expected hello from follower", bogn.logprefix)
+		return
+	}
+	atomic.StoreUint64(&r.ackseqno, seqno)
+	go r.acker()
+
+	cur := openwalcursor(bogn.wal, seqno+1)
+	if cur == nil {
+		if seqno, cur, err = r.bootstrap(); err != nil {
+			errorf("%v replicator: %v", bogn.logprefix, err)
+			return
+		}
+	} else {
+		msg = replseqnomsg(msg, replResume, seqno)
+		if err := r.tr.Send(msg); err != nil {
+			errorf("%v replicator: %v", bogn.logprefix, err)
+			return
+		}
+	}
+	defer cur.close()
+
+	infof("%v replicator: streaming from seqno %v", bogn.logprefix, seqno+1)
+	if err := r.stream(cur, seqno); err != nil {
+		errorf("%v replicator: %v", bogn.logprefix, err)
+	}
+}
+
+// bootstrap follower by shipping disk levels of the latest snapshot,
+// return the seqno of the latest disk level and a log cursor to
+// stream mutations after that.
+func (r *replicator) bootstrap() (uint64, *walcursor, error) {
+	bogn := r.bogn
+
+	snap := bogn.latestsnapshot()
+	defer snap.release()
+
+	seqno, disks := uint64(0), snap.disklevels([]api.Index{})
+	if len(disks) > 0 {
+		seqno = bogn.getdiskseqno(disks[0])
+	}
+	cur := openwalcursor(bogn.wal, seqno+1)
+	if cur == nil {
+		return 0, nil, fmt.Errorf("log does not hold seqno %v", seqno+1)
+	}
+
+	infof("%v replicator: bootstrap upto seqno %v", bogn.logprefix, seqno)
+	atomic.AddInt64(&r.nbootstraps, 1)
+	msg := []byte{replBootstrap}
+	err := r.tr.Send(msg)
+	for i := 0; err == nil && i < len(disks); i++ {
+		err = r.shipdisk(disks[i])
+	}
+	if err == nil {
+		err = r.tr.Send(replseqnomsg(msg, replResume, seqno))
+	}
+	if err != nil {
+		cur.close()
+		return 0, nil, err
+	}
+	return seqno, cur, nil
+}
+
+// shipdisk send all files of disk level, from all diskpaths.
+func (r *replicator) shipdisk(disk api.Index) error {
+	bogn := r.bogn
+	if bogn.diskstore != "bubt" {
+		panic("impossible situation")
+	}
+
+	msg, buf := make([]byte, 0, replchunk+1024), make([]byte, replchunk)
+	for _, path := range bogn.getdiskpaths() {
+		dir := filepath.Join(path, disk.ID())
+		fis, err := ioutil.ReadDir(dir)
+		if err != nil && os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		for _, fi := range fis {
+			if fi.IsDir() || filepath.Ext(fi.Name()) != ".data" {
+				continue // skip lock files.
+			}
+			name := disk.ID() + "/" + fi.Name()
+			fd, err := os.Open(filepath.Join(dir, fi.Name()))
+			if err != nil {
+				return err
+			}
+			for err == nil {
+				var n int
+				if n, err = io.ReadFull(fd, buf); n > 0 || err == io.EOF {
+					msg = append(msg[:0], replFile)
+					msg = append(msg, 0, 0, 0, 0)
+					binary.BigEndian.PutUint32(msg[1:5], uint32(len(name)))
+					msg = append(append(msg, name...), buf[:n]...)
+					if serr := r.tr.Send(msg); serr != nil {
+						err = serr
+					}
+				}
+			}
+			fd.Close()
+			if err != io.EOF && err != io.ErrUnexpectedEOF {
+				return err
+			}
+			infof("%v replicator: shipped %q", bogn.logprefix, name)
+		}
+	}
+	return nil
+}
+
+// stream log records from seqno+1, until replicator is closed.
+func (r *replicator) stream(cur *walcursor, seqno uint64) error {
+	bogn, msg, hbseqno := r.bogn, make([]byte, 0, 1024), uint64(0)
+	for {
+		select {
+		case <-r.finch:
+			return nil
+		default:
+		}
+		select {
+		case <-r.lostch:
+			return fmt.Errorf("lost connection with follower")
+		default:
+		}
+
+		rec, payload, ok := cur.next(seqno + 1)
+		if !ok {
+			return fmt.Errorf("log does not hold seqno %v", seqno+1)
+
+		} else if rec == nil { // all logged mutations are streamed.
+			if latest := bogn.Getseqno(); latest != hbseqno {
+				msg = replseqnomsg(msg, replHeartbeat, latest)
+				if err := r.tr.Send(msg); err != nil {
+					return err
+				}
+				hbseqno = latest
+			}
+			time.Sleep(Replicatick)
+			continue
+		}
+
+		msg = append(append(msg[:0], replRecord), payload...)
+		if err := r.tr.Send(msg); err != nil {
+			return err
+		}
+		seqno = rec.endseqno
+		atomic.StoreUint64(&r.sentseqno, seqno)
+		atomic.AddInt64(&r.nrecords, 1)
+	}
+}
+
+func (r *replicator) acker() {
+	defer close(r.lostch)
+
+	for {
+		msg, err := r.tr.Recv()
+		if err != nil {
+			return
+		}
+		if seqno, ok := replmsgseqno(msg, replAck); ok {
+			atomic.StoreUint64(&r.ackseqno, seqno)
+		}
+	}
+}
+
+func (r *replicator) close() {
+	close(r.finch)
+	r.tr.Close()
+	<-r.donech
+}
+
+func (r *replicator) stats(seqno uint64) map[string]interface{} {
+	ackseqno := atomic.LoadUint64(&r.ackseqno)
+	return map[string]interface{}{
+		"sentseqno":    atomic.LoadUint64(&r.sentseqno),
+		"ackseqno":     ackseqno,
+		"lag":          int64(seqno) - int64(ackseqno),
+		"n_records":    atomic.LoadInt64(&r.nrecords),
+		"n_bootstraps": atomic.LoadInt64(&r.nbootstraps),
+	}
+}
+
+//---- follower
+
+// follower apply mutations streamed by leader, with the same seqno.
+type follower struct {
+	// atomic access, 8-byte aligned
+	leaderseqno  uint64
+	appliedseqno uint64
+	nrecords     int64
+
+	bogn   *Bogn
+	tr     Transport
+	donech chan struct{}
+}
+
+// Follow a leader index connected via tr, see Replicate(). Index is
+// opened with `name` and `setts`, like New(), and setts shall be same
+// as that of leader's, except for disk paths and log path. If leader
+// no longer holds the mutations that follower need to catch up, index
+// is destroyed and leader's disk levels are shipped to follower's
+// first disk path, before streaming mutations. Returned index is
+// started, can be read from, but writes are refused with
+// api.ErrorReadonly until it is promoted using Promote(). Mutations
+// batched by leader in a transaction are applied one after the other.
+//
+// If follower falls behind the log on leader, replication is stopped,
+// and follower must be re-opened with Follow().
+func Follow(name string, setts s.Settings, tr Transport) (*Bogn, error) {
+	index, err := New(name, setts)
+	if err != nil {
+		tr.Close()
+		return nil, err
+	}
+	index.Start()
+
+	index, err = handshake(index, name, setts, tr)
+	if err != nil {
+		tr.Close()
+		return nil, err
+	}
+
+	f := &follower{bogn: index, tr: tr, donech: make(chan struct{})}
+	seqno := index.Getseqno()
+	f.appliedseqno, f.leaderseqno = seqno, seqno
+	index.replmu.Lock()
+	index.follower = f
+	atomic.StoreInt64(&index.following, 1)
+	index.replmu.Unlock()
+
+	go f.run()
+	return index, nil
+}
+
+// handshake with leader, and bootstrap index if leader choose to ship
+// its disk levels.
+func handshake(
+	index *Bogn, name string, setts s.Settings,
+	tr Transport) (*Bogn, error) {
+
+	fail := func(err error) (*Bogn, error) {
+		errorf("%v follower: %v", index.logprefix, err)
+		index.Close()
+		return nil, err
+	}
+
+	msg := replseqnomsg(nil, replHello, index.Getseqno())
+	if err := tr.Send(msg); err != nil {
+		return fail(err)
+	}
+	msg, err := tr.Recv()
+	if err != nil {
+		return fail(err)
+	} else if len(msg) > 0 && msg[0] == replBootstrap {
+		infof("%v follower: bootstrap from leader ...", index.logprefix)
+		index.Close()
+		index.Destroy()
+		var names []string
+		if msg, names, err = recvdisks(index, tr); err != nil {
+			return nil, err
+		}
+		if seqno, _ := replmsgseqno(msg, replResume); len(names) > 0 {
+			// disk levels are relocated from leader's diskpaths.
+			diskpath := index.getdiskpaths()[0]
+			if err := index.writemanifest(diskpath, names, seqno); err != nil {
+				return nil, err
+			}
+		}
+		if index, err = New(name, setts); err != nil {
+			return nil, err
+		}
+		index.Start()
+	}
+	seqno, ok := replmsgseqno(msg, replResume)
+	if !ok {
+		return fail(fmt.Errorf("expected resume from leader"))
+	} else if x := index.Getseqno(); x != seqno {
+		return fail(fmt.Errorf("follower at seqno %v, leader at %v", x, seqno))
+	}
+	infof("%v follower: following from seqno %v", index.logprefix, seqno+1)
+	return index, nil
+}
+
+// recvdisks receive disk levels into first diskpath, until leader
+// resumes streaming mutations. Return the message that ended the
+// bootstrap, along with names of received disk levels.
+func recvdisks(
+	index *Bogn, tr Transport) (msg []byte, names []string, err error) {
+
+	var fd *os.File
+	var name string
+
+	// files are synced before they are closed, so that New can open
+	// the received levels after a crash.
+	closefd := func() error {
+		if fd == nil {
+			return nil
+		}
+		err := fd.Sync()
+		if cerr := fd.Close(); err == nil {
+			err = cerr
+		}
+		fd = nil
+		if err == nil {
+			infof("%v follower: received %q", index.logprefix, name)
+		}
+		return err
+	}
+	defer closefd()
+
+	diskpath := index.getdiskpaths()[0]
+	for {
+		if msg, err = tr.Recv(); err != nil {
+			return nil, nil, err
+		} else if len(msg) == 0 || msg[0] != replFile {
+			if err = closefd(); err != nil {
+				return nil, nil, err
+			}
+			// sync directory entries of received levels and their files.
+			for _, dir := range names {
+				if err = syncdir(filepath.Join(diskpath, dir)); err != nil {
+					return nil, nil, err
+				}
+			}
+			if err = syncdir(diskpath); err != nil {
+				return nil, nil, err
+			}
+			return msg, names, nil
+		} else if len(msg) < 5 {
+			return nil, nil, fmt.Errorf("invalid file message")
+		}
+		n := int(binary.BigEndian.Uint32(msg[1:5]))
+		if len(msg) < 5+n {
+			return nil, nil, fmt.Errorf("invalid file message")
+		}
+		fname, data := string(msg[5:5+n]), msg[5+n:]
+		if fname != name {
+			if err := closefd(); err != nil {
+				return nil, nil, err
+			}
+			parts := strings.Split(fname, "/")
+			ok := len(parts) == 2 && filepath.Ext(parts[1]) == ".data"
+			if ok {
+				level, _, _ := index.path2level(parts[0])
+				ok = level >= 0
+			}
+			if !ok {
+				err := fmt.Errorf("invalid file %q from leader", fname)
+				return nil, nil, err
+			}
+			if len(names) == 0 || names[len(names)-1] != parts[0] {
+				names = append(names, parts[0])
+			}
+			dir := filepath.Join(diskpath, parts[0])
+			if err := os.MkdirAll(dir, 0775); err != nil {
+				return nil, nil, err
+			}
+			flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+			fd, err = os.OpenFile(filepath.Join(dir, parts[1]), flags, 0664)
+			if err != nil {
+				return nil, nil, err
+			}
+			name = fname
+		}
+		if _, err := fd.Write(data); err != nil {
+			return nil, nil, err
+		}
+	}
+}
+
+// syncdir flush directory entries to disk.
+func syncdir(dir string) error {
+	fd, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = fd.Sync()
+	if cerr := fd.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (f *follower) run() {
+	bogn := f.bogn
+	infof("%v follower: starting ...", bogn.logprefix)
+
+	defer func() {
+		if r := recover(); r != nil {
+			errorf("%v follower crashed %v", bogn.logprefix, r)
+			errorf("\n%s", lib.GetStacktrace(2, debug.Stack()))
+		} else {
+			infof("%v follower: stopped", bogn.logprefix)
+		}
+		f.tr.Close()
+		close(f.donech)
+	}()
+
+	rec := &walrecord{entries: make([]walentry, 0, 16)}
+	ack := make([]byte, 0, 16)
+	for {
+		msg, err := f.tr.Recv()
+		if err != nil {
+			infof("%v follower: %v", bogn.logprefix, err)
+			return
+		}
+		if len(msg) > walbatchhdr && msg[0] == replRecord {
+			if ok := rec.decode(msg[1:]); !ok {
+				fmsg := "%v follower: invalid record from leader"
+				errorf(fmsg, bogn.logprefix)
+				return
+			}
+			if err := bogn.applyreplica(rec); err != nil {
+				errorf("%v follower: %v", bogn.logprefix, err)
+				return
+			}
+			atomic.StoreUint64(&f.appliedseqno, rec.endseqno)
+			if rec.endseqno > atomic.LoadUint64(&f.leaderseqno) {
+				atomic.StoreUint64(&f.leaderseqno, rec.endseqno)
+			}
+			atomic.AddInt64(&f.nrecords, 1)
+
+		} else if seqno, ok := replmsgseqno(msg, replHeartbeat); ok {
+			atomic.StoreUint64(&f.leaderseqno, seqno)
+
+		} else {
+			errorf("%v follower: invalid message from leader", bogn.logprefix)
+			return
+		}
+		ack = replseqnomsg(ack, replAck, atomic.LoadUint64(&f.appliedseqno))
+		if err := f.tr.Send(ack); err != nil {
+			infof("%v follower: %v", bogn.logprefix, err)
+			return
+		}
+	}
+}
+
+func (f *follower) close() {
+	f.tr.Close()
+	<-f.donech
+}
+
+func (f *follower) stats() map[string]interface{} {
+	leaderseqno := atomic.LoadUint64(&f.leaderseqno)
+	appliedseqno := atomic.LoadUint64(&f.appliedseqno)
+	return map[string]interface{}{
+		"leaderseqno":  leaderseqno,
+		"appliedseqno": appliedseqno,
+		"lag":          int64(leaderseqno) - int64(appliedseqno),
+		"n_records":    atomic.LoadInt64(&f.nrecords),
+	}
+}
+
+// applyreplica apply log record, streamed from leader, on write store
+// and log it, if index is durable.
+func (bogn *Bogn) applyreplica(rec *walrecord) error {
+	var ticket int64
+	var err error
+
+	bogn.stallwrite(true /*block*/)
+
+	bogn.snaprlock()
+	dgm := atomic.LoadInt64(&bogn.dgmstate) == 1
+	if bogn.wal == nil {
+		bogn.applyrecord(bogn.currsnapshot().mw, rec, dgm)
+	} else {
+		bogn.walock()
+		bogn.applyrecord(bogn.currsnapshot().mw, rec, dgm)
+		ticket, err = bogn.wal.logrecord(rec)
+		bogn.walunlock()
+	}
+	bogn.snaprunlock()
+	return bogn.waitsync(ticket, err)
+}
+
+// Promote follower index to accept writes, it stops following the
+// leader. Typically called after leader has failed.
+func (bogn *Bogn) Promote() error {
+	bogn.replmu.Lock()
+	f := bogn.follower
+	bogn.follower = nil
+	bogn.replmu.Unlock()
+
+	if f == nil {
+		return fmt.Errorf("index %q is not following a leader", bogn.name)
+	}
+	f.close()
+	atomic.StoreInt64(&bogn.following, 0)
+	infof("%v promoted at seqno %v", bogn.logprefix, bogn.Getseqno())
+	return nil
+}
+
+// stopreplication to followers, and from leader, return whether index
+// was following a leader.
+func (bogn *Bogn) stopreplication() bool {
+	bogn.replmu.Lock()
+	replicators, f := bogn.replicators, bogn.follower
+	bogn.replicators, bogn.follower = nil, nil
+	bogn.replmu.Unlock()
+
+	for _, r := range replicators {
+		r.close()
+	}
+	if f != nil {
+		f.close()
+		atomic.StoreInt64(&bogn.following, 0)
+	}
+	return f != nil
+}
+
+// removereplicator that has stopped replicating to its follower.
+func (bogn *Bogn) removereplicator(r *replicator) {
+	bogn.replmu.Lock()
+	defer bogn.replmu.Unlock()
+
+	for i, x := range bogn.replicators {
+		if x == r {
+			copy(bogn.replicators[i:], bogn.replicators[i+1:])
+			n := len(bogn.replicators) - 1
+			bogn.replicators[n] = nil
+			bogn.replicators = bogn.replicators[:n]
+			return
+		}
+	}
+}
+
+// replpurgeseqno return seqno upto which log can be purged, that is
+// the lower of seqno and the lowest seqno acknowledged by followers
+// that are still replicating, so that log records are retained until
+// followers have applied them.
+func (bogn *Bogn) replpurgeseqno(seqno uint64) uint64 {
+	bogn.replmu.Lock()
+	defer bogn.replmu.Unlock()
+
+	for _, r := range bogn.replicators {
+		select {
+		case <-r.donech: // replication to this follower has stopped.
+			continue
+		default:
+		}
+		if ackseqno := atomic.LoadUint64(&r.ackseqno); ackseqno < seqno {
+			seqno = ackseqno
+		}
+	}
+	return seqno
+}
+
+// replstats return replication statistics, nil if index is neither a
+// leader nor a follower.
+func (bogn *Bogn) replstats(seqno uint64) map[string]interface{} {
+	bogn.replmu.Lock()
+	defer bogn.replmu.Unlock()
+
+	if len(bogn.replicators) == 0 && bogn.follower == nil {
+		return nil
+	}
+	stats := map[string]interface{}{}
+	if bogn.follower != nil {
+		stats["leader"] = bogn.follower.stats()
+	}
+	if len(bogn.replicators) > 0 {
+		followers := []map[string]interface{}{}
+		for _, r := range bogn.replicators {
+			followers = append(followers, r.stats(seqno))
+		}
+		stats["followers"] = followers
+	}
+	return stats
+}
@@ -11,6 +11,7 @@ import "github.com/bnclabs/gostore/api"
 import "github.com/bnclabs/gostore/lib"
 import "github.com/bnclabs/gostore/lsm"
 import "github.com/bnclabs/gostore/llrb"
+import "github.com/bnclabs/gostore/bubt"
 
 type snapshot struct {
 	// must be 8-byte aligned.
@@ -180,6 +181,10 @@ func (snap *snapshot) memheap() int64 {
 }
 
 func (snap *snapshot) latestyget() (get api.Getter) {
+	if snap.bogn.mergeoperator != nil {
+		return snap.ymerge(snap.mw)
+	}
+
 	gets := []api.Getter{}
 	if snap.mw != nil {
 		gets = append(gets, snap.mw.Get)
@@ -194,7 +199,7 @@ func (snap *snapshot) latestyget() (get api.Getter) {
 	if atomic.LoadInt64(&snap.bogn.dgmstate) == 1 {
 		for _, disk := range snap.disklevels([]api.Index{}) {
 			if snap.mc != nil {
-				gets = append(gets, snap.cachedget(disk.Get))
+				gets = append(gets, snap.cachedget(disk))
 			} else {
 				gets = append(gets, disk.Get)
 			}
@@ -205,8 +210,8 @@ func (snap *snapshot) latestyget() (get api.Getter) {
 		return nil
 	}
 	get = gets[len(gets)-1]
-	for i := len(gets) - 2; i >= 0; i-- {
-		get = lsm.YGet(gets[i], get)
+	for i := len(gets) - 2; i >= 0; i-- { // latest level as `b` argument.
+		get = lsm.YGet(get, gets[i])
 	}
 	return
 }
@@ -216,6 +221,10 @@ func (snap *snapshot) txnyget(
 
 	var disks [256]api.Index
 
+	if snap.bogn.mergeoperator != nil {
+		return snap.ymerge(tv)
+	}
+
 	if tv != nil {
 		gets = append(gets, tv.Get)
 	}
@@ -229,7 +238,7 @@ func (snap *snapshot) txnyget(
 	if atomic.LoadInt64(&snap.bogn.dgmstate) == 1 {
 		for _, disk := range snap.disklevels(disks[:0]) {
 			if snap.mc != nil {
-				gets = append(gets, snap.cachedget(disk.Get))
+				gets = append(gets, snap.cachedget(disk))
 			} else {
 				gets = append(gets, disk.Get)
 			}
@@ -240,16 +249,83 @@ func (snap *snapshot) txnyget(
 		return nil
 	}
 	get := gets[len(gets)-1]
+	for i := len(gets) - 2; i >= 0; i-- { // latest level as `b` argument.
+		get = lsm.YGet(get, gets[i])
+	}
+	return get
+}
+
+// ymerge is same as latestyget and txnyget, except that merge operands
+// are folded over older versions of the key. Levels are merged with the
+// latest level as `b` argument. mw can be the write store or a
+// transaction or a view on the write store.
+func (snap *snapshot) ymerge(mw interface{}) api.Getter {
+	var disks [256]api.Index
+
+	gets := []api.MergeGetter{}
+	switch idx := mw.(type) {
+	case api.Index:
+		gets = append(gets, mergegetter(idx, idx.Get))
+	case api.Transactor:
+		gets = append(gets, mergegetter(idx, idx.Get))
+	}
+	if snap.mr != nil {
+		gets = append(gets, mergegetter(snap.mr, snap.mr.Get))
+	}
+	if snap.mc != nil {
+		gets = append(gets, mergegetter(snap.mc, snap.mc.Get))
+	}
+
+	if atomic.LoadInt64(&snap.bogn.dgmstate) == 1 {
+		for _, disk := range snap.disklevels(disks[:0]) {
+			if snap.mc != nil {
+				gets = append(gets, lsm.Mergeable(snap.cachedget(disk)))
+			} else {
+				gets = append(gets, lsm.Mergeable(disk.Get))
+			}
+		}
+	}
+
+	if len(gets) == 0 {
+		return nil
+	}
+	merge := snap.bogn.mergeoperator
+	get := gets[len(gets)-1]
 	for i := len(gets) - 2; i >= 0; i-- {
-		get = lsm.YGet(gets[i], get)
+		get = lsm.YGetMerge(get, gets[i], merge)
+	}
+	return snap.bogn.foldget(get)
+}
+
+// olderyget return a getter on disk levels older than level, to fold
+// the merge operands flushed into level.
+func (snap *snapshot) olderyget(level int) api.Getter {
+	var get api.Getter
+	for i := len(snap.disks) - 1; i > level; i-- {
+		if disk := snap.disks[i]; disk == nil {
+			continue
+		} else if get == nil {
+			get = disk.Get
+		} else {
+			get = lsm.YGet(get, disk.Get)
+		}
 	}
 	return get
 }
 
-// try caching the entry from this get operation.
-func (snap *snapshot) cachedget(get api.Getter) api.Getter {
+// try caching the entry from get operation on disk.
+func (snap *snapshot) cachedget(disk api.Index) api.Getter {
+	getexpiry := func(key, value []byte) ([]byte, uint64, bool, bool, int64) {
+		value, cas, deleted, ok := disk.Get(key, value)
+		return value, cas, deleted, ok, 0
+	}
+	switch d := disk.(type) {
+	case *bubt.Snapshot:
+		getexpiry = d.Getexpiry
+	}
+
 	return func(key, value []byte) ([]byte, uint64, bool, bool) {
-		value, cas, deleted, ok := get(key, value)
+		value, cas, deleted, ok, expiry := getexpiry(key, value)
 		if ok == false {
 			return value, cas, deleted, ok
 		}
@@ -263,7 +339,7 @@ func (snap *snapshot) cachedget(get api.Getter) api.Getter {
 			cmd.value = lib.Fixbuffer(cmd.value, int64(len(value)))
 			copy(cmd.value, value)
 			cmd.seqno = cas
-			cmd.deleted = deleted
+			cmd.deleted, cmd.expiry = deleted, expiry
 			select {
 			case snap.setch <- cmd:
 			default:
@@ -284,21 +360,38 @@ func (snap *snapshot) finalizeindex(index api.Index) {
 	}
 }
 
-// full table scan.
-func (snap *snapshot) iterator() api.Iterator {
+// catchupindex wait for read snapshot of index to include all
+// mutations upto seqno.
+func (snap *snapshot) catchupindex(index api.Index, seqno uint64) {
+	if index != nil {
+		switch idx := index.(type) {
+		case *llrb.MVCC:
+			idx.Catchup(seqno)
+		}
+	}
+}
+
+// range scan on all levels.
+func (snap *snapshot) iterator(
+	low, high []byte, inclusive bool) api.Iterator {
+
 	var ref [20]api.Iterator
 	scans := ref[:0]
 
-	if iter := snap.mw.Scan(); iter != nil {
+	if snap.bogn.mergeoperator != nil {
+		return snap.mergeiterator(low, high, inclusive)
+	}
+
+	if iter := snap.mw.ScanRange(low, high, inclusive); iter != nil {
 		scans = append(scans, iter)
 	}
 	if snap.mr != nil {
-		if iter := snap.mr.Scan(); iter != nil {
+		if iter := snap.mr.ScanRange(low, high, inclusive); iter != nil {
 			scans = append(scans, iter)
 		}
 	}
 	for _, disk := range snap.disklevels([]api.Index{}) {
-		if iter := disk.Scan(); iter != nil {
+		if iter := disk.ScanRange(low, high, inclusive); iter != nil {
 			scans = append(scans, iter)
 		}
 	}
@@ -306,6 +399,82 @@ func (snap *snapshot) iterator() api.Iterator {
 	return reduceiter(scans)
 }
 
+// mergeiterator is same as iterator, except that merge operands are
+// folded over older versions of the key.
+func (snap *snapshot) mergeiterator(
+	low, high []byte, inclusive bool) api.Iterator {
+
+	var ref [20]api.MergeIterator
+	scans := ref[:0]
+
+	for _, index := range []api.Index{snap.mw, snap.mr} {
+		if index == nil {
+			continue
+		}
+		iter := index.(api.Merger).ScanOperands(low, high, inclusive)
+		if iter != nil {
+			scans = append(scans, iter)
+		}
+	}
+	for _, disk := range snap.disklevels([]api.Index{}) {
+		if iter := disk.ScanRange(low, high, inclusive); iter != nil {
+			scans = append(scans, lsm.MergeableIterator(iter))
+		}
+	}
+	if len(scans) == 0 {
+		return nil
+	}
+	merge := snap.bogn.mergeoperator
+	scan := scans[len(scans)-1]
+	for i := len(scans) - 2; i >= 0; i-- {
+		scan = lsm.YSortMerge(scans[i], scan, merge)
+	}
+	return snap.bogn.folditer(scan)
+}
+
+// full table scan of index entries on all levels. Cache store is
+// skipped, its entries are copies from disk levels and shall hide
+// the value-log reference of disk entries.
+func (snap *snapshot) entryiterator() api.EntryIterator {
+	var ref [20]api.EntryIterator
+	scans := ref[:0]
+
+	if itere := snap.mw.ScanEntries(); itere != nil {
+		scans = append(scans, itere)
+	}
+	if snap.mr != nil {
+		if itere := snap.mr.ScanEntries(); itere != nil {
+			scans = append(scans, itere)
+		}
+	}
+	for _, disk := range snap.disklevels([]api.Index{}) {
+		if itere := disk.ScanEntries(); itere != nil {
+			scans = append(scans, itere)
+		}
+	}
+	if snap.bogn.mergeoperator != nil {
+		return snap.foldentries(scans, nil)
+	}
+	return reduceitere(scans)
+}
+
+// foldentries lsm-merge scans folding merge operands over older
+// versions of the key, operands left over are folded over values
+// from get.
+func (snap *snapshot) foldentries(
+	scans []api.EntryIterator, get api.Getter) api.EntryIterator {
+
+	if len(scans) == 0 {
+		return nil
+	}
+	merge := snap.bogn.mergeoperator
+	scan := scans[len(scans)-1]
+	for i := len(scans) - 2; i >= 0; i-- {
+		scan = lsm.YSortEntriesMerge(scans[i], scan, merge)
+	}
+	return snap.bogn.foldentries(scan, get)
+}
+
 // iterate on write store.
 func (snap *snapshot) persistiterator() api.EntryIterator {
 	if snap.mw != nil {
@@ -314,8 +483,11 @@ func (snap *snapshot) persistiterator() api.EntryIterator {
 	return nil
 }
 
-// iterate on write store, read store, cache store and a latest disk store.
-func (snap *snapshot) flushiterator(disks []api.Index) api.EntryIterator {
+// iterate on write store, read store, cache store and a latest disk
+// store, to be flushed into disk `level`.
+func (snap *snapshot) flushiterator(
+	disks []api.Index, level int) api.EntryIterator {
+
 	var ref [20]api.EntryIterator
 	scans := ref[:0]
 
@@ -332,10 +504,15 @@ func (snap *snapshot) flushiterator(disks []api.Index) api.EntryIterator {
 			scans = append(scans, itere)
 		}
 	}
+	if snap.bogn.mergeoperator != nil {
+		return snap.foldentries(scans, snap.olderyget(level))
+	}
 	return reduceitere(scans)
 }
 
-func (snap *snapshot) windupiterator(disk api.Index) api.EntryIterator {
+func (snap *snapshot) windupiterator(
+	disk api.Index, level int) api.EntryIterator {
+
 	var ref [20]api.EntryIterator
 	scans := ref[:0]
 
@@ -348,6 +525,9 @@ func (snap *snapshot) windupiterator(disk api.Index) api.EntryIterator {
 		}
 	}
 
+	if snap.bogn.mergeoperator != nil {
+		return snap.foldentries(scans, snap.olderyget(level))
+	}
 	return reduceitere(scans)
 }
 
@@ -355,6 +535,11 @@ func (snap *snapshot) set(key, value, oldvalue []byte) ([]byte, uint64) {
 	return snap.mw.Set(key, value, oldvalue)
 }
 
+func (snap *snapshot) setexpiry(
+	key, value, oldvalue []byte, expiry int64) ([]byte, uint64) {
+	return snap.mw.(api.Expirer).SetExpiry(key, value, oldvalue, expiry)
+}
+
 func (snap *snapshot) setCAS(
 	key, value, oldvalue []byte, cas uint64) ([]byte, uint64, error) {
 	return snap.mw.SetCAS(key, value, oldvalue, cas)
@@ -364,6 +549,11 @@ func (snap *snapshot) delete(key, value []byte, lsm bool) ([]byte, uint64) {
 	return snap.mw.Delete(key, value, lsm)
 }
 
+func (snap *snapshot) merge(key, operand []byte, lsm bool) uint64 {
+	merge := snap.bogn.mergeoperator
+	return snap.mw.(api.Merger).Merge(key, operand, merge, lsm)
+}
+
 func (snap *snapshot) close() {
 	if snap.bogn.workingset {
 		close(snap.setch)
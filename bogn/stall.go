@@ -0,0 +1,131 @@
+package bogn
+
+import "time"
+import "sync/atomic"
+
+import "github.com/bnclabs/gostore/api"
+import s "github.com/bnclabs/gosettings"
+
+// Stalldelay maximum delay applied on a write, when memory footprint
+// is between soft limit and hard limit. Writers blocked on the hard
+// limit re-check memory footprint every Stalldelay.
+var Stalldelay = time.Duration(1 * time.Millisecond)
+
+// stallsample number of writes between two samples of memory
+// footprint, unless writers are already being stalled.
+const stallsample = 64
+
+// readstallsettings, stalls are disabled for non-durable index, since
+// memory is never flushed to disk, writers would otherwise be stopped
+// for ever once hard limit is crossed.
+func (bogn *Bogn) readstallsettings(setts s.Settings) *Bogn {
+	if bogn.durable == false {
+		return bogn
+	}
+	memcap := float64(bogn.memcapacity)
+	bogn.stallsoft = int64(memcap * setts.Float64("stallsoftlimit"))
+	bogn.stallhard = int64(memcap * setts.Float64("stallhardlimit"))
+	bogn.stallblock = setts.Bool("stallblock")
+	return bogn
+}
+
+// stallwrite shall slow down writers when memory footprint exceeds soft
+// limit and stop writers when it exceeds hard limit. If block is false
+// return ErrorStall instead of waiting for memory to be flushed, caller
+// shall account for refused writes in nstallerrors. Shall be called
+// without holding snapshot lock, since flushers need the lock to
+// release memory.
+func (bogn *Bogn) stallwrite(block bool) error {
+	if bogn.stallsoft <= 0 && bogn.stallhard <= 0 {
+		return nil
+	}
+
+	heap := atomic.LoadInt64(&bogn.stallheap)
+	n := atomic.AddInt64(&bogn.nstallchecks, 1)
+	if (n%stallsample) == 0 || bogn.isstalled(heap) {
+		heap = bogn.samplememheap()
+	}
+
+	if bogn.stallhard > 0 && heap > bogn.stallhard {
+		if block == false {
+			return api.ErrorStall
+		}
+		start := time.Now()
+		for heap > bogn.stallhard {
+			select {
+			case <-bogn.finch:
+				heap = 0 // index is closing.
+			case <-time.After(Stalldelay):
+				heap = bogn.samplememheap()
+			}
+		}
+		atomic.AddInt64(&bogn.nstallhard, 1)
+		atomic.AddInt64(&bogn.stalltime, int64(time.Since(start)))
+
+	} else if bogn.stallsoft > 0 && heap > bogn.stallsoft {
+		hard := bogn.stallhard
+		if hard <= bogn.stallsoft {
+			hard = bogn.memcapacity
+		}
+		ratio := float64(heap-bogn.stallsoft) / float64(hard-bogn.stallsoft)
+		if ratio > 1 {
+			ratio = 1
+		}
+		delay := time.Duration(float64(Stalldelay) * ratio)
+		time.Sleep(delay)
+		atomic.AddInt64(&bogn.nstallsoft, 1)
+		atomic.AddInt64(&bogn.stalltime, int64(delay))
+	}
+	return nil
+}
+
+// stallcommit check hard limit for transactions that are already
+// holding the snapshot, return ErrorStall if writes are to be refused.
+func (bogn *Bogn) stallcommit(snap *snapshot) error {
+	if bogn.stallblock || bogn.stallhard <= 0 {
+		return nil
+	} else if snap.memheap() > bogn.stallhard {
+		atomic.AddInt64(&bogn.nstallerrors, 1)
+		return api.ErrorStall
+	}
+	return nil
+}
+
+func (bogn *Bogn) isstalled(heap int64) bool {
+	if bogn.stallsoft > 0 && heap > bogn.stallsoft {
+		return true
+	}
+	return bogn.stallhard > 0 && heap > bogn.stallhard
+}
+
+func (bogn *Bogn) samplememheap() (heap int64) {
+	bogn.snaprlock()
+	if snap := bogn.currsnapshot(); snap != nil {
+		heap = snap.memheap()
+	}
+	bogn.snaprunlock()
+	atomic.StoreInt64(&bogn.stallheap, heap)
+	return heap
+}
+
+// Stallstats return statistics on writes stalled due to memory
+// pressure.
+//
+// "softlimit", memory footprint in bytes, above which writes are slowed.
+// "hardlimit", memory footprint in bytes, above which writes are stopped.
+// "memheap", latest sample of memory footprint.
+// "n_softstalls", number of writes that were slowed down.
+// "n_hardstalls", number of writes that were blocked.
+// "n_stallerrors", number of writes refused with ErrorStall.
+// "stalltime", cumulative time writers were stalled, in nanoseconds.
+func (bogn *Bogn) Stallstats() s.Settings {
+	return s.Settings{
+		"softlimit":     bogn.stallsoft,
+		"hardlimit":     bogn.stallhard,
+		"memheap":       atomic.LoadInt64(&bogn.stallheap),
+		"n_softstalls":  atomic.LoadInt64(&bogn.nstallsoft),
+		"n_hardstalls":  atomic.LoadInt64(&bogn.nstallhard),
+		"n_stallerrors": atomic.LoadInt64(&bogn.nstallerrors),
+		"stalltime":     atomic.LoadInt64(&bogn.stalltime),
+	}
+}
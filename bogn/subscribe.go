@@ -0,0 +1,162 @@
+package bogn
+
+import "io"
+import "os"
+import "sort"
+
+import "github.com/bnclabs/gostore/api"
+import "github.com/bnclabs/gostore/lib"
+
+// Subscribe return an iterator over mutations with seqno greater than
+// or equal to `seqno`, in seqno order, uptil the latest mutation at
+// the time of subscription. If index is durable and its log still
+// holds mutations from `seqno`, every logged mutation is delivered.
+// Otherwise mutations are gathered by a seqno scan on the write store
+// and disk levels, in which case only the latest mutation on each key
+// is delivered. Scan cannot deliver non-lsm deletes, if any was applied
+// since `seqno` iterator returns api.ErrorMissingdeletes. Since log is
+// purged after flushing the write store to disk, subscribers lagging
+// behind a flush will be served from the scan, hence subscribers that
+// cannot miss intermediate mutations, or non-lsm deletes, shall keep
+// up with the flush.
+func (bogn *Bogn) Subscribe(seqno uint64) api.Iterator {
+	if iter := bogn.walchanges(seqno); iter != nil {
+		return iter
+	}
+	return bogn.snapchanges(seqno)
+}
+
+// walchanges return an iterator over logged mutations from seqno, if
+// log does not hold mutations from seqno return nil.
+func (bogn *Bogn) walchanges(from uint64) api.Iterator {
+	w := bogn.wal
+	if w == nil {
+		return nil
+	}
+
+	// open segments with the log lock held, so that they are not purged
+	// while they are being opened.
+	w.lock()
+	till := w.endseqno
+	segments, err := walsegments(w.dir)
+	if err != nil {
+		w.unlock()
+		errorf("%v subscribe: %v", bogn.logprefix, err)
+		return nil
+	} else if len(segments) == 0 {
+		w.unlock()
+		return nil
+	} else if seqno, _ := walsegmentseqno(segments[0]); seqno > from {
+		w.unlock()
+		return nil
+	}
+	fds := []*os.File{}
+	for i, segment := range segments {
+		if i < len(segments)-1 {
+			nextseqno, _ := walsegmentseqno(segments[i+1])
+			if nextseqno <= from { // all mutations in segment are older.
+				continue
+			}
+		}
+		fd, err := os.Open(segment)
+		if err != nil {
+			w.unlock()
+			errorf("%v subscribe: %v", bogn.logprefix, err)
+			for _, fd := range fds {
+				fd.Close()
+			}
+			return nil
+		}
+		fds = append(fds, fd)
+	}
+	w.unlock()
+
+	var rd *walreader
+	var cs lib.Changes
+
+	closefds := func() {
+		for _, fd := range fds {
+			fd.Close()
+		}
+		fds = nil
+	}
+	return func(fin bool) ([]byte, []byte, uint64, bool, error) {
+		if fin {
+			closefds()
+			return nil, nil, 0, false, io.EOF
+		}
+		for len(cs) == 0 {
+			if len(fds) == 0 {
+				return nil, nil, 0, false, io.EOF
+			} else if rd == nil {
+				rd = newwalreader()
+			}
+			rec, _ := rd.next(fds[0])
+			if rec == nil { // end of segment, or a partial tail beyond till.
+				fds[0].Close()
+				fds, rd = fds[1:], nil
+				continue
+			}
+			cs = addrecord(cs, rec, from, till)
+			if rec.endseqno >= till {
+				closefds()
+			}
+		}
+		c := cs[0]
+		cs = cs[1:]
+		return c.Key, c.Value, c.Seqno, c.Deleted, nil
+	}
+}
+
+// addrecord add entries from log record, whose seqno is between from
+// and till, in seqno order. Non-lsm deletes within a transaction are
+// logged without seqno, they are ordered at the end of the record.
+func addrecord(
+	cs lib.Changes, rec *walrecord, from, till uint64) lib.Changes {
+
+	n := len(cs)
+	for _, entry := range rec.entries {
+		seqno := entry.seqno
+		if seqno == 0 {
+			seqno = rec.endseqno
+		}
+		if seqno < from || seqno > till {
+			continue
+		}
+		deleted := entry.cmd == walDelete || entry.cmd == walDeletelsm
+		cs = cs.Add(entry.key, entry.value, seqno, deleted)
+	}
+	sort.Stable(cs[n:])
+	return cs
+}
+
+// snapchanges return an iterator over mutations from seqno, gathered
+// by a seqno scan on all levels of the latest snapshot. Levels are
+// merged by key, and entries are sorted by seqno. Snapshot is held till
+// iteration is complete.
+func (bogn *Bogn) snapchanges(from uint64) api.Iterator {
+	snap := bogn.latestsnapshot()
+	till := snap.mwseqno()
+	snap.catchupindex(snap.mw, till)
+
+	if delseqno := bogn.snapdelseqno(snap); delseqno > 0 && delseqno >= from {
+		snap.release()
+		return func(fin bool) ([]byte, []byte, uint64, bool, error) {
+			if fin {
+				return nil, nil, 0, false, io.EOF
+			}
+			return nil, nil, 0, false, api.ErrorMissingdeletes
+		}
+	}
+
+	scan := func() api.Iterator { return snap.iterator(nil, nil, false) }
+	iter := lib.SeqnoIterator(scan, from, till)
+	return func(fin bool) ([]byte, []byte, uint64, bool, error) {
+		key, value, seqno, del, err := iter(fin)
+		if err != nil && snap != nil {
+			snap.release()
+			snap = nil
+		}
+		return key, value, seqno, del, err
+	}
+}
@@ -0,0 +1,125 @@
+package bogn
+
+import "io"
+import "fmt"
+import "net"
+import "sync"
+import "bufio"
+import "encoding/binary"
+
+// Transport carry replication messages between leader and follower.
+// Messages shall be delivered in the same order they were sent, and
+// Recv shall block until a message is available or until transport is
+// closed. Transports are closed by leader and follower when they stop
+// replicating.
+type Transport interface {
+	// Send message, message buffer can be reused once Send returns.
+	Send(msg []byte) error
+	// Recv next message.
+	Recv() ([]byte, error)
+	// Close transport, pending and subsequent calls shall fail.
+	Close() error
+}
+
+// pipe is an in-process transport.
+type pipe struct {
+	sendch chan []byte
+	recvch chan []byte
+	closed *pipeclose
+}
+
+type pipeclose struct {
+	once  sync.Once
+	finch chan struct{}
+}
+
+// NewPipe return a pair of connected in-process transports, one for
+// leader and the other for follower.
+func NewPipe() (Transport, Transport) {
+	ach, bch := make(chan []byte, 1024), make(chan []byte, 1024)
+	closed := &pipeclose{finch: make(chan struct{})}
+	a := &pipe{sendch: ach, recvch: bch, closed: closed}
+	b := &pipe{sendch: bch, recvch: ach, closed: closed}
+	return a, b
+}
+
+// Send implement Transport interface.
+func (p *pipe) Send(msg []byte) error {
+	msg = append([]byte(nil), msg...)
+	select {
+	case p.sendch <- msg:
+		return nil
+	case <-p.closed.finch:
+	}
+	return io.ErrClosedPipe
+}
+
+// Recv implement Transport interface.
+func (p *pipe) Recv() ([]byte, error) {
+	select {
+	case msg := <-p.recvch:
+		return msg, nil
+	case <-p.closed.finch:
+	}
+	return nil, io.EOF
+}
+
+// Close implement Transport interface, closing one end shall close
+// the other end as well.
+func (p *pipe) Close() error {
+	p.closed.once.Do(func() { close(p.closed.finch) })
+	return nil
+}
+
+// conntransport frame messages over a stream connection as:
+//
+//   msglen uint32
+//   msg    []byte
+type conntransport struct {
+	mu   sync.Mutex
+	conn net.Conn
+	rd   *bufio.Reader
+	hdr  [4]byte
+	buf  []byte
+}
+
+// NewConnTransport return a transport over stream connection, like
+// tcp.
+func NewConnTransport(conn net.Conn) Transport {
+	return &conntransport{conn: conn, rd: bufio.NewReader(conn)}
+}
+
+// Send implement Transport interface.
+func (t *conntransport) Send(msg []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.buf = t.buf[:0]
+	var hdr [4]byte
+	binary.BigEndian.PutUint32(hdr[:], uint32(len(msg)))
+	t.buf = append(append(t.buf, hdr[:]...), msg...)
+	_, err := t.conn.Write(t.buf)
+	return err
+}
+
+// Recv implement Transport interface, shall not be called
+// concurrently.
+func (t *conntransport) Recv() ([]byte, error) {
+	if _, err := io.ReadFull(t.rd, t.hdr[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(t.hdr[:])
+	if n > replmaxmsg {
+		return nil, fmt.Errorf("message length %v > %v", n, replmaxmsg)
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(t.rd, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// Close implement Transport interface.
+func (t *conntransport) Close() error {
+	return t.conn.Close()
+}
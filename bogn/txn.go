@@ -4,6 +4,7 @@ import "sync/atomic"
 
 import "github.com/bnclabs/gostore/api"
 import "github.com/bnclabs/gostore/lib"
+import "github.com/bnclabs/gostore/llrb"
 
 // Txn transaction definition. Transaction gives a gaurantee of isolation and
 // atomicity on the latest snapshot.
@@ -16,6 +17,15 @@ type Txn struct {
 	mcview api.Transactor
 	dviews []api.Transactor
 	yget   api.Getter
+	// aborted for being active beyond txnmaxage.
+	expired bool
+	// memory footprint exceeded hard limit when transaction began.
+	stallerr error
+
+	// write-ahead-log
+	mwlocked bool
+	writes    []txnwrite
+	saves     []txnsave
 
 	// working memory.
 	cursors []*Cursor
@@ -23,6 +33,21 @@ type Txn struct {
 	gets    []api.Getter
 }
 
+// txnwrite remembers write operations within a transaction, to be
+// logged during commit.
+type txnwrite struct {
+	cmd   byte
+	key   []byte
+	value []byte
+}
+
+// txnsave remembers a savepoint on mwtxn along with the number of
+// writes to be logged at that point.
+type txnsave struct {
+	mwsave  int
+	nwrites int
+}
+
 func newtxn(id uint64, bogn *Bogn, snap *snapshot, cch chan *Cursor) *Txn {
 	txn := &Txn{
 		id: id, bogn: bogn, snap: snap,
@@ -31,6 +56,9 @@ func newtxn(id uint64, bogn *Bogn, snap *snapshot, cch chan *Cursor) *Txn {
 		curchan: cch,
 		gets:    make([]api.Getter, 0, 32),
 	}
+	if bogn.wal != nil {
+		txn.writes = make([]txnwrite, 0, 16)
+	}
 	return txn
 }
 
@@ -38,6 +66,13 @@ func (txn *Txn) inittxn() *Txn {
 	var disks [256]api.Index
 
 	id, snap := txn.id, txn.snap
+	if wal := txn.bogn.wal; wal != nil && txn.bogn.memstore == "llrb" {
+		// llrb transaction holds the write lock on mw until it is
+		// committed or aborted, serialize it with other logged writes,
+		// log itself is locked only while committing, refer walock().
+		txn.bogn.mwmu.Lock()
+		txn.mwlocked = true
+	}
 	txn.mwtxn = snap.mw.BeginTxn(id)
 	if snap.mr != nil {
 		txn.mrview = snap.mr.View(id)
@@ -63,6 +98,9 @@ func (txn *Txn) ID() uint64 {
 
 // OpenCursor open an active cursor inside the index.
 func (txn *Txn) OpenCursor(key []byte) (api.Cursor, error) {
+	if txn.isexpired() {
+		return nil, api.ErrorExpired
+	}
 	cur, err := txn.getcursor().opencursor(txn, nil, key)
 	if err != nil {
 		txn.putcursor(cur)
@@ -75,7 +113,30 @@ func (txn *Txn) OpenCursor(key []byte) (api.Cursor, error) {
 // under the transaction are successfully applied. Return
 // ErrorRollback if ACID properties are not met while applying the
 // write operations. Transactions are never partially committed.
+// Return ErrorStall, and abort the transaction, if memory footprint
+// exceeds hard limit and stallblock is false.
+//
+// Seqno observed for every key read by Get is validated against the
+// write store, if the key was updated after it was read transaction
+// is rolled back. Levels older than the write store are immutable
+// for the life of the transaction, hence keys read from them are
+// validated by checking that they are not yet written into the write
+// store. Keys read via cursors are not validated.
+//
+// Return ErrorExpired if transaction was aborted for being active
+// beyond "txnmaxage".
 func (txn *Txn) Commit() error {
+	if txn.isexpired() {
+		return api.ErrorExpired
+	}
+	if err := txn.stallerr; err != nil {
+		atomic.AddInt64(&txn.bogn.nstallerrors, 1)
+		txn.Abort()
+		return err
+	} else if err := txn.bogn.stallcommit(txn.snap); err != nil {
+		txn.Abort()
+		return err
+	}
 	if txn.mrview != nil {
 		txn.mrview.Abort()
 	}
@@ -86,10 +147,30 @@ func (txn *Txn) Commit() error {
 		dview.Abort()
 	}
 
+	var ticket int64
+	var err3 error
+
+	bogn, wal := txn.bogn, txn.bogn.wal
+	if wal != nil {
+		wal.lock()
+	}
 	err1 := txn.mwtxn.Commit()
-	err2 := txn.bogn.commit(txn)
+	if wal != nil {
+		if err1 == nil {
+			ticket, err3 = txn.logwrites(wal)
+		}
+		wal.unlock()
+	}
+	if txn.mwlocked {
+		txn.mwlocked = false
+		bogn.mwmu.Unlock()
+	}
+	err2 := bogn.commit(txn)
+	err3 = bogn.waitsync(ticket, err3)
 	if err1 != nil {
 		return err1
+	} else if err3 != nil {
+		return err3
 	} else if err2 != nil {
 		return err2
 	}
@@ -98,6 +179,13 @@ func (txn *Txn) Commit() error {
 
 // Abort transaction, underlying index won't be touched.
 func (txn *Txn) Abort() {
+	if txn.expired {
+		return
+	}
+	txn.abort()
+}
+
+func (txn *Txn) abort() {
 	if txn.mrview != nil {
 		txn.mrview.Abort()
 	}
@@ -109,34 +197,179 @@ func (txn *Txn) Abort() {
 	}
 
 	txn.mwtxn.Abort()
+	if txn.mwlocked {
+		txn.mwlocked = false
+		txn.bogn.mwmu.Unlock()
+	}
 	txn.bogn.aborttxn(txn)
 }
 
+// Savepoint mark the current state of writes made by this transaction
+// and return the savepoint, which can later be passed to RollbackTo.
+// Savepoints are stacked, rolling back to a savepoint shall discard
+// all savepoints taken after it. Return -1 if transaction has
+// expired.
+func (txn *Txn) Savepoint() int {
+	if txn.isexpired() {
+		return -1
+	}
+	mwsave := txn.mwtxn.(*llrb.Txn).Savepoint()
+	txn.saves = append(txn.saves, txnsave{mwsave, len(txn.writes)})
+	return len(txn.saves) - 1
+}
+
+// RollbackTo undo all writes made after savepoint, writes made before
+// savepoint are retained and transaction can continue. Savepoint
+// remains valid and can be rolled back to again.
+func (txn *Txn) RollbackTo(savepoint int) error {
+	if txn.isexpired() {
+		return api.ErrorExpired
+	}
+	if savepoint < 0 || savepoint >= len(txn.saves) {
+		return api.ErrorInvalidSavepoint
+	}
+	save := txn.saves[savepoint]
+	if err := txn.mwtxn.(*llrb.Txn).RollbackTo(save.mwsave); err != nil {
+		return err
+	}
+	txn.writes = txn.writes[:save.nwrites]
+	txn.saves = txn.saves[:savepoint+1]
+	return nil
+}
+
 //---- Exported Read methods
 
 // Get value for key from snapshot.
 func (txn *Txn) Get(
 	key, value []byte) (v []byte, cas uint64, deleted, ok bool) {
 
+	if txn.isexpired() {
+		return lib.Fixbuffer(value, 0), 0, false, false
+	}
 	return txn.yget(key, value)
 }
 
+// GetForUpdate is same as Get, additionally lock the key until the
+// transaction is committed or aborted and return its latest version.
+// Other transactions locking the same key shall wait for upto
+// "locktimeout" and return ErrorLocktimeout, or return ErrorDeadlock
+// if waiting would deadlock. Writes outside transactions are not
+// blocked by the lock.
+func (txn *Txn) GetForUpdate(
+	key, value []byte) (v []byte, cas uint64, deleted, ok bool, err error) {
+
+	if txn.isexpired() {
+		return value, 0, false, false, api.ErrorExpired
+	}
+	bogn := txn.bogn
+	if err = bogn.keylocks.Lock(txn, key, bogn.locktimeout); err != nil {
+		return value, 0, false, false, err
+	}
+	// latest version of key in write store, older levels are immutable
+	// for the life of the transaction.
+	if mwtxn, yes := txn.mwtxn.(*llrb.Txn); yes && bogn.mergeoperator == nil {
+		v, cas, deleted, ok, err = mwtxn.GetForUpdate(key, value)
+		if err != nil || ok {
+			return v, cas, deleted, ok, err
+		}
+	}
+	v, cas, deleted, ok = txn.yget(key, value)
+	return v, cas, deleted, ok, nil
+}
+
 //---- Exported Write methods
 
 // Set an entry of key, value pair. The set operation will be remembered
 // as a log entry and applied on the underlying structure during Commit.
 func (txn *Txn) Set(key, value, oldvalue []byte) []byte {
+	if txn.isexpired() {
+		return oldvalue
+	}
+	txn.addwrite(walSet, key, value)
 	return txn.mwtxn.Set(key, value, oldvalue)
 }
 
 // Delete key from index. The Delete operation will be remembered as a log
 // entry and applied on the underlying structure during commit.
 func (txn *Txn) Delete(key, oldvalue []byte, lsm bool) []byte {
+	if txn.isexpired() {
+		return oldvalue
+	}
+	if lsm {
+		txn.addwrite(walDeletelsm, key, nil)
+	} else {
+		txn.addwrite(walDelete, key, nil)
+	}
 	return txn.mwtxn.Delete(key, oldvalue, lsm)
 }
 
 //---- local methods
 
+// isexpired return true if transaction was aborted for being active
+// beyond "txnmaxage", abort it now if it has just expired. Writes on
+// an expired transaction are ignored.
+func (txn *Txn) isexpired() bool {
+	if txn.expired {
+		return true
+	}
+	bogn := txn.bogn
+	if !bogn.txnabort || !bogn.tracker.Expired(txn) {
+		return false
+	}
+	warnf("%v txn %v aborted, active beyond txnmaxage", bogn.logprefix, txn.id)
+	bogn.tracker.Aborted()
+	txn.expired = true
+	txn.abort()
+	return true
+}
+
+func (txn *Txn) addwrite(cmd byte, key, value []byte) {
+	if txn.bogn.wal == nil {
+		return
+	}
+	var w txnwrite
+	if n := len(txn.writes); n < cap(txn.writes) {
+		w = txn.writes[:n+1][n]
+	}
+	w.cmd = cmd
+	w.key = lib.Fixbuffer(w.key, int64(len(key)))
+	copy(w.key, key)
+	w.value = lib.Fixbuffer(w.value, int64(len(value)))
+	copy(w.value, value)
+	txn.writes = append(txn.writes, w)
+}
+
+// logwrites as a single batch, must be called after the transaction
+// is committed on mw and with log lock held.
+func (txn *Txn) logwrites(wal *wal) (int64, error) {
+	return logwrites(wal, txn.snap, txn.writes)
+}
+
+// logwrites as a single batch, must be called after writes are
+// committed on mw and with log lock held. Only the latest write on a
+// key is logged along with seqno assigned to it by the commit.
+func logwrites(
+	wal *wal, snap *snapshot, writes []txnwrite) (int64, error) {
+
+	if len(writes) == 0 {
+		return 0, nil
+	}
+	mw, seen := snap.mw, make(map[string]bool)
+	for i := len(writes) - 1; i >= 0; i-- {
+		w := writes[i]
+		if seen[string(w.key)] {
+			continue
+		}
+		seen[string(w.key)] = true
+		_, seqno, _, ok := mw.Get(w.key, nil)
+		if ok == false { // non-lsm delete
+			seqno = 0
+		}
+		wal.addentry(w.cmd, seqno, w.key, w.value)
+	}
+	return wal.commitbatch(snap.mwseqno())
+}
+
 func (txn *Txn) getcursor() (cur *Cursor) {
 	select {
 	case cur = <-txn.curchan:
@@ -152,6 +385,7 @@ func (txn *Txn) putcursor(cur *Cursor) {
 	cur.key = lib.Fixbuffer(cur.key, 0)
 	cur.value = lib.Fixbuffer(cur.value, 0)
 	cur.cas, cur.deleted = 0, false
+	cur.reverse, cur.eof, cur.skip = false, false, false
 	cur.iter, cur.iters = nil, cur.iters[:0]
 
 	select {
@@ -15,6 +15,8 @@ type View struct {
 	mcview api.Transactor
 	dviews []api.Transactor
 	yget   api.Getter
+	// aborted for being active beyond txnmaxage.
+	expired bool
 
 	// working memory.
 	cursors []*Cursor
@@ -62,6 +64,9 @@ func (view *View) ID() uint64 {
 
 // OpenCursor open an active cursor inside the index.
 func (view *View) OpenCursor(key []byte) (api.Cursor, error) {
+	if view.isexpired() {
+		return nil, api.ErrorExpired
+	}
 	cur, err := view.getcursor().opencursor(nil, view, key)
 	if err != nil {
 		view.putcursor(cur)
@@ -77,6 +82,13 @@ func (view *View) Commit() error {
 
 // Abort view, must be called once done with the view.
 func (view *View) Abort() {
+	if view.expired {
+		return
+	}
+	view.abort()
+}
+
+func (view *View) abort() {
 	if view.mrview != nil {
 		view.mrview.Abort()
 	}
@@ -97,6 +109,9 @@ func (view *View) Abort() {
 func (view *View) Get(
 	key, value []byte) (v []byte, cas uint64, deleted, ok bool) {
 
+	if view.isexpired() {
+		return lib.Fixbuffer(value, 0), 0, false, false
+	}
 	return view.yget(key, value)
 }
 
@@ -114,6 +129,24 @@ func (view *View) Delete(key, oldvalue []byte, lsm bool) []byte {
 
 //---- local methods
 
+// isexpired return true if view was aborted for being active beyond
+// "txnmaxage", abort it now if it has just expired.
+func (view *View) isexpired() bool {
+	if view.expired {
+		return true
+	}
+	bogn := view.bogn
+	if !bogn.txnabort || !bogn.tracker.Expired(view) {
+		return false
+	}
+	fmsg := "%v view %v aborted, active beyond txnmaxage"
+	warnf(fmsg, bogn.logprefix, view.id)
+	bogn.tracker.Aborted()
+	view.expired = true
+	view.abort()
+	return true
+}
+
 func (view *View) getcursor() (cur *Cursor) {
 	select {
 	case cur = <-view.curchan:
@@ -129,6 +162,7 @@ func (view *View) putcursor(cur *Cursor) {
 	cur.key = lib.Fixbuffer(cur.key, 0)
 	cur.value = lib.Fixbuffer(cur.value, 0)
 	cur.cas, cur.deleted = 0, false
+	cur.reverse, cur.eof, cur.skip = false, false, false
 	cur.iter, cur.iters = nil, cur.iters[:0]
 
 	select {
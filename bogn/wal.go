@@ -0,0 +1,686 @@
+package bogn
+
+import "io"
+import "os"
+import "fmt"
+import "sort"
+import "sync"
+import "time"
+import "strings"
+import "strconv"
+import "io/ioutil"
+import "hash/crc32"
+import "path/filepath"
+import "encoding/binary"
+
+import "github.com/bnclabs/gostore/lib"
+
+// wal is the write-ahead-log for a durable bogn instance. Every
+// mutation applied on the write store is appended to the log, along
+// with its seqno, before it is acknowledged to the application.
+//
+// Log is organised as one or more segment files under logdir, each
+// segment named after the first seqno that can be logged in it. A
+// segment is a sequence of records, and each record is a batch of
+// one or more mutations that shall be applied atomically:
+//
+//   reclen   uint32 - length of payload.
+//   checksum uint32 - crc32 checksum of payload.
+//   payload:
+//     endseqno uint64 - seqno on write store after applying the batch.
+//     count    uint32 - number of entries in this batch.
+//     entries  []walentry
+//
+// walentry:
+//   cmd      byte   - walSet, walDelete, walDeletelsm, walSetexpiry,
+//                     walMerge
+//   seqno    uint64 - seqno of this mutation, ZERO for non-lsm delete
+//                     within a transaction.
+//   keylen   uint32
+//   key      []byte
+//   valuelen uint32
+//   value    []byte
+//   expiry   int64  - only for walSetexpiry, unix time in seconds.
+type wal struct {
+	mu       sync.Mutex
+	dir      string
+	segment  string
+	fd       *os.File
+	fpos     int64 // write position in current segment.
+	logpos   int64 // total bytes logged, used as ticket for sync.
+	begseqno uint64 // first seqno that can be logged in current segment.
+	endseqno uint64 // last seqno logged so far.
+	syncmode string
+	synctick time.Duration
+	segsize  int64
+	tblcrc32 *crc32.Table
+	// once log fails to write or sync, all subsequent writes fail.
+	err error
+
+	// working memory.
+	block   []byte
+	count   uint32
+	scratch [16]byte
+
+	// group commit, fdmu is read-locked while syncing the current
+	// segment outside the log lock, and write-locked while closing it.
+	fdmu      sync.RWMutex
+	syncmu    sync.Mutex
+	synccond  *sync.Cond
+	syncedpos int64
+	syncerr   error
+	finch     chan struct{}
+	donech    chan struct{}
+	logprefix string
+}
+
+const (
+	walSet byte = iota + 1
+	walDelete
+	walDeletelsm
+	walSetexpiry
+	walMerge
+)
+
+const walrechdr = 8 // reclen + checksum
+const walbatchhdr = 12
+
+// walmaxrecord is the largest payload of a log record, batches larger
+// than this fail the log.
+const walmaxrecord = 256 * 1024 * 1024
+
+// openwal start a new log segment under dir, all mutations with seqno
+// greater than or equal to `seqno` will be logged in this segment.
+func openwal(
+	logprefix, dir string, seqno uint64,
+	syncmode string, synctick time.Duration, segsize int64) (*wal, error) {
+
+	w := &wal{
+		dir:       dir,
+		syncmode:  syncmode,
+		synctick:  synctick,
+		segsize:   segsize,
+		tblcrc32:  crc32.MakeTable(crc32.IEEE),
+		block:     make([]byte, 0, 1024),
+		logprefix: logprefix,
+	}
+	switch syncmode {
+	case "write", "group", "none":
+	default:
+		panic(fmt.Errorf("invalid logsync %q", syncmode))
+	}
+	if err := os.MkdirAll(dir, 0775); err != nil {
+		errorf("%v wal: MkdirAll(%q): %v", logprefix, dir, err)
+		return nil, err
+	}
+	w.endseqno = seqno - 1
+	if err := w.opensegment(seqno); err != nil {
+		return nil, err
+	}
+	if w.syncmode == "group" {
+		w.synccond = sync.NewCond(&w.syncmu)
+		w.finch, w.donech = make(chan struct{}), make(chan struct{})
+		go w.syncer()
+	}
+	fmsg := "%v wal: logging to %q with logsync:%v"
+	infof(fmsg, w.logprefix, w.segment, w.syncmode)
+	return w, nil
+}
+
+func walsegmentname(seqno uint64) string {
+	return fmt.Sprintf("bogn-wal-%020d.log", seqno)
+}
+
+func walsegmentseqno(filename string) (uint64, bool) {
+	name := filepath.Base(filename)
+	if !strings.HasPrefix(name, "bogn-wal-") {
+		return 0, false
+	} else if !strings.HasSuffix(name, ".log") {
+		return 0, false
+	}
+	name = strings.TrimSuffix(strings.TrimPrefix(name, "bogn-wal-"), ".log")
+	seqno, err := strconv.ParseUint(name, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return seqno, true
+}
+
+// walsegments return the list of log segments under dir sorted by
+// their starting seqno.
+func walsegments(dir string) ([]string, error) {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	segments := []string{}
+	for _, fi := range fis {
+		if fi.IsDir() {
+			continue
+		} else if _, ok := walsegmentseqno(fi.Name()); ok {
+			segments = append(segments, filepath.Join(dir, fi.Name()))
+		}
+	}
+	sort.Strings(segments) // seqno is zero padded.
+	return segments, nil
+}
+
+func (w *wal) opensegment(seqno uint64) error {
+	segment := filepath.Join(w.dir, walsegmentname(seqno))
+	flags := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	fd, err := os.OpenFile(segment, flags, 0644)
+	if err != nil {
+		errorf("%v wal: OpenFile(%q): %v", w.logprefix, segment, err)
+		return err
+	}
+	fi, err := fd.Stat()
+	if err != nil {
+		fd.Close()
+		errorf("%v wal: Stat(%q): %v", w.logprefix, segment, err)
+		return err
+	}
+	w.segment, w.fd, w.fpos, w.begseqno = segment, fd, fi.Size(), seqno
+	return nil
+}
+
+// rotate current segment, if it has grown beyond segsize.
+func (w *wal) rotate(seqno uint64) error {
+	if w.fpos < w.segsize {
+		return nil
+	}
+	return w.switchsegment(seqno)
+}
+
+func (w *wal) switchsegment(seqno uint64) error {
+	w.closesegment()
+	if err := w.opensegment(seqno); err != nil {
+		return w.fail(err)
+	}
+	infof("%v wal: rotated to %q", w.logprefix, w.segment)
+	return nil
+}
+
+// fail log for all subsequent writes, must be called with lock held.
+func (w *wal) fail(err error) error {
+	if w.err == nil {
+		w.err = err
+	}
+	w.block, w.count = w.block[:0], 0
+	return err
+}
+
+func (w *wal) closesegment() {
+	w.fdmu.Lock()
+	defer w.fdmu.Unlock()
+
+	if err := w.fd.Sync(); err != nil {
+		errorf("%v wal: Sync(%q): %v", w.logprefix, w.segment, err)
+	}
+	if err := w.fd.Close(); err != nil {
+		errorf("%v wal: Close(%q): %v", w.logprefix, w.segment, err)
+	}
+	w.fd = nil
+}
+
+func (w *wal) lock() {
+	w.mu.Lock()
+}
+
+func (w *wal) unlock() {
+	w.mu.Unlock()
+}
+
+// addentry to the current batch, must be called with lock held.
+func (w *wal) addentry(cmd byte, seqno uint64, key, value []byte) {
+	if len(w.block) == 0 {
+		w.block = lib.Fixbuffer(w.block, walrechdr+walbatchhdr)
+		w.count = 0
+	}
+	w.block = append(w.block, cmd)
+	binary.BigEndian.PutUint64(w.scratch[:8], seqno)
+	w.block = append(w.block, w.scratch[:8]...)
+	binary.BigEndian.PutUint32(w.scratch[:4], uint32(len(key)))
+	w.block = append(w.block, w.scratch[:4]...)
+	w.block = append(w.block, key...)
+	binary.BigEndian.PutUint32(w.scratch[:4], uint32(len(value)))
+	w.block = append(w.block, w.scratch[:4]...)
+	w.block = append(w.block, value...)
+	w.count++
+}
+
+// commitbatch write the current batch as a single record, must be
+// called with lock held. Return a ticket that can be used with
+// waitsync(). Return error if log failed to write or sync the batch,
+// now or earlier.
+func (w *wal) commitbatch(endseqno uint64) (int64, error) {
+	if w.err != nil {
+		return 0, w.fail(w.err)
+	} else if len(w.block) == 0 {
+		return 0, nil
+	}
+	payload := w.block[walrechdr:]
+	if len(payload) > walmaxrecord {
+		err := fmt.Errorf("log record %v > %v", len(payload), walmaxrecord)
+		errorf("%v wal: %v", w.logprefix, err)
+		return 0, w.fail(err)
+	}
+	binary.BigEndian.PutUint64(payload[:8], endseqno)
+	binary.BigEndian.PutUint32(payload[8:12], w.count)
+	checksum := crc32.Checksum(payload, w.tblcrc32)
+	binary.BigEndian.PutUint32(w.block[:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(w.block[4:8], checksum)
+
+	if n, err := w.fd.Write(w.block); err != nil {
+		errorf("%v wal: Write(%q): %v", w.logprefix, w.segment, err)
+		return 0, w.fail(err)
+	} else if n != len(w.block) {
+		err := fmt.Errorf("partial write %v<%v", n, len(w.block))
+		errorf("%v wal: Write(%q): %v", w.logprefix, w.segment, err)
+		return 0, w.fail(err)
+	}
+	if w.syncmode == "write" {
+		if err := w.fd.Sync(); err != nil {
+			errorf("%v wal: Sync(%q): %v", w.logprefix, w.segment, err)
+			return 0, w.fail(err)
+		}
+	}
+	w.fpos += int64(len(w.block))
+	w.logpos += int64(len(w.block))
+	w.block, w.count = w.block[:0], 0
+	w.endseqno = endseqno
+
+	if err := w.rotate(endseqno + 1); err != nil {
+		return 0, err
+	}
+	return w.logpos, nil
+}
+
+func (w *wal) logset(key, value []byte, seqno uint64) (int64, error) {
+	w.addentry(walSet, seqno, key, value)
+	return w.commitbatch(seqno)
+}
+
+func (w *wal) logsetexpiry(
+	key, value []byte, expiry int64, seqno uint64) (int64, error) {
+
+	w.addentry(walSetexpiry, seqno, key, value)
+	binary.BigEndian.PutUint64(w.scratch[:8], uint64(expiry))
+	w.block = append(w.block, w.scratch[:8]...)
+	return w.commitbatch(seqno)
+}
+
+func (w *wal) logmerge(key, operand []byte, seqno uint64) (int64, error) {
+	w.addentry(walMerge, seqno, key, operand)
+	return w.commitbatch(seqno)
+}
+
+func (w *wal) logdelete(
+	key []byte, seqno uint64, lsm bool) (int64, error) {
+
+	if lsm {
+		w.addentry(walDeletelsm, seqno, key, nil)
+	} else {
+		w.addentry(walDelete, seqno, key, nil)
+	}
+	return w.commitbatch(seqno)
+}
+
+// logrecord log a batch of mutations, read from another log, as a
+// single record with the same seqnos.
+func (w *wal) logrecord(rec *walrecord) (int64, error) {
+	for _, entry := range rec.entries {
+		w.addentry(entry.cmd, entry.seqno, entry.key, entry.value)
+		if entry.cmd == walSetexpiry {
+			binary.BigEndian.PutUint64(w.scratch[:8], uint64(entry.expiry))
+			w.block = append(w.block, w.scratch[:8]...)
+		}
+	}
+	return w.commitbatch(rec.endseqno)
+}
+
+// waitsync block until mutations upto ticket are synced to disk,
+// applicable only for group commit. Don't call this with lock held.
+// Return error if log failed to sync them.
+func (w *wal) waitsync(ticket int64) (err error) {
+	if w == nil || ticket == 0 || w.syncmode != "group" {
+		return nil
+	}
+	w.syncmu.Lock()
+	for w.syncedpos < ticket && w.syncerr == nil {
+		w.synccond.Wait()
+	}
+	if w.syncedpos < ticket {
+		err = w.syncerr
+	}
+	w.syncmu.Unlock()
+	return err
+}
+
+func (w *wal) syncer() {
+	defer close(w.donech)
+
+	ticker := time.NewTicker(w.synctick)
+	defer ticker.Stop()
+
+	// sync outside the log lock, so that writers can continue logging
+	// while the segment is synced. Segment is not closed until sync is
+	// done, and segments that are closed are synced before closing.
+	dosync := func() {
+		w.lock()
+		logpos, fd, segment := w.logpos, w.fd, w.segment
+		w.fdmu.RLock()
+		w.unlock()
+		var err error
+		if fd != nil {
+			if err = fd.Sync(); err != nil {
+				errorf("%v wal: Sync(%q): %v", w.logprefix, segment, err)
+			}
+		}
+		w.fdmu.RUnlock()
+
+		if err != nil {
+			w.lock()
+			w.fail(err)
+			w.unlock()
+		}
+
+		w.syncmu.Lock()
+		if err != nil && w.syncerr == nil {
+			w.syncerr = err
+		} else if err == nil && w.syncerr == nil {
+			w.syncedpos = logpos
+		}
+		w.synccond.Broadcast()
+		w.syncmu.Unlock()
+	}
+
+	for {
+		select {
+		case <-ticker.C:
+			dosync()
+		case <-w.finch:
+			dosync()
+			return
+		}
+	}
+}
+
+// purge log segments whose mutations are all flushed to disk, that
+// is, upto and including seqno. Current segment, if not empty, is
+// switched to a new segment so that it can be purged by subsequent
+// flush.
+func (w *wal) purge(seqno uint64) {
+	if w == nil {
+		return
+	}
+
+	w.lock()
+	defer w.unlock()
+
+	if w.err != nil {
+		return
+	} else if w.fpos > 0 {
+		if err := w.switchsegment(w.endseqno + 1); err != nil {
+			return
+		}
+	}
+	segments, err := walsegments(w.dir)
+	if err != nil {
+		errorf("%v wal: %v", w.logprefix, err)
+		return
+	}
+	for i := 0; i < len(segments)-1; i++ {
+		segment := segments[i]
+		if segment == w.segment {
+			break
+		}
+		// last seqno in this segment.
+		nextseqno, _ := walsegmentseqno(segments[i+1])
+		if (nextseqno - 1) > seqno {
+			break
+		}
+		if err := os.Remove(segment); err != nil {
+			errorf("%v wal: Remove(%q): %v", w.logprefix, segment, err)
+			return
+		}
+		infof("%v wal: purged %q upto seqno %v", w.logprefix, segment, seqno)
+	}
+}
+
+func (w *wal) close() {
+	if w == nil {
+		return
+	}
+	if w.syncmode == "group" {
+		close(w.finch)
+		<-w.donech
+	}
+	w.lock()
+	if w.fd != nil {
+		w.closesegment()
+	}
+	w.unlock()
+	infof("%v wal: closed", w.logprefix)
+}
+
+// walrecord is a batch of mutations read from log.
+type walrecord struct {
+	endseqno uint64
+	entries  []walentry
+}
+
+type walentry struct {
+	cmd    byte
+	seqno  uint64
+	key    []byte
+	value  []byte
+	expiry int64
+}
+
+// readsegment iterate on every valid record in segment file. Return
+// the offset till which the segment contains valid records, and
+// whether the segment ended with a torn or corrupted record.
+func readsegment(
+	segment string, callb func(rec *walrecord) bool) (int64, bool, error) {
+
+	fd, err := os.Open(segment)
+	if err != nil {
+		return 0, false, err
+	}
+	defer fd.Close()
+
+	rd := newwalreader()
+	for {
+		rec, torn := rd.next(fd)
+		if rec == nil {
+			return rd.fpos, torn, nil
+		}
+		if callb(rec) == false {
+			return rd.fpos, false, nil
+		}
+	}
+}
+
+// walreader read records, one at a time, from a log segment.
+type walreader struct {
+	tblcrc32 *crc32.Table
+	hdr      []byte
+	payload  []byte
+	rec      *walrecord
+	fpos     int64 // offset till which records are read.
+}
+
+func newwalreader() *walreader {
+	return &walreader{
+		tblcrc32: crc32.MakeTable(crc32.IEEE),
+		hdr:      make([]byte, walrechdr),
+		payload:  make([]byte, 0, 1024),
+		rec:      &walrecord{entries: make([]walentry, 0, 16)},
+	}
+}
+
+// next record from fd, returned record is valid only till the next
+// call. Return nil if fd is at EOF or at a torn or corrupted record,
+// in which case torn is true.
+func (rd *walreader) next(fd io.Reader) (rec *walrecord, torn bool) {
+	if _, err := io.ReadFull(fd, rd.hdr); err == io.EOF {
+		return nil, false
+	} else if err != nil { // partial header
+		return nil, true
+	}
+	reclen := int64(binary.BigEndian.Uint32(rd.hdr[:4]))
+	checksum := binary.BigEndian.Uint32(rd.hdr[4:8])
+	if reclen < walbatchhdr || reclen > walmaxrecord {
+		return nil, true
+	}
+	rd.payload = lib.Fixbuffer(rd.payload, reclen)
+	if _, err := io.ReadFull(fd, rd.payload); err != nil {
+		return nil, true
+	} else if crc32.Checksum(rd.payload, rd.tblcrc32) != checksum {
+		return nil, true
+	} else if ok := rd.rec.decode(rd.payload); !ok {
+		return nil, true
+	}
+	rd.fpos += walrechdr + reclen
+	return rd.rec, false
+}
+
+func (rec *walrecord) decode(payload []byte) bool {
+	rec.endseqno = binary.BigEndian.Uint64(payload[:8])
+	count := binary.BigEndian.Uint32(payload[8:12])
+	rec.entries = rec.entries[:0]
+	n := walbatchhdr
+	for i := uint32(0); i < count; i++ {
+		var entry walentry
+		if len(payload[n:]) < 13 {
+			return false
+		}
+		switch entry.cmd = payload[n]; entry.cmd {
+		case walSet, walDelete, walDeletelsm, walSetexpiry, walMerge:
+		default:
+			return false
+		}
+		entry.seqno = binary.BigEndian.Uint64(payload[n+1 : n+9])
+		keylen := int(binary.BigEndian.Uint32(payload[n+9 : n+13]))
+		n += 13
+		if len(payload[n:]) < keylen+4 {
+			return false
+		}
+		entry.key, n = payload[n:n+keylen], n+keylen
+		valuelen := int(binary.BigEndian.Uint32(payload[n : n+4]))
+		n += 4
+		if len(payload[n:]) < valuelen {
+			return false
+		}
+		entry.value, n = payload[n:n+valuelen], n+valuelen
+		if entry.cmd == walSetexpiry {
+			if len(payload[n:]) < 8 {
+				return false
+			}
+			entry.expiry = int64(binary.BigEndian.Uint64(payload[n : n+8]))
+			n += 8
+		}
+		rec.entries = append(rec.entries, entry)
+	}
+	return n == len(payload)
+}
+
+// segmentfor return the log segment that can hold seqno, return false
+// if segments holding seqno are purged, or if seqno is yet to be
+// logged beyond the current segment.
+func (w *wal) segmentfor(seqno uint64) (string, bool) {
+	w.lock()
+	defer w.unlock()
+
+	if seqno > w.endseqno+1 {
+		return "", false
+	}
+	segments, err := walsegments(w.dir)
+	if err != nil {
+		errorf("%v wal: %v", w.logprefix, err)
+		return "", false
+	}
+	for i := len(segments) - 1; i >= 0; i-- {
+		if begseqno, _ := walsegmentseqno(segments[i]); begseqno <= seqno {
+			return segments[i], true
+		}
+	}
+	return "", false
+}
+
+// walcursor tail log segments, one record at a time, while log is
+// being appended and rotated.
+type walcursor struct {
+	w       *wal
+	segment string
+	fd      *os.File
+	rd      *walreader
+}
+
+// openwalcursor at the segment holding seqno, return nil if log does
+// not hold mutations from seqno.
+func openwalcursor(w *wal, seqno uint64) *walcursor {
+	cur := &walcursor{w: w}
+	if segment, ok := w.segmentfor(seqno); !ok {
+		return nil
+	} else if err := cur.open(segment); err != nil {
+		return nil
+	}
+	return cur
+}
+
+func (cur *walcursor) open(segment string) error {
+	fd, err := os.Open(segment)
+	if err != nil {
+		errorf("%v wal: Open(%q): %v", cur.w.logprefix, segment, err)
+		return err
+	}
+	cur.close()
+	cur.segment, cur.fd, cur.rd = segment, fd, newwalreader()
+	return nil
+}
+
+// next record that ends at or after seqno, along with its payload,
+// both valid only till the next call. Return nil record if all logged
+// records are read. Return false if log no more holds mutations from
+// seqno.
+func (cur *walcursor) next(seqno uint64) (*walrecord, []byte, bool) {
+	var rotated string
+
+	for {
+		if rec, _ := cur.rd.next(cur.fd); rec != nil {
+			if rec.endseqno < seqno {
+				continue
+			}
+			return rec, cur.rd.payload, true
+		}
+		// rewind to the last complete record, tail might be partial.
+		if _, err := cur.fd.Seek(cur.rd.fpos, io.SeekStart); err != nil {
+			errorf("%v wal: Seek(%q): %v", cur.w.logprefix, cur.segment, err)
+			return nil, nil, false
+		}
+		if rotated != "" { // current segment is fully read.
+			if err := cur.open(rotated); err != nil {
+				return nil, nil, false
+			}
+			rotated = ""
+			continue
+		}
+		segment, ok := cur.w.segmentfor(seqno)
+		if !ok {
+			return nil, nil, false
+		} else if segment == cur.segment {
+			return nil, nil, true
+		}
+		// segments are rotated only after a record is fully written,
+		// read the current segment once more before moving on.
+		rotated = segment
+	}
+}
+
+func (cur *walcursor) close() {
+	if cur.fd != nil {
+		cur.fd.Close()
+		cur.fd = nil
+	}
+}
@@ -0,0 +1,341 @@
+package bogn
+
+import "os"
+import "fmt"
+import "time"
+import "bufio"
+import "strings"
+import "testing"
+import "os/exec"
+import "math/rand"
+
+func TestWALReplayTorn(t *testing.T) {
+	destoryindex("index", makepaths())
+
+	setts, paths := makesettings(), makepaths()
+	setts["bubt.diskpaths"] = paths
+	setts["durable"] = true
+	index, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+	index.Set([]byte("key0"), []byte("val0"), nil)
+	logdir, seqno := index.logdir(""), index.Getseqno()
+	index.Close()
+
+	// simulate a crash, log mutations without flushing them to disk.
+	w, err := openwal(index.logprefix, logdir, seqno+1, "write", 0, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 100
+	for i := 0; i < n; i++ {
+		key, val := fmt.Sprintf("key%v", i), fmt.Sprintf("val%v", i)
+		seqno++
+		w.lock()
+		w.logset([]byte(key), []byte(val), seqno)
+		w.unlock()
+		if i%10 == 0 {
+			seqno++
+			w.lock()
+			w.logdelete([]byte(key), seqno, false /*lsm*/)
+			w.unlock()
+		}
+	}
+	segment := w.segment
+	w.close()
+
+	// torn tail.
+	fi, err := os.Stat(segment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd, err := os.OpenFile(segment, os.O_WRONLY|os.O_APPEND, 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd.Write([]byte{0, 0, 1, 0, 0xde, 0xad, 0xbe, 0xef, 1, 2, 3})
+	fd.Close()
+
+	index, err = New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+
+	if x := index.Getseqno(); x != seqno {
+		t.Errorf("expected %v, got %v", seqno, x)
+	}
+	if fi1, err := os.Stat(segment); err != nil {
+		t.Fatal(err)
+	} else if fi1.Size() != fi.Size() {
+		t.Errorf("expected %v, got %v", fi.Size(), fi1.Size())
+	}
+	for i := 0; i < n; i++ {
+		key, val := fmt.Sprintf("key%v", i), fmt.Sprintf("val%v", i)
+		value, _, deleted, ok := index.Get([]byte(key), []byte{})
+		if i%10 == 0 {
+			if ok && !deleted {
+				t.Errorf("unexpected key %q", key)
+			}
+		} else if ok == false || deleted {
+			t.Errorf("missing key %q", key)
+		} else if string(value) != val {
+			t.Errorf("expected %q, got %q", val, value)
+		}
+	}
+
+	index.Close()
+	index.Destroy()
+}
+
+func TestWALPurge(t *testing.T) {
+	destoryindex("index", makepaths())
+
+	setts, paths := makesettings(), makepaths()
+	setts["bubt.diskpaths"] = paths
+	setts["durable"] = true
+	setts["autocommit"] = 1
+	setts["logsegsize"] = 1024
+	index, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+
+	for i := 0; i < 1000; i++ {
+		key, val := fmt.Sprintf("key%v", i), fmt.Sprintf("val%v", i)
+		index.Set([]byte(key), []byte(val), nil)
+	}
+	seqno := index.Getseqno()
+	time.Sleep(3 * time.Second)
+
+	segments, err := walsegments(index.logdir(""))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(segments) != 1 {
+		t.Errorf("unexpected %v", segments)
+	} else if x, _ := walsegmentseqno(segments[0]); x != seqno+1 {
+		t.Errorf("expected %v, got %v", seqno+1, x)
+	}
+
+	index.Close()
+	index.Destroy()
+}
+
+// TestWALCrash kill the writer at random points, and verify that no
+// acknowledged write is lost after recovery.
+func TestWALCrash(t *testing.T) {
+	destoryindex("crashindex", makepaths())
+
+	setts, paths := makesettings(), makepaths()
+	setts["bubt.diskpaths"] = paths
+	setts["durable"] = true
+
+	for round, logsync := range []string{"none", "group", "write", "none"} {
+		acked := walcrashround(t, round, logsync)
+		t.Logf("round %v logsync:%v %v acked", round, logsync, len(acked))
+
+		setts["logsync"] = logsync
+		index, err := New("crashindex", setts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		index.Start()
+		for key, val := range acked {
+			value, _, deleted, ok := index.Get([]byte(key), []byte{})
+			if len(val) == 0 {
+				if ok && !deleted {
+					t.Errorf("round %v unexpected key %q", round, key)
+				}
+			} else if ok == false || deleted {
+				t.Errorf("round %v missing key %q", round, key)
+			} else if string(value) != val {
+				t.Errorf("round %v expected %q, got %q", round, val, value)
+			}
+		}
+		index.Close()
+	}
+
+	destoryindex("crashindex", makepaths())
+}
+
+func walcrashround(
+	t *testing.T, round int, logsync string) map[string]string {
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestWALCrashWriter$")
+	cmd.Env = append(
+		os.Environ(),
+		fmt.Sprintf("BOGN_WALCRASH=%v:%v", round, logsync),
+	)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(time.Duration(1000+rand.Intn(2000)) * time.Millisecond)
+		cmd.Process.Kill()
+	}()
+
+	// key -> value, empty value for deleted key.
+	acked := map[string]string{}
+	r := bufio.NewReader(stdout)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil { // last line could be partial.
+			break
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 3 || fields[0] != "walcrash" {
+			continue
+		}
+		switch fields[1] {
+		case "try": // not yet acknowledged.
+			delete(acked, fields[2])
+		case "set":
+			acked[fields[2]] = fields[3]
+		case "del":
+			acked[fields[2]] = ""
+		}
+	}
+	cmd.Wait()
+	return acked
+}
+
+// TestWALCrashWriter is run as a child process by TestWALCrash.
+func TestWALCrashWriter(t *testing.T) {
+	env := os.Getenv("BOGN_WALCRASH")
+	if env == "" {
+		t.Skip("run by TestWALCrash")
+	}
+	parts := strings.Split(env, ":")
+	round, logsync := parts[0], parts[1]
+
+	setts, paths := makesettings(), makepaths()
+	setts["bubt.diskpaths"] = paths
+	setts["durable"] = true
+	setts["autocommit"] = 1
+	setts["logsync"] = logsync
+	setts["logsegsize"] = 64 * 1024
+	index, err := New("crashindex", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+
+	for i := 0; ; i++ {
+		key := fmt.Sprintf("key-%v-%v", round, i)
+		val := fmt.Sprintf("val-%v-%v", round, i)
+		fmt.Printf("walcrash try %v\n", key)
+		index.Set([]byte(key), []byte(val), nil)
+		fmt.Printf("walcrash set %v %v\n", key, val)
+		if i%7 == 0 {
+			fmt.Printf("walcrash try %v\n", key)
+			index.Delete([]byte(key), nil, i%2 == 0 /*lsm*/)
+			fmt.Printf("walcrash del %v\n", key)
+		}
+	}
+}
+
+func TestWALReplayExpiry(t *testing.T) {
+	destoryindex("index", makepaths())
+
+	setts, paths := makesettings(), makepaths()
+	setts["bubt.diskpaths"] = paths
+	setts["durable"] = true
+	index, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+	logdir, seqno := index.logdir(""), index.Getseqno()
+	index.Close()
+
+	// log mutations with expiry, without flushing them to disk.
+	w, err := openwal(index.logprefix, logdir, seqno+1, "write", 0, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().Unix()
+	w.lock()
+	w.logsetexpiry([]byte("key1"), []byte("val1"), now-10, seqno+1)
+	w.logsetexpiry([]byte("key2"), []byte("val2"), now+3600, seqno+2)
+	w.unlock()
+	w.close()
+
+	index, err = New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+
+	if x := index.Getseqno(); x != seqno+2 {
+		t.Errorf("expected %v, got %v", seqno+2, x)
+	}
+	if _, _, deleted, ok := index.Get([]byte("key1"), nil); ok && !deleted {
+		t.Errorf("unexpected key1")
+	}
+	value, _, deleted, ok := index.Get([]byte("key2"), []byte{})
+	if ok == false || deleted {
+		t.Errorf("missing key2")
+	} else if string(value) != "val2" {
+		t.Errorf("expected %q, got %q", "val2", value)
+	}
+
+	index.Close()
+	index.Destroy()
+}
+
+func TestWALReplayMerge(t *testing.T) {
+	destoryindex("index", makepaths())
+
+	setts, paths := makesettings(), makepaths()
+	setts["bubt.diskpaths"] = paths
+	setts["durable"] = true
+	setts["mergeoperator"] = addmerge
+	index, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+	index.Set([]byte("key1"), []byte("10"), nil)
+	logdir, seqno := index.logdir(""), index.Getseqno()
+	index.Close()
+
+	// log merge operands, without flushing them to disk.
+	w, err := openwal(index.logprefix, logdir, seqno+1, "write", 0, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.lock()
+	w.logmerge([]byte("key1"), []byte("5"), seqno+1)
+	w.logmerge([]byte("key2"), []byte("7"), seqno+2)
+	w.unlock()
+	w.close()
+
+	index, err = New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+
+	if x := index.Getseqno(); x != seqno+2 {
+		t.Errorf("expected %v, got %v", seqno+2, x)
+	}
+	refs := map[string]string{"key1": "15", "key2": "7"}
+	for key, ref := range refs {
+		value, _, deleted, ok := index.Get([]byte(key), []byte{})
+		if ok == false || deleted {
+			t.Errorf("missing %s", key)
+		} else if string(value) != ref {
+			t.Errorf("expected %q, got %q", ref, value)
+		}
+	}
+
+	index.Close()
+	index.Destroy()
+}
@@ -0,0 +1,179 @@
+package bogn
+
+import "sync/atomic"
+
+import "github.com/bnclabs/gostore/api"
+import "github.com/bnclabs/gostore/lib"
+
+// WriteBatch accumulates Set, SetCAS and Delete operations and apply
+// them atomically on the index. Unlike Txn, write batch does not
+// open views on read store, cache store and disk levels, hence it is
+// cheaper to use when there is no read involved. Use NewWriteBatch on
+// Bogn object to create a new batch.
+type WriteBatch struct {
+	bogn   *Bogn
+	writes []txnwrite
+	cases  []batchcas
+	gets   []api.Getter
+}
+
+// batchcas remembers SetCAS operations within a batch, to be matched
+// before applying the batch.
+type batchcas struct {
+	off int // offset into writes.
+	cas uint64
+}
+
+// NewWriteBatch create a new batch of write operations on this index.
+func (bogn *Bogn) NewWriteBatch() *WriteBatch {
+	return &WriteBatch{
+		bogn:   bogn,
+		writes: make([]txnwrite, 0, 16),
+		cases:  make([]batchcas, 0),
+		gets:   make([]api.Getter, 0, 32),
+	}
+}
+
+// Count return number of write operations in this batch.
+func (batch *WriteBatch) Count() int {
+	return len(batch.writes)
+}
+
+// Set a key, value pair in the batch.
+func (batch *WriteBatch) Set(key, value []byte) *WriteBatch {
+	batch.addwrite(walSet, key, value)
+	return batch
+}
+
+// SetCAS a key, value pair in the batch. If CAS is ZERO then key
+// should not be present in the index, otherwise existing CAS should
+// match the supplied CAS. CAS is matched against the index as it was
+// before applying the batch.
+func (batch *WriteBatch) SetCAS(key, value []byte, cas uint64) *WriteBatch {
+	off := len(batch.writes)
+	batch.addwrite(walSet, key, value)
+	batch.cases = append(batch.cases, batchcas{off: off, cas: cas})
+	return batch
+}
+
+// Delete key in the batch. If lsm is true, then key will be marked as
+// deleted instead of removing it from the index.
+func (batch *WriteBatch) Delete(key []byte, lsm bool) *WriteBatch {
+	if lsm {
+		batch.addwrite(walDeletelsm, key, nil)
+	} else {
+		batch.addwrite(walDelete, key, nil)
+	}
+	return batch
+}
+
+// Reset the batch, so that it can be reused for a new set of write
+// operations.
+func (batch *WriteBatch) Reset() *WriteBatch {
+	batch.writes, batch.cases = batch.writes[:0], batch.cases[:0]
+	return batch
+}
+
+// Apply all write operations in the batch, in the order they were
+// added, on the index. Either all operations are applied under a
+// contiguous range of seqno, or none of them are applied, in which
+// case, api.ErrorInvalidCAS is returned if any of the SetCAS did not
+// match. If index is durable, the batch is logged as a single record.
+// Writers are stalled like Set, and api.ErrorStall is returned if
+// stalls are not blocking. On success, batch is reset.
+func (batch *WriteBatch) Apply() error {
+	var ticket int64
+
+	if len(batch.writes) == 0 {
+		return nil
+	}
+
+	bogn := batch.bogn
+	if bogn.isreadonly() {
+		return api.ErrorReadonly
+	}
+	if err := bogn.stallwrite(bogn.stallblock); err != nil {
+		atomic.AddInt64(&bogn.nstallerrors, 1)
+		return err
+	}
+	bogn.snaprlock()
+
+	lsm := atomic.LoadInt64(&bogn.dgmstate) == 1 // auto-enable lsm in dgm
+
+	// to preserve the lock order, between log and mw, acquire the log
+	// lock ahead.
+	wal := bogn.wal
+	if wal != nil {
+		bogn.walock()
+	}
+	snap := bogn.currsnapshot()
+	mwtxn := snap.mw.BeginTxn(0xBA7C)
+	if err := batch.matchcas(snap, mwtxn); err != nil {
+		mwtxn.Abort()
+		if wal != nil {
+			bogn.walunlock()
+		}
+		bogn.snaprunlock()
+		return err
+	}
+	for i, w := range batch.writes {
+		switch w.cmd {
+		case walSet:
+			mwtxn.Set(w.key, w.value, nil)
+		case walDelete:
+			if lsm {
+				batch.writes[i].cmd = walDeletelsm
+			}
+			mwtxn.Delete(w.key, nil, lsm)
+		case walDeletelsm:
+			mwtxn.Delete(w.key, nil, true /*lsm*/)
+		}
+	}
+	err := mwtxn.Commit()
+	if wal != nil {
+		if err == nil {
+			ticket, err = logwrites(wal, snap, batch.writes)
+		}
+		bogn.walunlock()
+	}
+	bogn.snaprunlock()
+	if err = bogn.waitsync(ticket, err); err == nil {
+		batch.Reset()
+	}
+	return err
+}
+
+//---- local methods
+
+func (batch *WriteBatch) addwrite(cmd byte, key, value []byte) {
+	var w txnwrite
+	if n := len(batch.writes); n < cap(batch.writes) {
+		w = batch.writes[:n+1][n]
+	}
+	w.cmd = cmd
+	w.key = lib.Fixbuffer(w.key, int64(len(key)))
+	copy(w.key, key)
+	w.value = lib.Fixbuffer(w.value, int64(len(value)))
+	copy(w.value, value)
+	batch.writes = append(batch.writes, w)
+}
+
+// matchcas for all SetCAS operations, before any write is applied on
+// the mw transaction.
+func (batch *WriteBatch) matchcas(
+	snap *snapshot, mwtxn api.Transactor) error {
+
+	if len(batch.cases) == 0 {
+		return nil
+	}
+	yget := snap.txnyget(mwtxn, batch.gets[:0])
+	for _, c := range batch.cases {
+		_, gcas, deleted, ok := yget(batch.writes[c.off].key, nil)
+		ok1 := (ok && deleted == false) && gcas != c.cas
+		ok2 := (ok == false || deleted) && c.cas != 0
+		if ok1 || ok2 {
+			return api.ErrorInvalidCAS
+		}
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package bubt
+
+import "io"
+import "os"
+import "fmt"
+import "encoding/binary"
+
+// bloomfilter on keys indexed in a snapshot, used to short-circuit
+// Get for keys that are not present in the snapshot.
+type bloomfilter struct {
+	nhash uint32
+	nbits uint64
+	bits  []byte
+}
+
+// newbloom create a bloom filter for nkeys with bitsperkey, number
+// of hash functions is picked to minimize the false positive rate.
+func newbloom(nkeys, bitsperkey int64) *bloomfilter {
+	nhash := uint32(float64(bitsperkey) * 0.69) // ln(2)
+	if nhash < 1 {
+		nhash = 1
+	} else if nhash > 30 {
+		nhash = 30
+	}
+	nbits := uint64(nkeys * bitsperkey)
+	if nbits < 64 {
+		nbits = 64
+	}
+	nbits = ((nbits + 7) / 8) * 8
+	return &bloomfilter{
+		nhash: nhash, nbits: nbits, bits: make([]byte, nbits/8),
+	}
+}
+
+// bloomhash compute 64-bit FNV-1a hash of key, finalized with murmur3
+// mixer. Lower and upper 32-bits are used for double hashing.
+func bloomhash(key []byte) uint64 {
+	hash := uint64(14695981039346656037)
+	for _, c := range key {
+		hash ^= uint64(c)
+		hash *= 1099511628211
+	}
+	hash ^= hash >> 33
+	hash *= 0xff51afd7ed558ccd
+	hash ^= hash >> 33
+	hash *= 0xc4ceb9fe1a85ec53
+	hash ^= hash >> 33
+	return hash
+}
+
+func (bf *bloomfilter) addhash(hash uint64) {
+	h1, h2 := hash&0xFFFFFFFF, hash>>32
+	for i := uint64(0); i < uint64(bf.nhash); i++ {
+		bit := (h1 + (i * h2)) % bf.nbits
+		bf.bits[bit/8] |= 1 << (bit % 8)
+	}
+}
+
+// contains return false if key is definitely not present.
+func (bf *bloomfilter) contains(key []byte) bool {
+	hash := bloomhash(key)
+	h1, h2 := hash&0xFFFFFFFF, hash>>32
+	for i := uint64(0); i < uint64(bf.nhash); i++ {
+		bit := (h1 + (i * h2)) % bf.nbits
+		if (bf.bits[bit/8] & (1 << (bit % 8))) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// encode bloom filter as,
+// | 8-byte length | 4-byte nhash | bits ... |
+// padded to blocksize.
+func (bf *bloomfilter) encode(blocksize int64) []byte {
+	ln := int64(8 + 4 + len(bf.bits))
+	ln = (((ln - 1) / blocksize) + 1) * blocksize
+	block := make([]byte, ln)
+	binary.BigEndian.PutUint64(block, uint64(4+len(bf.bits)))
+	binary.BigEndian.PutUint32(block[8:], bf.nhash)
+	copy(block[12:], bf.bits)
+	return block
+}
+
+func decodebloom(block []byte) (*bloomfilter, error) {
+	if len(block) < 12 {
+		return nil, fmt.Errorf("bubt.snap.partialbloom")
+	}
+	ln := binary.BigEndian.Uint64(block)
+	if ln < 4 || uint64(len(block)) < 8+ln {
+		return nil, fmt.Errorf("bubt.snap.invalidbloom")
+	}
+	bf := &bloomfilter{nhash: binary.BigEndian.Uint32(block[8:])}
+	bf.bits = block[12 : 8+ln]
+	if bf.nbits = uint64(len(bf.bits)) * 8; bf.nbits == 0 {
+		return nil, fmt.Errorf("bubt.snap.invalidbloom")
+	}
+	return bf, nil
+}
+
+// bloomspillsize is the number of key hashes held in memory while
+// building the bloom filter, beyond which they are spilled to disk.
+const bloomspillsize = 64 * 1024
+
+// bloomspill gather hashes of keys, while streaming entries in Build,
+// so that bloom filter can be sized on the exact number of keys. Memory
+// is bounded by spilling hashes to a temporary file.
+type bloomspill struct {
+	file   string
+	fd     *os.File
+	nkeys  int64
+	hashes []uint64
+	buf    []byte
+}
+
+func newbloomspill(file string) *bloomspill {
+	return &bloomspill{
+		file:   file,
+		hashes: make([]uint64, 0, bloomspillsize),
+		buf:    make([]byte, bloomspillsize*8),
+	}
+}
+
+func (bs *bloomspill) add(hash uint64) error {
+	bs.hashes = append(bs.hashes, hash)
+	bs.nkeys++
+	if len(bs.hashes) < bloomspillsize {
+		return nil
+	}
+	return bs.spill()
+}
+
+func (bs *bloomspill) spill() (err error) {
+	if bs.fd == nil {
+		flags := os.O_RDWR | os.O_CREATE | os.O_TRUNC
+		if bs.fd, err = os.OpenFile(bs.file, flags, 0660); err != nil {
+			return err
+		}
+	}
+	buf := bs.buf[:len(bs.hashes)*8]
+	for i, hash := range bs.hashes {
+		binary.BigEndian.PutUint64(buf[i*8:], hash)
+	}
+	if _, err = bs.fd.Write(buf); err != nil {
+		return err
+	}
+	bs.hashes = bs.hashes[:0]
+	return nil
+}
+
+// bloom create a bloom filter from gathered hashes.
+func (bs *bloomspill) bloom(bitsperkey int64) (*bloomfilter, error) {
+	bf := newbloom(bs.nkeys, bitsperkey)
+	if bs.fd != nil {
+		if _, err := bs.fd.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		for {
+			n, err := io.ReadFull(bs.fd, bs.buf)
+			for off := 0; off+8 <= n; off += 8 {
+				bf.addhash(binary.BigEndian.Uint64(bs.buf[off:]))
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, hash := range bs.hashes {
+		bf.addhash(hash)
+	}
+	return bf, nil
+}
+
+// close and remove spilled hashes, if any.
+func (bs *bloomspill) close() {
+	if bs.fd != nil {
+		bs.fd.Close()
+		os.Remove(bs.file)
+		bs.fd = nil
+	}
+}
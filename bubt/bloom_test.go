@@ -0,0 +1,77 @@
+package bubt
+
+import "os"
+import "fmt"
+import "testing"
+import "path/filepath"
+
+func TestBloomFilter(t *testing.T) {
+	n, bitsperkey := 100000, int64(10)
+	bf := newbloom(int64(n), bitsperkey)
+	for i := 0; i < n; i++ {
+		bf.addhash(bloomhash([]byte(fmt.Sprintf("key%v", i))))
+	}
+
+	block := bf.encode(4096)
+	if x := int64(len(block)); (x % 4096) != 0 {
+		t.Errorf("unexpected block size %v", x)
+	}
+	bf, err := decodebloom(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < n; i++ {
+		if key := []byte(fmt.Sprintf("key%v", i)); bf.contains(key) == false {
+			t.Fatalf("missing key %q", key)
+		}
+	}
+	falsepositive := 0
+	for i := 0; i < n; i++ {
+		if bf.contains([]byte(fmt.Sprintf("miss%v", i))) {
+			falsepositive++
+		}
+	}
+	// ~1% for 10 bits per key.
+	if rate := float64(falsepositive) / float64(n); rate > 0.02 {
+		t.Errorf("false positive rate %v", rate)
+	}
+
+	if _, err := decodebloom(block[:8]); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestBloomSpill(t *testing.T) {
+	file := filepath.Join(os.TempDir(), "bubt-bloom.spill")
+	os.Remove(file)
+
+	n, bitsperkey := (2*bloomspillsize)+10, int64(10)
+	bs := newbloomspill(file)
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key%v", i))
+		if err := bs.add(bloomhash(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(bs.hashes) != 10 {
+		t.Errorf("expected %v, got %v", 10, len(bs.hashes))
+	}
+	bf, err := bs.bloom(bitsperkey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs.close()
+	if _, err := os.Stat(file); err == nil {
+		t.Errorf("expected %q to be removed", file)
+	}
+
+	if ref := newbloom(int64(n), bitsperkey); ref.nbits != bf.nbits {
+		t.Errorf("expected %v, got %v", ref.nbits, bf.nbits)
+	}
+	for i := 0; i < n; i++ {
+		if key := []byte(fmt.Sprintf("key%v", i)); bf.contains(key) == false {
+			t.Fatalf("missing key %q", key)
+		}
+	}
+}
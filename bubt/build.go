@@ -25,6 +25,7 @@ var metadataMarker = []byte("wawaltreatment")
 type Bubt struct {
 	name       string
 	tombpurge  bool
+	exprpurge  bool
 	mflusher   *bubtflusher
 	zflushers  []*bubtflusher
 	vflushers  []*bubtflusher
@@ -34,6 +35,8 @@ type Bubt struct {
 	vmode      string
 	appendid   string
 	mdok       bool
+	bloombits  int64
+	limiter    *Ratelimiter
 
 	// settings, will be flushed to the tip of indexfile.
 	mblocksize int64
@@ -62,6 +65,7 @@ func NewBubt(
 		zblocksize: zblocksize,
 		vblocksize: vblocksize,
 		tombpurge:  false,
+		exprpurge:  false,
 		mdok:       false,
 	}
 	mpath, zpaths := tree.pickmzpath(paths)
@@ -97,6 +101,14 @@ func (tree *Bubt) TombstonePurge(what bool) {
 	tree.tombpurge = what
 }
 
+// ExpiryPurge to enable or disable purging expired entries while
+// Building a bubt instance from an iterator. When disabled, expired
+// entries are retained as tombstones, so that older versions of the
+// same key, say in other levels of an lsm, remain shadowed.
+func (tree *Bubt) ExpiryPurge(what bool) {
+	tree.exprpurge = what
+}
+
 // AppendValuelogs builder should use `valuelogs` files instead of
 // creating a new set of value-logs corresponding to each z-index
 // files, vblocksize should be same as used while creating `valuelogs`.
@@ -130,6 +142,30 @@ func (tree *Bubt) AppendValuelogs(vblocksize int64, appendid string, valuelogs [
 	tree.vmode = "appendlink"
 }
 
+// BloomFilter to build a bloom filter, with bitsperkey, on all keys
+// indexed by this btree. Bloom filter is persisted along with the
+// btree and used by snapshot to short-circuit Get on missing keys.
+// While building, hashes of keys are spilled to a temporary file to
+// keep memory bounded. If bitsperkey is ZERO, which is the default,
+// no bloom filter is built.
+func (tree *Bubt) BloomFilter(bitsperkey int64) {
+	if bitsperkey < 0 {
+		bitsperkey = 0
+	}
+	tree.bloombits = bitsperkey
+}
+
+// Ratelimit to throttle writes to disk, blocks written by this builder
+// shall be accounted against limiter. Pass nil, which is the default,
+// to write without throttling.
+func (tree *Bubt) Ratelimit(limiter *Ratelimiter) {
+	tree.limiter = limiter
+	tree.mflusher.limiter = limiter
+	for _, zflusher := range tree.zflushers {
+		zflusher.limiter = limiter
+	}
+}
+
 func (tree *Bubt) makezflushers(zpaths []string) []*bubtflusher {
 	zflushers := make([]*bubtflusher, 0)
 	for idx, zpath := range zpaths {
@@ -156,6 +192,7 @@ func (tree *Bubt) makevflushers(vfiles []string) ([]*bubtflusher, uint64) {
 		if err != nil {
 			panic(err)
 		}
+		vflusher.limiter = tree.limiter
 		vflushers = append(vflushers, vflusher)
 		fsize := filesize(vfile)
 		if fsize > 0 {
@@ -175,20 +212,43 @@ func (tree *Bubt) Build(itere api.EntryIterator, metadata []byte) (err error) {
 	debugf("%v starting bottoms up build ...\n", tree.logprefix)
 
 	var n_ablocks uint64
+	var hashes *bloomspill // of keys, for bloom filter.
 
 	tree.vflushers, n_ablocks = tree.makevflushers(tree.vfiles)
+	if tree.bloombits > 0 {
+		dir := filepath.Dir(tree.mflusher.file)
+		hashes = newbloomspill(filepath.Join(dir, "bubt-bloom.spill"))
+		defer hashes.close()
+	}
 
 	start := time.Now()
+	now := start.Unix() // to check for expired entries.
 	maxseqno, keymem, valmem := uint64(0), uint64(0), uint64(0)
 	n_count, n_deleted, paddingmem := int64(0), int64(0), int64(0)
 	n_zblocks, n_mblocks, n_vblocks := int64(0), uint64(0), n_ablocks
+
+	// ispurged return true if entry shall not be indexed.
+	ispurged := func(del bool, expiry int64) bool {
+		if tree.tombpurge && del {
+			return true
+		}
+		return tree.exprpurge && expiry > 0 && expiry <= now
+	}
+
 	compiter := func(
 		fin bool) (key, val []byte,
-		valuelen uint64, vlogpos int64, seqno uint64, del bool, e error) {
+		valuelen uint64, vlogpos int64, seqno uint64, del bool,
+		expiry int64, e error) {
 
 		entry := itere(fin)
 		key, seqno, del, e = entry.Key()
-		if len(tree.appendid) > 0 && entry.ID() == tree.appendid {
+		if del == false {
+			expiry = entry.Expiry()
+		}
+		if expiry > 0 && expiry <= now && tree.exprpurge == false {
+			// retain expired entry as tombstone.
+			val, valuelen, vlogpos, del, expiry = nil, 0, -1, true, 0
+		} else if len(tree.appendid) > 0 && entry.ID() == tree.appendid {
 			val = nil
 			valuelen, vlogpos = entry.Valueref()
 		} else {
@@ -201,9 +261,9 @@ func (tree *Bubt) Build(itere api.EntryIterator, metadata []byte) (err error) {
 			if maxseqno < seqno {
 				maxseqno = seqno
 			}
-			if tree.tombpurge && del { // skip accounting for deleted entries
+			if ispurged(del, expiry) { // skip accounting for purged entries
 				// wish there is tail-recursion !!
-				return key, val, valuelen, vlogpos, seqno, del, e
+				return key, val, valuelen, vlogpos, seqno, del, expiry, e
 			}
 			// account everything else for non-deleted entries.
 			keymem = keymem + uint64(len(key))
@@ -213,8 +273,13 @@ func (tree *Bubt) Build(itere api.EntryIterator, metadata []byte) (err error) {
 				valmem += valuelen
 			}
 			n_count++
+			if hashes != nil {
+				if err := hashes.add(bloomhash(key)); err != nil {
+					panic(err)
+				}
+			}
 		}
-		return key, val, valuelen, vlogpos, seqno, del, e
+		return key, val, valuelen, vlogpos, seqno, del, expiry, e
 	}
 
 	scratchvlog := make([]byte, tree.vblocksize)
@@ -313,6 +378,7 @@ func (tree *Bubt) Build(itere api.EntryIterator, metadata []byte) (err error) {
 	var vlogpos int64
 	var seqno uint64
 	var deleted bool
+	var expiry int64
 
 	buildz := func() {
 		if len(key) == 0 {
@@ -320,21 +386,26 @@ func (tree *Bubt) Build(itere api.EntryIterator, metadata []byte) (err error) {
 		}
 
 		ok := true
-		if (tree.tombpurge && deleted == false) || tree.tombpurge == false {
-			ok = z.insert(key, value, valuelen, vlogpos, seqno, deleted)
+		if ispurged(deleted, expiry) == false {
+			ok = z.insert(
+				key, value, valuelen, vlogpos, seqno, deleted, expiry,
+			)
 			if ok == false {
 				panic("first insert to zblock, check whether key > zblocksize")
 			}
 		}
 		for ok {
-			key, value, valuelen, vlogpos, seqno, deleted, err = compiter(false)
+			key, value, valuelen, vlogpos, seqno, deleted, expiry, err =
+				compiter(false)
 			if err == io.EOF {
 				break
 			} else if err != nil {
 				panic(err)
 			}
-			if (tree.tombpurge && deleted == false) || tree.tombpurge == false {
-				ok = z.insert(key, value, valuelen, vlogpos, seqno, deleted)
+			if ispurged(deleted, expiry) == false {
+				ok = z.insert(
+					key, value, valuelen, vlogpos, seqno, deleted, expiry,
+				)
 			}
 		}
 		return
@@ -415,7 +486,8 @@ func (tree *Bubt) Build(itere api.EntryIterator, metadata []byte) (err error) {
 	// start building the tree, with maximum fill possible rate.
 	var root int64
 	if itere != nil {
-		key, value, valuelen, vlogpos, seqno, deleted, err = compiter(false)
+		key, value, valuelen, vlogpos, seqno, deleted, expiry, err =
+			compiter(false)
 		if err != nil && err.Error() != io.EOF.Error() {
 			panic(err)
 
@@ -451,6 +523,20 @@ func (tree *Bubt) Build(itere api.EntryIterator, metadata []byte) (err error) {
 		vflusher.vlog = vflusher.vlog[:0]
 	}
 
+	// flush 1 or more m-blocks of bloom filter, if enabled.
+	bloomsize := int64(0)
+	if hashes != nil && hashes.nkeys > 0 {
+		bf, err := hashes.bloom(tree.bloombits)
+		if err != nil {
+			panic(err)
+		}
+		block := bf.encode(tree.mblocksize)
+		if err := tree.mflusher.writedata(block); err != nil {
+			panic(err)
+		}
+		bloomsize = int64(len(block))
+	}
+
 	// flush 1 MarkerBlocksize of infoblock
 	block := make([]byte, MarkerBlocksize)
 	infoblock := s.Settings{
@@ -471,6 +557,7 @@ func (tree *Bubt) Build(itere api.EntryIterator, metadata []byte) (err error) {
 		"n_ablocks":  fmt.Sprintf("%d", n_ablocks),
 		"n_count":    fmt.Sprintf("%d", n_count),
 		"n_deleted":  fmt.Sprintf("%d", n_deleted),
+		"bloomsize":  fmt.Sprintf("%d", bloomsize),
 	}
 	data, _ := json.Marshal(infoblock)
 	if x, y := len(data)+8, len(block); x > y {
@@ -493,8 +580,9 @@ func (tree *Bubt) Build(itere api.EntryIterator, metadata []byte) (err error) {
 		tree.mdok = true
 	}
 
-	fmsg := "%v built with root@%v %v bytes infoblock %v bytes metadata"
-	infof(fmsg, tree.logprefix, root, infoblkn, lenMetadata)
+	fmsg := "%v built with root@%v %v bytes bloom %v bytes infoblock " +
+		"%v bytes metadata"
+	infof(fmsg, tree.logprefix, root, bloomsize, infoblkn, lenMetadata)
 	return nil
 }
 
@@ -327,6 +327,101 @@ func TestTombstonePurge(t *testing.T) {
 	miter(true /*fin*/)
 }
 
+func TestExpiryPurge(t *testing.T) {
+	n := 10000
+	paths := makepaths123(-1)
+	setts := s.Settings{"memcapacity": 1024 * 1024 * 1024}
+	mi := llrb.NewLLRB("buildllrb", setts)
+	defer mi.Destroy()
+
+	now := time.Now().Unix()
+	past, future := now-10, now+3600
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key%015d", i))
+		val := []byte(fmt.Sprintf("val%015d", i))
+		switch i % 3 {
+		case 0:
+			mi.SetExpiry(key, val, nil, past)
+		case 1:
+			mi.SetExpiry(key, val, nil, future)
+		default:
+			mi.Set(key, val, nil)
+		}
+	}
+
+	t.Logf("paths %v, entries: %v", paths, n)
+
+	rand.Seed(time.Now().UnixNano())
+	for _, purge := range []bool{false, true} {
+		name, msize := "testbuild", int64(4096)
+		zsize := []int64{0, msize, msize * 2}[rand.Intn(100000)%3]
+		vsize := []int64{0, zsize, zsize * 2}[rand.Intn(100000)%3]
+		mmap := []bool{false, true}[rand.Intn(10000)%2]
+		fmsg := "purge: %v, zsize: %v, vsize: %v, mmap: %v"
+		t.Logf(fmsg, purge, zsize, vsize, mmap)
+		bubt, err := NewBubt(name, paths, msize, zsize, vsize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bubt.ExpiryPurge(purge)
+		mitere := mi.ScanEntries()
+		if err := bubt.Build(mitere, []byte("this is metadata")); err != nil {
+			t.Fatal(err)
+		}
+		mitere(true /*fin*/)
+		bubt.Close()
+
+		snap, err := OpenSnapshot(name, paths, mmap)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		count := int64(n)
+		if purge {
+			count = int64(n - ((n + 2) / 3))
+		}
+		if x := snap.Count(); x != count {
+			t.Errorf("expected %v, got %v", count, x)
+		}
+		for i := 0; i < n; i++ {
+			key := []byte(fmt.Sprintf("key%015d", i))
+			val := []byte(fmt.Sprintf("val%015d", i))
+			v, _, d, ok := snap.Get(key, []byte{})
+			if i%3 == 0 && purge && ok {
+				t.Errorf("%s unexpected key", key)
+			} else if i%3 == 0 && !purge && (ok == false || d == false) {
+				t.Errorf("%s expected tombstone, got %v %v", key, ok, d)
+			} else if i%3 != 0 && (ok == false || d) {
+				t.Errorf("%s unexpected %v %v", key, ok, d)
+			} else if i%3 != 0 && bytes.Compare(v, val) != 0 {
+				t.Errorf("%s expected %q, got %q", key, val, v)
+			}
+		}
+		// expiry should survive the disk format.
+		itere := snap.ScanEntries()
+		for entry := itere(false); ; entry = itere(false) {
+			key, _, deleted, err := entry.Key()
+			if err != nil {
+				break
+			}
+			var i int
+			fmt.Sscanf(string(key), "key%d", &i)
+			expiry := entry.Expiry()
+			if i%3 == 1 && expiry != future {
+				t.Errorf("%s expected %v, got %v", key, future, expiry)
+			} else if i%3 != 1 && expiry != 0 {
+				t.Errorf("%s unexpected expiry %v", key, expiry)
+			} else if i%3 == 0 && deleted == false {
+				t.Errorf("%s expected tombstone", key)
+			}
+		}
+		itere(true /*fin*/)
+
+		snap.Close()
+		snap.Destroy()
+	}
+}
+
 func TestSnapshotScanM1(t *testing.T) {
 	n := 1000000
 	paths := makepaths1()
@@ -1261,6 +1356,96 @@ func TestCursorYNext1(t *testing.T) {
 	miter(true /*fin*/)
 }
 
+func TestCursorGetPrev(t *testing.T) {
+	n, paths := 10000, makepaths123(-1)
+	mi, _, _ := makeLLRB(n)
+	defer mi.Destroy()
+
+	rand.Seed(time.Now().UnixNano())
+	name, msize := "testbuild", int64(4096)
+	zsize := []int64{0, msize, msize * 2}[rand.Intn(100000)%3]
+	mmap := []bool{false, true}[rand.Intn(10000)%2]
+	t.Logf("paths: %v, zsize: %v, mmap: %v", len(paths), zsize, mmap)
+	bubt, err := NewBubt(name, paths, msize, zsize, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mitere := mi.ScanEntries()
+	if err := bubt.Build(mitere, []byte("this is metadata")); err != nil {
+		t.Fatal(err)
+	}
+	mitere(true /*fin*/)
+	bubt.Close()
+
+	snap, err := OpenSnapshot(name, paths, mmap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Destroy()
+	defer snap.Close()
+
+	// walk both cursors, back and forth, and compare.
+	walk := func(key []byte, moves []bool) {
+		dview, mview := snap.View(0), mi.View(0)
+		defer dview.Abort()
+		defer mview.Abort()
+
+		mcur, _ := mview.OpenCursor(key)
+		dcur, err1 := dview.OpenCursor(key)
+		if err1 != nil {
+			t.Fatal(err1)
+		}
+		var k1, v1, k2, v2 []byte
+		var s1, s2 uint64
+		var d1, d2 bool
+		var err3, err4 error
+		for i, prev := range moves {
+			if prev {
+				k1, v1, s1, d1, err3 = mcur.YPrev(false /*fin*/)
+				k2, v2, s2, d2, err4 = dcur.YPrev(false /*fin*/)
+			} else {
+				k1, v1, s1, d1, err3 = mcur.YNext(false /*fin*/)
+				k2, v2, s2, d2, err4 = dcur.YNext(false /*fin*/)
+			}
+			if err3 != err4 {
+				t.Fatalf("%s:%v expected %v, got %v", key, i, err3, err4)
+			} else if bytes.Compare(k1, k2) != 0 {
+				t.Fatalf("%s:%v expected %q, got %q", key, i, k1, k2)
+			} else if s1 != s2 {
+				t.Fatalf("%s:%v expected %v, got %v", key, i, s1, s2)
+			} else if d1 != d2 {
+				t.Fatalf("%s:%v expected %v, got %v", key, i, d1, d2)
+			} else if d1 == false && bytes.Compare(v1, v2) != 0 {
+				t.Fatalf("%s:%v expected %q, got %q", key, i, v1, v2)
+			}
+		}
+	}
+
+	// full table scan, forward and then backward.
+	moves := make([]bool, 0, 4*n)
+	for i := 0; i < n+2; i++ {
+		moves = append(moves, false)
+	}
+	for i := 0; i < n+2; i++ {
+		moves = append(moves, true)
+	}
+	walk(nil, append(moves, false, false, true, false))
+
+	miter := mi.Scan()
+	key, _, _, _, err := miter(false /*fin*/)
+	for i := 0; err == nil; i++ {
+		if i%100 == 0 {
+			moves = moves[:0]
+			for j := 0; j < 1000; j++ {
+				moves = append(moves, rand.Intn(3) == 0)
+			}
+			walk(key, moves)
+		}
+		key, _, _, _, err = miter(false /*fin*/)
+	}
+	miter(true /*fin*/)
+}
+
 func TestCursorYNext2(t *testing.T) {
 	n, paths := 10000, makepaths123(-1)
 	mi, _, _ := makeLLRB(n)
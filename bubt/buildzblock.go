@@ -55,12 +55,15 @@ func (z *zblock) reset(vlogpos int64, vlog []byte) *zblock {
 
 func (z *zblock) insert(
 	key, value []byte, valuelen uint64, vlogpos int64,
-	seqno uint64, deleted bool) bool {
+	seqno uint64, deleted bool, expiry int64) bool {
 
 	//fmt.Println(len(key), len(value), z.zblocksize)
 	if key == nil {
 		return false
-	} else if z.isoverflow(key, value, deleted) {
+	} else if deleted {
+		expiry = 0 // tombstones never expire.
+	}
+	if z.isoverflow(key, value, deleted, expiry) {
 		return false
 	}
 
@@ -69,6 +72,9 @@ func (z *zblock) insert(
 	var scratch [24]byte
 	ze := zentry(scratch[:])
 	ze = ze.setseqno(seqno).setkeylen(uint64(len(key)))
+	if expiry > 0 {
+		ze.setexpiry()
+	}
 
 	if deleted {
 		ze.setdeleted().setvaluelen(0)
@@ -109,6 +115,10 @@ func (z *zblock) insert(
 			z.entries = append(z.entries, scratch[:8]...)
 		}
 	}
+	if expiry > 0 {
+		binary.BigEndian.PutUint64(scratch[:8], uint64(expiry))
+		z.entries = append(z.entries, scratch[:8]...)
+	}
 
 	z.setfirstkey(key)
 
@@ -141,8 +151,13 @@ func (z *zblock) finalize() (int64, bool) {
 
 //---- local methods
 
-func (z *zblock) isoverflow(key, value []byte, deleted bool) bool {
+func (z *zblock) isoverflow(
+	key, value []byte, deleted bool, expiry int64) bool {
+
 	entrysz := int64(zentrysize + len(key))
+	if expiry > 0 {
+		entrysz += 8
+	}
 	if deleted == false {
 		if z.vblocksize > 0 {
 			entrysz += 8 // just file position into value log.
@@ -16,7 +16,7 @@ func TestZBlock1(t *testing.T) {
 		i := uint64(0)
 		k := fmt.Sprintf("%16d", i)
 		v, seqno, deleted := k, i, true
-		for z.insert([]byte(k), []byte(v), 0, -1, seqno, deleted) {
+		for z.insert([]byte(k), []byte(v), 0, -1, seqno, deleted, 0) {
 			//t.Logf("insert %s", k)
 			i++
 			k = fmt.Sprintf("%16d", i)
@@ -80,7 +80,7 @@ func TestZBlock2(t *testing.T) {
 		i := uint64(0)
 		k := fmt.Sprintf("%16d", i)
 		seqno, deleted, vlogpos := i, true, int64(i*100)
-		for z.insert([]byte(k), nil, 16, vlogpos, seqno, deleted) {
+		for z.insert([]byte(k), nil, 16, vlogpos, seqno, deleted, 0) {
 			//t.Logf("insert %s", k)
 			i++
 			k = fmt.Sprintf("%16d", i)
@@ -145,7 +145,7 @@ func BenchmarkZInsert(b *testing.B) {
 	k, value := []byte("aaaaaaaaaaaaaaaaaaaaaaa"), []byte("bbbbbbbbbbbbb")
 	z := newz(blocksize, -1)
 	for i := 0; i < b.N; i++ {
-		if z.insert(k, value, 0, -1, 0, false) == false {
+		if z.insert(k, value, 0, -1, 0, false, 0) == false {
 			z.reset(0, nil)
 		}
 	}
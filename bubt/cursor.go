@@ -62,12 +62,15 @@ func (cur *Cursor) Key() (key []byte, deleted bool) {
 		return nil, false
 	}
 
+	var lv lazyvalue
+
 	z := zsnap(cur.buf.zblock)
 	if z.isbounded(cur.index) {
-		key, _, _, deleted = z.entryat(cur.index)
+		key, lv, _, deleted = z.entryat(cur.index)
 	} else {
-		key, _, _, deleted, _ = cur.getnext()
+		key, lv, _, deleted, _ = cur.getnext()
 	}
+	deleted = lv.expire(deleted)
 	return
 }
 
@@ -82,12 +85,11 @@ func (cur *Cursor) Value() (value []byte) {
 	z := zsnap(cur.buf.zblock)
 	if z.isbounded(cur.index) {
 		_, lv, _, _ = z.entryat(cur.index)
-		value, cur.buf.vblock = lv.getactual(cur.snap, cur.buf.vblock)
-
 	} else {
 		_, lv, _, _, _ = cur.getnext()
-		value, cur.buf.vblock = lv.getactual(cur.snap, cur.buf.vblock)
 	}
+	lv.expire(false)
+	value, cur.buf.vblock = lv.getactual(cur.snap, cur.buf.vblock)
 	return
 }
 
@@ -97,6 +99,7 @@ func (cur *Cursor) GetNext() (key, value []byte, deleted bool, err error) {
 	var lv lazyvalue
 
 	key, lv, _, deleted, err = cur.getnext()
+	deleted = lv.expire(deleted)
 	value, cur.buf.vblock = lv.getactual(cur.snap, cur.buf.vblock)
 	return
 }
@@ -106,6 +109,11 @@ func (cur *Cursor) getnext() (
 
 	if cur.finished {
 		return nil, lv, 0, false, io.EOF
+
+	} else if cur.index < 0 { // moved past the beginning, by getprev.
+		cur.index = 0
+		key, lv, seqno, deleted = zsnap(cur.buf.zblock).entryat(cur.index)
+		return key, lv, seqno, deleted, nil
 	}
 
 	key, lv, seqno, deleted = zsnap(cur.buf.zblock).getnext(cur.index)
@@ -129,6 +137,48 @@ func (cur *Cursor) getnext() (
 	return nil, lv, 0, false, err
 }
 
+// GetPrev move cursor to previous entry and return its key, value,
+// whether it is deleted, err will be io.EOF or any other disk error.
+func (cur *Cursor) GetPrev() (key, value []byte, deleted bool, err error) {
+	var lv lazyvalue
+
+	key, lv, _, deleted, err = cur.getprev()
+	deleted = lv.expire(deleted)
+	value, cur.buf.vblock = lv.getactual(cur.snap, cur.buf.vblock)
+	return
+}
+
+// getprev walk backwards within zblock, and from one zblock to its
+// previous zblock, in the reverse order of round-robin placement of
+// zblocks across shards.
+func (cur *Cursor) getprev() (
+	key []byte, lv lazyvalue, seqno uint64, deleted bool, err error) {
+
+	cur.ynext = true
+	if cur.finished { // moved past the end, start from last entry.
+		if err = cur.lastblock(cur.snap); err != nil {
+			return nil, lv, 0, false, err
+		}
+		key, lv, seqno, deleted = zsnap(cur.buf.zblock).entryat(cur.index)
+		return key, lv, seqno, deleted, nil
+
+	} else if cur.index < 0 {
+		return nil, lv, 0, false, io.EOF
+	}
+
+	z := zsnap(cur.buf.zblock)
+	if z.isbounded(cur.index - 1) {
+		cur.index--
+		key, lv, seqno, deleted = z.entryat(cur.index)
+		return key, lv, seqno, deleted, nil
+	}
+	if err = cur.prevblock(cur.snap); err != nil {
+		return nil, lv, 0, false, err
+	}
+	key, lv, seqno, deleted = zsnap(cur.buf.zblock).entryat(cur.index)
+	return key, lv, seqno, deleted, nil
+}
+
 // YNext can be used for lsm-sort. Similar to GetNext, but includes the
 // seqno at which the entry was created/updated/deleted.
 func (cur *Cursor) YNext(fin bool) (key,
@@ -146,11 +196,30 @@ func (cur *Cursor) YNext(fin bool) (key,
 		cur.ynext = true
 		if z.isbounded(cur.index) {
 			key, lv, seqno, deleted = z.entryat(cur.index)
+			deleted = lv.expire(deleted)
 			value, cur.buf.vblock = lv.getactual(cur.snap, cur.buf.vblock)
 			return
 		}
 	}
 	key, lv, seqno, deleted, err = cur.getnext()
+	deleted = lv.expire(deleted)
+	value, cur.buf.vblock = lv.getactual(cur.snap, cur.buf.vblock)
+	return
+}
+
+// YPrev can be used for descending lsm-sort. Move cursor to previous
+// entry and return the same, along with its seqno.
+func (cur *Cursor) YPrev(fin bool) (key,
+	value []byte, seqno uint64, deleted bool, err error) {
+
+	var lv lazyvalue
+
+	if fin {
+		cur.finished = fin
+		return nil, nil, 0, false, io.EOF
+	}
+	key, lv, seqno, deleted, err = cur.getprev()
+	deleted = lv.expire(deleted)
 	value, cur.buf.vblock = lv.getactual(cur.snap, cur.buf.vblock)
 	return
 }
@@ -213,3 +282,59 @@ func (cur *Cursor) Delete(key, oldvalue []byte, lsm bool) []byte {
 func (cur *Cursor) Delcursor(lsm bool) {
 	panic("Delcursor not allowed on view-cursor")
 }
+
+func (cur *Cursor) prevblock(snap *Snapshot) error {
+	nshards := byte(len(cur.fposs))
+	shardidx := (cur.shardidx + nshards - 1) % nshards
+	fpos := cur.fposs[shardidx] - snap.zblocksize
+	if fpos < 0 {
+		cur.index = -1
+		return io.EOF
+	}
+	if err := cur.readblock(snap, shardidx, fpos); err != nil {
+		return err
+	}
+	cur.shardidx, cur.fposs[shardidx] = shardidx, fpos
+	cur.index = zsnap(cur.buf.zblock).entries() - 1
+	return nil
+}
+
+func (cur *Cursor) lastblock(snap *Snapshot) error {
+	nblocks := int64(0)
+	for _, zsize := range snap.zsizes {
+		nblocks += (zsize - MarkerBlocksize) / snap.zblocksize
+	}
+	if nblocks == 0 {
+		return io.EOF
+	}
+	// zblocks are placed round-robin across shards.
+	nshards := int64(len(cur.fposs))
+	shardidx := (nblocks - 1) % nshards
+	fpos := ((nblocks - 1) / nshards) * snap.zblocksize
+	if err := cur.readblock(snap, byte(shardidx), fpos); err != nil {
+		return err
+	}
+	for i := range cur.fposs {
+		if int64(i) < shardidx {
+			cur.fposs[i] = fpos + snap.zblocksize
+		} else {
+			cur.fposs[i] = fpos
+		}
+	}
+	cur.shardidx, cur.finished = byte(shardidx), false
+	cur.index = zsnap(cur.buf.zblock).entries() - 1
+	return nil
+}
+
+func (cur *Cursor) readblock(snap *Snapshot, shardidx byte, fpos int64) error {
+	n, err := snap.readzs[shardidx].ReadAt(cur.buf.zblock, fpos)
+	if err != nil {
+		errorf("%v %v", cur.snap.logprefix, err)
+		return err
+	} else if x := len(cur.buf.zblock); n < x {
+		err := fmt.Errorf("read %v bytes for zblock %v", n, x)
+		errorf("%v %v", cur.snap.logprefix, err)
+		return err
+	}
+	return nil
+}
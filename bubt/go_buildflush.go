@@ -16,6 +16,8 @@ type bubtflusher struct {
 	ch     chan *blockdata
 	quitch chan struct{}
 	pool   *blockpool
+
+	limiter *Ratelimiter
 }
 
 func startflusher(
@@ -59,6 +61,7 @@ func (flusher *bubtflusher) writedata(data []byte) error {
 	if len(data) == 0 {
 		return nil
 	}
+	flusher.limiter.wait(len(data))
 	block := flusher.pool.getblock(len(data))
 	copy(block.data, data)
 	select {
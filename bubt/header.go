@@ -98,3 +98,17 @@ func readinfoblock(r io.ReaderAt) (fpos int64, info s.Settings, err error) {
 	}
 	return fpos, info, err
 }
+
+func readbloom(r io.ReaderAt, fpos, size int64) (*bloomfilter, error) {
+	if fpos < 0 {
+		return nil, fmt.Errorf("bubt.snap.nobloom")
+	}
+	block := lib.Fixbuffer(nil, size)
+	n, err := r.ReadAt(block, fpos)
+	if err != nil {
+		return nil, err
+	} else if n < len(block) {
+		return nil, fmt.Errorf("bubt.snap.partialbloom")
+	}
+	return decodebloom(block)
+}
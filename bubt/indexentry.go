@@ -45,3 +45,13 @@ func (entry *indexentry) Valueref() (valuelen uint64, vlogpos int64) {
 	valuelen, vlogpos = uint64(entry.lv.valuelen), entry.lv.vlogpos
 	return
 }
+
+func (entry *indexentry) Expiry() int64 {
+	return entry.lv.expiry
+}
+
+// IsOperand always return false, merge operands are folded before they
+// are persisted on disk.
+func (entry *indexentry) IsOperand() bool {
+	return false
+}
@@ -1,6 +1,7 @@
 package bubt
 
 import "fmt"
+import "time"
 
 import "github.com/bnclabs/gostore/lib"
 
@@ -10,6 +11,7 @@ type lazyvalue struct {
 	vlogpos  int64
 	shardidx int
 	fpos     int64
+	expiry   int64 // unix time in seconds, ZERO if value never expires.
 }
 
 func (lv *lazyvalue) setfields(valuelen, vlogpos int64, value []byte) {
@@ -21,6 +23,21 @@ func (lv *lazyvalue) setfields(valuelen, vlogpos int64, value []byte) {
 	lv.valuelen, lv.vlogpos = valuelen, vlogpos
 	lv.shardidx = int(uint64(vlogpos) >> 56)
 	lv.fpos = int64(uint64(vlogpos) & 0x00FFFFFFFFFFFFFF)
+	lv.expiry = 0
+}
+
+func (lv *lazyvalue) setexpiry(expiry int64) {
+	lv.expiry = expiry
+}
+
+// expire value if expiry has elapsed, return deleted as true for
+// expired values, so that they are hidden from readers.
+func (lv *lazyvalue) expire(deleted bool) bool {
+	if lv.expiry > 0 && lv.expiry <= time.Now().Unix() {
+		lv.setfields(0, 0, nil)
+		return true
+	}
+	return deleted
 }
 
 func (lv *lazyvalue) getactual(snap *Snapshot, vblock []byte) ([]byte, []byte) {
@@ -0,0 +1,114 @@
+package bubt
+
+import "sync"
+import "time"
+
+import s "github.com/bnclabs/gosettings"
+
+// Ratelimiter caps the rate, in bytes per second, at which blocks are
+// written to disk. Same limiter can be shared by any number of Bubt
+// builders, in which case the budget is shared across all of their
+// flushers. Rate can be changed while builders are running.
+type Ratelimiter struct {
+	mu        sync.Mutex
+	rate      int64   // bytes per second, ZERO means unlimited.
+	available float64 // bytes that can be written without waiting.
+	updated   time.Time
+
+	// stats
+	n_bytes     int64
+	n_throttles int64
+	throttled   time.Duration
+}
+
+// NewRatelimiter create a limiter for rate bytes per second. If rate
+// is ZERO, writes are not throttled.
+func NewRatelimiter(rate int64) *Ratelimiter {
+	limiter := &Ratelimiter{updated: time.Now()}
+	limiter.SetRate(rate)
+	return limiter
+}
+
+// SetRate update the limiter to rate bytes per second, ZERO disables
+// throttling.
+func (limiter *Ratelimiter) SetRate(rate int64) {
+	if rate < 0 {
+		rate = 0
+	}
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	limiter.refill(time.Now())
+	limiter.rate = rate
+	if limiter.available > float64(rate) {
+		limiter.available = float64(rate)
+	}
+}
+
+// Rate return the current rate in bytes per second.
+func (limiter *Ratelimiter) Rate() int64 {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	return limiter.rate
+}
+
+// Stats return statistics on throttled writes.
+//
+// "rate", current rate in bytes per second.
+// "n_bytes", total bytes written via this limiter.
+// "n_throttles", number of writes that had to wait.
+// "throttled", cumulative time spent waiting, in nanoseconds.
+func (limiter *Ratelimiter) Stats() s.Settings {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	return s.Settings{
+		"rate":        limiter.rate,
+		"n_bytes":     limiter.n_bytes,
+		"n_throttles": limiter.n_throttles,
+		"throttled":   int64(limiter.throttled),
+	}
+}
+
+// wait till n bytes can be written within the budget. Bytes are
+// debited upfront, so concurrent writers queue up behind each other.
+func (limiter *Ratelimiter) wait(n int) {
+	if limiter == nil {
+		return
+	}
+
+	limiter.mu.Lock()
+	limiter.n_bytes += int64(n)
+	if limiter.rate == 0 {
+		limiter.mu.Unlock()
+		return
+	}
+	limiter.refill(time.Now())
+	limiter.available -= float64(n)
+	var delay time.Duration
+	if limiter.available < 0 {
+		secs := -limiter.available / float64(limiter.rate)
+		delay = time.Duration(secs * float64(time.Second))
+		limiter.n_throttles++
+		limiter.throttled += delay
+	}
+	limiter.mu.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// refill budget for the time elapsed since last update, burst is
+// capped to one second worth of bytes.
+func (limiter *Ratelimiter) refill(now time.Time) {
+	elapsed := now.Sub(limiter.updated).Seconds()
+	limiter.updated = now
+	if limiter.rate == 0 {
+		limiter.available = 0
+		return
+	}
+	limiter.available += elapsed * float64(limiter.rate)
+	if limiter.available > float64(limiter.rate) {
+		limiter.available = float64(limiter.rate)
+	}
+}
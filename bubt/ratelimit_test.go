@@ -0,0 +1,102 @@
+package bubt
+
+import "time"
+import "testing"
+
+func TestRatelimiter(t *testing.T) {
+	limiter := NewRatelimiter(0)
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		limiter.wait(1024 * 1024)
+	}
+	stats := limiter.Stats()
+	if x := stats.Int64("n_throttles"); x != 0 {
+		t.Errorf("unexpected %v", x)
+	} else if x := stats.Int64("n_bytes"); x != 100*1024*1024 {
+		t.Errorf("expected %v, got %v", 100*1024*1024, x)
+	} else if took := time.Since(start); took > time.Second {
+		t.Errorf("unexpected %v", took)
+	}
+
+	// 1MB/s, write 2MB in 4 writers.
+	limiter.SetRate(1024 * 1024)
+	if x := limiter.Rate(); x != 1024*1024 {
+		t.Errorf("expected %v, got %v", 1024*1024, x)
+	}
+	start = time.Now()
+	donech := make(chan bool, 4)
+	for i := 0; i < 4; i++ {
+		go func() {
+			for j := 0; j < 8; j++ {
+				limiter.wait(64 * 1024)
+			}
+			donech <- true
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		<-donech
+	}
+	took := time.Since(start)
+	if took < 1900*time.Millisecond || took > 3*time.Second {
+		t.Errorf("unexpected %v", took)
+	}
+	stats = limiter.Stats()
+	if x := stats.Int64("n_throttles"); x == 0 {
+		t.Errorf("expected throttles")
+	} else if x := time.Duration(stats.Int64("throttled")); x < time.Second {
+		t.Errorf("unexpected %v", x)
+	}
+
+	// disable throttling at runtime.
+	limiter.SetRate(0)
+	start = time.Now()
+	limiter.wait(10 * 1024 * 1024)
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("unexpected %v", took)
+	}
+}
+
+func TestBuildRatelimit(t *testing.T) {
+	n := 20000
+	paths := makepaths123(-1)
+	mi, _, _ := makeLLRB(n)
+	defer mi.Destroy()
+
+	name, msize := "testbuild", int64(4096)
+	bubt, err := NewBubt(name, paths, msize, msize, msize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limiter := NewRatelimiter(1024 * 1024)
+	bubt.Ratelimit(limiter)
+
+	start := time.Now()
+	mitere := mi.ScanEntries()
+	if err := bubt.Build(mitere, []byte("this is metadata")); err != nil {
+		t.Fatal(err)
+	}
+	mitere(true /*fin*/)
+	bubt.Close()
+	took := time.Since(start)
+
+	snap, err := OpenSnapshot(name, paths, false /*mmap*/)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Destroy()
+	defer snap.Close()
+
+	if snap.Count() != mi.Count() {
+		t.Errorf("expected %v, got %v", mi.Count(), snap.Count())
+	}
+	stats := limiter.Stats()
+	nbytes := stats.Int64("n_bytes")
+	if nbytes == 0 {
+		t.Errorf("unexpected %v", nbytes)
+	} else if min := time.Duration(nbytes/(1024*1024)) * time.Second; took < min {
+		t.Errorf("expected atleast %v, took %v", min, took)
+	}
+	t.Logf("wrote %v bytes in %v, throttled %v times for %v",
+		nbytes, took, stats["n_throttles"],
+		time.Duration(stats.Int64("throttled")))
+}
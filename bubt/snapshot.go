@@ -51,9 +51,12 @@ type Snapshot struct {
 	n_ablocks  int64
 	n_count    int64
 	n_deleted  int64
+	bloomsize  int64
 	footprint  int64
 	logprefix  string
 
+	bloom *bloomfilter // nil, if snapshot is built without bloom filter.
+
 	viewcache chan *View
 	curcache  chan *Cursor
 	rdpool    *readerpool
@@ -218,8 +221,18 @@ func (snap *Snapshot) readheader(r io.ReaderAt) (*Snapshot, error) {
 	snap.n_ablocks = info.Int64("n_ablocks")
 	snap.n_count = info.Int64("n_count")
 	snap.n_deleted = info.Int64("n_deleted")
+	if _, ok := info["bloomsize"]; ok { // older snapshots don't have it.
+		snap.bloomsize = info.Int64("bloomsize")
+	}
+	if snap.bloomsize > 0 {
+		bpos := fpos - snap.bloomsize
+		if snap.bloom, err = readbloom(r, bpos, snap.bloomsize); err != nil {
+			errorf("%v %v", snap.logprefix, err)
+			return snap, err
+		}
+	}
 
-	snap.root = fpos - snap.mblocksize
+	snap.root = fpos - snap.bloomsize - snap.mblocksize
 	return snap, nil
 }
 
@@ -293,6 +306,7 @@ func (snap *Snapshot) Metadata() []byte {
 //   n_vblocks  : total number of blocks in value log.
 //   n_count    : number of entries in this snapshot, includes deleted.
 //   n_deleted  : number of entries marked as deleted.
+//   bloomsize  : size of bloom filter on disk, ZERO if not built.
 //   footprint  : disk footprint for this snapshot.
 func (snap *Snapshot) Info() s.Settings {
 	return s.Settings{
@@ -315,6 +329,7 @@ func (snap *Snapshot) Info() s.Settings {
 		"n_ablocks":  snap.n_ablocks,
 		"n_count":    snap.n_count,
 		"n_deleted":  snap.n_deleted,
+		"bloomsize":  snap.bloomsize,
 		"footprint":  snap.footprint,
 	}
 }
@@ -421,6 +436,7 @@ func (snap *Snapshot) validatequick() {
 	computed += (snap.n_mblocks * snap.mblocksize)
 	computed += (snap.n_vblocks * snap.vblocksize)
 	computed += MarkerBlocksize + MarkerBlocksize /*infoblock*/
+	computed += snap.bloomsize
 	ln := int64(len(snap.metadata))
 	computed += (((ln - 1) / snap.mblocksize) + 1) * snap.mblocksize
 	computed += MarkerBlocksize * int64(len(snap.readzs))
@@ -513,18 +529,38 @@ func (snap *Snapshot) Destroy() {
 // Get value for key, if value argument is not nil it will be used to
 // copy the entry's value. Also returns entry's cas, whether entry is
 // marked as deleted by LSM. If ok is false, then key is not found.
+// If snapshot is built with bloom filter, missing keys are mostly
+// answered without reading the disk.
 func (snap *Snapshot) Get(
 	key, value []byte) (actualvalue []byte, cas uint64, deleted, ok bool) {
 
+	actualvalue, cas, deleted, ok, _ = snap.Getexpiry(key, value)
+	return actualvalue, cas, deleted, ok
+}
+
+// Getexpiry is same as Get, additionally return entry's expiry as unix
+// time in seconds, ZERO if entry never expires. Expired entries are
+// returned as deleted.
+func (snap *Snapshot) Getexpiry(
+	key, value []byte) (
+	actualvalue []byte, cas uint64, deleted, ok bool, expiry int64) {
+
 	var wkey []byte
 	var lv lazyvalue
 	var v []byte
 
+	if snap.bloom != nil && snap.bloom.contains(key) == false {
+		return nil, 0, false, false, 0
+	}
+
 	msize, zsize, vsize := snap.mblocksize, snap.zblocksize, snap.vblocksize
 	buf := snap.rdpool.getreadbuffer(msize, zsize, vsize)
 
 	shardidx, fpos := snap.findinmblock(key, buf)
 	_, wkey, lv, cas, deleted, ok = snap.findinzblock(shardidx, fpos, key, buf)
+	if ok {
+		expiry, deleted = lv.expiry, lv.expire(deleted)
+	}
 
 	cmp := bytes.Compare(wkey, key)
 	if cmp == 0 && value != nil {
@@ -534,7 +570,7 @@ func (snap *Snapshot) Get(
 	}
 
 	snap.rdpool.putreadbuffer(buf)
-	return actualvalue, cas, deleted, ok
+	return actualvalue, cas, deleted, ok, expiry
 }
 
 func (snap *Snapshot) findinmblock(
@@ -583,6 +619,48 @@ func (snap *Snapshot) findinzblock(
 	return
 }
 
+// ApproximateSize return an estimate of bytes, in keys and values,
+// held by entries between low and high, without reading z-blocks.
+// Estimate is proportional to the number of z-blocks spanning the
+// range, located via m-blocks. If low is nil, range starts from the
+// first key, if high is nil, range extends till the last key.
+func (snap *Snapshot) ApproximateSize(low, high []byte) int64 {
+	if snap.n_zblocks == 0 {
+		return 0
+	} else if low != nil && high != nil && bytes.Compare(low, high) > 0 {
+		return 0
+	}
+
+	msize, zsize, vsize := snap.mblocksize, snap.zblocksize, snap.vblocksize
+	buf := snap.rdpool.getreadbuffer(msize, zsize, vsize)
+	from, till := int64(0), snap.n_zblocks
+	if low != nil {
+		from = snap.zblockordinal(low, buf)
+	}
+	if high != nil {
+		till = snap.zblockordinal(high, buf) + 1
+	}
+	snap.rdpool.putreadbuffer(buf)
+
+	if till > snap.n_zblocks {
+		till = snap.n_zblocks
+	}
+	if till <= from {
+		return 0
+	}
+	payload := snap.keymem + snap.valmem
+	return (payload * (till - from)) / snap.n_zblocks
+}
+
+// zblockordinal return the position of z-block, that shall hold key,
+// in build order. Z-blocks are distributed across z-index files in
+// round-robin.
+func (snap *Snapshot) zblockordinal(key []byte, buf *readbuffers) int64 {
+	shardidx, fpos := snap.findinmblock(key, buf)
+	nshards := int64(len(snap.readzs))
+	return ((fpos / snap.zblocksize) * nshards) + int64(shardidx)
+}
+
 // BeginTxn is not allowed.
 func (snap *Snapshot) BeginTxn(id uint64) api.Transactor {
 	panic("not allowed")
@@ -603,20 +681,36 @@ func (snap *Snapshot) abortview(view *View) error {
 // reaching end of table (io.EOF), application should call iterator
 // with fin as true. EG: iter(true)
 func (snap *Snapshot) Scan() api.Iterator {
+	return snap.ScanRange(nil, nil, false)
+}
+
+// ScanRange return an iterator from low key, inclusive, upto high
+// key. If inclusive is true, entry with high key is also included.
+// If low is nil, iteration starts from first entry, and if high is
+// nil, iteration continues till last entry. Iteration stops at the
+// first entry beyond high key, without reading subsequent zblocks.
+// If iteration is stopped before reaching the end of range (io.EOF),
+// application should call iterator with fin as true. EG: iter(true)
+func (snap *Snapshot) ScanRange(
+	low, high []byte, inclusive bool) api.Iterator {
+
 	view := snap.getview(0xC0FFEE)
-	cur, err := view.OpenCursor(nil)
+	cur, err := view.OpenCursor(low)
 	if err != nil {
 		view.Abort()
-		fmsg := "%v view(%v).OpenCursor(nil): %v"
-		errorf(fmsg, snap.logprefix, view.id, err)
+		fmsg := "%v view(%v).OpenCursor(%q): %v"
+		errorf(fmsg, snap.logprefix, view.id, low, err)
 		return nil
 
 	} else if cur == nil {
 		view.Abort()
-		fmsg := "%v view(%v).OpenCursor(nil) cursor is nil"
-		errorf(fmsg, snap.logprefix, view.id)
+		fmsg := "%v view(%v).OpenCursor(%q) cursor is nil"
+		errorf(fmsg, snap.logprefix, view.id, low)
 		return nil
 	}
+	if high != nil {
+		high = append([]byte(nil), high...)
+	}
 
 	var key, value []byte
 	var seqno uint64
@@ -632,6 +726,15 @@ func (snap *Snapshot) Scan() api.Iterator {
 			return nil, nil, 0, false, err
 		}
 		key, value, seqno, deleted, err = cur.YNext(fin)
+		if err == nil && high != nil {
+			if cmp := bytes.Compare(key, high); cmp > 0 {
+				cur.YNext(true /*fin*/)
+				err = io.EOF
+			} else if cmp == 0 && !inclusive {
+				cur.YNext(true /*fin*/)
+				err = io.EOF
+			}
+		}
 		if err != nil {
 			view.Abort()
 			return nil, nil, 0, false, err
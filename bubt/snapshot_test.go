@@ -1,6 +1,9 @@
 package bubt
 
+import "io"
+import "fmt"
 import "time"
+import "bytes"
 import "testing"
 import "math/rand"
 
@@ -74,6 +77,145 @@ func TestLog(t *testing.T) {
 	snap.Log()
 }
 
+func TestBloomGet(t *testing.T) {
+	n := 100000
+	paths := makepaths123(-1)
+	mi, keys, _ := makeLLRB(n)
+	defer mi.Destroy()
+
+	name, msize := "testbloom", int64(4096)
+	mmap := []bool{false, true}[rand.Intn(10000)%2]
+	bubt, err := NewBubt(name, paths, msize, msize, 0 /*vsize*/)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bubt.BloomFilter(10)
+	mitere := mi.ScanEntries()
+	if err := bubt.Build(mitere, []byte("this is metadata")); err != nil {
+		t.Fatal(err)
+	}
+	mitere(true /*fin*/)
+	bubt.Close()
+
+	snap, err := OpenSnapshot(name, paths, mmap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Destroy()
+	defer snap.Close()
+
+	if snap.bloom == nil {
+		t.Fatalf("expected bloom filter")
+	} else if x := snap.Info().Int64("bloomsize"); x <= 0 {
+		t.Errorf("unexpected bloomsize %v", x)
+	}
+	snap.Validate()
+
+	for _, key := range keys {
+		refval, refcas, refdel, _ := mi.Get(key, []byte{})
+		val, cas, del, ok := snap.Get(key, []byte{})
+		if ok == false {
+			t.Fatalf("missing key %q", key)
+		} else if del != refdel {
+			t.Errorf("%q expected %v, got %v", key, refdel, del)
+		} else if cas != refcas {
+			t.Errorf("%q expected %v, got %v", key, refcas, cas)
+		} else if del == false && bytes.Compare(val, refval) != 0 {
+			t.Errorf("%q expected %q, got %q", key, refval, val)
+		}
+	}
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("miss%v", i))
+		if _, _, _, ok := snap.Get(key, []byte{}); ok {
+			t.Errorf("unexpected key %q", key)
+		}
+	}
+}
+
+func TestScanRange(t *testing.T) {
+	n := 10000
+	paths := makepaths123(-1)
+	mi, keys := makeLLRBEven(n)
+	defer mi.Destroy()
+
+	name, msize, zsize := "testscanrange", int64(4096), int64(4096)
+	bubt, err := NewBubt(name, paths, msize, zsize, 0 /*vsize*/)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mitere := mi.ScanEntries()
+	if err := bubt.Build(mitere, []byte("this is metadata")); err != nil {
+		t.Fatal(err)
+	}
+	mitere(true /*fin*/)
+	bubt.Close()
+
+	snap, err := OpenSnapshot(name, paths, false /*mmap*/)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Destroy()
+	defer snap.Close()
+
+	mkkey := func(i int) []byte {
+		return []byte(fmt.Sprintf("key%015d", i))
+	}
+	testcases := [][]interface{}{
+		{[]byte(nil), []byte(nil), false},
+		{[]byte(nil), mkkey(1000), false},
+		{[]byte(nil), mkkey(1000), true},
+		{mkkey(1000), []byte(nil), true},
+		{mkkey(1001), []byte(nil), true},
+		{mkkey(1000), mkkey(5000), false},
+		{mkkey(1000), mkkey(5000), true},
+		{mkkey(999), mkkey(5001), true},
+		{mkkey(1000), mkkey(1000), true},
+		{mkkey(1000), mkkey(1000), false},
+		{mkkey(5000), mkkey(1000), true},
+		{mkkey(2 * n), []byte(nil), false},
+		{[]byte("a"), []byte("z"), false},
+	}
+	for i := 0; i < 100; i++ {
+		x, y := rand.Intn(2*n), rand.Intn(2*n)
+		tcase := []interface{}{mkkey(x), mkkey(y), x%2 == 0}
+		testcases = append(testcases, tcase)
+	}
+	for _, tcase := range testcases {
+		low, high := tcase[0].([]byte), tcase[1].([]byte)
+		incl := tcase[2].(bool)
+
+		refkeys := [][]byte{}
+		for _, key := range keys {
+			if low != nil && bytes.Compare(key, low) < 0 {
+				continue
+			} else if high != nil && bytes.Compare(key, high) > 0 {
+				continue
+			} else if !incl && bytes.Compare(key, high) == 0 {
+				continue
+			}
+			refkeys = append(refkeys, key)
+		}
+
+		count := 0
+		iter := snap.ScanRange(low, high, incl)
+		key, _, _, _, err := iter(false /*fin*/)
+		for ; err == nil; key, _, _, _, err = iter(false /*fin*/) {
+			if count >= len(refkeys) {
+				t.Fatalf("%q-%q unexpected %q", low, high, key)
+			} else if bytes.Compare(key, refkeys[count]) != 0 {
+				t.Fatalf("expected %q, got %q", refkeys[count], key)
+			}
+			count++
+		}
+		if err != io.EOF {
+			t.Errorf("unexpected %v", err)
+		} else if count != len(refkeys) {
+			t.Errorf("expected %v, got %v", len(refkeys), count)
+		}
+		iter(true /*fin*/)
+	}
+}
+
 func BenchmarkSnapCount(b *testing.B) {
 	snap, _ := makeBubt(10000, 4096, 4096, 0)
 	defer snap.Destroy()
@@ -290,3 +432,63 @@ func makeBubt(n int, msize, zsize, vsize int64) (*Snapshot, [][]byte) {
 	}
 	return snap, keys
 }
+
+func TestApproximateSize(t *testing.T) {
+	n := 10000
+	paths := makepaths123(-1)
+	mi, keys := makeLLRBEven(n)
+	defer mi.Destroy()
+
+	name, msize, zsize := "testapprox", int64(4096), int64(4096)
+	bubt, err := NewBubt(name, paths, msize, zsize, 0 /*vsize*/)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mitere := mi.ScanEntries()
+	if err := bubt.Build(mitere, []byte("this is metadata")); err != nil {
+		t.Fatal(err)
+	}
+	mitere(true /*fin*/)
+	bubt.Close()
+
+	snap, err := OpenSnapshot(name, paths, false /*mmap*/)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Destroy()
+	defer snap.Close()
+
+	info := snap.Info()
+	payload := info.Int64("keymem") + info.Int64("valmem")
+	if x := snap.ApproximateSize(nil, nil); x != payload {
+		t.Errorf("expected %v, got %v", payload, x)
+	}
+	// one z-block worth of payload, on either side of the range.
+	slack := 2 * (payload / info.Int64("n_zblocks"))
+
+	mkkey := func(i int) []byte {
+		return []byte(fmt.Sprintf("key%015d", i))
+	}
+	for i := 0; i < 100; i++ {
+		x, y := rand.Intn(2*n), rand.Intn(2*n)
+		low, high := mkkey(x), mkkey(y)
+		ref := int64(0)
+		for _, key := range keys {
+			if bytes.Compare(key, low) < 0 || bytes.Compare(key, high) > 0 {
+				continue
+			}
+			v, _, deleted, _ := mi.Get(key, make([]byte, 0, 32))
+			ref += int64(len(key))
+			if !deleted {
+				ref += int64(len(v))
+			}
+		}
+		size := snap.ApproximateSize(low, high)
+		if x > y && size != 0 {
+			t.Errorf("%s-%s unexpected %v", low, high, size)
+		} else if size < ref-slack || size > ref+slack {
+			fmsg := "%s-%s expected %v (+/-%v), got %v"
+			t.Errorf(fmsg, low, high, ref, slack, size)
+		}
+	}
+}
@@ -25,6 +25,8 @@ func (z zsnap) findkey(
 		if cmp == 0 { // adjust+half >= key
 			//fmt.Printf("zfindkey-1 %v %v %q\n", adjust, 0, actualkey)
 			return adjust, actualkey, lv, seqno, del, true
+		} else if cmp > 0 { // key is less than the first key in zblock.
+			return adjust, actualkey, lv, 0, false, false
 		}
 		// cmp < 0
 		//fmt.Printf("zfindkey-2 %v %v %q\n", adjust, -1, actualkey)
@@ -65,8 +67,13 @@ func (z zsnap) compareat(
 		if ze.isvlog() {
 			vlogpos := int64(binary.BigEndian.Uint64(z[x : x+8]))
 			lv.setfields(int64(ln), vlogpos, nil)
+			x += 8
 		} else if ln > 0 {
 			lv.setfields(int64(ln), 0, z[x:x+ln])
+			x += ln
+		}
+		if ze.isexpiry() {
+			lv.setexpiry(int64(binary.BigEndian.Uint64(z[x : x+8])))
 		}
 	}
 	return cmp, currkey, lv, cas, deleted
@@ -97,11 +104,16 @@ func (z zsnap) entryat(
 	if vlogok {
 		vlogpos := int64(binary.BigEndian.Uint64(z[x : x+8]))
 		lv.setfields(int64(valuelen), vlogpos, nil)
+		x += 8
 	} else if valuelen > 0 {
 		lv.setfields(int64(valuelen), 0, z[x:x+valuelen])
+		x += valuelen
 	} else {
 		lv.setfields(0, 0, nil)
 	}
+	if ze.isexpiry() {
+		lv.setexpiry(int64(binary.BigEndian.Uint64(z[x : x+8])))
+	}
 	return
 }
 
@@ -114,6 +126,10 @@ func (z zsnap) getnext(
 	return key, lv, 0, false
 }
 
+func (z zsnap) entries() int {
+	return int(binary.BigEndian.Uint32(z[:4]))
+}
+
 func (z zsnap) isbounded(index int) bool {
 	idxlen := int(binary.BigEndian.Uint32(z[:4]))
 	return (index >= 0) && (index < idxlen)
@@ -5,6 +5,7 @@ import "encoding/binary"
 const (
 	zflagDeleted byte = 0x1
 	zflagVlog    byte = 0x2
+	zflagExpiry  byte = 0x4
 )
 
 // zentry represents the binary layout of each entry in the leaf(z) block.
@@ -14,6 +15,7 @@ const (
 // byte array of key
 // 8-byte fpos into value log, if value is present, and stored in value-log.
 //  or byte array of value, if value is present.
+// 8-byte expiry as unix time in seconds, if zflagExpiry is set.
 type zentry []byte // key, and optionally value shall follow.
 
 const zentrysize = 24
@@ -50,6 +52,22 @@ func (ze zentry) isvlog() bool {
 	return ((binary.BigEndian.Uint64(ze[:8]) >> 60) & uint64(zflagVlog)) != 0
 }
 
+func (ze zentry) setexpiry() zentry {
+	hdr1 := binary.BigEndian.Uint64(ze[:8])
+	binary.BigEndian.PutUint64(ze[:8], hdr1|(uint64(zflagExpiry)<<60))
+	return ze
+}
+
+func (ze zentry) clearexpiry() zentry {
+	hdr1 := binary.BigEndian.Uint64(ze[:8])
+	binary.BigEndian.PutUint64(ze[:8], hdr1&(^(uint64(zflagExpiry) << 60)))
+	return ze
+}
+
+func (ze zentry) isexpiry() bool {
+	return ((binary.BigEndian.Uint64(ze[:8]) >> 60) & uint64(zflagExpiry)) != 0
+}
+
 func (ze zentry) setseqno(seqno uint64) zentry {
 	hdr1 := binary.BigEndian.Uint64(ze[:8])
 	hdr1 = (hdr1 & 0xF000000000000000) | seqno
@@ -16,6 +16,12 @@ func TestZentry(t *testing.T) {
 	} else if ze.clearvlog(); ze.isvlog() == true {
 		t.Errorf("unexpected true")
 	}
+	// test expiry flag
+	if ze.setexpiry(); ze.isexpiry() == false {
+		t.Errorf("unexpected false")
+	} else if ze.clearexpiry(); ze.isexpiry() == true {
+		t.Errorf("unexpected true")
+	}
 
 	seqno := uint64(0x234567812345678)
 	if ze.setseqno(seqno); ze.seqno() != seqno {
@@ -0,0 +1,84 @@
+// Command bognmigrate rebuild disk levels of a bogn index with new
+// settings, index shall not be in use while it is migrated.
+//
+//	bognmigrate -name index -diskpaths /data1,/data2 -zblocksize 8192
+//
+// Only the settings supplied as arguments are changed, rest of the
+// settings are carried over from the latest disk level.
+package main
+
+import "os"
+import "fmt"
+import "flag"
+import "strings"
+
+import "github.com/bnclabs/gostore/bogn"
+import s "github.com/bnclabs/gosettings"
+
+var options struct {
+	name       string
+	diskstore  string
+	diskpaths  string
+	memstore   string
+	mblocksize int64
+	zblocksize int64
+	vblocksize int64
+	bloombits  int64
+}
+
+func argparse() s.Settings {
+	f := flag.NewFlagSet("bognmigrate", flag.ExitOnError)
+	f.StringVar(&options.name, "name", "",
+		"name of the bogn index")
+	f.StringVar(&options.diskstore, "diskstore", "bubt",
+		"disk store used by the index")
+	f.StringVar(&options.diskpaths, "diskpaths", "",
+		"comma separated list of paths holding disk levels")
+	f.StringVar(&options.memstore, "memstore", "",
+		"new memory store, llrb or mvcc")
+	f.Int64Var(&options.mblocksize, "mblocksize", 0,
+		"new m-block size for disk levels")
+	f.Int64Var(&options.zblocksize, "zblocksize", 0,
+		"new z-block size for disk levels")
+	f.Int64Var(&options.vblocksize, "vblocksize", 0,
+		"new v-block size for disk levels, 0 to disable value-logs")
+	f.Int64Var(&options.bloombits, "bloombits", 0,
+		"new number of bits per entry for bloom filter")
+	f.Parse(os.Args[1:])
+
+	setts := s.Settings{}
+	f.Visit(func(fg *flag.Flag) {
+		switch fg.Name {
+		case "memstore":
+			setts["memstore"] = options.memstore
+		case "mblocksize":
+			setts["bubt.mblocksize"] = options.mblocksize
+		case "zblocksize":
+			setts["bubt.zblocksize"] = options.zblocksize
+		case "vblocksize":
+			setts["bubt.vblocksize"] = options.vblocksize
+		case "bloombits":
+			setts["bubt.bloombits"] = options.bloombits
+		}
+	})
+	return setts
+}
+
+func main() {
+	setts := argparse()
+	if options.name == "" || options.diskpaths == "" {
+		fmt.Fprintf(os.Stderr, "please provide -name and -diskpaths\n")
+		os.Exit(1)
+	} else if len(setts) == 0 {
+		fmt.Fprintf(os.Stderr, "nothing to migrate\n")
+		os.Exit(1)
+	}
+
+	diskpaths := strings.Split(options.diskpaths, ",")
+	err := bogn.MigrateIndex(options.name, options.diskstore, diskpaths, setts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "bognmigrate: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("migrated %q with %v\n", options.name, setts)
+}
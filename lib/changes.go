@@ -0,0 +1,68 @@
+package lib
+
+import "io"
+import "sort"
+
+import "github.com/bnclabs/gostore/api"
+
+// Change is a mutation captured for subscribers.
+type Change struct {
+	Key     []byte
+	Value   []byte
+	Seqno   uint64
+	Deleted bool
+}
+
+// Changes sorted by seqno.
+type Changes []Change
+
+func (cs Changes) Len() int           { return len(cs) }
+func (cs Changes) Less(i, j int) bool { return cs[i].Seqno < cs[j].Seqno }
+func (cs Changes) Swap(i, j int)      { cs[i], cs[j] = cs[j], cs[i] }
+
+// Add a copy of mutation to the list.
+func (cs Changes) Add(key, value []byte, seqno uint64, deleted bool) Changes {
+	c := Change{Seqno: seqno, Deleted: deleted}
+	c.Key = append(c.Key, key...)
+	c.Value = append(c.Value, value...)
+	return append(cs, c)
+}
+
+// SeqnoIterator return an iterator over entries, from iterator created
+// by scan, whose seqno is between from and till, both inclusive, in
+// seqno order. Index is scanned once, on the first call to the iterator,
+// hence memory is proportional to number of entries changed since from.
+func SeqnoIterator(scan func() api.Iterator, from, till uint64) api.Iterator {
+	var cs Changes
+
+	scanned := false
+	fill := func() {
+		if iter := scan(); iter != nil {
+			key, value, seqno, del, err := iter(false /*fin*/)
+			for err == nil {
+				if seqno >= from && seqno <= till {
+					cs = cs.Add(key, value, seqno, del)
+				}
+				key, value, seqno, del, err = iter(false /*fin*/)
+			}
+			iter(true /*fin*/)
+		}
+		sort.Sort(cs)
+	}
+
+	return func(fin bool) ([]byte, []byte, uint64, bool, error) {
+		if fin {
+			cs, scanned = nil, true
+			return nil, nil, 0, false, io.EOF
+		} else if scanned == false {
+			fill()
+			scanned = true
+		}
+		if len(cs) == 0 {
+			return nil, nil, 0, false, io.EOF
+		}
+		c := cs[0]
+		cs = cs[1:]
+		return c.Key, c.Value, c.Seqno, c.Deleted, nil
+	}
+}
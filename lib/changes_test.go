@@ -0,0 +1,53 @@
+package lib
+
+import "io"
+import "fmt"
+import "sort"
+import "testing"
+
+import "github.com/bnclabs/gostore/api"
+
+func TestSeqnoIterator(t *testing.T) {
+	// entries in key order, with seqno in reverse order.
+	n, cs := 1000, Changes{}
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key%08d", i))
+		cs = cs.Add(key, key, uint64(n-i), i%10 == 0)
+	}
+	nscans := 0
+	scan := func() api.Iterator {
+		nscans++
+		entries := cs
+		return func(fin bool) ([]byte, []byte, uint64, bool, error) {
+			if fin || len(entries) == 0 {
+				return nil, nil, 0, false, io.EOF
+			}
+			c := entries[0]
+			entries = entries[1:]
+			return c.Key, c.Value, c.Seqno, c.Deleted, nil
+		}
+	}
+
+	ref := append(Changes{}, cs...)
+	sort.Sort(ref)
+	from, till := uint64(11), uint64(990)
+	iter := SeqnoIterator(scan, from, till)
+	key, value, seqno, del, err := iter(false /*fin*/)
+	for _, c := range ref[from-1 : till] {
+		if err != nil {
+			t.Fatal(err)
+		} else if string(key) != string(c.Key) {
+			t.Fatalf("expected %q, got %q", c.Key, key)
+		} else if string(value) != string(c.Value) {
+			t.Fatalf("expected %q, got %q", c.Value, value)
+		} else if seqno != c.Seqno || del != c.Deleted {
+			t.Fatalf("expected %v,%v got %v,%v", c.Seqno, c.Deleted, seqno, del)
+		}
+		key, value, seqno, del, err = iter(false /*fin*/)
+	}
+	if err != io.EOF {
+		t.Errorf("expected %v, got %v", io.EOF, err)
+	} else if nscans != 1 {
+		t.Errorf("expected %v, got %v", 1, nscans)
+	}
+}
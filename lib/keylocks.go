@@ -0,0 +1,142 @@
+package lib
+
+import "sync"
+import "time"
+
+import "github.com/bnclabs/gostore/api"
+
+// Keylocks exclusive locks on keys, held by owners like transactions.
+// An owner can lock any number of keys and shall release all of them
+// together via Unlockall. Deadlocks are detected by walking the chain
+// of owners waiting for each other's locks.
+type Keylocks struct {
+	mu      sync.Mutex
+	locks   map[string]*keylock
+	owned   map[interface{}][]string
+	waiting map[interface{}]string // owner -> key it is waiting on.
+
+	// stats
+	n_locks    int64
+	n_waits    int64
+	n_timeouts int64
+	n_deadlock int64
+}
+
+type keylock struct {
+	owner  interface{}
+	donech chan struct{} // closed when lock is released.
+}
+
+// NewKeylocks create a new table of key locks.
+func NewKeylocks() *Keylocks {
+	return &Keylocks{
+		locks:   make(map[string]*keylock),
+		owned:   make(map[interface{}][]string),
+		waiting: make(map[interface{}]string),
+	}
+}
+
+// Lock key for owner, wait for upto timeout if key is locked by another
+// owner. If timeout is ZERO, wait until the lock is released. Return
+// ErrorLocktimeout if lock could not be acquired within timeout, and
+// ErrorDeadlock if waiting would deadlock with other owners. Locking
+// a key already held by owner is a no-op.
+func (kl *Keylocks) Lock(
+	owner interface{}, key []byte, timeout time.Duration) error {
+
+	var timeoutch <-chan time.Time
+
+	kl.mu.Lock()
+	for {
+		lock, ok := kl.locks[string(key)]
+		if !ok {
+			k := string(key)
+			kl.locks[k] = &keylock{owner: owner, donech: make(chan struct{})}
+			kl.owned[owner] = append(kl.owned[owner], k)
+			delete(kl.waiting, owner)
+			kl.n_locks++
+			kl.mu.Unlock()
+			return nil
+
+		} else if lock.owner == owner {
+			kl.mu.Unlock()
+			return nil
+
+		} else if kl.isdeadlock(owner, lock.owner) {
+			delete(kl.waiting, owner)
+			kl.n_deadlock++
+			kl.mu.Unlock()
+			return api.ErrorDeadlock
+		}
+
+		kl.waiting[owner] = string(key)
+		kl.n_waits++
+		kl.mu.Unlock()
+
+		if timeout > 0 && timeoutch == nil {
+			timer := time.NewTimer(timeout)
+			defer timer.Stop()
+			timeoutch = timer.C
+		}
+		select {
+		case <-lock.donech:
+		case <-timeoutch:
+			kl.mu.Lock()
+			delete(kl.waiting, owner)
+			kl.n_timeouts++
+			kl.mu.Unlock()
+			return api.ErrorLocktimeout
+		}
+		kl.mu.Lock()
+	}
+}
+
+// Unlockall release all locks held by owner, and wake up owners
+// waiting on them.
+func (kl *Keylocks) Unlockall(owner interface{}) {
+	kl.mu.Lock()
+	defer kl.mu.Unlock()
+
+	for _, k := range kl.owned[owner] {
+		if lock, ok := kl.locks[k]; ok && lock.owner == owner {
+			close(lock.donech)
+			delete(kl.locks, k)
+		}
+	}
+	delete(kl.owned, owner)
+	delete(kl.waiting, owner)
+}
+
+// Stats return number of locks held and statistics on lock waits.
+func (kl *Keylocks) Stats() map[string]interface{} {
+	kl.mu.Lock()
+	defer kl.mu.Unlock()
+
+	return map[string]interface{}{
+		"n_active":   int64(len(kl.locks)),
+		"n_locks":    kl.n_locks,
+		"n_waits":    kl.n_waits,
+		"n_timeouts": kl.n_timeouts,
+		"n_deadlock": kl.n_deadlock,
+	}
+}
+
+// isdeadlock follow the chain of owners, starting from holder, waiting
+// for locks, if the chain leads back to owner, waiting would deadlock.
+func (kl *Keylocks) isdeadlock(owner, holder interface{}) bool {
+	for i := 0; i <= len(kl.waiting); i++ {
+		if holder == owner {
+			return true
+		}
+		key, ok := kl.waiting[holder]
+		if !ok {
+			return false
+		}
+		lock, ok := kl.locks[key]
+		if !ok {
+			return false
+		}
+		holder = lock.owner
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package lib
+
+import "time"
+import "testing"
+
+import "github.com/bnclabs/gostore/api"
+
+func TestKeylocks(t *testing.T) {
+	kl := NewKeylocks()
+	owner1, owner2 := "owner1", "owner2"
+	key1 := []byte("key1")
+
+	if err := kl.Lock(owner1, key1, time.Second); err != nil {
+		t.Fatal(err)
+	} else if err := kl.Lock(owner1, key1, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	// timeout
+	start := time.Now()
+	if err := kl.Lock(owner2, key1, 10*time.Millisecond); err == nil {
+		t.Errorf("expected %v", api.ErrorLocktimeout)
+	} else if err != api.ErrorLocktimeout {
+		t.Errorf("expected %v, got %v", api.ErrorLocktimeout, err)
+	} else if took := time.Since(start); took < 10*time.Millisecond {
+		t.Errorf("unexpected %v", took)
+	}
+
+	// wait for release
+	donech := make(chan error)
+	go func() { donech <- kl.Lock(owner2, key1, 0) }()
+	time.Sleep(10 * time.Millisecond)
+	kl.Unlockall(owner1)
+	if err := <-donech; err != nil {
+		t.Errorf("unexpected %v", err)
+	}
+
+	stats := kl.Stats()
+	if x := stats["n_active"].(int64); x != 1 {
+		t.Errorf("expected %v, got %v", 1, x)
+	} else if x := stats["n_timeouts"].(int64); x != 1 {
+		t.Errorf("expected %v, got %v", 1, x)
+	}
+	kl.Unlockall(owner2)
+}
+
+func TestKeylocksDeadlock(t *testing.T) {
+	kl := NewKeylocks()
+	owner1, owner2 := "owner1", "owner2"
+	key1, key2 := []byte("key1"), []byte("key2")
+
+	kl.Lock(owner1, key1, 0)
+	kl.Lock(owner2, key2, 0)
+
+	donech := make(chan error)
+	go func() { donech <- kl.Lock(owner1, key2, 0) }()
+	time.Sleep(10 * time.Millisecond)
+	if err := kl.Lock(owner2, key1, 0); err != api.ErrorDeadlock {
+		t.Errorf("expected %v, got %v", api.ErrorDeadlock, err)
+	}
+	kl.Unlockall(owner2)
+	if err := <-donech; err != nil {
+		t.Errorf("unexpected %v", err)
+	}
+	kl.Unlockall(owner1)
+
+	if x := kl.Stats()["n_deadlock"].(int64); x != 1 {
+		t.Errorf("expected %v, got %v", 1, x)
+	} else if x := kl.Stats()["n_active"].(int64); x != 0 {
+		t.Errorf("expected %v, got %v", 0, x)
+	}
+}
@@ -0,0 +1,169 @@
+package lib
+
+import "fmt"
+import "sync"
+import "time"
+import "bytes"
+import "runtime"
+
+// Txntracker track active transactions and views, along with their
+// creation time and caller stack, so that the ones that are held for
+// too long, pinning older snapshots, can be reported or aborted.
+type Txntracker struct {
+	mu        sync.Mutex
+	maxage    time.Duration
+	active    map[interface{}]*tracked
+	lastsweep time.Time
+
+	// stats
+	n_tracked int64
+	n_expired int64
+	n_aborted int64
+}
+
+type tracked struct {
+	id      uint64
+	kind    string
+	born    time.Time
+	pcs     [16]uintptr
+	npcs    int
+	expired bool
+}
+
+// NewTxntracker create a tracker, transactions and views that are
+// active for more than maxage are treated as expired. If maxage is
+// ZERO, tracking is disabled.
+func NewTxntracker(maxage time.Duration) *Txntracker {
+	return &Txntracker{
+		maxage:    maxage,
+		active:    make(map[interface{}]*tracked),
+		lastsweep: time.Now(),
+	}
+}
+
+// Begin tracking handle, kind can be "txn" or "view". Caller stack
+// is captured, skipping `skip` frames above the caller of Begin.
+func (tt *Txntracker) Begin(
+	handle interface{}, id uint64, kind string, skip int) {
+
+	if tt.maxage <= 0 {
+		return
+	}
+	t := &tracked{id: id, kind: kind, born: time.Now()}
+	t.npcs = runtime.Callers(skip+2, t.pcs[:])
+
+	tt.mu.Lock()
+	tt.active[handle] = t
+	tt.n_tracked++
+	tt.mu.Unlock()
+}
+
+// End tracking handle, shall be called when handle is committed or
+// aborted.
+func (tt *Txntracker) End(handle interface{}) {
+	if tt.maxage <= 0 {
+		return
+	}
+	tt.mu.Lock()
+	delete(tt.active, handle)
+	tt.mu.Unlock()
+}
+
+// Expired return whether handle is active for more than maxage.
+func (tt *Txntracker) Expired(handle interface{}) bool {
+	if tt.maxage <= 0 {
+		return false
+	}
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	if t, ok := tt.active[handle]; ok {
+		return time.Since(t.born) > tt.maxage
+	}
+	return false
+}
+
+// Aborted account for an expired handle that was aborted by force.
+func (tt *Txntracker) Aborted() {
+	tt.mu.Lock()
+	tt.n_aborted++
+	tt.mu.Unlock()
+}
+
+// Sweep active handles and return a description, along with caller
+// stack, for every handle that expired since the last sweep. Sweeps
+// are done at most once every second, calling it more often is
+// cheap.
+func (tt *Txntracker) Sweep() []string {
+	if tt.maxage <= 0 {
+		return nil
+	}
+
+	now := time.Now()
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+
+	if now.Sub(tt.lastsweep) < time.Second {
+		return nil
+	}
+	tt.lastsweep = now
+
+	var msgs []string
+	for _, t := range tt.active {
+		if t.expired || now.Sub(t.born) <= tt.maxage {
+			continue
+		}
+		t.expired = true
+		tt.n_expired++
+		msgs = append(msgs, t.describe(now))
+	}
+	return msgs
+}
+
+// Stats return statistics on tracked handles.
+//
+// "maxage", configured max age in nanoseconds.
+// "n_active", number of handles that are not yet committed/aborted.
+// "n_tracked", total number of handles tracked so far.
+// "n_expired", number of handles found active beyond maxage.
+// "n_aborted", number of expired handles aborted by force.
+// "oldest", age of the oldest active handle in nanoseconds.
+// "expired", list of active handles that have expired.
+func (tt *Txntracker) Stats() map[string]interface{} {
+	now := time.Now()
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+
+	oldest, expired := time.Duration(0), []string{}
+	for _, t := range tt.active {
+		if age := now.Sub(t.born); age > oldest {
+			oldest = age
+		}
+		if tt.maxage > 0 && now.Sub(t.born) > tt.maxage {
+			expired = append(expired, t.describe(now))
+		}
+	}
+	return map[string]interface{}{
+		"maxage":    int64(tt.maxage),
+		"n_active":  int64(len(tt.active)),
+		"n_tracked": tt.n_tracked,
+		"n_expired": tt.n_expired,
+		"n_aborted": tt.n_aborted,
+		"oldest":    int64(oldest),
+		"expired":   expired,
+	}
+}
+
+func (t *tracked) describe(now time.Time) string {
+	var buf bytes.Buffer
+
+	fmsg := "%v %v active for %v, created at\n"
+	fmt.Fprintf(&buf, fmsg, t.kind, t.id, now.Sub(t.born))
+	frames := runtime.CallersFrames(t.pcs[:t.npcs])
+	for more := t.npcs > 0; more; {
+		var frame runtime.Frame
+		frame, more = frames.Next()
+		fmsg := "    %v\n        %v:%v\n"
+		fmt.Fprintf(&buf, fmsg, frame.Function, frame.File, frame.Line)
+	}
+	return buf.String()
+}
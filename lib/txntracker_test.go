@@ -0,0 +1,64 @@
+package lib
+
+import "time"
+import "strings"
+import "testing"
+
+func TestTxntracker(t *testing.T) {
+	tt := NewTxntracker(50 * time.Millisecond)
+	txn1, txn2 := "txn1", "txn2"
+	tt.Begin(txn1, 1, "txn", 0)
+	tt.Begin(txn2, 2, "view", 0)
+	if tt.Expired(txn1) {
+		t.Errorf("unexpected expired")
+	} else if msgs := tt.Sweep(); len(msgs) > 0 {
+		t.Errorf("unexpected %v", msgs)
+	}
+	tt.End(txn2)
+
+	time.Sleep(100 * time.Millisecond)
+	tt.lastsweep = tt.lastsweep.Add(-time.Second)
+	if !tt.Expired(txn1) {
+		t.Errorf("expected expired")
+	} else if tt.Expired(txn2) {
+		t.Errorf("unexpected expired")
+	}
+	msgs := tt.Sweep()
+	if len(msgs) != 1 {
+		t.Fatalf("unexpected %v", msgs)
+	} else if !strings.Contains(msgs[0], "TestTxntracker") {
+		t.Errorf("unexpected %v", msgs[0])
+	}
+	// expired handles are reported only once.
+	tt.lastsweep = tt.lastsweep.Add(-time.Second)
+	if msgs := tt.Sweep(); len(msgs) > 0 {
+		t.Errorf("unexpected %v", msgs)
+	}
+
+	tt.Aborted()
+	stats := tt.Stats()
+	if x := stats["n_active"].(int64); x != 1 {
+		t.Errorf("expected %v, got %v", 1, x)
+	} else if x := stats["n_tracked"].(int64); x != 2 {
+		t.Errorf("expected %v, got %v", 2, x)
+	} else if x := stats["n_expired"].(int64); x != 1 {
+		t.Errorf("expected %v, got %v", 1, x)
+	} else if x := stats["n_aborted"].(int64); x != 1 {
+		t.Errorf("expected %v, got %v", 1, x)
+	} else if x := len(stats["expired"].([]string)); x != 1 {
+		t.Errorf("expected %v, got %v", 1, x)
+	} else if x := stats["oldest"].(int64); x < int64(50*time.Millisecond) {
+		t.Errorf("unexpected %v", x)
+	}
+	tt.End(txn1)
+	if x := tt.Stats()["n_active"].(int64); x != 0 {
+		t.Errorf("expected %v, got %v", 0, x)
+	}
+
+	// disabled.
+	tt = NewTxntracker(0)
+	tt.Begin(txn1, 1, "txn", 0)
+	if x := tt.Stats()["n_active"].(int64); x != 0 {
+		t.Errorf("expected %v, got %v", 0, x)
+	}
+}
@@ -4,12 +4,14 @@ import "fmt"
 import "math"
 import "bytes"
 import "errors"
+import "time"
 import "unsafe"
 
 import "github.com/bnclabs/gostore/lib"
 
 type llrbstats struct { // TODO: add json tags.
 	n_count   int64 // number of nodes in the tree
+	n_deleted int64 // number of nodes marked as deleted
 	n_inserts int64
 	n_updates int64
 	n_deletes int64
@@ -134,6 +136,13 @@ type txnsmeta struct {
 	cursors   chan *Cursor
 	txncache  chan *Txn
 	viewcache chan *View
+	// key locks held by transactions, released on commit or abort.
+	keylocks    *lib.Keylocks
+	locktimeout time.Duration
+	// active transactions and views, expired ones are reported and
+	// aborted if txnabort is true.
+	tracker  *lib.Txntracker
+	txnabort bool
 }
 
 func (meta *txnsmeta) inittxns() {
@@ -142,6 +151,8 @@ func (meta *txnsmeta) inittxns() {
 	meta.viewcache = make(chan *View, maxtxns)
 	meta.cursors = make(chan *Cursor, maxtxns*2)
 	meta.records = make(chan *record, maxtxns*5)
+	meta.keylocks = lib.NewKeylocks()
+	meta.tracker = lib.NewTxntracker(0)
 }
 
 func (meta *txnsmeta) gettxn(id uint64, db, snap interface{}) (txn *Txn) {
@@ -163,6 +174,11 @@ func (meta *txnsmeta) gettxn(id uint64, db, snap interface{}) (txn *Txn) {
 }
 
 func (meta *txnsmeta) puttxn(txn *Txn) {
+	meta.tracker.End(txn)
+	meta.keylocks.Unlockall(txn)
+	for key := range txn.locked {
+		delete(txn.locked, key)
+	}
 	for index, head := range txn.writes { // free all records in this txn.
 		for head != nil {
 			next := head.next
@@ -171,10 +187,21 @@ func (meta *txnsmeta) puttxn(txn *Txn) {
 		}
 		delete(txn.writes, index)
 	}
+	for i := range txn.wlog {
+		txn.wlog[i] = nil
+	}
+	txn.wlog, txn.saves = txn.wlog[:0], txn.saves[:0]
+	for _, rec := range txn.reads {
+		txn.putrecord(rec)
+	}
+	txn.reads = txn.reads[:0]
 	for _, cur := range txn.cursors {
 		txn.putcursor(cur)
 	}
 	txn.cursors = txn.cursors[:0]
+	if txn.expired { // application might still hold on to txn.
+		return
+	}
 	select {
 	case meta.txncache <- txn:
 	default: // Left for GC
@@ -200,10 +227,14 @@ func (meta *txnsmeta) getview(id uint64, db, snap interface{}) (view *View) {
 }
 
 func (meta *txnsmeta) putview(view *View) {
+	meta.tracker.End(view)
 	for _, cur := range view.cursors {
 		view.putcursor(cur)
 	}
 	view.cursors = view.cursors[:0]
+	if view.expired { // application might still hold on to view.
+		return
+	}
 	select {
 	case meta.viewcache <- view:
 	default: // Left for GC
@@ -15,12 +15,31 @@ import "github.com/cloudfoundry/gosigar"
 // "allocator" (string, default: "flist")
 //      Type of allocator to use.
 //
+// "locktimeout" (int64, default: 1000)
+//      Used only in MVCC, time period in millisecond, a transaction
+//      shall wait to lock a key in GetForUpdate. If ZERO, wait till
+//      the lock is released.
+//
+// "txnmaxage" (int64, default: 0)
+//      Used only in MVCC, time period in seconds. Transactions and
+//      views active beyond txnmaxage pin older snapshots, they are
+//      logged as warnings along with the stack that created them,
+//      refer to Stats()["txns"]. If ZERO, tracking is disabled.
+//
+// "txnabort" (bool, default: false)
+//      Used only in MVCC, if true, transactions and views active
+//      beyond txnmaxage are aborted on their next call, Commit and
+//      OpenCursor shall return ErrorExpired.
+//
 func Defaultsettings() s.Settings {
 	_, _, freeram := getsysmem()
 	setts := s.Settings{
 		"memcapacity":  freeram,
 		"snapshottick": 4,
 		"allocator":    "flist",
+		"locktimeout":  1000,
+		"txnmaxage":    0,
+		"txnabort":     false,
 	}
 	return setts
 }
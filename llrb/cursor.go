@@ -9,9 +9,11 @@ var _ = fmt.Sprintf("")
 // Cursor object maintains an active pointer into the index. Use OpenCursor
 // on Txn object to create a new cursor.
 type Cursor struct {
-	txn   *Txn
-	ynext bool
-	stack []uintptr
+	txn     *Txn
+	ynext   bool
+	reverse bool // cursor is moving in descending order.
+	root    *Llrbnode
+	stack   []uintptr
 }
 
 func (cur *Cursor) opencursor(txn *Txn, snapshot interface{}, key []byte) *Cursor {
@@ -24,6 +26,7 @@ func (cur *Cursor) opencursor(txn *Txn, snapshot interface{}, key []byte) *Curso
 	case *mvccsnapshot:
 		root = snap.getroot()
 	}
+	cur.root, cur.reverse = root, false
 	cur.stack, cur.ynext = cur.first(root, key, cur.stack), false
 	return cur
 }
@@ -37,7 +40,8 @@ func (cur *Cursor) Key() (key []byte, deleted bool) {
 	}
 	ptr := cur.stack[len(cur.stack)-1]
 	nd := (*Llrbnode)(unsafe.Pointer(ptr & (^uintptr(0x3))))
-	return nd.getkey(), nd.isdeleted()
+	_, deleted = nd.visible()
+	return nd.getkey(), deleted
 }
 
 // Value return current value under the cursor. Returned byte slice will
@@ -49,7 +53,8 @@ func (cur *Cursor) Value() []byte {
 	}
 	ptr := cur.stack[len(cur.stack)-1]
 	nd := (*Llrbnode)(unsafe.Pointer(ptr & (^uintptr(0x3))))
-	return nd.Value()
+	value, _ := nd.visible()
+	return value
 }
 
 // GetNext move cursor to next entry in snapshot and return its key and
@@ -57,10 +62,13 @@ func (cur *Cursor) Value() []byte {
 // must not be used after transaction is committed or aborted.
 func (cur *Cursor) GetNext() (key, value []byte, deleted bool, err error) {
 	//fmt.Println(cur.stack)
-	if len(cur.stack) == 0 {
+	if cur.reverse {
+		cur.stack, cur.reverse = cur.after(cur.stack), false
+	} else if len(cur.stack) == 0 {
 		return nil, nil, false, io.EOF
+	} else {
+		cur.stack = cur.next(cur.stack)
 	}
-	cur.stack = cur.next(cur.stack)
 	if len(cur.stack) == 0 {
 		return nil, nil, false, io.EOF
 	}
@@ -69,6 +77,18 @@ func (cur *Cursor) GetNext() (key, value []byte, deleted bool, err error) {
 	return
 }
 
+// GetPrev move cursor to previous entry in snapshot and return its key
+// and value. Returned byte slices will be a reference to index entry,
+// hence must not be used after transaction is committed or aborted.
+func (cur *Cursor) GetPrev() (key, value []byte, deleted bool, err error) {
+	if cur.moveprev(); len(cur.stack) == 0 {
+		return nil, nil, false, io.EOF
+	}
+	key, deleted = cur.Key()
+	value = cur.Value()
+	return
+}
+
 // Set is an alias to txn.Set call. The current position of the cursor
 // does not affect the set operation.
 func (cur *Cursor) Set(key, value, oldvalue []byte) []byte {
@@ -101,6 +121,10 @@ func (cur *Cursor) Delcursor(lsm bool) {
 func (cur *Cursor) YNext(
 	fin bool) (key, value []byte, seqno uint64, deleted bool, err error) {
 
+	if cur.reverse {
+		cur.stack, cur.reverse = cur.after(cur.stack), false
+		cur.ynext = false
+	}
 	if len(cur.stack) == 0 {
 		return nil, nil, 0, false, io.EOF
 	}
@@ -108,8 +132,8 @@ func (cur *Cursor) YNext(
 		cur.ynext = true
 		ptr := cur.stack[len(cur.stack)-1]
 		nd := (*Llrbnode)(unsafe.Pointer(ptr & (^uintptr(0x3))))
-		key, seqno, deleted = nd.getkey(), nd.getseqno(), nd.isdeleted()
-		value = nd.Value()
+		key, seqno = nd.getkey(), nd.getseqno()
+		value, deleted = nd.visible()
 		return
 	}
 	cur.stack = cur.next(cur.stack)
@@ -118,11 +142,35 @@ func (cur *Cursor) YNext(
 	}
 	ptr := cur.stack[len(cur.stack)-1]
 	nd := (*Llrbnode)(unsafe.Pointer(ptr & (^uintptr(0x3))))
-	key, seqno, deleted = nd.getkey(), nd.getseqno(), nd.isdeleted()
-	value = nd.Value()
+	key, seqno = nd.getkey(), nd.getseqno()
+	value, deleted = nd.visible()
 	return
 }
 
+// YPrev move cursor to previous entry in snapshot and return the
+// entry, along with its seqno. Typically used for descending lsm-sort.
+func (cur *Cursor) YPrev(
+	fin bool) (key, value []byte, seqno uint64, deleted bool, err error) {
+
+	if cur.moveprev(); len(cur.stack) == 0 {
+		return nil, nil, 0, false, io.EOF
+	}
+	ptr := cur.stack[len(cur.stack)-1]
+	nd := (*Llrbnode)(unsafe.Pointer(ptr & (^uintptr(0x3))))
+	key, seqno = nd.getkey(), nd.getseqno()
+	value, deleted = nd.visible()
+	return
+}
+
+func (cur *Cursor) moveprev() {
+	cur.ynext = true
+	if cur.reverse == false { // switch direction.
+		cur.stack, cur.reverse = cur.before(cur.stack), true
+	} else if len(cur.stack) > 0 {
+		cur.stack = cur.prev(cur.stack)
+	}
+}
+
 func (cur *Cursor) first(
 	root *Llrbnode, key []byte, stack []uintptr) []uintptr {
 
@@ -171,3 +219,64 @@ func (cur *Cursor) leftmost(nd *Llrbnode, stack []uintptr) []uintptr {
 	}
 	return stack
 }
+
+// before position the cursor on the entry just before current entry,
+// or on the last entry if cursor has moved past the end.
+func (cur *Cursor) before(stack []uintptr) []uintptr {
+	var key []byte
+	if len(stack) > 0 {
+		ptr := stack[len(stack)-1]
+		key = (*Llrbnode)(unsafe.Pointer(ptr & (^uintptr(0x3)))).getkey()
+	}
+	stack = stack[:0]
+	for nd := cur.root; nd != nil; {
+		ptr := (uintptr)(unsafe.Pointer(nd))
+		if key != nil && nd.gekey(key, false) {
+			stack = append(stack, ptr|0x3)
+			nd = nd.left
+			continue
+		}
+		stack = append(stack, ptr|0x0)
+		nd = nd.right
+	}
+	return cur.popout(stack)
+}
+
+// after position the cursor on the entry just after current entry,
+// or on the first entry if cursor has moved past the beginning.
+func (cur *Cursor) after(stack []uintptr) []uintptr {
+	var key []byte
+	if len(stack) > 0 {
+		ptr := stack[len(stack)-1]
+		key = (*Llrbnode)(unsafe.Pointer(ptr & (^uintptr(0x3)))).getkey()
+	}
+	stack = stack[:0]
+	for nd := cur.root; nd != nil; {
+		ptr := (uintptr)(unsafe.Pointer(nd))
+		if key != nil && nd.lekey(key, false) {
+			stack = append(stack, ptr|0x3)
+			nd = nd.right
+			continue
+		}
+		stack = append(stack, ptr|0x0)
+		nd = nd.left
+	}
+	return cur.popout(stack)
+}
+
+func (cur *Cursor) prev(stack []uintptr) []uintptr {
+	ptr := stack[len(stack)-1]
+	nd := (*Llrbnode)(unsafe.Pointer(ptr & (^uintptr(0x3))))
+	stack[len(stack)-1] = ptr | 0x3
+	stack = cur.rightmost(nd.left, stack)
+	return cur.popout(stack)
+}
+
+func (cur *Cursor) rightmost(nd *Llrbnode, stack []uintptr) []uintptr {
+	if nd != nil {
+		ptr := (uintptr)(unsafe.Pointer(nd)) | 0x0
+		stack = append(stack, ptr)
+		return cur.rightmost(nd.right, stack)
+	}
+	return stack
+}
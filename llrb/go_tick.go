@@ -21,5 +21,6 @@ loop:
 		default:
 		}
 		mvcc.makesnapshot(false /*init*/)
+		mvcc.sweeptxns()
 	}
 }
@@ -8,6 +8,8 @@ type indexentry struct {
 	value   []byte
 	seqno   uint64
 	deleted bool
+	expiry  int64
+	operand bool
 	err     error
 }
 
@@ -20,6 +22,17 @@ func (entry *indexentry) set(
 	copy(entry.value, value)
 
 	entry.seqno, entry.deleted, entry.err = seqno, deleted, err
+	entry.expiry, entry.operand = 0, false
+	return entry
+}
+
+func (entry *indexentry) setexpiry(expiry int64) *indexentry {
+	entry.expiry = expiry
+	return entry
+}
+
+func (entry *indexentry) setoperand(operand bool) *indexentry {
+	entry.operand = operand
 	return entry
 }
 
@@ -38,3 +51,11 @@ func (entry *indexentry) ID() string {
 func (entry *indexentry) Valueref() (valuelen uint64, vlogpos int64) {
 	return uint64(len(entry.value)), -1
 }
+
+func (entry *indexentry) Expiry() int64 {
+	return entry.expiry
+}
+
+func (entry *indexentry) IsOperand() bool {
+	return entry.operand
+}
@@ -7,4 +7,12 @@ func init() {
 	var _ api.Index = &LLRB{}
 	// check whether mvcc confirms to api.Index{} interface.
 	var _ api.Index = &MVCC{}
+	// check whether mvcc confirms to api.Subscriber{} interface.
+	var _ api.Subscriber = &MVCC{}
+	// check whether llrb and mvcc confirms to api.Expirer{} interface.
+	var _ api.Expirer = &LLRB{}
+	var _ api.Expirer = &MVCC{}
+	// check whether llrb and mvcc confirms to api.Merger{} interface.
+	var _ api.Merger = &LLRB{}
+	var _ api.Merger = &MVCC{}
 }
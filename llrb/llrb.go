@@ -29,6 +29,7 @@ type LLRB struct { // tree container
 	valarena  api.Mallocer
 	root      unsafe.Pointer // *Llrbnode
 	seqno     uint64
+	delseqno  uint64 // seqno of the latest non-lsm delete
 	rw        sync.RWMutex
 	finch     chan struct{}
 	txnsmeta
@@ -98,15 +99,11 @@ func (llrb *LLRB) setroot(root *Llrbnode) {
 	llrb.root = unsafe.Pointer(root)
 }
 
-func (llrb *LLRB) newnode(k, v []byte) *Llrbnode {
+func (llrb *LLRB) newnode(k, v []byte, expiry int64) *Llrbnode {
 	ptr := llrb.nodearena.Alloc(int64(nodesize + len(k)))
 	nd := (*Llrbnode)(ptr)
-	nd.setdirty().setred().setkey(k)
-	if len(v) > 0 {
-		ptr = llrb.valarena.Alloc(int64(nvaluesize + len(v)))
-		nv := (*nodevalue)(ptr)
-		nd.setnodevalue(nv.setvalue(v))
-	}
+	nd.setdirty().setred().setkey(k).clearoperand()
+	nd.setnodevalue(newnodevalue(llrb.valarena, v, expiry))
 	llrb.n_nodes++
 	return nd
 }
@@ -148,6 +145,9 @@ func (llrb *LLRB) upsertcounts(key, value []byte, oldnd *Llrbnode) {
 		llrb.n_inserts++
 		return
 	}
+	if oldnd.isdeleted() {
+		atomic.AddInt64(&llrb.n_deleted, -1)
+	}
 	llrb.n_updates++
 	llrb.keymemory -= int64(len(oldnd.getkey()))
 	if nv := oldnd.nodevalue(); nv != nil {
@@ -161,6 +161,9 @@ func (llrb *LLRB) delcounts(nd *Llrbnode) {
 		if nv := nd.nodevalue(); nv != nil {
 			llrb.valmemory -= int64(len(nv.value()))
 		}
+		if nd.isdeleted() {
+			atomic.AddInt64(&llrb.n_deleted, -1)
+		}
 		atomic.AddInt64(&llrb.n_count, -1)
 		llrb.n_deletes++
 	}
@@ -201,17 +204,70 @@ func (llrb *LLRB) Getseqno() uint64 {
 // its value will be over-written. Make sure key is not nil.
 // Return old value if oldvalue points to valid buffer.
 func (llrb *LLRB) Set(key, value, oldvalue []byte) (ov []byte, cas uint64) {
+	return llrb.SetExpiry(key, value, oldvalue, 0)
+}
+
+// SetExpiry is same as Set, additionally the entry shall expire at
+// `expiry`, unix time in seconds. Expired entries are reported as
+// deleted, without value. If expiry is ZERO, entry never expires.
+func (llrb *LLRB) SetExpiry(
+	key, value, oldvalue []byte, expiry int64) (ov []byte, cas uint64) {
+
 	if !llrb.lock() {
 		return
 	}
+	ov, cas = llrb.set(key, value, oldvalue, expiry, false /*operand*/)
+	llrb.unlock()
+	return ov, cas
+}
+
+// Merge operand into key's value using merge operator. If key is
+// missing and lsm is true, operand is remembered as merge operand, to
+// be folded over older versions of the key in other lsm levels, else
+// operand is folded over nil value.
+func (llrb *LLRB) Merge(
+	key, operand []byte, merge api.MergeOperator, lsm bool) uint64 {
+
+	if !llrb.lock() {
+		return 0
+	}
+
+	value, isoperand := operand, lsm
+	if nd, ok := llrb.getkey(llrb.getroot(), key); ok {
+		// node's value is freed by upsert, fold over a copy.
+		val, deleted := nd.visible()
+		if deleted {
+			val = nil
+		}
+		val = append([]byte(nil), val...)
+		value, isoperand = merge(key, val, operand), nd.isoperand()
+		isoperand = isoperand && !deleted
+	} else if lsm == false {
+		value = merge(key, nil, operand)
+	}
+	_, cas := llrb.set(key, value, nil, 0 /*expiry*/, isoperand)
+
+	llrb.unlock()
+	return cas
+}
+
+func (llrb *LLRB) set(
+	key, value, oldvalue []byte,
+	expiry int64, operand bool) (ov []byte, cas uint64) {
 
 	llrb.seqno++
 
-	root, newnd, oldnd := llrb.upsert(llrb.getroot(), 1 /*depth*/, key, value)
+	root, depth := llrb.getroot(), int64(1)
+	root, newnd, oldnd := llrb.upsert(root, depth, key, value, expiry)
 	root.setblack()
 	newnd.cleardeleted()
 	newnd.cleardirty()
 	newnd.setseqno(llrb.seqno)
+	if operand {
+		newnd.setoperand()
+	} else {
+		newnd.clearoperand()
+	}
 	seqno := llrb.seqno
 
 	llrb.setroot(root)
@@ -228,19 +284,18 @@ func (llrb *LLRB) Set(key, value, oldvalue []byte) (ov []byte, cas uint64) {
 
 	llrb.freenode(oldnd)
 
-	llrb.unlock()
 	return oldvalue, seqno
 }
 
 // returns root, newnd, oldnd
 func (llrb *LLRB) upsert(
 	nd *Llrbnode, depth int64,
-	key, value []byte) (root, oldnd, newnd *Llrbnode) {
+	key, value []byte, expiry int64) (root, oldnd, newnd *Llrbnode) {
 
 	var dirty bool
 
 	if nd == nil {
-		newnd := llrb.newnode(key, value)
+		newnd := llrb.newnode(key, value, expiry)
 		llrb.h_upsertdepth.Add(depth)
 		return newnd, newnd, nil
 	}
@@ -248,19 +303,20 @@ func (llrb *LLRB) upsert(
 	nd = llrb.walkdownrot23(nd)
 
 	if nd.gtkey(key, false) {
-		nd.left, newnd, oldnd = llrb.upsert(nd.left, depth+1, key, value)
+		nd.left, newnd, oldnd =
+			llrb.upsert(nd.left, depth+1, key, value, expiry)
 	} else if nd.ltkey(key, false) {
-		nd.right, newnd, oldnd = llrb.upsert(nd.right, depth+1, key, value)
+		nd.right, newnd, oldnd =
+			llrb.upsert(nd.right, depth+1, key, value, expiry)
 	} else {
 		oldnd, dirty = llrb.clonenode(nd), false
 		if nv := nd.nodevalue(); nv != nil { // free the value if present
 			llrb.valarena.Free(unsafe.Pointer(nv))
 			nd, dirty = nd.setnodevalue(nil), true
 		}
-		if len(value) > 0 { // add new value if req.
-			ptr := llrb.valarena.Alloc(int64(nvaluesize + len(value)))
-			nv := (*nodevalue)(ptr)
-			nd, dirty = nd.setnodevalue(nv.setvalue(value)), true
+		// add new value, and expiry, if req.
+		if nv := newnodevalue(llrb.valarena, value, expiry); nv != nil {
+			nd, dirty = nd.setnodevalue(nv), true
 		}
 		newnd = nd
 		if dirty {
@@ -306,6 +362,7 @@ func (llrb *LLRB) setcas(
 	newnd.cleardeleted()
 	newnd.cleardirty()
 	newnd.setseqno(llrb.seqno)
+	newnd.clearoperand()
 	seqno := llrb.seqno
 
 	llrb.setroot(root)
@@ -336,7 +393,7 @@ func (llrb *LLRB) upsertcas(
 		return nil, nil, nil, api.ErrorInvalidCAS
 
 	} else if nd == nil { // Expected a create
-		newnd := llrb.newnode(key, value)
+		newnd := llrb.newnode(key, value, 0 /*expiry*/)
 		llrb.h_upsertdepth.Add(depth)
 		return newnd, newnd, nil, nil
 	}
@@ -368,10 +425,9 @@ func (llrb *LLRB) upsertcas(
 				llrb.valarena.Free(unsafe.Pointer(nv))
 				nd, dirty = nd.setnodevalue(nil), true
 			}
-			if len(value) > 0 { // add new value if req.
-				ptr := llrb.valarena.Alloc(int64(nvaluesize + len(value)))
-				nv := (*nodevalue)(ptr)
-				nd, dirty = nd.setnodevalue(nv.setvalue(value)), true
+			// add new value if req.
+			if nv := newnodevalue(llrb.valarena, value, 0); nv != nil {
+				nd, dirty = nd.setnodevalue(nv), true
 			}
 			newnd = nd
 			if dirty {
@@ -410,7 +466,10 @@ func (llrb *LLRB) dodelete(key, oldvalue []byte, lsm bool) ([]byte, uint64) {
 	seqno := llrb.seqno
 	if lsm {
 		if nd, ok := llrb.getkey(llrb.getroot(), key); ok {
-			nd.setseqnodeleted(llrb.seqno)
+			if nd.isdeleted() == false {
+				atomic.AddInt64(&llrb.n_deleted, 1)
+			}
+			nd.setseqnodeleted(llrb.seqno).clearoperand()
 			if oldvalue != nil {
 				val = nd.Value()
 				oldvalue = lib.Fixbuffer(oldvalue, int64(len(val)))
@@ -418,7 +477,7 @@ func (llrb *LLRB) dodelete(key, oldvalue []byte, lsm bool) ([]byte, uint64) {
 			}
 
 		} else {
-			root, newnd, oldnd := llrb.upsert(root, 1 /*depth*/, key, nil)
+			root, newnd, oldnd := llrb.upsert(root, 1, key, nil, 0 /*expiry*/)
 			if oldnd != nil {
 				panic("impossible situation")
 			}
@@ -428,6 +487,7 @@ func (llrb *LLRB) dodelete(key, oldvalue []byte, lsm bool) ([]byte, uint64) {
 			newnd.setseqno(llrb.seqno)
 			llrb.setroot(root)
 			llrb.upsertcounts(key, nil, oldnd /*nil*/)
+			atomic.AddInt64(&llrb.n_deleted, 1)
 		}
 
 	} else {
@@ -437,6 +497,9 @@ func (llrb *LLRB) dodelete(key, oldvalue []byte, lsm bool) ([]byte, uint64) {
 		}
 		llrb.setroot(root)
 		llrb.delcounts(deleted)
+		if deleted != nil {
+			llrb.delseqno = seqno
+		}
 		if deleted != nil && oldvalue != nil {
 			val = deleted.Value()
 			oldvalue = lib.Fixbuffer(oldvalue, int64(len(val)))
@@ -601,27 +664,41 @@ func (llrb *LLRB) Get(
 	if !llrb.rlock() {
 		return
 	}
-	value, cas, deleted, ok = llrb.get(key, value)
+	value, cas, deleted, _, ok = llrb.get(key, value)
 	llrb.runlock()
 	return value, cas, deleted, ok
 }
 
+// Getoperand is same as Get, additionally return whether value is a
+// merge operand, yet to be folded over older versions of the key.
+func (llrb *LLRB) Getoperand(
+	key, value []byte) (v []byte, cas uint64, deleted, operand, ok bool) {
+
+	if !llrb.rlock() {
+		return
+	}
+	value, cas, deleted, operand, ok = llrb.get(key, value)
+	llrb.runlock()
+	return value, cas, deleted, operand, ok
+}
+
 func (llrb *LLRB) get(
-	key, value []byte) (v []byte, cas uint64, deleted, ok bool) {
+	key, value []byte) (v []byte, cas uint64, deleted, operand, ok bool) {
 
 	deleted, seqno := false, uint64(0)
 	nd, ok := llrb.getkey(llrb.getroot(), key)
 	if ok {
+		var val []byte
+		val, deleted = nd.visible()
 		if value != nil {
-			val := nd.Value()
 			value = lib.Fixbuffer(value, int64(len(val)))
 			copy(value, val)
 		}
-		seqno, deleted = nd.getseqno(), nd.isdeleted()
+		seqno, operand = nd.getseqno(), nd.isoperand()
 	} else if value != nil {
 		value = lib.Fixbuffer(value, 0)
 	}
-	return value, seqno, deleted, ok
+	return value, seqno, deleted, operand, ok
 }
 
 func (llrb *LLRB) getkey(nd *Llrbnode, k []byte) (*Llrbnode, bool) {
@@ -642,32 +719,58 @@ func (llrb *LLRB) getkey(nd *Llrbnode, k []byte) (*Llrbnode, bool) {
 // reaching end of table (io.EOF), application should call iterator
 // with fin as true. EG: iter(true)
 func (llrb *LLRB) Scan() api.Iterator {
-	currkey := []byte(nil)
-	sb := makescanbuf()
+	return llrb.ScanRange(nil, nil, false)
+}
+
+// ScanRange return an iterator from low key, inclusive, upto high
+// key. If inclusive is true, entry with high key is also included.
+// If low is nil, iteration starts from first entry, and if high is
+// nil, iteration continues till last entry. If iteration is stopped
+// before reaching the end of range (io.EOF), application should call
+// iterator with fin as true. EG: iter(true)
+func (llrb *LLRB) ScanRange(low, high []byte, inclusive bool) api.Iterator {
+	iter := llrb.ScanOperands(low, high, inclusive)
+	return func(fin bool) ([]byte, []byte, uint64, bool, error) {
+		key, value, seqno, deleted, _, err := iter(fin)
+		return key, value, seqno, deleted, err
+	}
+}
+
+// ScanOperands is same as ScanRange, additionally return whether each
+// value is a merge operand, yet to be folded over older versions of
+// the key.
+func (llrb *LLRB) ScanOperands(
+	low, high []byte, inclusive bool) api.MergeIterator {
+
+	currkey := append([]byte(nil), low...)
+	sb := makescanbuf().setrange(high, inclusive)
 
 	var err error
-	leseqno := llrb.startscan(nil, sb, 0)
+	now := time.Now().Unix()
+	leseqno := llrb.startscan(low, true /*ge*/, sb, 0)
 
-	return func(fin bool) ([]byte, []byte, uint64, bool, error) {
+	return func(fin bool) ([]byte, []byte, uint64, bool, bool, error) {
 		if err != nil {
-			return nil, nil, 0, false, err
+			return nil, nil, 0, false, false, err
 		} else if fin {
 			err, sb = io.EOF, nil
-			return nil, nil, 0, false, err
+			return nil, nil, 0, false, false, err
 		}
 
-		key, value, seqno, deleted := sb.pop()
+		key, value, seqno, deleted, operand, expiry := sb.pop()
 		if key == nil {
-			llrb.startscan(currkey, sb, leseqno)
-			key, value, seqno, deleted = sb.pop()
+			llrb.startscan(currkey, false /*ge*/, sb, leseqno)
+			key, value, seqno, deleted, operand, expiry = sb.pop()
 		}
 		currkey = lib.Fixbuffer(currkey, int64(len(key)))
 		copy(currkey, key)
 		if key == nil {
 			err, sb = io.EOF, nil
-			return nil, nil, 0, false, err
+			return nil, nil, 0, false, false, err
+		} else if isexpired(expiry, now) {
+			value, deleted = nil, true
 		}
-		return key, value, seqno, deleted, nil
+		return key, value, seqno, deleted, operand, nil
 	}
 }
 
@@ -679,7 +782,7 @@ func (llrb *LLRB) ScanEntries() api.EntryIterator {
 	sb := makescanbuf()
 
 	re := &indexentry{id: llrb.ID()}
-	leseqno := llrb.startscan(nil, sb, 0)
+	leseqno := llrb.startscan(nil, true /*ge*/, sb, 0)
 
 	return func(fin bool) api.IndexEntry {
 		if re.err != nil {
@@ -690,10 +793,10 @@ func (llrb *LLRB) ScanEntries() api.EntryIterator {
 			return re.set(nil, nil, 0, false, io.EOF)
 		}
 
-		key, value, seqno, deleted := sb.pop()
+		key, value, seqno, deleted, operand, expiry := sb.pop()
 		if key == nil { // prefetch is nil
-			llrb.startscan(currkey, sb, leseqno)
-			key, value, seqno, deleted = sb.pop()
+			llrb.startscan(currkey, false /*ge*/, sb, leseqno)
+			key, value, seqno, deleted, operand, expiry = sb.pop()
 		}
 
 		if key == nil { // iteration has finished
@@ -702,20 +805,25 @@ func (llrb *LLRB) ScanEntries() api.EntryIterator {
 		}
 		currkey = lib.Fixbuffer(currkey, int64(len(key)))
 		copy(currkey, key)
-		return re.set(key, value, seqno, deleted, nil)
+		re.set(key, value, seqno, deleted, nil).setexpiry(expiry)
+		return re.setoperand(operand)
 	}
 }
 
-func (llrb *LLRB) startscan(key []byte, sb *scanbuf, leseqno uint64) uint64 {
+// startscan fill sb with entries after key, or from key if ge is true,
+// which is the case for first batch of a scan.
+func (llrb *LLRB) startscan(
+	key []byte, ge bool, sb *scanbuf, leseqno uint64) uint64 {
+
 	if !llrb.rlock() {
 		return leseqno
 	}
-	if key == nil {
+	if ge {
 		leseqno = llrb.seqno
 	}
 
 	sb.preparewrite()
-	llrb.scan(llrb.getroot(), key, sb, leseqno)
+	llrb.scan(llrb.getroot(), key, ge, sb, leseqno)
 	sb.prepareread()
 
 	llrb.runlock()
@@ -723,25 +831,31 @@ func (llrb *LLRB) startscan(key []byte, sb *scanbuf, leseqno uint64) uint64 {
 }
 
 func (llrb *LLRB) scan(
-	nd *Llrbnode, key []byte, sb *scanbuf, leseqno uint64) bool {
+	nd *Llrbnode, key []byte, ge bool, sb *scanbuf, leseqno uint64) bool {
 
 	if nd == nil {
 		return true
 	}
-	if key != nil && nd.lekey(key, false) {
-		return llrb.scan(nd.right, key, sb, leseqno)
+	if key != nil && ge && nd.ltkey(key, false) {
+		return llrb.scan(nd.right, key, ge, sb, leseqno)
+	} else if key != nil && !ge && nd.lekey(key, false) {
+		return llrb.scan(nd.right, key, ge, sb, leseqno)
 	}
-	if !llrb.scan(nd.left, key, sb, leseqno) {
+	if !llrb.scan(nd.left, key, ge, sb, leseqno) {
+		return false
+	} else if sb.beyond(nd) { // rest of the tree is beyond high key.
 		return false
 	}
 	seqno := nd.getseqno()
 	if seqno <= leseqno {
-		n := sb.append(nd.getkey(), nd.Value(), seqno, nd.isdeleted())
+		key, value := nd.getkey(), nd.Value()
+		deleted, operand := nd.isdeleted(), nd.isoperand()
+		n := sb.append(key, value, seqno, deleted, operand, nd.getexpiry())
 		if n >= scanlimit {
 			return false
 		}
 	}
-	return llrb.scan(nd.right, key, sb, leseqno)
+	return llrb.scan(nd.right, key, ge, sb, leseqno)
 }
 
 //---- Exported Control methods
@@ -756,6 +870,21 @@ func (llrb *LLRB) Count() int64 {
 	return atomic.LoadInt64(&llrb.n_count)
 }
 
+// ApproximateSize return an estimate of bytes, in keys and values,
+// held by entries between low and high, both inclusive, without
+// iterating on the entries. Estimate is proportional to the position
+// of low and high in the tree. If low is nil, range starts from the
+// first key, if high is nil, range extends till the last key.
+func (llrb *LLRB) ApproximateSize(low, high []byte) int64 {
+	if !llrb.rlock() {
+		return 0
+	}
+	payload := llrb.keymemory + llrb.valmemory
+	size := llrb.getroot().rangesize(low, high, payload)
+	llrb.runlock()
+	return size
+}
+
 // Dotdump to convert whole tree into dot script that can be
 // visualized using graphviz. Until dotdump exits concurrent write
 // operations will block.
@@ -788,6 +917,8 @@ func (llrb *LLRB) Stats() map[string]interface{} {
 func (llrb *LLRB) stats() map[string]interface{} {
 	m := make(map[string]interface{})
 	m["n_count"] = atomic.LoadInt64(&llrb.n_count)
+	m["n_deleted"] = atomic.LoadInt64(&llrb.n_deleted)
+	m["delseqno"] = llrb.delseqno
 	m["n_inserts"] = llrb.n_inserts
 	m["n_updates"] = llrb.n_updates
 	m["n_deletes"] = llrb.n_deletes
@@ -930,6 +1061,7 @@ func (llrb *LLRB) Clone(name string) *LLRB {
 	newllrb.llrbstats = llrb.llrbstats
 	newllrb.h_upsertdepth = llrb.h_upsertdepth.Clone()
 	newllrb.seqno = llrb.seqno
+	newllrb.delseqno = llrb.delseqno
 
 	newllrb.setroot(newllrb.clonetree(llrb.getroot()))
 
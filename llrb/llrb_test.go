@@ -4,6 +4,9 @@ import "io"
 import "fmt"
 import "bytes"
 import "testing"
+import "time"
+import "strings"
+import "strconv"
 import "io/ioutil"
 import "encoding/json"
 import "encoding/binary"
@@ -909,6 +912,60 @@ func TestLLRBView(t *testing.T) {
 	}
 }
 
+func TestLLRBTxnSavepoint(t *testing.T) {
+	llrb := NewLLRB("txn", Defaultsettings())
+	defer llrb.Destroy()
+
+	key1, key2, key3 := []byte("key1"), []byte("key2"), []byte("key3")
+	llrb.Set(key1, []byte("value1"), nil)
+
+	txn := llrb.BeginTxn(0x1234).(*Txn)
+	txn.Set(key2, []byte("value2"), nil)
+	sp1 := txn.Savepoint()
+	txn.Set(key1, []byte("value11"), nil)
+	txn.Set(key3, []byte("value3"), nil)
+	sp2 := txn.Savepoint()
+	txn.Delete(key2, nil, false)
+	txn.Set(key3, []byte("value33"), nil)
+
+	if err := txn.RollbackTo(sp2); err != nil {
+		t.Fatal(err)
+	}
+	if v, _, _, _ := txn.Get(key2, []byte{}); string(v) != "value2" {
+		t.Errorf("unexpected %s", v)
+	} else if v, _, _, _ := txn.Get(key3, []byte{}); string(v) != "value3" {
+		t.Errorf("unexpected %s", v)
+	}
+	if err := txn.RollbackTo(sp1); err != nil {
+		t.Fatal(err)
+	} else if err := txn.RollbackTo(sp2); err != api.ErrorInvalidSavepoint {
+		t.Errorf("expected %v, got %v", api.ErrorInvalidSavepoint, err)
+	}
+	if v, _, _, _ := txn.Get(key1, []byte{}); string(v) != "value1" {
+		t.Errorf("unexpected %s", v)
+	} else if _, _, _, ok := txn.Get(key3, []byte{}); ok {
+		t.Errorf("unexpected %s", key3)
+	}
+	// rollback to the same savepoint again.
+	txn.Set(key3, []byte("value333"), nil)
+	if err := txn.RollbackTo(sp1); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if v, _, _, _ := llrb.Get(key1, []byte{}); string(v) != "value1" {
+		t.Errorf("unexpected %s", v)
+	} else if v, _, _, _ := llrb.Get(key2, []byte{}); string(v) != "value2" {
+		t.Errorf("unexpected %s", v)
+	} else if _, _, _, ok := llrb.Get(key3, []byte{}); ok {
+		t.Errorf("unexpected %s", key3)
+	} else if x := llrb.Count(); x != 2 {
+		t.Errorf("expected %v, got %v", 2, x)
+	}
+}
+
 func TestLLRBTxnCursor1(t *testing.T) {
 	llrb := NewLLRB("view", Defaultsettings())
 	defer llrb.Destroy()
@@ -1159,6 +1216,91 @@ func TestLLRBScan(t *testing.T) {
 	}
 }
 
+func TestLLRBScanRange(t *testing.T) {
+	llrb := NewLLRB("scanrange", Defaultsettings())
+	defer llrb.Destroy()
+
+	n, keys := 1000, [][]byte{}
+	for i := 0; i < n; i++ {
+		k := []byte(fmt.Sprintf("key%08v", i*2))
+		v := []byte(fmt.Sprintf("val%08v", i*2))
+		llrb.Set(k, v, nil)
+		keys = append(keys, k)
+	}
+
+	mkkey := func(i int) []byte {
+		return []byte(fmt.Sprintf("key%08v", i))
+	}
+	testcases := [][]interface{}{
+		{[]byte(nil), []byte(nil), false},
+		{[]byte(nil), mkkey(100), false},
+		{[]byte(nil), mkkey(100), true},
+		{mkkey(100), []byte(nil), true},
+		{mkkey(101), []byte(nil), true},
+		{mkkey(100), mkkey(200), false},
+		{mkkey(100), mkkey(200), true},
+		{mkkey(99), mkkey(201), true},
+		{mkkey(100), mkkey(100), true},
+		{mkkey(100), mkkey(100), false},
+		{mkkey(200), mkkey(100), true},
+		{mkkey(2 * n), []byte(nil), false},
+		{[]byte("a"), []byte("z"), false},
+	}
+	for _, tcase := range testcases {
+		low, high := tcase[0].([]byte), tcase[1].([]byte)
+		incl := tcase[2].(bool)
+
+		refkeys := [][]byte{}
+		for _, key := range keys {
+			if low != nil && bytes.Compare(key, low) < 0 {
+				continue
+			} else if high != nil && bytes.Compare(key, high) > 0 {
+				continue
+			} else if !incl && bytes.Compare(key, high) == 0 {
+				continue
+			}
+			refkeys = append(refkeys, key)
+		}
+
+		count := 0
+		iter := llrb.ScanRange(low, high, incl)
+		key, _, _, _, err := iter(false /*fin*/)
+		for ; err == nil; key, _, _, _, err = iter(false /*fin*/) {
+			if count >= len(refkeys) {
+				t.Fatalf("%q-%q unexpected %q", low, high, key)
+			} else if bytes.Compare(key, refkeys[count]) != 0 {
+				t.Fatalf("expected %q, got %q", refkeys[count], key)
+			}
+			count++
+		}
+		if err != io.EOF {
+			t.Errorf("unexpected %v", err)
+		} else if count != len(refkeys) {
+			t.Errorf("expected %v, got %v", len(refkeys), count)
+		}
+		iter(true /*fin*/)
+	}
+}
+
+func TestLLRBCursorGetPrev(t *testing.T) {
+	llrb := NewLLRB("getprev", Defaultsettings())
+	defer llrb.Destroy()
+
+	n, keys := 1000, [][]byte{}
+	for i := 0; i < n; i++ {
+		k := []byte(fmt.Sprintf("key%08v", i*2))
+		v := []byte(fmt.Sprintf("val%08v", i*2))
+		llrb.Set(k, v, nil)
+		keys = append(keys, k)
+	}
+
+	for i := -1; i <= 2*n; i += 7 {
+		view := llrb.View(0)
+		testcursorprev(t, view, i, keys)
+		view.Abort()
+	}
+}
+
 func TestLLRBScanEntries(t *testing.T) {
 	load := func(n int, llrb *LLRB) {
 		for i := 0; i < n; i++ {
@@ -1494,6 +1636,308 @@ func testynext(t *testing.T, cur api.Cursor, from int, keys, vals []string) {
 	}
 }
 
+// testcursorprev walk the cursor back and forth, and match it with
+// the position in keys. Cursor is opened at seekkey i.
+func testcursorprev(t *testing.T, view api.Transactor, i int, keys [][]byte) {
+	seekkey := []byte(fmt.Sprintf("key%08v", i))
+	if i < 0 {
+		seekkey = nil
+	}
+	pos := 0 // position of the entry just after the cursor.
+	for pos < len(keys) && bytes.Compare(keys[pos], seekkey) < 0 {
+		pos++
+	}
+	cur, err := view.OpenCursor(seekkey)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	moves := "ppnpppnnnnPPPPpnnnnnnnnnnppppppppppppppppppppppppppppppppp"
+	moves += strings.Repeat("n", 2*len(keys)) + "pppnnnnp"
+	moves += strings.Repeat("P", 2*len(keys)) + "npnnnpP"
+	yielded := false
+	for j, move := range moves {
+		var key []byte
+		var err error
+		switch move {
+		case 'n':
+			if yielded && pos < len(keys) {
+				pos++
+			}
+			key, _, _, _, err = cur.YNext(false /*fin*/)
+		case 'p':
+			if pos >= 0 {
+				pos--
+			}
+			key, _, _, _, err = cur.YPrev(false /*fin*/)
+		case 'P':
+			if pos >= 0 {
+				pos--
+			}
+			key, _, _, err = cur.GetPrev()
+		}
+		yielded = true
+
+		if pos < 0 || pos >= len(keys) {
+			if err != io.EOF {
+				t.Errorf("%q move %v expected EOF, got %q %v", seekkey, j, key, err)
+				return
+			}
+		} else if err != nil {
+			t.Errorf("%q move %v unexpected %v", seekkey, j, err)
+			return
+		} else if bytes.Compare(key, keys[pos]) != 0 {
+			t.Errorf("%q move %v expected %q, got %q", seekkey, j, keys[pos], key)
+			return
+		}
+	}
+}
+
+func TestLLRBExpiry(t *testing.T) {
+	llrb := NewLLRB("expiry", Defaultsettings())
+	defer llrb.Destroy()
+
+	testexpiry(t, llrb, llrb.SetExpiry, func() {})
+}
+
+// testexpiry set entries that have expired, entries that shall expire
+// in future and entries without expiry, and verify Get, Scan and
+// ScanEntries. catchup shall wait for reads to include all writes.
+func testexpiry(
+	t *testing.T, index api.Index,
+	setexpiry func([]byte, []byte, []byte, int64) ([]byte, uint64),
+	catchup func()) {
+
+	n := 1000
+	now := time.Now().Unix()
+	past, future := now-10, now+3600
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key%08v", i))
+		switch i % 4 {
+		case 0:
+			setexpiry(key, key, nil, past)
+		case 1:
+			setexpiry(key, key, nil, future)
+		case 2:
+			index.Set(key, key, nil)
+		case 3: // expired entry over-written by set.
+			setexpiry(key, key, nil, past)
+			index.Set(key, key, nil)
+		}
+	}
+	catchup()
+
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key%08v", i))
+		val, _, deleted, ok := index.Get(key, []byte{})
+		if ok == false {
+			t.Errorf("%q missing", key)
+		} else if i%4 == 0 && (deleted == false || len(val) > 0) {
+			t.Errorf("%q expected expired, got %v %q", key, deleted, val)
+		} else if i%4 != 0 && deleted {
+			t.Errorf("%q unexpected deleted", key)
+		} else if i%4 != 0 && bytes.Compare(key, val) != 0 {
+			t.Errorf("%q expected %q, got %q", key, key, val)
+		}
+	}
+
+	iter, count := index.Scan(), 0
+	for key, val, _, deleted, err := iter(false); err == nil; {
+		if count%4 == 0 && (deleted == false || len(val) > 0) {
+			t.Errorf("%q expected expired, got %v %q", key, deleted, val)
+		} else if count%4 != 0 && deleted {
+			t.Errorf("%q unexpected deleted", key)
+		}
+		count++
+		key, val, _, deleted, err = iter(false)
+	}
+	iter(true /*fin*/)
+	if count != n {
+		t.Errorf("expected %v, got %v", n, count)
+	}
+
+	itere, count := index.ScanEntries(), 0
+	for entry := itere(false); ; entry = itere(false) {
+		key, _, deleted, err := entry.Key()
+		if err != nil {
+			break
+		}
+		refexpiry := []int64{past, future, 0, 0}[count%4]
+		if deleted {
+			t.Errorf("%q unexpected deleted", key)
+		} else if x := entry.Expiry(); x != refexpiry {
+			t.Errorf("%q expected %v, got %v", key, refexpiry, x)
+		} else if bytes.Compare(key, entry.Value()) != 0 {
+			t.Errorf("%q expected %q, got %q", key, key, entry.Value())
+		}
+		count++
+	}
+	itere(true /*fin*/)
+	if count != n {
+		t.Errorf("expected %v, got %v", n, count)
+	}
+}
+
+func TestLLRBMerge(t *testing.T) {
+	llrb := NewLLRB("merge", Defaultsettings())
+	defer llrb.Destroy()
+
+	testmerge(t, llrb, llrb, func() {})
+}
+
+// testmerge apply merge operands on missing, live and deleted keys, and
+// verify Getoperand and ScanOperands. catchup shall wait for reads to
+// include all writes.
+func testmerge(
+	t *testing.T, index api.Index, merger api.Merger, catchup func()) {
+
+	n := 1000
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key%08v", i))
+		switch i % 4 {
+		case 0: // operand on missing key, shall remain an operand.
+			merger.Merge(key, []byte("10"), addmerge, true /*lsm*/)
+			merger.Merge(key, []byte("1"), addmerge, true /*lsm*/)
+		case 1: // operand on missing key, folded over nil.
+			merger.Merge(key, []byte("11"), addmerge, false /*lsm*/)
+		case 2:
+			index.Set(key, []byte("100"), nil)
+			merger.Merge(key, []byte("11"), addmerge, true /*lsm*/)
+		case 3: // operand on deleted key, folded over nil.
+			index.Set(key, []byte("100"), nil)
+			index.Delete(key, nil, true /*lsm*/)
+			merger.Merge(key, []byte("11"), addmerge, true /*lsm*/)
+		}
+	}
+	catchup()
+
+	refvals := []string{"11", "11", "111", "11"}
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key%08v", i))
+		val, _, deleted, operand, ok := merger.Getoperand(key, []byte{})
+		if ok == false {
+			t.Errorf("%q missing", key)
+		} else if deleted {
+			t.Errorf("%q unexpected deleted", key)
+		} else if operand != (i%4 == 0) {
+			t.Errorf("%q expected %v, got %v", key, i%4 == 0, operand)
+		} else if string(val) != refvals[i%4] {
+			t.Errorf("%q expected %s, got %s", key, refvals[i%4], val)
+		}
+	}
+
+	iter, count := merger.ScanOperands(nil, nil, false), 0
+	key, val, _, deleted, operand, err := iter(false)
+	for err == nil {
+		if deleted {
+			t.Errorf("%q unexpected deleted", key)
+		} else if operand != (count%4 == 0) {
+			t.Errorf("%q expected %v, got %v", key, count%4 == 0, operand)
+		} else if string(val) != refvals[count%4] {
+			t.Errorf("%q expected %s, got %s", key, refvals[count%4], val)
+		}
+		count++
+		key, val, _, deleted, operand, err = iter(false)
+	}
+	iter(true /*fin*/)
+	if count != n {
+		t.Errorf("expected %v, got %v", n, count)
+	}
+
+	// set and delete shall clear the operand.
+	for i := 0; i < n; i += 4 {
+		key := []byte(fmt.Sprintf("key%08v", i))
+		if i%8 == 0 {
+			index.Set(key, []byte("100"), nil)
+		} else {
+			index.Delete(key, nil, true /*lsm*/)
+		}
+	}
+	catchup()
+
+	itere, count := index.ScanEntries(), 0
+	for entry := itere(false); ; entry = itere(false) {
+		key, _, _, err := entry.Key()
+		if err != nil {
+			break
+		} else if entry.IsOperand() {
+			t.Errorf("%q unexpected operand", key)
+		}
+		count++
+	}
+	itere(true /*fin*/)
+	if count != n {
+		t.Errorf("expected %v, got %v", n, count)
+	}
+}
+
+// addmerge treat values as decimal integers and add them.
+func TestLLRBApproximate(t *testing.T) {
+	llrb := NewLLRB("approximate", Defaultsettings())
+	defer llrb.Destroy()
+
+	testapproximate(t, llrb, llrb.ApproximateSize, llrb.Stats)
+}
+
+// testapproximate verify n_deleted statistics for lsm and non-lsm
+// deletes, and estimates from ApproximateSize.
+func testapproximate(
+	t *testing.T, index api.Index,
+	approximatesize func(low, high []byte) int64,
+	stats func() map[string]interface{}) {
+
+	ndeleted := func() int64 {
+		return stats()["n_deleted"].(int64)
+	}
+
+	n := 10000
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key%08v", i))
+		index.Set(key, key, nil)
+	}
+	payload := int64(n * 22)
+	if x := approximatesize(nil, nil); x != payload {
+		t.Errorf("expected %v, got %v", payload, x)
+	}
+	low, high := []byte("key00002500"), []byte("key00007499")
+	if x := approximatesize(low, high); x < payload/4 {
+		t.Errorf("expected %v, got %v", payload/2, x)
+	} else if x > (payload*3)/4 {
+		t.Errorf("expected %v, got %v", payload/2, x)
+	}
+	if x := approximatesize(high, low); x != 0 {
+		t.Errorf("unexpected %v", x)
+	}
+
+	// mark existing and missing keys as deleted.
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("key%08v", i))
+		index.Delete(key, nil, true /*lsm*/)
+		index.Delete(key, nil, true /*lsm*/) // already deleted.
+	}
+	for i := n; i < n+10; i++ {
+		key := []byte(fmt.Sprintf("key%08v", i))
+		index.Delete(key, nil, true /*lsm*/)
+	}
+	if x := ndeleted(); x != 110 {
+		t.Errorf("expected %v, got %v", 110, x)
+	}
+	// set over deleted key, and delete deleted key from index.
+	index.Set([]byte("key00000000"), []byte("key00000000"), nil)
+	index.Delete([]byte("key00000001"), nil, false /*lsm*/)
+	index.Delete([]byte("key00000100"), nil, false /*lsm*/)
+	if x := ndeleted(); x != 108 {
+		t.Errorf("expected %v, got %v", 108, x)
+	}
+}
+
+func addmerge(key, value, operand []byte) []byte {
+	x, _ := strconv.Atoi(string(value))
+	y, _ := strconv.Atoi(string(operand))
+	return []byte(strconv.Itoa(x + y))
+}
+
 func makeLLRB(n int) (*LLRB, [][]byte) {
 	mi := NewLLRB("buildllrb", Defaultsettings())
 	k, v := []byte("key000000000000"), []byte("val00000000000000")
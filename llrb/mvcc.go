@@ -32,6 +32,7 @@ type MVCC struct {
 	nodearena api.Mallocer
 	valarena  api.Mallocer
 	seqno     uint64
+	delseqno  uint64 // seqno of the latest non-lsm delete
 	rw        sync.RWMutex
 	rwhbf     sync.RWMutex
 	finch     chan struct{}
@@ -112,6 +113,11 @@ func (mvcc *MVCC) readsettings(setts s.Settings) *MVCC {
 	snaptick := setts.Int64("snapshottick")
 	mvcc.snaptick = time.Duration(snaptick) * time.Millisecond
 	mvcc.allocator = setts.String("allocator")
+	locktimeout := setts.Int64("locktimeout")
+	mvcc.locktimeout = time.Duration(locktimeout) * time.Millisecond
+	maxage := time.Duration(setts.Int64("txnmaxage")) * time.Second
+	mvcc.tracker = lib.NewTxntracker(maxage)
+	mvcc.txnabort = setts.Bool("txnabort")
 	return mvcc
 }
 
@@ -162,16 +168,11 @@ func (mvcc *MVCC) releasesnapshot(old *mvccsnapshot, new *mvccsnapshot) {
 	}
 }
 
-func (mvcc *MVCC) newnode(k, v []byte) *Llrbnode {
+func (mvcc *MVCC) newnode(k, v []byte, expiry int64) *Llrbnode {
 	ptr := mvcc.nodearena.Alloc(int64(nodesize + len(k)))
 	nd := (*Llrbnode)(ptr)
-	nd.setdirty().setred().setkey(k).setreclaim()
-	if len(v) > 0 {
-		ptr = mvcc.valarena.Alloc(int64(nvaluesize + len(v)))
-		nv := (*nodevalue)(ptr)
-		nv.hdr = 0
-		nd.setnodevalue(nv.setvalue(v))
-	}
+	nd.setdirty().setred().setkey(k).setreclaim().clearoperand()
+	nd.setnodevalue(newnodevalue(mvcc.valarena, v, expiry))
 	mvcc.n_nodes++
 	return nd
 }
@@ -217,6 +218,9 @@ func (mvcc *MVCC) upsertcounts(key, value []byte, oldnd *Llrbnode) {
 		mvcc.n_inserts++
 		return
 	}
+	if oldnd.isdeleted() {
+		atomic.AddInt64(&mvcc.n_deleted, -1)
+	}
 	mvcc.n_updates++
 	mvcc.keymemory -= int64(len(oldnd.getkey()))
 	if nv := oldnd.nodevalue(); nv != nil {
@@ -231,6 +235,9 @@ func (mvcc *MVCC) delcounts(nd *Llrbnode, lsm bool) {
 			if nv := nd.nodevalue(); nv != nil {
 				mvcc.valmemory -= int64(len(nv.value()))
 			}
+			if nd.isdeleted() {
+				atomic.AddInt64(&mvcc.n_deleted, -1)
+			}
 			atomic.AddInt64(&mvcc.n_count, -1)
 			mvcc.n_deletes++
 		}
@@ -291,6 +298,21 @@ func (mvcc *MVCC) Count() int64 {
 	return atomic.LoadInt64(&mvcc.n_count)
 }
 
+// ApproximateSize return an estimate of bytes, in keys and values,
+// held by entries between low and high, both inclusive, without
+// iterating on the entries. Estimate is proportional to the position
+// of low and high in the tree. If low is nil, range starts from the
+// first key, if high is nil, range extends till the last key.
+func (mvcc *MVCC) ApproximateSize(low, high []byte) (size int64) {
+	if wsnap := mvcc.writesnapshot(); wsnap != nil {
+		kmem := atomic.LoadInt64(&mvcc.keymemory)
+		vmem := atomic.LoadInt64(&mvcc.valmemory)
+		size = wsnap.getroot().rangesize(low, high, kmem+vmem)
+		wsnap.release()
+	}
+	return size
+}
+
 // Dotdump to convert whole tree into dot script that can be
 // visualized using graphviz. Until dotdump exits concurrent write
 // operations will block.
@@ -323,6 +345,8 @@ func (mvcc *MVCC) Stats() map[string]interface{} {
 func (mvcc *MVCC) stats() map[string]interface{} {
 	m := make(map[string]interface{})
 	m["n_count"] = atomic.LoadInt64(&mvcc.n_count)
+	m["n_deleted"] = atomic.LoadInt64(&mvcc.n_deleted)
+	m["delseqno"] = atomic.LoadUint64(&mvcc.delseqno)
 	m["n_inserts"] = atomic.LoadInt64(&mvcc.n_inserts)
 	m["n_updates"] = atomic.LoadInt64(&mvcc.n_updates)
 	m["n_deletes"] = atomic.LoadInt64(&mvcc.n_deletes)
@@ -361,6 +385,7 @@ func (mvcc *MVCC) stats() map[string]interface{} {
 	mvcc.rwhbf.RUnlock()
 
 	m["h_reclaims"] = mvcc.h_reclaims.Fullstats()
+	m["txns"] = mvcc.tracker.Stats()
 	return m
 }
 
@@ -546,6 +571,7 @@ func (mvcc *MVCC) Clone(name string) *MVCC {
 	wsnap := mvcc.writesnapshot()
 
 	newmvcc.seqno = atomic.LoadUint64(&mvcc.seqno)
+	newmvcc.delseqno = atomic.LoadUint64(&mvcc.delseqno)
 	newmvcc.setroot(newmvcc.clonetree(wsnap.getroot()))
 
 	newmvcc.clonestats(mvcc.stats())
@@ -576,6 +602,7 @@ func (mvcc *MVCC) clonetree(nd *Llrbnode) *Llrbnode {
 
 func (mvcc *MVCC) clonestats(stats map[string]interface{}) {
 	atomic.StoreInt64(&mvcc.n_count, stats["n_count"].(int64))
+	atomic.StoreInt64(&mvcc.n_deleted, stats["n_deleted"].(int64))
 	atomic.StoreInt64(&mvcc.n_inserts, stats["n_inserts"].(int64))
 	atomic.StoreInt64(&mvcc.n_updates, stats["n_updates"].(int64))
 	atomic.StoreInt64(&mvcc.n_deletes, stats["n_deletes"].(int64))
@@ -669,20 +696,62 @@ func (mvcc *MVCC) Getseqno() uint64 {
 // its value will be over-written. Make sure key is not nil.
 // Return old value if oldvalue points to a valid buffer.
 func (mvcc *MVCC) Set(key, value, oldvalue []byte) (ov []byte, cas uint64) {
+	return mvcc.SetExpiry(key, value, oldvalue, 0)
+}
+
+// SetExpiry is same as Set, additionally the entry shall expire at
+// `expiry`, unix time in seconds. Expired entries are reported as
+// deleted, without value. If expiry is ZERO, entry never expires.
+func (mvcc *MVCC) SetExpiry(
+	key, value, oldvalue []byte, expiry int64) (ov []byte, cas uint64) {
+
 	if !mvcc.lock() {
 		return
 	}
 
 	wsnap := mvcc.writesnapshot()
-	ov, cas = mvcc.set(wsnap, key, value, oldvalue)
+	ov, cas = mvcc.set(wsnap, key, value, oldvalue, expiry, false /*operand*/)
 	wsnap.release()
 
 	mvcc.unlock()
 	return
 }
 
+// Merge operand into key's value using merge operator. If key is
+// missing and lsm is true, operand is remembered as merge operand, to
+// be folded over older versions of the key in other lsm levels, else
+// operand is folded over nil value.
+func (mvcc *MVCC) Merge(
+	key, operand []byte, merge api.MergeOperator, lsm bool) uint64 {
+
+	if !mvcc.lock() {
+		return 0
+	}
+
+	wsnap := mvcc.writesnapshot()
+	value, isoperand := operand, lsm
+	if nd, ok := mvcc.getkey(wsnap.getroot(), key); ok {
+		// older node is reclaimed only after snapshot is released.
+		val, deleted := nd.visible()
+		if deleted {
+			val = nil
+		}
+		value, isoperand = merge(key, val, operand), nd.isoperand()
+		isoperand = isoperand && !deleted
+	} else if lsm == false {
+		value = merge(key, nil, operand)
+	}
+	_, cas := mvcc.set(wsnap, key, value, nil, 0 /*expiry*/, isoperand)
+	wsnap.release()
+
+	mvcc.unlock()
+	return cas
+}
+
 func (mvcc *MVCC) set(
-	wsnap *mvccsnapshot, key, value, oldvalue []byte) (ov []byte, cas uint64) {
+	wsnap *mvccsnapshot,
+	key, value, oldvalue []byte,
+	expiry int64, operand bool) (ov []byte, cas uint64) {
 
 	var newnd, oldnd *Llrbnode
 
@@ -690,11 +759,17 @@ func (mvcc *MVCC) set(
 	reclaim := wsnap.reclaim[:0]
 
 	root := wsnap.getroot()
-	root, newnd, oldnd, reclaim = mvcc.upsert(root, 1, key, value, reclaim)
+	root, newnd, oldnd, reclaim =
+		mvcc.upsert(root, 1, key, value, expiry, reclaim)
 	root.setblack()
 	newnd.cleardeleted()
 	newnd.cleardirty()
 	newnd.setseqno(seqno)
+	if operand {
+		newnd.setoperand()
+	} else {
+		newnd.clearoperand()
+	}
 
 	wsnap.setroot(root)
 	mvcc.upsertcounts(key, value, oldnd)
@@ -715,13 +790,13 @@ func (mvcc *MVCC) set(
 
 func (mvcc *MVCC) upsert(
 	nd *Llrbnode, depth int64,
-	key, value []byte,
+	key, value []byte, expiry int64,
 	reclaim []*Llrbnode) (*Llrbnode, *Llrbnode, *Llrbnode, []*Llrbnode) {
 
 	var oldnd, newnd, ndmvcc *Llrbnode
 
 	if nd == nil {
-		newnd := mvcc.newnode(key, value)
+		newnd := mvcc.newnode(key, value, expiry)
 		return newnd, newnd, nil, reclaim
 	}
 	reclaim = append(reclaim, nd)
@@ -730,12 +805,12 @@ func (mvcc *MVCC) upsert(
 		ndmvcc = mvcc.clonenode(nd, false)
 		//ndmvcc = mvcc.walkdownrot23(ndmvcc)
 		ndmvcc.left, newnd, oldnd, reclaim =
-			mvcc.upsert(ndmvcc.left, depth+1, key, value, reclaim)
+			mvcc.upsert(ndmvcc.left, depth+1, key, value, expiry, reclaim)
 	} else if nd.ltkey(key, false) {
 		ndmvcc = mvcc.clonenode(nd, false)
 		//ndmvcc = mvcc.walkdownrot23(ndmvcc)
 		ndmvcc.right, newnd, oldnd, reclaim =
-			mvcc.upsert(ndmvcc.right, depth+1, key, value, reclaim)
+			mvcc.upsert(ndmvcc.right, depth+1, key, value, expiry, reclaim)
 	} else {
 		ndmvcc = mvcc.clonenode(nd, true)
 		//ndmvcc = mvcc.walkdownrot23(ndmvcc)
@@ -744,11 +819,9 @@ func (mvcc *MVCC) upsert(
 			mvcc.valarena.Free(unsafe.Pointer(nv))
 			ndmvcc = ndmvcc.setnodevalue(nil)
 		}
-		if len(value) > 0 { // add new value.
-			ptr := mvcc.valarena.Alloc(int64(nvaluesize + len(value)))
-			nv := (*nodevalue)(ptr)
-			nv.hdr = 0
-			ndmvcc = ndmvcc.setnodevalue(nv.setvalue(value))
+		// add new value, and expiry.
+		if nv := newnodevalue(mvcc.valarena, value, expiry); nv != nil {
+			ndmvcc = ndmvcc.setnodevalue(nv)
 		}
 		ndmvcc.setdirty()
 		newnd = ndmvcc
@@ -796,7 +869,7 @@ func (mvcc *MVCC) setcas(
 		//fmt.Printf("SetCAS %q %v %v BadCAS 0\n", key, nd.getseqno(), cas)
 		return oldvalue, 0, api.ErrorInvalidCAS
 	}
-	oldvalue, cas = mvcc.set(wsnap, key, value, oldvalue)
+	oldvalue, cas = mvcc.set(wsnap, key, value, oldvalue, 0, false)
 	return oldvalue, cas, nil
 }
 
@@ -814,7 +887,7 @@ func (mvcc *MVCC) upsertcas(
 		return nil, nil, nil, reclaim, api.ErrorInvalidCAS
 
 	} else if nd == nil { // Expected a create
-		newnd := mvcc.newnode(key, value)
+		newnd := mvcc.newnode(key, value, 0 /*expiry*/)
 		return newnd, newnd, nil, reclaim, nil
 	}
 	reclaim = append(reclaim, nd)
@@ -852,11 +925,9 @@ func (mvcc *MVCC) upsertcas(
 				mvcc.valarena.Free(unsafe.Pointer(nv))
 				ndmvcc = ndmvcc.setnodevalue(nil)
 			}
-			if len(value) > 0 { // add new value.
-				ptr := mvcc.valarena.Alloc(int64(nvaluesize + len(value)))
-				nv := (*nodevalue)(ptr)
-				nv.hdr = 0
-				ndmvcc = ndmvcc.setnodevalue(nv.setvalue(value))
+			// add new value.
+			if nv := newnodevalue(mvcc.valarena, value, 0); nv != nil {
+				ndmvcc = ndmvcc.setnodevalue(nv)
 			}
 			ndmvcc.setdirty()
 			newnd = ndmvcc
@@ -897,8 +968,11 @@ func (mvcc *MVCC) dodelete(
 		root, newnd, oldnd, reclaim = mvcc.lsmdelete(root, key, reclaim)
 		root.setblack()
 		newnd.cleardirty()
-		newnd.setseqnodeleted(seqno)
+		newnd.setseqnodeleted(seqno).clearoperand()
 		wsnap.setroot(root)
+		if oldnd == nil || oldnd.isdeleted() == false {
+			atomic.AddInt64(&mvcc.n_deleted, 1)
+		}
 		if oldnd == nil {
 			mvcc.upsertcounts(key, nil, oldnd)
 
@@ -914,6 +988,9 @@ func (mvcc *MVCC) dodelete(
 			root.setblack()
 		}
 		wsnap.setroot(root)
+		if deleted != nil {
+			atomic.StoreUint64(&mvcc.delseqno, seqno)
+		}
 
 		if deleted != nil && oldvalue != nil {
 			val := deleted.Value()
@@ -935,7 +1012,7 @@ func (mvcc *MVCC) lsmdelete(
 	var oldnd, newnd, ndmvcc *Llrbnode
 
 	if nd == nil {
-		newnd := mvcc.newnode(key, nil)
+		newnd := mvcc.newnode(key, nil, 0 /*expiry*/)
 		return newnd, newnd, nil, reclaim
 	}
 
@@ -1064,11 +1141,25 @@ func (mvcc *MVCC) BeginTxn(id uint64) api.Transactor {
 	if snapshot := mvcc.readsnapshot(); snapshot != nil {
 		atomic.AddInt64(&mvcc.n_txns, 1)
 		txn := mvcc.gettxn(id, mvcc /*db*/, snapshot /*snap*/)
+		mvcc.tracker.Begin(txn, txn.id, "txn", 1)
 		return txn
 	}
 	return nil
 }
 
+// getlatest return the latest version of key from the write snapshot,
+// unlike Get that return the version from the latest read snapshot.
+func (mvcc *MVCC) getlatest(
+	key, value []byte) (v []byte, cas uint64, deleted, ok bool) {
+
+	mvcc.rlock()
+	wsnap := mvcc.writesnapshot()
+	v, cas, deleted, _, ok = wsnap.get(key, value)
+	wsnap.release()
+	mvcc.runlock()
+	return v, cas, deleted, ok
+}
+
 func (mvcc *MVCC) commit(txn *Txn) error {
 	if !mvcc.lock() { // no further mutations allowed until we are done.
 		txn.snapshot.(*mvccsnapshot).release()
@@ -1105,6 +1196,18 @@ func (mvcc *MVCC) docommit(wsnap *mvccsnapshot, txn *Txn) error {
 		}
 	}
 
+	// Check whether keys read by the transaction are updated after
+	// they were read.
+	for _, rec := range txn.reads {
+		seqno := uint64(0)
+		if nd, ok := mvcc.getkey(wsnap.getroot(), rec.key); ok {
+			seqno = nd.getseqno()
+		}
+		if seqno != rec.seqno {
+			return api.ErrorRollback // rollback
+		}
+	}
+
 	// CAS matches, proceed to commit.
 	for _, head := range txn.writes {
 		prevkey := []byte(nil)
@@ -1123,7 +1226,7 @@ func (mvcc *MVCC) docommit(wsnap *mvccsnapshot, txn *Txn) error {
 func (mvcc *MVCC) commitrecord(wsnap *mvccsnapshot, rec *record) (err error) {
 	switch rec.cmd {
 	case cmdSet:
-		mvcc.set(wsnap, rec.key, rec.value, nil)
+		mvcc.set(wsnap, rec.key, rec.value, nil, 0, false /*operand*/)
 	case cmdDelete:
 		mvcc.dodelete(wsnap, rec.key, nil, rec.lsm)
 	}
@@ -1151,11 +1254,20 @@ func (mvcc *MVCC) View(id uint64) api.Transactor {
 	if snapshot := mvcc.readsnapshot(); snapshot != nil {
 		atomic.AddInt64(&mvcc.n_txns, 1)
 		view := mvcc.getview(id, mvcc /*db*/, snapshot /*snap*/)
+		mvcc.tracker.Begin(view, view.id, "view", 1)
 		return view
 	}
 	return nil
 }
 
+// sweeptxns log transactions and views that are active beyond
+// txnmaxage.
+func (mvcc *MVCC) sweeptxns() {
+	for _, msg := range mvcc.tracker.Sweep() {
+		warnf("%v %v", mvcc.logprefix, msg)
+	}
+}
+
 func (mvcc *MVCC) abortview(view *View) {
 	snapshot := view.snapshot.(*mvccsnapshot)
 	snapshot.release()
@@ -1172,7 +1284,19 @@ func (mvcc *MVCC) Get(
 	key, value []byte) (v []byte, cas uint64, deleted, ok bool) {
 
 	if wsnap := mvcc.writesnapshot(); wsnap != nil {
-		v, cas, deleted, ok = wsnap.get(key, value)
+		v, cas, deleted, _, ok = wsnap.get(key, value)
+		wsnap.release()
+	}
+	return
+}
+
+// Getoperand is same as Get, additionally return whether value is a
+// merge operand, yet to be folded over older versions of the key.
+func (mvcc *MVCC) Getoperand(
+	key, value []byte) (v []byte, cas uint64, deleted, operand, ok bool) {
+
+	if wsnap := mvcc.writesnapshot(); wsnap != nil {
+		v, cas, deleted, operand, ok = wsnap.get(key, value)
 		wsnap.release()
 	}
 	return
@@ -1205,38 +1329,78 @@ func (mvcc *MVCC) Finalize() {
 	}
 }
 
+// Catchup will wait for read snapshot to catchup with seqno. Unlike
+// Finalize, call will return even with background mutations.
+func (mvcc *MVCC) Catchup(seqno uint64) {
+	for {
+		rsnap := mvcc.readsnapshot()
+		snapseqno := rsnap.seqno
+		rsnap.release()
+		if snapseqno >= seqno {
+			return
+		}
+		runtime.Gosched()
+	}
+}
+
 // Scan return a full table iterator, if iteration is stopped before
 // reaching end of table (io.EOF), application should call iterator
 // with fin as true. EG: iter(true)
 func (mvcc *MVCC) Scan() api.Iterator {
-	currkey := []byte(nil)
-	sb := makescanbuf()
+	return mvcc.ScanRange(nil, nil, false)
+}
+
+// ScanRange return an iterator from low key, inclusive, upto high
+// key. If inclusive is true, entry with high key is also included.
+// If low is nil, iteration starts from first entry, and if high is
+// nil, iteration continues till last entry. If iteration is stopped
+// before reaching the end of range (io.EOF), application should call
+// iterator with fin as true. EG: iter(true)
+func (mvcc *MVCC) ScanRange(low, high []byte, inclusive bool) api.Iterator {
+	iter := mvcc.ScanOperands(low, high, inclusive)
+	return func(fin bool) ([]byte, []byte, uint64, bool, error) {
+		key, value, seqno, deleted, _, err := iter(fin)
+		return key, value, seqno, deleted, err
+	}
+}
+
+// ScanOperands is same as ScanRange, additionally return whether each
+// value is a merge operand, yet to be folded over older versions of
+// the key.
+func (mvcc *MVCC) ScanOperands(
+	low, high []byte, inclusive bool) api.MergeIterator {
+
+	currkey := append([]byte(nil), low...)
+	sb := makescanbuf().setrange(high, inclusive)
 
 	var err error
-	leseqno := mvcc.startscan(nil, sb, 0)
+	now := time.Now().Unix()
+	leseqno := mvcc.startscan(low, true /*ge*/, sb, 0)
 	tip := mvcc.Getseqno()
 	fmsg := "%s scan started (%v-%v) = %v behind the tip"
 	infof(fmsg, mvcc.logprefix, tip, leseqno, tip-leseqno)
-	return func(fin bool) ([]byte, []byte, uint64, bool, error) {
+	return func(fin bool) ([]byte, []byte, uint64, bool, bool, error) {
 		if err != nil {
-			return nil, nil, 0, false, err
+			return nil, nil, 0, false, false, err
 		} else if fin {
 			err, sb = io.EOF, nil
-			return nil, nil, 0, false, err
+			return nil, nil, 0, false, false, err
 		}
 
-		key, value, seqno, deleted := sb.pop()
+		key, value, seqno, deleted, operand, expiry := sb.pop()
 		if key == nil {
-			mvcc.startscan(currkey, sb, leseqno)
-			key, value, seqno, deleted = sb.pop()
+			mvcc.startscan(currkey, false /*ge*/, sb, leseqno)
+			key, value, seqno, deleted, operand, expiry = sb.pop()
 		}
 		currkey = lib.Fixbuffer(currkey, int64(len(key)))
 		copy(currkey, key)
 		if key == nil {
 			err, sb = io.EOF, nil
-			return nil, nil, 0, false, err
+			return nil, nil, 0, false, false, err
+		} else if isexpired(expiry, now) {
+			value, deleted = nil, true
 		}
-		return key, value, seqno, deleted, nil
+		return key, value, seqno, deleted, operand, nil
 	}
 }
 
@@ -1248,7 +1412,7 @@ func (mvcc *MVCC) ScanEntries() api.EntryIterator {
 	sb := makescanbuf()
 
 	re := &indexentry{id: mvcc.ID()}
-	leseqno := mvcc.startscan(nil, sb, 0)
+	leseqno := mvcc.startscan(nil, true /*ge*/, sb, 0)
 
 	return func(fin bool) api.IndexEntry {
 		if re.err != nil {
@@ -1258,10 +1422,10 @@ func (mvcc *MVCC) ScanEntries() api.EntryIterator {
 			return re.set(nil, nil, 0, false, io.EOF)
 		}
 
-		key, value, seqno, deleted := sb.pop()
+		key, value, seqno, deleted, operand, expiry := sb.pop()
 		if key == nil { // prefetch is nil
-			mvcc.startscan(currkey, sb, leseqno)
-			key, value, seqno, deleted = sb.pop()
+			mvcc.startscan(currkey, false /*ge*/, sb, leseqno)
+			key, value, seqno, deleted, operand, expiry = sb.pop()
 		}
 
 		if key == nil { // iteration has finished
@@ -1270,19 +1434,47 @@ func (mvcc *MVCC) ScanEntries() api.EntryIterator {
 		}
 		currkey = lib.Fixbuffer(currkey, int64(len(key)))
 		copy(currkey, key)
-		return re.set(key, value, seqno, deleted, nil)
+		re.set(key, value, seqno, deleted, nil).setexpiry(expiry)
+		return re.setoperand(operand)
+	}
+}
+
+// Subscribe return an iterator over mutations with seqno greater than
+// or equal to `seqno`, in seqno order, uptil the latest mutation at
+// the time of subscription. Mutations are gathered by a seqno scan on
+// the read snapshot, hence only the latest mutation on each key is
+// delivered, and deletes are delivered only if they are lsm deletes.
+// If a non-lsm delete was applied since `seqno`, iterator returns
+// api.ErrorMissingdeletes. Keys updated while iterating, are delivered
+// by a later subscription from the next seqno.
+func (mvcc *MVCC) Subscribe(seqno uint64) api.Iterator {
+	till := mvcc.Getseqno()
+	mvcc.Catchup(till)
+	delseqno := atomic.LoadUint64(&mvcc.delseqno)
+	if delseqno > 0 && delseqno >= seqno {
+		return func(fin bool) ([]byte, []byte, uint64, bool, error) {
+			if fin {
+				return nil, nil, 0, false, io.EOF
+			}
+			return nil, nil, 0, false, api.ErrorMissingdeletes
+		}
 	}
+	return lib.SeqnoIterator(mvcc.Scan, seqno, till)
 }
 
+// startscan fill sb with entries after key, or from key if ge is true,
+// which is the case for first batch of a scan.
 // TODO: can we instead to the snapshot and avoid rlock ?
-func (mvcc *MVCC) startscan(key []byte, sb *scanbuf, leseqno uint64) uint64 {
+func (mvcc *MVCC) startscan(
+	key []byte, ge bool, sb *scanbuf, leseqno uint64) uint64 {
+
 	rsnap := mvcc.readsnapshot()
-	if key == nil {
+	if ge {
 		leseqno = rsnap.seqno
 	}
 
 	sb.preparewrite()
-	mvcc.scan(rsnap.getroot(), key, sb, leseqno)
+	mvcc.scan(rsnap.getroot(), key, ge, sb, leseqno)
 	sb.prepareread()
 
 	rsnap.release()
@@ -1290,25 +1482,31 @@ func (mvcc *MVCC) startscan(key []byte, sb *scanbuf, leseqno uint64) uint64 {
 }
 
 func (mvcc *MVCC) scan(
-	nd *Llrbnode, key []byte, sb *scanbuf, leseqno uint64) bool {
+	nd *Llrbnode, key []byte, ge bool, sb *scanbuf, leseqno uint64) bool {
 
 	if nd == nil {
 		return true
 	}
-	if key != nil && nd.lekey(key, false) {
-		return mvcc.scan(nd.right, key, sb, leseqno)
+	if key != nil && ge && nd.ltkey(key, false) {
+		return mvcc.scan(nd.right, key, ge, sb, leseqno)
+	} else if key != nil && !ge && nd.lekey(key, false) {
+		return mvcc.scan(nd.right, key, ge, sb, leseqno)
 	}
-	if !mvcc.scan(nd.left, key, sb, leseqno) {
+	if !mvcc.scan(nd.left, key, ge, sb, leseqno) {
+		return false
+	} else if sb.beyond(nd) { // rest of the tree is beyond high key.
 		return false
 	}
 	seqno := nd.getseqno()
 	if seqno <= leseqno {
-		n := sb.append(nd.getkey(), nd.Value(), seqno, nd.isdeleted())
+		key, value := nd.getkey(), nd.Value()
+		deleted, operand := nd.isdeleted(), nd.isoperand()
+		n := sb.append(key, value, seqno, deleted, operand, nd.getexpiry())
 		if n >= scanlimit {
 			return false
 		}
 	}
-	return mvcc.scan(nd.right, key, sb, leseqno)
+	return mvcc.scan(nd.right, key, ge, sb, leseqno)
 }
 
 // llrb rotation routines for 2-3 algorithm
@@ -4,6 +4,7 @@ import "io"
 import "fmt"
 import "time"
 import "bytes"
+import "strconv"
 import "testing"
 import "io/ioutil"
 import "encoding/binary"
@@ -881,6 +882,196 @@ func TestMVCCTxn(t *testing.T) {
 	}
 }
 
+func TestMVCCTxnConflict(t *testing.T) {
+	mvcc := NewMVCC("txn", Defaultsettings())
+	defer mvcc.Destroy()
+	snaptick := time.Duration(Defaultsettings().Int64("snapshottick") * 2)
+	snaptick = snaptick * time.Millisecond
+
+	key1, key2, key3 := []byte("key1"), []byte("key2"), []byte("key3")
+	mvcc.Set(key1, []byte("value1"), nil)
+	mvcc.Set(key2, []byte("value2"), nil)
+	time.Sleep(snaptick)
+
+	// key read by the transaction is updated before commit.
+	txn := mvcc.BeginTxn(0x1234)
+	if value, _, _, _ := txn.Get(key1, []byte{}); string(value) != "value1" {
+		t.Errorf("unexpected %s", value)
+	}
+	txn.Set(key2, []byte("txnvalue"), nil)
+	mvcc.Set(key1, []byte("value11"), nil)
+	if err := txn.Commit(); err != api.ErrorRollback {
+		t.Errorf("expected %v, got %v", api.ErrorRollback, err)
+	}
+	if value, _, _, _ := mvcc.Get(key2, []byte{}); string(value) != "value2" {
+		t.Errorf("unexpected %s", value)
+	}
+	time.Sleep(snaptick)
+
+	// missing key read by the transaction is created before commit.
+	txn = mvcc.BeginTxn(0x12345)
+	if _, _, _, ok := txn.Get(key3, []byte{}); ok {
+		t.Errorf("unexpected %s", key3)
+	}
+	txn.Set(key2, []byte("txnvalue"), nil)
+	mvcc.Set(key3, []byte("value3"), nil)
+	if err := txn.Commit(); err != api.ErrorRollback {
+		t.Errorf("expected %v, got %v", api.ErrorRollback, err)
+	}
+	time.Sleep(snaptick)
+
+	// no conflict.
+	txn = mvcc.BeginTxn(0x123456)
+	txn.Get(key1, []byte{})
+	txn.Get(key3, []byte{})
+	txn.Set(key2, []byte("txnvalue"), nil)
+	mvcc.Set([]byte("key4"), []byte("value4"), nil)
+	if err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(snaptick)
+	if value, _, _, _ := mvcc.Get(key2, []byte{}); string(value) != "txnvalue" {
+		t.Errorf("unexpected %s", value)
+	}
+}
+
+func TestMVCCGetForUpdate(t *testing.T) {
+	setts := Defaultsettings()
+	setts["locktimeout"] = 100
+	mvcc := NewMVCC("txn", setts)
+	defer mvcc.Destroy()
+	snaptick := time.Duration(Defaultsettings().Int64("snapshottick") * 2)
+	snaptick = snaptick * time.Millisecond
+
+	key1, key2 := []byte("key1"), []byte("key2")
+	mvcc.Set(key1, []byte("0"), nil)
+	mvcc.Set(key2, []byte("0"), nil)
+	time.Sleep(snaptick)
+
+	// concurrent transactions increment the same key.
+	n, donech := 10, make(chan error, 10)
+	for i := 0; i < n; i++ {
+		go func(id uint64) {
+			txn := mvcc.BeginTxn(id).(*Txn)
+			value, _, _, _, err := txn.GetForUpdate(key1, []byte{})
+			if err != nil {
+				txn.Abort()
+				donech <- err
+				return
+			}
+			x, _ := strconv.Atoi(string(value))
+			txn.Set(key1, []byte(strconv.Itoa(x+1)), nil)
+			donech <- txn.Commit()
+		}(uint64(i + 1))
+	}
+	for i := 0; i < n; i++ {
+		if err := <-donech; err != nil {
+			t.Errorf("unexpected %v", err)
+		}
+	}
+	time.Sleep(snaptick)
+	if value, _, _, _ := mvcc.Get(key1, []byte{}); string(value) != "10" {
+		t.Errorf("expected %v, got %s", 10, value)
+	}
+
+	// lock timeout, lock is released on abort.
+	txn1 := mvcc.BeginTxn(0x1).(*Txn)
+	txn2 := mvcc.BeginTxn(0x2).(*Txn)
+	if _, _, _, _, err := txn1.GetForUpdate(key1, nil); err != nil {
+		t.Fatal(err)
+	}
+	_, _, _, _, err := txn2.GetForUpdate(key1, nil)
+	if err != api.ErrorLocktimeout {
+		t.Errorf("expected %v, got %v", api.ErrorLocktimeout, err)
+	}
+	txn1.Abort()
+	if _, _, _, _, err := txn2.GetForUpdate(key1, nil); err != nil {
+		t.Errorf("unexpected %v", err)
+	}
+	txn2.Abort()
+
+	// deadlock
+	txn1 = mvcc.BeginTxn(0x1).(*Txn)
+	txn2 = mvcc.BeginTxn(0x2).(*Txn)
+	txn1.GetForUpdate(key1, nil)
+	txn2.GetForUpdate(key2, nil)
+	go func() {
+		_, _, _, _, err := txn1.GetForUpdate(key2, nil)
+		donech <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if _, _, _, _, err := txn2.GetForUpdate(key1, nil); err != api.ErrorDeadlock {
+		t.Errorf("expected %v, got %v", api.ErrorDeadlock, err)
+	}
+	txn2.Abort()
+	if err := <-donech; err != nil {
+		t.Errorf("unexpected %v", err)
+	}
+	txn1.Abort()
+}
+
+func TestMVCCTxnExpiry(t *testing.T) {
+	setts := Defaultsettings()
+	setts["txnmaxage"], setts["txnabort"] = 1, true
+	mvcc := NewMVCC("txn", setts)
+	defer mvcc.Destroy()
+	snaptick := time.Duration(Defaultsettings().Int64("snapshottick") * 2)
+	snaptick = snaptick * time.Millisecond
+
+	key1 := []byte("key1")
+	mvcc.Set(key1, []byte("value1"), nil)
+	time.Sleep(snaptick)
+
+	txn := mvcc.BeginTxn(0x1234).(*Txn)
+	view := mvcc.View(0x12345)
+	txn.Set(key1, []byte("txnvalue"), nil)
+	if v, _, _, _ := view.Get(key1, []byte{}); string(v) != "value1" {
+		t.Errorf("unexpected %s", v)
+	}
+	stats := mvcc.Stats()["txns"].(map[string]interface{})
+	if x := stats["n_active"].(int64); x != 2 {
+		t.Errorf("expected %v, got %v", 2, x)
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	stats = mvcc.Stats()["txns"].(map[string]interface{})
+	if x := len(stats["expired"].([]string)); x != 2 {
+		t.Errorf("expected %v, got %v", 2, x)
+	}
+	if _, _, _, ok := view.Get(key1, []byte{}); ok {
+		t.Errorf("unexpected %s", key1)
+	} else if _, err := view.OpenCursor(key1); err != api.ErrorExpired {
+		t.Errorf("expected %v, got %v", api.ErrorExpired, err)
+	}
+	view.Abort()
+	txn.Set(key1, []byte("txnvalue1"), nil)
+	if err := txn.Commit(); err != api.ErrorExpired {
+		t.Errorf("expected %v, got %v", api.ErrorExpired, err)
+	}
+	txn.Abort()
+
+	stats = mvcc.Stats()["txns"].(map[string]interface{})
+	if x := stats["n_active"].(int64); x != 0 {
+		t.Errorf("expected %v, got %v", 0, x)
+	} else if x := stats["n_aborted"].(int64); x != 2 {
+		t.Errorf("expected %v, got %v", 2, x)
+	}
+	if v, _, _, _ := mvcc.Get(key1, []byte{}); string(v) != "value1" {
+		t.Errorf("unexpected %s", v)
+	}
+
+	// new transactions are not affected.
+	txn = mvcc.BeginTxn(0x123456).(*Txn)
+	txn.Set(key1, []byte("txnvalue"), nil)
+	if err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(snaptick)
+	if v, _, _, _ := mvcc.Get(key1, []byte{}); string(v) != "txnvalue" {
+		t.Errorf("unexpected %s", v)
+	}
+}
+
 func TestMVCCView(t *testing.T) {
 	mvcc := NewMVCC("view", Defaultsettings())
 	defer mvcc.Destroy()
@@ -1144,6 +1335,95 @@ func TestMVCCScan(t *testing.T) {
 
 }
 
+func TestMVCCCursorGetPrev(t *testing.T) {
+	mvcc := NewMVCC("getprev", Defaultsettings())
+	defer mvcc.Destroy()
+
+	n, keys := 1000, [][]byte{}
+	for i := 0; i < n; i++ {
+		k := []byte(fmt.Sprintf("key%08v", i*2))
+		v := []byte(fmt.Sprintf("val%08v", i*2))
+		mvcc.Set(k, v, nil)
+		keys = append(keys, k)
+	}
+	snaptick := time.Duration(Defaultsettings().Int64("snapshottick"))
+	time.Sleep(snaptick * 4 * time.Millisecond)
+
+	for i := -1; i <= 2*n; i += 7 {
+		view := mvcc.View(0)
+		testcursorprev(t, view, i, keys)
+		view.Abort()
+	}
+}
+
+func TestMVCCScanRange(t *testing.T) {
+	mvcc := NewMVCC("scanrange", Defaultsettings())
+	defer mvcc.Destroy()
+
+	n, keys := 1000, [][]byte{}
+	for i := 0; i < n; i++ {
+		k := []byte(fmt.Sprintf("key%08v", i*2))
+		v := []byte(fmt.Sprintf("val%08v", i*2))
+		mvcc.Set(k, v, nil)
+		keys = append(keys, k)
+	}
+	snaptick := time.Duration(Defaultsettings().Int64("snapshottick"))
+	time.Sleep(snaptick * 4 * time.Millisecond)
+
+	mkkey := func(i int) []byte {
+		return []byte(fmt.Sprintf("key%08v", i))
+	}
+	testcases := [][]interface{}{
+		{[]byte(nil), []byte(nil), false},
+		{[]byte(nil), mkkey(100), false},
+		{[]byte(nil), mkkey(100), true},
+		{mkkey(100), []byte(nil), true},
+		{mkkey(101), []byte(nil), true},
+		{mkkey(100), mkkey(200), false},
+		{mkkey(100), mkkey(200), true},
+		{mkkey(99), mkkey(201), true},
+		{mkkey(100), mkkey(100), true},
+		{mkkey(100), mkkey(100), false},
+		{mkkey(200), mkkey(100), true},
+		{mkkey(2 * n), []byte(nil), false},
+		{[]byte("a"), []byte("z"), false},
+	}
+	for _, tcase := range testcases {
+		low, high := tcase[0].([]byte), tcase[1].([]byte)
+		incl := tcase[2].(bool)
+
+		refkeys := [][]byte{}
+		for _, key := range keys {
+			if low != nil && bytes.Compare(key, low) < 0 {
+				continue
+			} else if high != nil && bytes.Compare(key, high) > 0 {
+				continue
+			} else if !incl && bytes.Compare(key, high) == 0 {
+				continue
+			}
+			refkeys = append(refkeys, key)
+		}
+
+		count := 0
+		iter := mvcc.ScanRange(low, high, incl)
+		key, _, _, _, err := iter(false /*fin*/)
+		for ; err == nil; key, _, _, _, err = iter(false /*fin*/) {
+			if count >= len(refkeys) {
+				t.Fatalf("%q-%q unexpected %q", low, high, key)
+			} else if bytes.Compare(key, refkeys[count]) != 0 {
+				t.Fatalf("expected %q, got %q", refkeys[count], key)
+			}
+			count++
+		}
+		if err != io.EOF {
+			t.Errorf("unexpected %v", err)
+		} else if count != len(refkeys) {
+			t.Errorf("expected %v, got %v", len(refkeys), count)
+		}
+		iter(true /*fin*/)
+	}
+}
+
 func TestMVCCScanEntries(t *testing.T) {
 	load := func(n int, mvcc *MVCC) {
 		for i := 0; i < n; i++ {
@@ -1411,3 +1691,91 @@ func makeBenchMVCC(n int) *MVCC {
 //buf := bytes.NewBuffer(nil)
 //mvcc.Dotdump(buf)
 //ioutil.WriteFile("out.dot", buf.Bytes(), 0664)
+
+func TestMVCCSubscribe(t *testing.T) {
+	mvcc := NewMVCC("subscribe", Defaultsettings())
+	defer mvcc.Destroy()
+
+	// key -> seqno of latest mutation, along with deleted flag.
+	n, seqnos, deletes := 1000, map[string]uint64{}, map[string]bool{}
+	for i := 0; i < n*2; i++ {
+		k := []byte(fmt.Sprintf("key%08v", i%n))
+		if i%7 == 0 {
+			_, cas := mvcc.Delete(k, nil, true /*lsm*/)
+			seqnos[string(k)], deletes[string(k)] = cas, true
+			continue
+		}
+		_, cas := mvcc.Set(k, k, nil)
+		seqnos[string(k)], deletes[string(k)] = cas, false
+	}
+
+	for _, from := range []uint64{0, 1, 1500, uint64(n * 2), uint64(n*2) + 1} {
+		count, lastseqno := 0, uint64(0)
+		iter := mvcc.Subscribe(from)
+		key, val, seqno, del, err := iter(false /*fin*/)
+		for err == nil {
+			if seqno < from || seqno <= lastseqno {
+				fmsg := "from:%v unexpected seqno %v after %v"
+				t.Errorf(fmsg, from, seqno, lastseqno)
+			} else if refseqno := seqnos[string(key)]; seqno != refseqno {
+				t.Errorf("%q expected %v, got %v", key, refseqno, seqno)
+			} else if del != deletes[string(key)] {
+				t.Errorf("%q expected %v, got %v", key, deletes[string(key)], del)
+			} else if del == false && bytes.Compare(key, val) != 0 {
+				t.Errorf("%q expected %q, got %q", key, key, val)
+			}
+			lastseqno, count = seqno, count+1
+			key, val, seqno, del, err = iter(false /*fin*/)
+		}
+		iter(true /*fin*/)
+
+		refcount := 0
+		for _, seqno := range seqnos {
+			if seqno >= from {
+				refcount++
+			}
+		}
+		if err != io.EOF {
+			t.Errorf("from:%v unexpected %v", from, err)
+		} else if count != refcount {
+			t.Errorf("from:%v expected %v, got %v", from, refcount, count)
+		}
+	}
+
+	// non-lsm deletes cannot be served by seqno scan.
+	_, delseqno := mvcc.Delete([]byte("key00000001"), nil, false /*lsm*/)
+	for _, from := range []uint64{1, delseqno, delseqno + 1} {
+		iter := mvcc.Subscribe(from)
+		_, _, _, _, err := iter(false /*fin*/)
+		if from <= delseqno && err != api.ErrorMissingdeletes {
+			fmsg := "from:%v expected %v, got %v"
+			t.Errorf(fmsg, from, api.ErrorMissingdeletes, err)
+		} else if from > delseqno && err != io.EOF {
+			t.Errorf("from:%v expected %v, got %v", from, io.EOF, err)
+		}
+		iter(true /*fin*/)
+	}
+}
+
+func TestMVCCExpiry(t *testing.T) {
+	mvcc := NewMVCC("expiry", Defaultsettings())
+	defer mvcc.Destroy()
+
+	catchup := func() { mvcc.Catchup(mvcc.Getseqno()) }
+	testexpiry(t, mvcc, mvcc.SetExpiry, catchup)
+}
+
+func TestMVCCMerge(t *testing.T) {
+	mvcc := NewMVCC("merge", Defaultsettings())
+	defer mvcc.Destroy()
+
+	catchup := func() { mvcc.Catchup(mvcc.Getseqno()) }
+	testmerge(t, mvcc, mvcc, catchup)
+}
+
+func TestMVCCApproximate(t *testing.T) {
+	mvcc := NewMVCC("approximate", Defaultsettings())
+	defer mvcc.Destroy()
+
+	testapproximate(t, mvcc, mvcc.ApproximateSize, mvcc.Stats)
+}
@@ -2,8 +2,10 @@ package llrb
 
 import "io"
 import "fmt"
+import "bytes"
 import "unsafe"
 import "reflect"
+import "time"
 import "strings"
 import "sync/atomic"
 
@@ -18,12 +20,17 @@ const (
 	ndValreclaim uint64 = 0x8
 )
 
+// flags in node header.
+const (
+	ndOperand uint64 = 0x1
+)
+
 // Llrbnode defines a node in LLRB tree.
 type Llrbnode struct {
 	left     *Llrbnode
 	right    *Llrbnode
 	seqflags uint64 // seqno[64:4] flags[4:0]
-	hdr      uint64 // klen[64:48] access[48:8] reserved[8:0]
+	hdr      uint64 // klen[64:48] access[48:8] flags[8:0]
 	value    unsafe.Pointer
 	key      unsafe.Pointer
 }
@@ -71,6 +78,18 @@ func (nd *Llrbnode) setaccess(access uint64) *Llrbnode {
 	return nd
 }
 
+func (nd *Llrbnode) isoperand() bool {
+	return (nd.gethdr() & ndOperand) == ndOperand
+}
+
+func (nd *Llrbnode) setoperand() *Llrbnode {
+	return nd.sethdr(nd.gethdr() | ndOperand)
+}
+
+func (nd *Llrbnode) clearoperand() *Llrbnode {
+	return nd.sethdr(nd.gethdr() & (^ndOperand))
+}
+
 func (nd *Llrbnode) getkey() (key []byte) {
 	klen := nd.getkeylen()
 	sl := (*reflect.SliceHeader)(unsafe.Pointer(&key))
@@ -90,6 +109,26 @@ func (nd *Llrbnode) setkey(key []byte) *Llrbnode {
 	return nd
 }
 
+//----- expiry
+
+// getexpiry return unix time in seconds, ZERO if entry never expires.
+// Expiry is held in value, along with the value bytes.
+func (nd *Llrbnode) getexpiry() int64 {
+	if nv := nd.nodevalue(); nv != nil {
+		return nv.getexpiry()
+	}
+	return 0
+}
+
+func (nd *Llrbnode) isexpired() bool {
+	return isexpired(nd.getexpiry(), time.Now().Unix())
+}
+
+// isexpired return true if expiry is set and it has elapsed at `now`.
+func isexpired(expiry, now int64) bool {
+	return expiry > 0 && expiry <= now
+}
+
 //----- seqno and flags
 
 func (nd *Llrbnode) getseqflags() uint64 {
@@ -192,9 +231,18 @@ func (nd *Llrbnode) isreclaim() bool {
 	return (seqflags & ndValreclaim) == ndValreclaim
 }
 
+// visible return the value and deleted flag of this entry as seen by
+// readers, an expired entry is treated as deleted without value.
+func (nd *Llrbnode) visible() (value []byte, deleted bool) {
+	if nd.isexpired() {
+		return nil, true
+	}
+	return nd.Value(), nd.isdeleted()
+}
+
 // Value return the value byte-slice for this entry.
 func (nd *Llrbnode) Value() []byte {
-	if nv := nd.nodevalue(); nv != nil {
+	if nv := nd.nodevalue(); nv != nil && nv.valsize() > 0 {
 		return nv.value()
 	}
 	return nil
@@ -298,3 +346,42 @@ func (nd *Llrbnode) gekey(other []byte, partial bool) bool {
 	cmp := api.Binarycmp(key, other, partial)
 	return cmp == 0 || cmp == 1
 }
+
+// keyposition return an estimate of the fraction of entries, in the
+// sub-tree rooted at nd, that sort before key, or till key if
+// inclusive. Left and right sub-trees are assumed to be of same size,
+// which is approximately true for a balanced tree.
+func (nd *Llrbnode) keyposition(key []byte, inclusive bool) float64 {
+	pos, width := float64(0), float64(1)
+	for nd != nil {
+		width /= 2
+		if nd.ltkey(key, false) || (inclusive && !nd.gtkey(key, false)) {
+			pos, nd = pos+width, nd.right
+		} else {
+			nd = nd.left
+		}
+	}
+	return pos
+}
+
+// rangesize return an estimate of bytes, in keys and values, held by
+// entries between low and high, both inclusive, from payload of
+// sub-tree rooted at nd.
+func (nd *Llrbnode) rangesize(low, high []byte, payload int64) int64 {
+	if nd == nil {
+		return 0
+	} else if low != nil && high != nil && bytes.Compare(low, high) > 0 {
+		return 0
+	}
+	from, till := float64(0), float64(1)
+	if low != nil {
+		from = nd.keyposition(low, false /*inclusive*/)
+	}
+	if high != nil {
+		till = nd.keyposition(high, true /*inclusive*/)
+	}
+	if till <= from {
+		return 0
+	}
+	return int64(float64(payload) * (till - from))
+}
@@ -9,8 +9,12 @@ type scanbuf struct {
 	values [][]byte
 	seqnos []uint64
 	dels   []bool
+	opers  []bool
+	expiry []int64
 	windex int
 	rindex int
+	high   []byte // nil for unbounded scan.
+	incl   bool   // whether high key is inclusive.
 }
 
 func makescanbuf() *scanbuf {
@@ -19,16 +23,40 @@ func makescanbuf() *scanbuf {
 		values: make([][]byte, scanlimit),
 		seqnos: make([]uint64, scanlimit),
 		dels:   make([]bool, scanlimit),
+		opers:  make([]bool, scanlimit),
+		expiry: make([]int64, scanlimit),
 		rindex: 0,
 		windex: 0,
 	}
 }
 
+func (sb *scanbuf) setrange(high []byte, incl bool) *scanbuf {
+	if high != nil {
+		sb.high = lib.Fixbuffer(sb.high, int64(len(high)))
+		copy(sb.high, high)
+	}
+	sb.incl = incl
+	return sb
+}
+
+// beyond return true if node's key is beyond the high key of scan.
+func (sb *scanbuf) beyond(nd *Llrbnode) bool {
+	if sb.high == nil {
+		return false
+	} else if sb.incl {
+		return nd.gtkey(sb.high, false)
+	}
+	return nd.gekey(sb.high, false)
+}
+
 func (sb *scanbuf) preparewrite() {
 	sb.windex = 0
 }
 
-func (sb *scanbuf) append(key, value []byte, seqno uint64, deleted bool) int {
+func (sb *scanbuf) append(
+	key, value []byte, seqno uint64, deleted, operand bool,
+	expiry int64) int {
+
 	if sb.windex >= scanlimit {
 		panic("impossible situation, scanlimit exceeded")
 	}
@@ -45,6 +73,8 @@ func (sb *scanbuf) append(key, value []byte, seqno uint64, deleted bool) int {
 
 	sb.seqnos[sb.windex] = seqno
 	sb.dels[sb.windex] = deleted
+	sb.opers[sb.windex] = operand
+	sb.expiry[sb.windex] = expiry
 	sb.windex++
 	return sb.windex
 }
@@ -53,11 +83,14 @@ func (sb *scanbuf) prepareread() {
 	sb.rindex = 0
 }
 
-func (sb *scanbuf) pop() (key, value []byte, seqno uint64, deleted bool) {
+func (sb *scanbuf) pop() (
+	key, value []byte, seqno uint64, deleted, operand bool, expiry int64) {
+
 	if sb.rindex < sb.windex {
 		i := sb.rindex
 		key, value = sb.keys[i], sb.values[i]
-		seqno, deleted = sb.seqnos[i], sb.dels[i]
+		seqno, deleted, expiry = sb.seqnos[i], sb.dels[i], sb.expiry[i]
+		operand = sb.opers[i]
 		sb.rindex++
 	}
 	return
@@ -19,7 +19,7 @@ func TestScanner(t *testing.T) {
 	verify := func(from, till int) {
 		i := from
 		sb.prepareread()
-		key, val, seqno, deleted := sb.pop()
+		key, val, seqno, deleted, _, _ := sb.pop()
 		for key != nil {
 			tdata := testdata[i]
 			refkey, refval := tdata[0].([]byte), tdata[1].([]byte)
@@ -33,7 +33,7 @@ func TestScanner(t *testing.T) {
 			} else if deleted != refdeleted {
 				t.Errorf("expected %v, got %v", refdeleted, deleted)
 			}
-			key, val, seqno, deleted = sb.pop()
+			key, val, seqno, deleted, _, _ = sb.pop()
 			i++
 		}
 		if i != (till + 1) {
@@ -46,7 +46,8 @@ func TestScanner(t *testing.T) {
 	for till, tdata := range testdata {
 		key, val := tdata[0].([]byte), tdata[1].([]byte)
 		seqno, deleted := tdata[2].(uint64), tdata[3].(bool)
-		if n := sb.append(key, val, seqno, deleted); n >= scanlimit {
+		n := sb.append(key, val, seqno, deleted, false, 0)
+		if n >= scanlimit {
 			verify(from, till)
 			sb.preparewrite()
 			from = till + 1
@@ -78,23 +78,25 @@ func (snap *mvccsnapshot) setroot(root *Llrbnode) {
 
 // Get value for key, if value argument is not nil it will be used to copy the
 // entry's value. Also returns entry's cas, whether entry is marked as deleted
-// by LSM. If ok is false, then key is not found.
+// by LSM, whether entry is a merge operand. If ok is false, then key is not
+// found.
 func (snap *mvccsnapshot) get(
-	key, value []byte) (v []byte, cas uint64, deleted bool, ok bool) {
+	key, value []byte) (v []byte, cas uint64, deleted, operand, ok bool) {
 
 	deleted, seqno := false, uint64(0)
 	nd, ok := snap.getkey(snap.getroot(), key)
 	if ok {
+		var val []byte
+		val, deleted = nd.visible()
 		if value != nil {
-			val := nd.Value()
 			value = lib.Fixbuffer(value, int64(len(val)))
 			copy(value, val)
 		}
-		seqno, deleted = nd.getseqno(), nd.isdeleted()
+		seqno, operand = nd.getseqno(), nd.isoperand()
 	} else if value != nil {
 		value = lib.Fixbuffer(value, 0)
 	}
-	return value, seqno, deleted, ok
+	return value, seqno, deleted, operand, ok
 }
 
 func (snap *mvccsnapshot) getkey(nd *Llrbnode, k []byte) (*Llrbnode, bool) {
@@ -14,6 +14,11 @@ type Txn struct {
 	snapshot interface{}
 	tblcrc32 *crc32.Table
 	writes   map[uint32]*record
+	wlog     []*record // writes in the order they were made.
+	saves    []int     // savepoint stack, offsets into wlog.
+	reads    []*record
+	locked   map[string]bool
+	expired  bool // aborted for being active beyond txnmaxage.
 	cursors  []*Cursor
 	recchan  chan *record
 	curchan  chan *Cursor
@@ -22,6 +27,7 @@ type Txn struct {
 const (
 	cmdSet byte = iota + 1
 	cmdDelete
+	cmdGet
 )
 
 func newtxn(
@@ -52,7 +58,14 @@ func (txn *Txn) ID() uint64 {
 // under the transaction are successfully applied. Return
 // ErrorRollback if ACID properties are not met while applying the
 // write operations. Transactions are never partially committed.
+// For MVCC, if keys read or written by the transaction were updated
+// by other writers after they were read, transaction is rolled back.
+// Keys read via cursors are not validated. Return ErrorExpired if
+// transaction was aborted for being active beyond "txnmaxage".
 func (txn *Txn) Commit() error {
+	if txn.isexpired() {
+		return api.ErrorExpired
+	}
 	switch db := txn.db.(type) {
 	case *LLRB:
 		return db.commit(txn)
@@ -64,6 +77,9 @@ func (txn *Txn) Commit() error {
 
 // Abort transaction, underlying index won't be touched.
 func (txn *Txn) Abort() {
+	if txn.expired {
+		return
+	}
 	switch db := txn.db.(type) {
 	case *LLRB:
 		db.aborttxn(txn)
@@ -74,29 +90,118 @@ func (txn *Txn) Abort() {
 
 // OpenCursor open an active cursor inside the index.
 func (txn *Txn) OpenCursor(key []byte) (api.Cursor, error) {
+	if txn.isexpired() {
+		return nil, api.ErrorExpired
+	}
 	cur := txn.getcursor().opencursor(txn, txn.snapshot, key)
 	return cur, nil
 }
 
+// Savepoint mark the current state of writes made by this transaction
+// and return the savepoint, which can later be passed to RollbackTo.
+// Savepoints are stacked, rolling back to a savepoint shall discard
+// all savepoints taken after it. Return -1 if transaction has
+// expired.
+func (txn *Txn) Savepoint() int {
+	if txn.isexpired() {
+		return -1
+	}
+	txn.saves = append(txn.saves, len(txn.wlog))
+	return len(txn.saves) - 1
+}
+
+// RollbackTo undo all writes made after savepoint, writes made before
+// savepoint are retained and transaction can continue. Savepoint
+// remains valid and can be rolled back to again. Keys read or locked
+// after savepoint are still validated and locked till the end of the
+// transaction.
+func (txn *Txn) RollbackTo(savepoint int) error {
+	if txn.isexpired() {
+		return api.ErrorExpired
+	}
+	if savepoint < 0 || savepoint >= len(txn.saves) {
+		return api.ErrorInvalidSavepoint
+	}
+	mark := txn.saves[savepoint]
+	for i := len(txn.wlog) - 1; i >= mark; i-- {
+		txn.unlinkwrite(txn.wlog[i])
+		txn.wlog[i] = nil
+	}
+	txn.wlog, txn.saves = txn.wlog[:mark], txn.saves[:savepoint+1]
+	return nil
+}
+
 //---- Exported Read methods
 
 // Get value for key from snapshot.
 func (txn *Txn) Get(
 	key, value []byte) (v []byte, cas uint64, deleted, ok bool) {
 
+	v, cas, deleted, _, ok = txn.Getoperand(key, value)
+	return
+}
+
+// Getoperand is same as Get, additionally return whether value is a
+// merge operand. Entries written by this transaction are never merge
+// operands.
+func (txn *Txn) Getoperand(
+	key, value []byte) (v []byte, cas uint64, deleted, operand, ok bool) {
+
+	if txn.isexpired() {
+		return lib.Fixbuffer(value, 0), 0, false, false, false
+	}
 	index := crc32.Checksum(key, txn.tblcrc32)
 	head, _ := txn.writes[index]
 	_, next := head.get(key)
 	if next == nil {
-		v, cas, deleted, ok = txn.getonsnap(key, value)
-		return
+		v, cas, deleted, operand, ok = txn.getonsnap(key, value)
+		txn.addread(key, cas)
+		return v, cas, deleted, operand, ok
 
 	} else if next.cmd == cmdDelete {
-		return lib.Fixbuffer(v, 0), next.seqno, true, true
+		return lib.Fixbuffer(v, 0), next.seqno, true, false, true
 	}
 	v = lib.Fixbuffer(value, int64(len(next.value)))
 	copy(v, next.value)
-	return v, next.seqno, false, true
+	return v, next.seqno, false, false, true
+}
+
+// GetForUpdate is same as Get, additionally lock the key until the
+// transaction is committed or aborted and return its latest version,
+// which might be newer than the transaction's snapshot. Other
+// transactions locking the same key shall wait for upto "locktimeout"
+// and return ErrorLocktimeout, or return ErrorDeadlock if waiting
+// would deadlock. Writes outside transactions are not blocked by the
+// lock. LLRB transactions are exclusive, hence key is not locked.
+func (txn *Txn) GetForUpdate(
+	key, value []byte) (v []byte, cas uint64, deleted, ok bool, err error) {
+
+	if txn.isexpired() {
+		return value, 0, false, false, api.ErrorExpired
+	}
+	db, yes := txn.db.(*MVCC)
+	if !yes {
+		v, cas, deleted, ok = txn.Get(key, value)
+		return v, cas, deleted, ok, nil
+	}
+
+	if err = db.keylocks.Lock(txn, key, db.locktimeout); err != nil {
+		return value, 0, false, false, err
+	}
+	if txn.locked == nil {
+		txn.locked = make(map[string]bool)
+	}
+	txn.locked[string(key)] = true
+
+	index := crc32.Checksum(key, txn.tblcrc32)
+	head, _ := txn.writes[index]
+	if _, next := head.get(key); next != nil {
+		v, cas, deleted, ok = txn.Get(key, value)
+		return v, cas, deleted, ok, nil
+	}
+	v, cas, deleted, ok = db.getlatest(key, value)
+	txn.addread(key, cas)
+	return v, cas, deleted, ok, nil
 }
 
 //---- Exported Write methods
@@ -106,6 +211,9 @@ func (txn *Txn) Get(
 func (txn *Txn) Set(key, value, oldvalue []byte) []byte {
 	var seqno uint64
 
+	if txn.isexpired() {
+		return oldvalue
+	}
 	node := txn.getrecord()
 	node.key = lib.Fixbuffer(node.key, int64(len(key)))
 	copy(node.key, key)
@@ -117,6 +225,7 @@ func (txn *Txn) Set(key, value, oldvalue []byte) []byte {
 	head, _ := txn.writes[index]
 	old, newhead := head.prepend(key, node)
 	txn.writes[index] = newhead
+	txn.wlog = append(txn.wlog, node)
 
 	if old != nil {
 		if oldvalue != nil {
@@ -125,7 +234,7 @@ func (txn *Txn) Set(key, value, oldvalue []byte) []byte {
 		}
 		node.seqno = old.seqno
 	} else {
-		oldvalue, seqno, _, _ = txn.getonsnap(key, oldvalue)
+		oldvalue, seqno = txn.getforwrite(key, oldvalue)
 		node.seqno = seqno
 	}
 	return oldvalue
@@ -136,6 +245,9 @@ func (txn *Txn) Set(key, value, oldvalue []byte) []byte {
 func (txn *Txn) Delete(key, oldvalue []byte, lsm bool) []byte {
 	var seqno uint64
 
+	if txn.isexpired() {
+		return oldvalue
+	}
 	node := txn.getrecord()
 	node.key = lib.Fixbuffer(node.key, int64(len(key)))
 	copy(node.key, key)
@@ -146,6 +258,7 @@ func (txn *Txn) Delete(key, oldvalue []byte, lsm bool) []byte {
 	head, _ := txn.writes[index]
 	old, newhead := head.prepend(key, node)
 	txn.writes[index] = newhead
+	txn.wlog = append(txn.wlog, node)
 	if old != nil {
 		if oldvalue != nil {
 			oldvalue = lib.Fixbuffer(oldvalue, int64(len(old.value)))
@@ -153,7 +266,7 @@ func (txn *Txn) Delete(key, oldvalue []byte, lsm bool) []byte {
 		}
 		node.seqno = old.seqno
 	} else {
-		oldvalue, seqno, _, _ = txn.getonsnap(key, oldvalue)
+		oldvalue, seqno = txn.getforwrite(key, oldvalue)
 		node.seqno = seqno
 	}
 	return oldvalue
@@ -161,22 +274,25 @@ func (txn *Txn) Delete(key, oldvalue []byte, lsm bool) []byte {
 
 //---- local methods
 
-func (txn *Txn) getonsnap(key, value []byte) ([]byte, uint64, bool, bool) {
+func (txn *Txn) getonsnap(
+	key, value []byte) ([]byte, uint64, bool, bool, bool) {
+
 	switch snap := txn.snapshot.(type) {
 	case *LLRB:
-		deleted, seqno := false, uint64(0)
+		deleted, operand, seqno := false, false, uint64(0)
 		nd, ok := snap.getkey(snap.getroot(), key)
 		if ok {
+			var val []byte
+			val, deleted = nd.visible()
 			if value != nil {
-				val := nd.Value()
 				value = lib.Fixbuffer(value, int64(len(val)))
 				copy(value, val)
 			}
-			seqno, deleted = nd.getseqno(), nd.isdeleted()
+			seqno, operand = nd.getseqno(), nd.isoperand()
 		} else if value != nil {
 			value = lib.Fixbuffer(value, 0)
 		}
-		return value, seqno, deleted, ok
+		return value, seqno, deleted, operand, ok
 
 	case *mvccsnapshot:
 		return snap.get(key, value)
@@ -184,6 +300,70 @@ func (txn *Txn) getonsnap(key, value []byte) ([]byte, uint64, bool, bool) {
 	panic("unreachable code")
 }
 
+// getforwrite return the version of key to be validated on commit,
+// latest version for locked keys and snapshot's version otherwise.
+func (txn *Txn) getforwrite(key, value []byte) ([]byte, uint64) {
+	if txn.locked[string(key)] {
+		v, seqno, _, _ := txn.db.(*MVCC).getlatest(key, value)
+		return v, seqno
+	}
+	v, seqno, _, _, _ := txn.getonsnap(key, value)
+	return v, seqno
+}
+
+// unlinkwrite remove node, which must be the latest write on its key,
+// from writes. Older writes on the same key are chained after node.
+func (txn *Txn) unlinkwrite(node *record) {
+	index := crc32.Checksum(node.key, txn.tblcrc32)
+	head, _ := txn.writes[index]
+	parent, next := head.get(node.key)
+	if next != node {
+		panic("impossible situation")
+	}
+	if parent != nil {
+		parent.next = node.next
+	} else if node.next != nil {
+		txn.writes[index] = node.next
+	} else {
+		delete(txn.writes, index)
+	}
+	node.next = nil
+	txn.putrecord(node)
+}
+
+// isexpired return true if transaction was aborted for being active
+// beyond "txnmaxage", abort it now if it has just expired. Writes on
+// an expired transaction are ignored.
+func (txn *Txn) isexpired() bool {
+	if txn.expired {
+		return true
+	}
+	db, ok := txn.db.(*MVCC)
+	if !ok || !db.txnabort || !db.tracker.Expired(txn) {
+		return false
+	}
+	warnf("%v txn %v aborted, active beyond txnmaxage", db.logprefix, txn.id)
+	db.tracker.Aborted()
+	txn.expired = true
+	db.aborttxn(txn)
+	return true
+}
+
+// addread remember the seqno observed for key, so that commit can
+// detect whether key was updated after it was read. Only MVCC
+// transactions can be interleaved with other writers.
+func (txn *Txn) addread(key []byte, seqno uint64) {
+	if _, ok := txn.snapshot.(*mvccsnapshot); !ok {
+		return
+	}
+	node := txn.getrecord()
+	node.key = lib.Fixbuffer(node.key, int64(len(key)))
+	copy(node.key, key)
+	node.value = lib.Fixbuffer(node.value, 0)
+	node.cmd, node.seqno, node.next = cmdGet, seqno, nil
+	txn.reads = append(txn.reads, node)
+}
+
 func (txn *Txn) getrecord() (rec *record) {
 	select {
 	case rec = <-txn.recchan:
@@ -6,14 +6,43 @@ package llrb
 
 import "unsafe"
 import "reflect"
+import "encoding/binary"
+
+import "github.com/bnclabs/gostore/api"
 
 const nvaluesize = int(unsafe.Sizeof(nodevalue{})) - 8 // + valuesize
 
+// flags in value header.
+const (
+	nvExpiry uint64 = 0x8000000000000000
+)
+
+// nodevalue is followed by value bytes, and by 8-byte expiry as unix
+// time in seconds, if nvExpiry is set.
 type nodevalue struct {
-	hdr      uint64         // valuesize[39:]
+	hdr      uint64         // flags[64:40] valuesize[39:]
 	valstart unsafe.Pointer // just a place-holder
 }
 
+// newnodevalue allocate value from arena, with room for expiry only if
+// expiry is set. Return nil if there is neither value nor expiry.
+func newnodevalue(arena api.Mallocer, v []byte, expiry int64) *nodevalue {
+	if len(v) == 0 && expiry == 0 {
+		return nil
+	}
+	size := nvaluesize + len(v)
+	if expiry != 0 {
+		size += 8
+	}
+	nv := (*nodevalue)(arena.Alloc(int64(size)))
+	nv.hdr = 0
+	nv.setvalue(v)
+	if expiry != 0 {
+		nv.setexpiry(expiry)
+	}
+	return nv
+}
+
 func (nv *nodevalue) sizeof() int {
 	return int(unsafe.Sizeof(*nv))
 }
@@ -42,6 +71,28 @@ func (nv *nodevalue) setvalue(val []byte) *nodevalue {
 	return nv
 }
 
+// setexpiry shall be called after setvalue, on value allocated with
+// room for expiry.
+func (nv *nodevalue) setexpiry(expiry int64) *nodevalue {
+	binary.BigEndian.PutUint64(nv.expirybytes(), uint64(expiry))
+	nv.hdr |= nvExpiry
+	return nv
+}
+
+func (nv *nodevalue) getexpiry() int64 {
+	if (nv.hdr & nvExpiry) == 0 {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(nv.expirybytes()))
+}
+
+func (nv *nodevalue) expirybytes() (buf []byte) {
+	sl := (*reflect.SliceHeader)(unsafe.Pointer(&buf))
+	sl.Len, sl.Cap = 8, 8
+	sl.Data = (uintptr)(unsafe.Pointer(&nv.valstart)) + uintptr(nv.valsize())
+	return
+}
+
 func (nv *nodevalue) value() (val []byte) {
 	sl := (*reflect.SliceHeader)(unsafe.Pointer(&val))
 	sl.Len = nv.valsize()
@@ -3,6 +3,7 @@ package llrb
 import "testing"
 import "bytes"
 import "fmt"
+import "unsafe"
 
 import "github.com/bnclabs/gostore/malloc"
 
@@ -26,6 +27,37 @@ func TestNodeValue(t *testing.T) {
 	marena.Free(ptr)
 }
 
+func TestNodeValueExpiry(t *testing.T) {
+	capacity := int64(1024 * 1024 * 1024)
+	marena := malloc.NewArena(capacity, "flist")
+	value := []byte("hello world")
+
+	if nv := newnodevalue(marena, nil, 0); nv != nil {
+		t.Errorf("unexpected value")
+	}
+	nv := newnodevalue(marena, value, 0)
+	if x := nv.getexpiry(); x != 0 {
+		t.Errorf("expected %v, got %v", 0, x)
+	}
+	marena.Free(unsafe.Pointer(nv))
+
+	nv = newnodevalue(marena, value, 1234567890)
+	if x := nv.getexpiry(); x != 1234567890 {
+		t.Errorf("expected %v, got %v", 1234567890, x)
+	} else if v := nv.value(); bytes.Compare(value, v) != 0 {
+		t.Errorf("expected %v, got %v", value, v)
+	}
+	marena.Free(unsafe.Pointer(nv))
+
+	nv = newnodevalue(marena, nil, 1234567890)
+	if x := nv.getexpiry(); x != 1234567890 {
+		t.Errorf("expected %v, got %v", 1234567890, x)
+	} else if x := nv.valsize(); x != 0 {
+		t.Errorf("expected %v, got %v", 0, x)
+	}
+	marena.Free(unsafe.Pointer(nv))
+}
+
 func BenchmarkValueSize(b *testing.B) {
 	capacity := int64(1024 * 1024 * 1024)
 	marena := malloc.NewArena(capacity, "flist")
@@ -1,11 +1,13 @@
 package llrb
 
 import "github.com/bnclabs/gostore/api"
+import "github.com/bnclabs/gostore/lib"
 
 // View transaction definition. Read only version of Txn.
 type View struct {
 	id       uint64
 	snapshot interface{}
+	expired  bool // aborted for being active beyond txnmaxage.
 	cursors  []*Cursor
 	curchan  chan *Cursor
 }
@@ -24,12 +26,18 @@ func (view *View) ID() uint64 {
 
 // OpenCursor open an active cursor inside the index.
 func (view *View) OpenCursor(key []byte) (api.Cursor, error) {
+	if view.isexpired() {
+		return nil, api.ErrorExpired
+	}
 	cur := view.getcursor().opencursor(nil, view.snapshot, key)
 	return cur, nil
 }
 
 // Abort view, must be called once done with the view.
 func (view *View) Abort() {
+	if view.expired {
+		return
+	}
 	switch snap := view.snapshot.(type) {
 	case *LLRB:
 		snap.abortview(view)
@@ -59,13 +67,26 @@ func (view *View) Commit() error {
 func (view *View) Get(
 	key, value []byte) (v []byte, cas uint64, deleted, ok bool) {
 
-	v, cas, deleted, ok = view.getonsnap(key, value)
+	v, cas, deleted, _, ok = view.getonsnap(key, value)
 	return
 }
 
+// Getoperand is same as Get, additionally return whether value is a
+// merge operand, yet to be folded over older versions of the key.
+func (view *View) Getoperand(
+	key, value []byte) (v []byte, cas uint64, deleted, operand, ok bool) {
+
+	return view.getonsnap(key, value)
+}
+
 //---- local methods
 
-func (view *View) getonsnap(key, value []byte) ([]byte, uint64, bool, bool) {
+func (view *View) getonsnap(
+	key, value []byte) ([]byte, uint64, bool, bool, bool) {
+
+	if view.isexpired() {
+		return lib.Fixbuffer(value, 0), 0, false, false, false
+	}
 	switch snap := view.snapshot.(type) {
 	case *LLRB:
 		return snap.get(key, value)
@@ -75,6 +96,28 @@ func (view *View) getonsnap(key, value []byte) ([]byte, uint64, bool, bool) {
 	panic("unreachable code")
 }
 
+// isexpired return true if view was aborted for being active beyond
+// "txnmaxage", abort it now if it has just expired.
+func (view *View) isexpired() bool {
+	if view.expired {
+		return true
+	}
+	snap, ok := view.snapshot.(*mvccsnapshot)
+	if !ok {
+		return false
+	}
+	mvcc := snap.mvcc
+	if !mvcc.txnabort || !mvcc.tracker.Expired(view) {
+		return false
+	}
+	fmsg := "%v view %v aborted, active beyond txnmaxage"
+	warnf(fmsg, mvcc.logprefix, view.id)
+	mvcc.tracker.Aborted()
+	view.expired = true
+	mvcc.abortview(view)
+	return true
+}
+
 func (view *View) getcursor() (cur *Cursor) {
 	select {
 	case cur = <-view.curchan:
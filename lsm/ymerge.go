@@ -0,0 +1,261 @@
+package lsm
+
+import "io"
+import "bytes"
+
+import "github.com/bnclabs/gostore/api"
+
+// Mergeable adapts a Getter to MergeGetter, for indexes that can't hold
+// merge operands, like disk snapshots.
+func Mergeable(get api.Getter) api.MergeGetter {
+	return func(key, value []byte) ([]byte, uint64, bool, bool, bool) {
+		val, cas, del, ok := get(key, value)
+		return val, cas, del, false, ok
+	}
+}
+
+// MergeableIterator adapts an Iterator to MergeIterator, for indexes
+// that can't hold merge operands, like disk snapshots.
+func MergeableIterator(iter api.Iterator) api.MergeIterator {
+	if iter == nil {
+		return nil
+	}
+	return func(fin bool) ([]byte, []byte, uint64, bool, bool, error) {
+		key, val, seqno, del, err := iter(fin)
+		return key, val, seqno, del, false, err
+	}
+}
+
+// domerge fold operand over older value. If older version is missing,
+// returned value continues to be an operand.
+func domerge(
+	merge api.MergeOperator, key, operand, val []byte,
+	del, op, ok bool) ([]byte, bool) {
+
+	if !ok {
+		return operand, true
+	} else if del {
+		return merge(key, nil, operand), false
+	}
+	return merge(key, val, operand), op
+}
+
+// YGetMerge is same as YGet, except that if b returns a merge operand,
+// it is folded over the value returned by a. Returned value remains an
+// operand if a is also missing the key or returns an operand.
+func YGetMerge(a, b api.MergeGetter, merge api.MergeOperator) api.MergeGetter {
+	return func(key, value []byte) ([]byte, uint64, bool, bool, bool) {
+		buf := value
+		if buf == nil {
+			buf = make([]byte, 0, 16)
+		}
+		val, cas, del, op, ok := b(key, buf)
+		if !ok {
+			return a(key, value)
+		} else if !op {
+			if value == nil {
+				val = nil
+			}
+			return val, cas, del, op, ok
+		}
+		operand := cp(nil, val)
+		aval, _, adel, aop, aok := a(key, buf)
+		val, op = domerge(merge, key, operand, aval, adel, aop, aok)
+		return val, cas, false, op, true
+	}
+}
+
+func pullm(
+	x api.MergeIterator, fin bool,
+	k, v []byte) ([]byte, []byte, uint64, bool, bool, error) {
+
+	if x == nil {
+		return k, v, 0, false, false, io.EOF
+	}
+	key, val, seqno, del, op, err := x(fin)
+	for err == nil && bytes.Compare(key, k) == 0 {
+		key, val, seqno, del, op, err = x(fin)
+	}
+	if err != nil {
+		return k, v, seqno, del, op, err
+	}
+	return cp(k, key), cp(v, val), seqno, del, op, err
+}
+
+// YSortMerge is same as YSort, except that if the latest version of a
+// key is a merge operand, it is folded over the older version of the
+// key. Returned entry remains an operand if older version is missing
+// or is an operand itself.
+func YSortMerge(
+	a, b api.MergeIterator, merge api.MergeOperator) api.MergeIterator {
+
+	key, val := make([]byte, 0, 16), make([]byte, 0, 16)
+
+	bkey, bval := make([]byte, 0, 16), make([]byte, 0, 16)
+	bkey, bval, bseqno, bdel, bop, berr := pullm(b, false, bkey, bval)
+	akey, aval := make([]byte, 0, 16), make([]byte, 0, 16)
+	akey, aval, aseqno, adel, aop, aerr := pullm(a, false, akey, aval)
+
+	return func(fin bool) ([]byte, []byte, uint64, bool, bool, error) {
+		var seqno uint64
+		var del, op bool
+		var err error
+
+		if aerr != nil && berr != nil {
+			key, val, seqno, del, op, err = nil, nil, 0, false, false, io.EOF
+
+		} else if aerr != nil {
+			key, val = cp(key, bkey), cp(val, bval)
+			seqno, del, op, err = bseqno, bdel, bop, berr
+			bkey, bval, bseqno, bdel, bop, berr = pullm(b, fin, bkey, bval)
+
+		} else if berr != nil {
+			key, val = cp(key, akey), cp(val, aval)
+			seqno, del, op, err = aseqno, adel, aop, aerr
+			akey, aval, aseqno, adel, aop, aerr = pullm(a, fin, akey, aval)
+
+		} else if cmp := bytes.Compare(bkey, akey); cmp < 0 {
+			key, val = cp(key, bkey), cp(val, bval)
+			seqno, del, op, err = bseqno, bdel, bop, berr
+			bkey, bval, bseqno, bdel, bop, berr = pullm(b, fin, bkey, bval)
+
+		} else if cmp > 0 {
+			key, val = cp(key, akey), cp(val, aval)
+			seqno, del, op, err = aseqno, adel, aop, aerr
+			akey, aval, aseqno, adel, aop, aerr = pullm(a, fin, akey, aval)
+
+		} else {
+			if bseqno > aseqno && bop {
+				key, seqno, del, err = cp(key, bkey), bseqno, false, berr
+				v, o := domerge(merge, key, bval, aval, adel, aop, true)
+				val, op = cp(val, v), o
+			} else if bseqno > aseqno {
+				key, val = cp(key, bkey), cp(val, bval)
+				seqno, del, op, err = bseqno, bdel, bop, berr
+			} else if aop {
+				key, seqno, del, err = cp(key, akey), aseqno, false, aerr
+				v, o := domerge(merge, key, aval, bval, bdel, bop, true)
+				val, op = cp(val, v), o
+			} else {
+				key, val = cp(key, akey), cp(val, aval)
+				seqno, del, op, err = aseqno, adel, aop, aerr
+			}
+			bkey, bval, bseqno, bdel, bop, berr = pullm(b, fin, bkey, bval)
+			akey, aval, aseqno, adel, aop, aerr = pullm(a, fin, akey, aval)
+		}
+		return key, val, seqno, del, op, err
+	}
+}
+
+// YSortEntriesMerge is same as YSortEntries, except that if the latest
+// version of a key is a merge operand, it is folded over the older
+// version of the key. Returned entry remains an operand if older
+// version is missing or is an operand itself.
+func YSortEntriesMerge(
+	a, b api.EntryIterator, merge api.MergeOperator) api.EntryIterator {
+
+	var aentry, bentry api.IndexEntry
+	var key []byte
+	var aseqno, bseqno uint64
+	var aerr, berr error
+
+	akey, bkey := make([]byte, 0, 16), make([]byte, 0, 16)
+	eof, mentry := neweofentry(), &mergeentry{}
+	anext, bnext, entry := true, true, api.IndexEntry(eof)
+
+	return func(fin bool) api.IndexEntry {
+		if aerr == nil && anext {
+			aentry = pulle(a, fin, akey, eof)
+			if aentry != nil {
+				key, aseqno, _, aerr = aentry.Key()
+				akey = cp(akey, key)
+			} else {
+				aerr = io.EOF
+			}
+		}
+		if berr == nil && bnext {
+			bentry = pulle(b, fin, bkey, eof)
+			if bentry != nil {
+				key, bseqno, _, berr = bentry.Key()
+				bkey = cp(bkey, key)
+			} else {
+				berr = io.EOF
+			}
+		}
+
+		anext, bnext = false, false
+		if aerr != nil && berr != nil {
+			entry = eof
+
+		} else if aerr != nil {
+			entry, bnext = bentry, true
+
+		} else if berr != nil {
+			entry, anext = aentry, true
+
+		} else if cmp := bytes.Compare(bkey, akey); cmp < 0 {
+			entry, bnext = bentry, true
+
+		} else if cmp > 0 {
+			entry, anext = aentry, true
+
+		} else {
+			newer, older := aentry, bentry
+			if bseqno > aseqno {
+				newer, older = bentry, aentry
+			}
+			entry = newer
+			if newer.IsOperand() {
+				_, _, del, _ := older.Key()
+				val, op := domerge(
+					merge, bkey, newer.Value(), older.Value(),
+					del, older.IsOperand(), true /*ok*/)
+				entry = mentry.set(newer, val, op)
+			}
+			anext, bnext = true, true
+		}
+		return entry
+	}
+}
+
+// mergeentry is an entry whose operand is folded over older version.
+type mergeentry struct {
+	id    string
+	key   []byte
+	value []byte
+	seqno uint64
+	op    bool
+}
+
+func (me *mergeentry) set(
+	entry api.IndexEntry, value []byte, op bool) *mergeentry {
+
+	key, seqno, _, _ := entry.Key()
+	me.id, me.key, me.seqno = entry.ID(), cp(me.key, key), seqno
+	me.value, me.op = cp(me.value, value), op
+	return me
+}
+
+func (me *mergeentry) ID() string {
+	return me.id
+}
+
+func (me *mergeentry) Key() (key []byte, seqno uint64, del bool, err error) {
+	return me.key, me.seqno, false, nil
+}
+
+func (me *mergeentry) Value() []byte {
+	return me.value
+}
+
+func (me *mergeentry) Valueref() (valuelen uint64, vlogpos int64) {
+	return uint64(len(me.value)), -1
+}
+
+func (me *mergeentry) Expiry() int64 {
+	return 0
+}
+
+func (me *mergeentry) IsOperand() bool {
+	return me.op
+}
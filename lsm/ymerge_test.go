@@ -0,0 +1,165 @@
+package lsm
+
+import "io"
+import "fmt"
+import "bytes"
+import "strconv"
+import "testing"
+
+import "github.com/bnclabs/gostore/llrb"
+import s "github.com/bnclabs/gosettings"
+
+func TestYGetMerge(t *testing.T) {
+	ref, base := makeMergeLLRBs(1000)
+	llrb1, llrb2 := makeMergeLLRB("llrb1", ref), makeMergeLLRB("llrb2", ref)
+	defer ref.Destroy()
+	defer base.Destroy()
+	defer llrb1.Destroy()
+	defer llrb2.Destroy()
+
+	get := YGetMerge(Mergeable(base.Get), llrb1.Getoperand, addmerge)
+	get = YGetMerge(get, llrb2.Getoperand, addmerge)
+
+	for i := 0; i < 1200; i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+		refval, refcas, refdel, refok := ref.Get(key, make([]byte, 0, 16))
+		val, cas, del, op, ok := get(key, make([]byte, 0, 16))
+		if ok != refok {
+			t.Fatalf("%q expected %v, got %v", key, refok, ok)
+		} else if del != refdel {
+			t.Fatalf("%q expected %v, got %v", key, refdel, del)
+		} else if del == false && bytes.Compare(val, refval) != 0 {
+			t.Fatalf("%q expected %q, got %q", key, refval, val)
+		} else if x := i >= 1000 && ok; op != x {
+			t.Fatalf("%q expected %v, got %v", key, x, op)
+		} else if cas != refcas {
+			t.Fatalf("%q expected %v, got %v", key, refcas, cas)
+		}
+	}
+}
+
+func TestYSortMerge(t *testing.T) {
+	ref, base := makeMergeLLRBs(1000)
+	llrb1, llrb2 := makeMergeLLRB("llrb1", ref), makeMergeLLRB("llrb2", ref)
+	defer ref.Destroy()
+	defer base.Destroy()
+	defer llrb1.Destroy()
+	defer llrb2.Destroy()
+
+	iter := YSortMerge(
+		MergeableIterator(base.Scan()), llrb1.ScanOperands(nil, nil, false),
+		addmerge)
+	iter = YSortMerge(iter, llrb2.ScanOperands(nil, nil, false), addmerge)
+
+	refiter := ref.Scan()
+	key, value, seqno, deleted, err := refiter(false)
+	for err == nil {
+		k, v, sq, d, op, e := iter(false)
+		i, _ := strconv.Atoi(string(key[3:]))
+		if bytes.Compare(key, k) != 0 {
+			t.Fatalf("expected %q, got %q", key, k)
+		} else if e != nil {
+			t.Fatalf("%q unexpected %v", key, e)
+		} else if d != deleted {
+			t.Fatalf("%q expected %v, got %v", key, deleted, d)
+		} else if sq != seqno {
+			t.Fatalf("%q expected %v, got %v", key, seqno, sq)
+		} else if deleted == false && bytes.Compare(value, v) != 0 {
+			t.Fatalf("%q expected %q, got %q", key, value, v)
+		} else if op != (i >= 1000) {
+			t.Fatalf("%q expected %v, got %v", key, i >= 1000, op)
+		}
+		key, value, seqno, deleted, err = refiter(false)
+	}
+	if _, _, _, _, _, e := iter(false); e != io.EOF {
+		t.Errorf("unexpected %v", e)
+	}
+	iter(true /*fin*/)
+	refiter(true /*fin*/)
+}
+
+func TestYSortEntriesMerge(t *testing.T) {
+	ref, base := makeMergeLLRBs(1000)
+	llrb1, llrb2 := makeMergeLLRB("llrb1", ref), makeMergeLLRB("llrb2", ref)
+	defer ref.Destroy()
+	defer base.Destroy()
+	defer llrb1.Destroy()
+	defer llrb2.Destroy()
+
+	iter := YSortEntriesMerge(base.ScanEntries(), llrb1.ScanEntries(), addmerge)
+	iter = YSortEntriesMerge(iter, llrb2.ScanEntries(), addmerge)
+
+	refiter := ref.Scan()
+	key, value, seqno, deleted, err := refiter(false)
+	for err == nil {
+		entry := iter(false)
+		k, sq, d, e := entry.Key()
+		v := entry.Value()
+		i, _ := strconv.Atoi(string(key[3:]))
+		if bytes.Compare(key, k) != 0 {
+			t.Fatalf("expected %q, got %q", key, k)
+		} else if e != nil {
+			t.Fatalf("%q unexpected %v", key, e)
+		} else if d != deleted {
+			t.Fatalf("%q expected %v, got %v", key, deleted, d)
+		} else if sq != seqno {
+			t.Fatalf("%q expected %v, got %v", key, seqno, sq)
+		} else if deleted == false && bytes.Compare(value, v) != 0 {
+			t.Fatalf("%q expected %q, got %q", key, value, v)
+		} else if x := entry.IsOperand(); x != (i >= 1000) {
+			t.Fatalf("%q expected %v, got %v", key, i >= 1000, x)
+		}
+		key, value, seqno, deleted, err = refiter(false)
+	}
+	if _, _, _, e := iter(false).Key(); e != io.EOF {
+		t.Errorf("unexpected %v", e)
+	}
+	iter(true /*fin*/)
+	refiter(true /*fin*/)
+}
+
+// addmerge treat values as decimal integers and add them.
+func addmerge(key, value, operand []byte) []byte {
+	x, _ := strconv.Atoi(string(value))
+	y, _ := strconv.Atoi(string(operand))
+	return []byte(strconv.Itoa(x + y))
+}
+
+// makeMergeLLRBs return a reference index and a base index with n keys,
+// every 10th key deleted.
+func makeMergeLLRBs(n int) (*llrb.LLRB, *llrb.LLRB) {
+	setts := s.Settings{"memcapacity": 1024 * 1024 * 1024}
+	ref := llrb.NewLLRB("refllrb", setts)
+	base := llrb.NewLLRB("base", setts)
+
+	for i := 0; i < n; i++ {
+		key, val := fmt.Sprintf("key%d", i), fmt.Sprintf("%d", i)
+		base.Set([]byte(key), []byte(val), nil)
+		ref.Set([]byte(key), []byte(val), nil)
+		if i%10 == 0 {
+			base.Delete([]byte(key), nil, true /*lsm*/)
+			ref.Delete([]byte(key), nil, true /*lsm*/)
+		}
+	}
+	return ref, base
+}
+
+// makeMergeLLRB return a newer index with merge operands for every
+// other key, including keys missing in older levels.
+func makeMergeLLRB(name string, ref *llrb.LLRB) *llrb.LLRB {
+	setts := s.Settings{"memcapacity": 1024 * 1024 * 1024}
+	mi := llrb.NewLLRB(name, setts)
+	mi.Setseqno(ref.Getseqno())
+
+	for i := 0; i < 1200; i += 2 {
+		key := []byte(fmt.Sprintf("key%d", i))
+		operand := []byte(fmt.Sprintf("%d", i%7))
+		mi.Merge(key, operand, addmerge, true /*lsm*/)
+		ref.Merge(key, operand, addmerge, false /*lsm*/)
+		if i%3 == 0 {
+			mi.Merge(key, operand, addmerge, true /*lsm*/)
+			ref.Merge(key, operand, addmerge, false /*lsm*/)
+		}
+	}
+	return mi
+}
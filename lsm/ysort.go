@@ -30,9 +30,27 @@ func pull(
 	return cp(k, key), cp(v, val), seqno, del, err
 }
 
+// compare keys in the order of iteration.
+func compare(x, y []byte, desc bool) int {
+	if desc {
+		return bytes.Compare(y, x)
+	}
+	return bytes.Compare(x, y)
+}
+
 // YSort is a iterate combinator that takes two iterator and return
 // a new iterator that handles LSM.
 func YSort(a, b api.Iterator) api.Iterator {
+	return ysort(a, b, false /*desc*/)
+}
+
+// YSortDesc is same as YSort, except that both the iterators and the
+// returned iterator iterate in descending order.
+func YSortDesc(a, b api.Iterator) api.Iterator {
+	return ysort(a, b, true /*desc*/)
+}
+
+func ysort(a, b api.Iterator, desc bool) api.Iterator {
 	key, val := make([]byte, 0, 16), make([]byte, 0, 16)
 
 	bkey, bval := make([]byte, 0, 16), make([]byte, 0, 16)
@@ -60,7 +78,7 @@ func YSort(a, b api.Iterator) api.Iterator {
 			seqno, del, err = aseqno, adel, aerr
 			akey, aval, aseqno, adel, aerr = pull(a, fin, akey, aval)
 
-		} else if cmp := bytes.Compare(bkey, akey); cmp < 0 {
+		} else if cmp := compare(bkey, akey, desc); cmp < 0 {
 			key, val = cp(key, bkey), cp(val, bval)
 			seqno, del, err = bseqno, bdel, berr
 			bkey, bval, bseqno, bdel, berr = pull(b, fin, bkey, bval)
@@ -324,6 +324,85 @@ func TestYSortV2(t *testing.T) {
 	refiter(true /*fin*/)
 }
 
+func TestYSortDesc(t *testing.T) {
+	setts := s.Settings{"memcapacity": 1024 * 1024 * 1024}
+	ref := llrb.NewLLRB("refllrb", setts)
+
+	llrb1, keys := makeLLRB("llrb1", 10000, nil, ref, -1, -1)
+	llrb2, keys := makeLLRB("llrb2", 0, keys, ref, 4, 8)
+	llrb3, _ := makeLLRB("llrb3", 0, keys, ref, 4, 8)
+	defer llrb1.Destroy()
+	defer llrb2.Destroy()
+	defer llrb3.Destroy()
+
+	paths := makepaths()
+
+	name, msize, mmap := "bubt1", int64(4096), false
+	zsize := []int64{0, msize, msize * 2}[rand.Intn(100000)%3]
+	vsize := []int64{0, zsize, zsize * 2}[rand.Intn(100000)%3]
+	bb, err := bubt.NewBubt(name, paths, msize, zsize, vsize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	itere := llrb1.ScanEntries()
+	err = bb.Build(itere, []byte("this is metadata for llrb1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bb.Close()
+	itere(true /*fin*/)
+
+	bubt1, err := bubt.OpenSnapshot(name, paths, mmap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bubt1.Destroy()
+	defer bubt1.Close()
+
+	// open cursors past the last key, to iterate in descending order.
+	lastkey := []byte("key999999999999")
+	refview, bview := ref.View(0), bubt1.View(0)
+	view2, view3 := llrb2.View(0), llrb3.View(0)
+	refcur, _ := refview.OpenCursor(lastkey)
+	bcur, _ := bview.OpenCursor(lastkey)
+	cur2, _ := view2.OpenCursor(lastkey)
+	cur3, _ := view3.OpenCursor(lastkey)
+
+	count := 0
+	refiter := refcur.YPrev
+	iter := YSortDesc(bcur.YPrev, YSortDesc(cur2.YPrev, cur3.YPrev))
+	key, value, seqno, deleted, err := refiter(false)
+	for err == nil {
+		k, v, s, d, e := iter(false)
+		if bytes.Compare(key, k) != 0 {
+			t.Errorf("expected %q, got %q", key, k)
+		} else if err != e {
+			t.Errorf("%q expected %v, got %v", key, err, e)
+		} else if d != deleted {
+			t.Errorf("%q expected %v, got %v", key, deleted, d)
+		} else if s != seqno {
+			t.Errorf("%q expected %v, got %v", key, seqno, s)
+		} else if deleted == false && bytes.Compare(value, v) != 0 {
+			t.Errorf("%q expected %q, got %q", key, value, v)
+		}
+		count++
+		key, value, seqno, deleted, err = refiter(false)
+	}
+	_, _, _, _, e := iter(false)
+	if e != err {
+		t.Errorf("unexpected %v", e)
+	} else if x := ref.Count(); int64(count) != x {
+		t.Errorf("expected %v, got %v", x, count)
+	}
+	iter(true /*fin*/)
+	refiter(true /*fin*/)
+
+	refview.Abort()
+	bview.Abort()
+	view2.Abort()
+	view3.Abort()
+}
+
 func BenchmarkYSortM(b *testing.B) {
 	setts := s.Settings{"memcapacity": 1024 * 1024 * 1024}
 	ref := llrb.NewLLRB("refllrb", setts)
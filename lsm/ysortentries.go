@@ -29,6 +29,16 @@ func pulle(
 // YSortEntries is a iterate combinator that takes two iterator and
 // return a new iterator that handles LSM.
 func YSortEntries(a, b api.EntryIterator) api.EntryIterator {
+	return ysortentries(a, b, false /*desc*/)
+}
+
+// YSortEntriesDesc is same as YSortEntries, except that both the
+// iterators and the returned iterator iterate in descending order.
+func YSortEntriesDesc(a, b api.EntryIterator) api.EntryIterator {
+	return ysortentries(a, b, true /*desc*/)
+}
+
+func ysortentries(a, b api.EntryIterator, desc bool) api.EntryIterator {
 	var aentry, bentry api.IndexEntry
 	var key []byte
 	var aseqno, bseqno uint64
@@ -70,7 +80,7 @@ func YSortEntries(a, b api.EntryIterator) api.EntryIterator {
 		} else if berr != nil {
 			entry, anext = aentry, true
 
-		} else if cmp := bytes.Compare(bkey, akey); cmp < 0 {
+		} else if cmp := compare(bkey, akey, desc); cmp < 0 {
 			entry, bnext = bentry, true
 
 		} else if cmp > 0 {